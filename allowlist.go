@@ -0,0 +1,127 @@
+package logparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Allowlist suppresses known false positives for sensitive patterns, such as
+// documentation example keys or dummy tokens used in CI.
+type Allowlist struct {
+	// Values are exact strings that are never reported.
+	Values []string
+	// Regexes reject a finding when any of them matches.
+	Regexes []*regexp.Regexp
+	// Stopwords reject a finding when the secret contains any of them
+	// (case-insensitive).
+	Stopwords []string
+	// MatchTarget applies Regexes to the whole pattern match instead of just
+	// the secret part.
+	MatchTarget bool
+}
+
+// NewAllowlist builds an Allowlist from entries. An entry wrapped in slashes,
+// like "/.+EXAMPLE$/", is compiled as a regex; anything else is an exact value.
+func NewAllowlist(entries ...string) (*Allowlist, error) {
+	a := &Allowlist{}
+	if err := a.add(entries...); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Allowlist) add(entries ...string) error {
+	for _, e := range entries {
+		if len(e) >= 2 && strings.HasPrefix(e, "/") && strings.HasSuffix(e, "/") {
+			re, err := regexp.Compile(e[1 : len(e)-1])
+			if err != nil {
+				return fmt.Errorf("invalid allowlist regex %q: %w", e, err)
+			}
+			a.Regexes = append(a.Regexes, re)
+			continue
+		}
+		a.Values = append(a.Values, e)
+	}
+	return nil
+}
+
+// Allowed reports whether a finding should be suppressed. secret is the
+// sensitive part of the match (see PrecompiledPattern.SecretGroup) and match
+// is the full text matched by the pattern.
+func (a *Allowlist) Allowed(secret, match string) bool {
+	if a == nil {
+		return false
+	}
+	for _, v := range a.Values {
+		if v == secret || v == match {
+			return true
+		}
+	}
+	target := secret
+	if a.MatchTarget {
+		target = match
+	}
+	for _, re := range a.Regexes {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+	if len(a.Stopwords) > 0 {
+		lowerSecret := strings.ToLower(secret)
+		for _, w := range a.Stopwords {
+			if strings.Contains(lowerSecret, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowlistConfig is the on-disk allowlist format of a SensitivePattern. It
+// accepts either a plain array of entries (see NewAllowlist) or an object in
+// the gitleaks style:
+//
+//	{"values": [...], "regexes": [...], "stopwords": [...], "regexTarget": "match"}
+type AllowlistConfig struct {
+	Entries     []string `json:"-"`
+	Values      []string `json:"values"`
+	Regexes     []string `json:"regexes"`
+	Stopwords   []string `json:"stopwords"`
+	RegexTarget string   `json:"regexTarget"`
+}
+
+func (a *AllowlistConfig) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		return json.Unmarshal(data, &a.Entries)
+	}
+	type plain AllowlistConfig
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+func (a *AllowlistConfig) compile() (*Allowlist, error) {
+	if a == nil {
+		return nil, nil
+	}
+	res, err := NewAllowlist(a.Entries...)
+	if err != nil {
+		return nil, err
+	}
+	res.Values = append(res.Values, a.Values...)
+	for _, r := range a.Regexes {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist regex %q: %w", r, err)
+		}
+		res.Regexes = append(res.Regexes, re)
+	}
+	for _, w := range a.Stopwords {
+		res.Stopwords = append(res.Stopwords, strings.ToLower(w))
+	}
+	res.MatchTarget = a.RegexTarget == "match"
+	if len(res.Values) == 0 && len(res.Regexes) == 0 && len(res.Stopwords) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
@@ -0,0 +1,74 @@
+package logparser
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// AttributeExtractor extracts a structured field from messages: the first
+// submatch of Pattern, or the whole match if it has none, is the value of
+// the attribute Name.
+type AttributeExtractor struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultAttributeExtractors extract the fully qualified exception class
+// ("exception"), the HTTP status code ("http_status") and errno-style codes
+// ("errno") of messages. Several extractors may set the same attribute; the
+// first one matching wins.
+var DefaultAttributeExtractors = []AttributeExtractor{
+	{Name: "exception", Pattern: regexp.MustCompile(`\b((?:[a-zA-Z_$][\w$]*\.)+[A-Z][\w$]*(?:Exception|Error))\b`)},
+	{Name: "http_status", Pattern: regexp.MustCompile(`(?i)\b(?:http_?status(?:_?code)?|status_?code)["']?\s*[=:]\s*["']?([1-5]\d\d)\b`)},
+	{Name: "http_status", Pattern: regexp.MustCompile(`\bHTTP(?:/\d(?:\.\d)?)?\s+([1-5]\d\d)\b`)},
+	{Name: "errno", Pattern: regexp.MustCompile(`(?i)\berrno["']?\s*[=:]?\s*(-?\d+)\b`)},
+	{Name: "errno", Pattern: regexp.MustCompile(`\b(E(?:CONNREFUSED|CONNRESET|CONNABORTED|TIMEDOUT|HOSTUNREACH|NETUNREACH|ADDRINUSE|NOENT|ACCES|PERM|PIPE|AGAIN|EXIST|MFILE|NOSPC|NOMEM|INVAL|BADF|BUSY|NOTFOUND))\b`)},
+}
+
+// extractAttributes returns the attributes of content, nil if none match.
+func extractAttributes(content string, extractors []AttributeExtractor) map[string]string {
+	var res map[string]string
+	for _, e := range extractors {
+		if _, ok := res[e.Name]; ok {
+			continue
+		}
+		m := e.Pattern.FindStringSubmatch(content)
+		if m == nil {
+			continue
+		}
+		value := m[0]
+		if len(m) > 1 {
+			value = m[1]
+		}
+		if res == nil {
+			res = map[string]string{}
+		}
+		res[e.Name] = value
+	}
+	return res
+}
+
+func copyAttributes(attributes map[string]string) map[string]string {
+	if attributes == nil {
+		return nil
+	}
+	res := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		res[k] = v
+	}
+	return res
+}
+
+// validateAttributeExtractors checks that extractors have a name and a
+// pattern.
+func validateAttributeExtractors(extractors []AttributeExtractor) error {
+	for i, e := range extractors {
+		if e.Name == "" {
+			return fmt.Errorf("attribute extractor %d has no name", i)
+		}
+		if e.Pattern == nil {
+			return fmt.Errorf("attribute extractor %q has no pattern", e.Name)
+		}
+	}
+	return nil
+}
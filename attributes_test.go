@@ -0,0 +1,51 @@
+package logparser
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractAttributes(t *testing.T) {
+	remote := "Failed to get latest location by identifier: USJOT | p44.exception.RemoteServiceException: Failed to make remote service call.\nApiErrorDto(httpStatusCode=404, httpMessage=Not Found, errorMessage=null, errors=[MessageDto(severity=ERROR, message=There does not exist any locations for type PORT_UN_LOCODE and value USJOT, diagnostic=null, source=null)], supportReferenceId=9ea963cd-7ba3-411f-8a3f-b01d569574bf)"
+	assert.Equal(t, map[string]string{"exception": "p44.exception.RemoteServiceException", "http_status": "404"}, extractAttributes(remote, DefaultAttributeExtractors))
+
+	assert.Equal(t, map[string]string{"http_status": "503"}, extractAttributes(`GET /api/orders HTTP/1.1 503 Service Unavailable`, DefaultAttributeExtractors))
+	assert.Equal(t, map[string]string{"http_status": "500"}, extractAttributes(`request failed {"status_code": 500}`, DefaultAttributeExtractors))
+	assert.Equal(t, map[string]string{"errno": "ECONNREFUSED"}, extractAttributes(`connect ECONNREFUSED 10.0.0.1:5432`, DefaultAttributeExtractors))
+	assert.Equal(t, map[string]string{"errno": "111"}, extractAttributes(`socket error errno=111 connection refused`, DefaultAttributeExtractors))
+	assert.Equal(t, map[string]string{"exception": "java.lang.IllegalStateException"}, extractAttributes(`java.lang.IllegalStateException: closed`, DefaultAttributeExtractors))
+	// Unqualified class names and plain numbers are not attributes.
+	assert.Nil(t, extractAttributes(`IllegalStateException after 404 retries`, DefaultAttributeExtractors))
+
+	tenant := AttributeExtractor{Name: "tenant", Pattern: regexp.MustCompile(`tenant=(\w+)`)}
+	assert.Equal(t, map[string]string{"tenant": "acme"}, extractAttributes(`tenant=acme quota exceeded`, []AttributeExtractor{tenant}))
+}
+
+func TestWithAttributeExtractors(t *testing.T) {
+	lines := []string{
+		"2024-01-15 10:00:00 ERROR tenant=acme call failed: p44.exception.RemoteServiceException: httpStatusCode=404",
+		"2024-01-15 10:00:01 ERROR tenant=other call failed: p44.exception.RemoteServiceException: httpStatusCode=500",
+	}
+	res, _, err := ParseLines(lines)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	// The values are the ones of the first message.
+	assert.Equal(t, map[string]string{"exception": "p44.exception.RemoteServiceException", "http_status": "404"}, res[0].Attributes)
+
+	tenant := AttributeExtractor{Name: "tenant", Pattern: regexp.MustCompile(`tenant=(\w+)`)}
+	res, _, err = ParseLines(lines, WithAttributeExtractors(append(DefaultAttributeExtractors[:len(DefaultAttributeExtractors):len(DefaultAttributeExtractors)], tenant)...))
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, map[string]string{"exception": "p44.exception.RemoteServiceException", "http_status": "404", "tenant": "acme"}, res[0].Attributes)
+
+	res, _, err = ParseLines(lines, WithAttributeExtractors())
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Nil(t, res[0].Attributes)
+
+	_, err = NewParserWithOptions(make(chan LogEntry), WithAttributeExtractors(AttributeExtractor{Name: "tenant"}))
+	assert.Error(t, err)
+}
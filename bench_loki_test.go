@@ -120,7 +120,7 @@ func TestLokiBenchmark(t *testing.T) {
 			matches := DetectSensitiveData(line, "bench", patterns)
 			totalMatches += len(matches)
 			for _, m := range matches {
-				matchNames[m.name]++
+				matchNames[m.Name]++
 			}
 		}
 		elapsed := time.Since(start)
@@ -150,7 +150,7 @@ func TestLokiBenchmark(t *testing.T) {
 					if len(sample) > 150 {
 						sample = sample[:150] + "..."
 					}
-					t.Logf("    [%s] %s", matches[0].name, sample)
+					t.Logf("    [%s] %s", matches[0].Name, sample)
 					shown++
 					if shown >= 5 {
 						break
@@ -1,18 +1,31 @@
 package logparser
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	goDrain "github.com/jaeyo/go-drain3/pkg/drain3"
 )
 
 // LogPattern represents a discovered log pattern with its statistics
 type LogPattern struct {
-	Template   string  // Log template with wildcards (e.g., "Failed to get * | Exception: *")
-	Count      int     // Number of logs matching this pattern
-	Percentage float64 // Percentage of total logs
-	Example    string  // Example log message that matches this pattern
+	ClusterID  int64     // Drain3 cluster ID; stable within a session and across SaveState and LoadState
+	Hash       string    // TemplateHash of Template; stable across runs and input orderings
+	Template   string    // Log template with wildcards (e.g., "Failed to get * | Exception: *")
+	Count      int       // Number of logs matching this pattern
+	CountError int       // Maximum overestimation of Count in heavy-hitter mode (0 = exact)
+	Percentage float64   // Percentage of total logs
+	Example    string    // Example log message that matches this pattern
+	FirstSeen  time.Time // Earliest timestamp given to AddLogWithTimestamp (zero if none)
+	LastSeen   time.Time // Latest timestamp given to AddLogWithTimestamp (zero if none)
+
+	SubPatterns []LogPattern // Patterns merged into this one by MergeSimilarPatterns
+	Samples     []string     // Sample of the logs matching this pattern, see WithClusterSamples
 }
 
 // ExtractPatterns analyzes multiple log lines and returns common patterns.
@@ -37,152 +50,330 @@ type LogPattern struct {
 //	// 1. "Failed to get location: <*> | RemoteServiceException" (count: 2)
 //	// 2. "DetectEtaChanges failed | NullPointerException" (count: 1)
 func ExtractPatterns(logs []string, maxPatterns int) []LogPattern {
-	if len(logs) == 0 {
+	patterns, err := ExtractPatternsWithConfig(logs, maxPatterns, DefaultDrainConfig())
+	if err != nil {
 		return []LogPattern{}
 	}
+	return patterns
+}
 
-	// Configure Drain3 for log pattern extraction
-	// These settings are optimized for error log analysis
-	drain, err := goDrain.NewDrain(
-		goDrain.WithDepth(4),          // Parse tree depth - balanced for structured logs
-		goDrain.WithSimTh(0.5),        // 50% similarity threshold - groups similar errors
-		goDrain.WithMaxChildren(50),   // Max children per tree node - performance optimized
-		goDrain.WithMaxCluster(1000),  // Max number of clusters - handle diverse logs
-	)
+// DrainConfig configures the Drain3 clustering used by ExtractPatternsWithConfig
+// and PatternExtractor.
+type DrainConfig struct {
+	// Depth is the depth of the parse tree, including the root and the leaf
+	// level; logs are routed by their first Depth-2 tokens. At least 3.
+	Depth int
+	// SimilarityThreshold is the minimum share of matching tokens for a log
+	// to join a cluster, in (0, 1]. Higher values give more, finer clusters.
+	SimilarityThreshold float64
+	// MaxChildren is the maximum number of children per tree node.
+	MaxChildren int
+	// MaxClusters is the maximum number of clusters; when it is reached, the
+	// least recently used cluster is dropped.
+	MaxClusters int
+	// ExtraDelimiters are split on in addition to spaces, e.g. "=" or ",".
+	ExtraDelimiters []string
+	// MergeThreshold, if set, merges the resulting patterns whose templates
+	// are at least this similar, in [0, 1] (see MergeSimilarPatterns).
+	MergeThreshold float64
+}
 
-	if err != nil {
-		return []LogPattern{}
+// DefaultDrainConfig returns the configuration used by ExtractPatterns,
+// tuned for error log analysis.
+func DefaultDrainConfig() DrainConfig {
+	return DrainConfig{
+		Depth:               4,    // Parse tree depth - balanced for structured logs
+		SimilarityThreshold: 0.5,  // 50% similarity threshold - groups similar errors
+		MaxChildren:         50,   // Max children per tree node - performance optimized
+		MaxClusters:         1000, // Max number of clusters - handle diverse logs
 	}
+}
 
-	// Map to store first example for each cluster
-	clusterExamples := make(map[int64]string)
+// Validate reports the first invalid setting of cfg.
+func (cfg DrainConfig) Validate() error {
+	switch {
+	case cfg.Depth < 3:
+		return fmt.Errorf("drain depth must be at least 3, got %d", cfg.Depth)
+	case cfg.SimilarityThreshold <= 0 || cfg.SimilarityThreshold > 1:
+		return fmt.Errorf("similarity threshold must be in (0, 1], got %g", cfg.SimilarityThreshold)
+	case cfg.MaxChildren <= 0:
+		return fmt.Errorf("max children must be positive, got %d", cfg.MaxChildren)
+	case cfg.MaxClusters <= 0:
+		return fmt.Errorf("max clusters must be positive, got %d", cfg.MaxClusters)
+	case cfg.MergeThreshold < 0 || cfg.MergeThreshold > 1:
+		return fmt.Errorf("merge threshold must be in [0, 1], got %g", cfg.MergeThreshold)
+	}
+	return nil
+}
 
-	// Process each log message
-	for _, log := range logs {
-		if strings.TrimSpace(log) == "" {
-			continue
-		}
+func (cfg DrainConfig) newDrain() (*goDrain.Drain, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return goDrain.NewDrain(
+		goDrain.WithDepth(int64(cfg.Depth)),
+		goDrain.WithSimTh(cfg.SimilarityThreshold),
+		goDrain.WithMaxChildren(int64(cfg.MaxChildren)),
+		goDrain.WithMaxCluster(cfg.MaxClusters),
+		goDrain.WithExtraDelimiter(append([]string{}, cfg.ExtraDelimiters...)),
+	)
+}
 
-		// Add to drain3 for pattern extraction
-		cluster, _, err := drain.AddLogMessage(log)
-		if err != nil {
+// ExtractPatternsWithConfig is like ExtractPatterns with the clustering
+// configured by cfg. It fails if cfg is invalid.
+func ExtractPatternsWithConfig(logs []string, maxPatterns int, cfg DrainConfig) ([]LogPattern, error) {
+	pe, err := NewPatternExtractor(WithDrainConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+	for _, log := range logs {
+		if err := pe.AddLog(log); err != nil {
 			continue
 		}
-
-		// Store first example for this cluster if we don't have one yet
-		if cluster != nil {
-			if _, exists := clusterExamples[cluster.ClusterId]; !exists {
-				clusterExamples[cluster.ClusterId] = log
-			}
-		}
 	}
+	return pe.GetPatterns(maxPatterns), nil
+}
 
-	// Get all clusters and convert to LogPattern
-	clusters := drain.GetClusters()
-	if len(clusters) == 0 {
-		return []LogPattern{}
-	}
+// PatternExtractor provides streaming log pattern extraction using Drain3 algorithm.
+// Use this for memory-efficient processing of large log files.
+// It is safe for concurrent use.
+type PatternExtractor struct {
+	cfg DrainConfig
+	// trendBucket and trendBuckets set the size and maximum number of the
+	// time buckets kept per cluster for GetPatternTrends.
+	trendBucket  time.Duration
+	trendBuckets int
+	// sensitive is set by WithClusterSensitiveDetection.
+	sensitive *clusterSensitive
+	// heavyHitters is the number of clusters kept in heavy-hitter mode (see
+	// WithHeavyHitters), 0 if disabled.
+	heavyHitters int
+	// samples is the number of logs sampled per cluster (see
+	// WithClusterSamples), 0 if disabled.
+	samples int
+	// hashAlgorithm is the algorithm of the pattern hashes, see
+	// WithClusterHashAlgorithm.
+	hashAlgorithm HashAlgorithm
+
+	lock       sync.Mutex
+	rng        *rand.Rand
+	drain      *goDrain.Drain
+	clusters   map[int64]*clusterStat
+	totalCount int
+	// levels holds the extractors of the logs added with AddEntry, one per
+	// level.
+	levels map[Level]*PatternExtractor
+}
 
-	patterns := make([]LogPattern, 0, len(clusters))
-	totalCount := 0
+// clusterStat is the bookkeeping kept per Drain3 cluster.
+type clusterStat struct {
+	example    string
+	count      int
+	countError int
+	firstSeen  time.Time
+	lastSeen   time.Time
+	trend      []BucketCount // sorted by Start
+	samples    []string
+}
 
-	for _, cluster := range clusters {
-		template := formatDrainTemplate(cluster)
-		if template != "" {
-			example := clusterExamples[cluster.ClusterId]
-			if example == "" && len(logs) > 0 {
-				example = logs[0] // Fallback to first log if no example found
-			}
+// PatternExtractorOption configures a PatternExtractor. Options validate their
+// arguments and return an error instead of applying an invalid setting.
+type PatternExtractorOption func(*PatternExtractor) error
 
-			patterns = append(patterns, LogPattern{
-				Template:   template,
-				Count:      int(cluster.Size),
-				Percentage: 0, // Will calculate after getting total
-				Example:    example,
-			})
-			totalCount += int(cluster.Size)
+// WithDrainConfig replaces the clustering configuration (default
+// DefaultDrainConfig).
+func WithDrainConfig(cfg DrainConfig) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		if err := cfg.Validate(); err != nil {
+			return err
 		}
+		pe.cfg = cfg
+		return nil
 	}
+}
 
-	// Calculate percentages
-	if totalCount > 0 {
-		for i := range patterns {
-			patterns[i].Percentage = float64(patterns[i].Count) * 100.0 / float64(totalCount)
+// WithSimilarityThreshold sets the minimum share of matching tokens for a log
+// to join a cluster, between 0 and 1 (default 0.5).
+func WithSimilarityThreshold(th float64) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		if th <= 0 || th > 1 {
+			return fmt.Errorf("similarity threshold must be in (0, 1], got %g", th)
 		}
+		pe.cfg.SimilarityThreshold = th
+		return nil
 	}
+}
 
-	// Sort by count (descending), then by template alphabetically
-	sort.Slice(patterns, func(i, j int) bool {
-		if patterns[i].Count == patterns[j].Count {
-			return patterns[i].Template < patterns[j].Template
+// WithMaxClusters sets the maximum number of clusters (default 1000). When
+// it is reached, the least recently used cluster is dropped.
+func WithMaxClusters(n int) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		if n <= 0 {
+			return fmt.Errorf("max clusters must be positive, got %d", n)
 		}
-		return patterns[i].Count > patterns[j].Count
-	})
-
-	// Limit results if requested
-	if maxPatterns > 0 && len(patterns) > maxPatterns {
-		patterns = patterns[:maxPatterns]
+		pe.cfg.MaxClusters = n
+		return nil
 	}
+}
 
-	return patterns
+// WithTrendBuckets sets the size and the maximum number of the time buckets
+// kept per cluster for GetPatternTrends (default 60 one-minute buckets).
+// Once a cluster has max buckets, the oldest one is dropped.
+func WithTrendBuckets(size time.Duration, max int) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		if size <= 0 {
+			return fmt.Errorf("trend bucket size must be positive, got %s", size)
+		}
+		if max <= 0 {
+			return fmt.Errorf("trend buckets must be positive, got %d", max)
+		}
+		pe.trendBucket, pe.trendBuckets = size, max
+		return nil
+	}
 }
 
-// PatternExtractor provides streaming log pattern extraction using Drain3 algorithm.
-// Use this for memory-efficient processing of large log files.
-type PatternExtractor struct {
-	drain           *goDrain.Drain
-	clusterExamples map[int64]string
-	totalCount      int
+// WithClusterHashAlgorithm sets the algorithm of the LogPattern hashes, to
+// match the one given to the Parser with WithHashAlgorithm (default
+// HashMD5).
+func WithClusterHashAlgorithm(algorithm HashAlgorithm) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		if !algorithm.valid() {
+			return fmt.Errorf("unknown hash algorithm %q", algorithm)
+		}
+		pe.hashAlgorithm = algorithm
+		return nil
+	}
 }
 
 // NewPatternExtractor creates a new streaming pattern extractor.
 // It processes logs one at a time without buffering them all in memory.
-func NewPatternExtractor() (*PatternExtractor, error) {
-	drain, err := goDrain.NewDrain(
-		goDrain.WithDepth(4),         // Parse tree depth - balanced for structured logs
-		goDrain.WithSimTh(0.5),       // 50% similarity threshold - groups similar errors
-		goDrain.WithMaxChildren(50),  // Max children per tree node - performance optimized
-		goDrain.WithMaxCluster(1000), // Max number of clusters - handle diverse logs
-	)
-	if err != nil {
+func NewPatternExtractor(opts ...PatternExtractorOption) (*PatternExtractor, error) {
+	pe := &PatternExtractor{cfg: DefaultDrainConfig(), trendBucket: defaultTrendBucket, trendBuckets: defaultTrendBuckets}
+	for _, opt := range opts {
+		if err := opt(pe); err != nil {
+			return nil, err
+		}
+	}
+	if pe.heavyHitters > 0 {
+		// one more than the clusters kept, so that Drain3 never evicts
+		// before evictColdest does
+		pe.cfg.MaxClusters = pe.heavyHitters + 1
+	}
+	if err := pe.reset(); err != nil {
 		return nil, err
 	}
+	return pe, nil
+}
 
-	return &PatternExtractor{
-		drain:           drain,
-		clusterExamples: make(map[int64]string),
-		totalCount:      0,
-	}, nil
+// reset replaces the clusters with an empty set; the caller must hold the
+// lock or own pe exclusively.
+func (pe *PatternExtractor) reset() error {
+	drain, err := pe.cfg.newDrain()
+	if err != nil {
+		return err
+	}
+	pe.drain = drain
+	pe.clusters = make(map[int64]*clusterStat)
+	pe.totalCount = 0
+	pe.levels = make(map[Level]*PatternExtractor)
+	return nil
+}
+
+// Reset discards the clusters and counts, keeping the options.
+func (pe *PatternExtractor) Reset() error {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	return pe.reset()
 }
 
 // AddLog processes a single log line. Call this for each log line in streaming fashion.
 // This method is memory-efficient as it doesn't store the log after processing.
 func (pe *PatternExtractor) AddLog(log string) error {
+	return pe.AddLogWithTimestamp(log, time.Time{})
+}
+
+// AddLogWithTimestamp is like AddLog and also records ts in the FirstSeen and
+// LastSeen of the log's pattern. A zero ts is ignored.
+func (pe *PatternExtractor) AddLogWithTimestamp(log string, ts time.Time) error {
 	if strings.TrimSpace(log) == "" {
 		return nil
 	}
 
+	if pe.sensitive != nil {
+		pe.sensitive.observe(log, ts)
+	}
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	_, _, err := pe.add(log, log, ts)
+	return err
+}
+
+// add adds log to its cluster and reports whether the cluster was created;
+// example is stored if it is the first log of the cluster. The caller must
+// hold the lock.
+func (pe *PatternExtractor) add(log, example string, ts time.Time) (*goDrain.LogCluster, bool, error) {
 	pe.totalCount++
+	log = clusterLine(log)
+	example = validUTF8(example)
 
 	// Add to drain3 for pattern extraction
-	cluster, _, err := pe.drain.AddLogMessage(log)
+	cluster, update, err := pe.drain.AddLogMessage(log)
 	if err != nil {
-		return err
+		return nil, false, err
+	}
+	if cluster == nil {
+		return nil, false, nil
 	}
 
-	// Store first example for this cluster if we don't have one yet
-	if cluster != nil {
-		if _, exists := pe.clusterExamples[cluster.ClusterId]; !exists {
-			pe.clusterExamples[cluster.ClusterId] = log
+	stat := pe.clusters[cluster.ClusterId]
+	if stat == nil {
+		// Store the first example for this cluster, without its secrets
+		if pe.sensitive != nil {
+			example = pe.sensitive.redact(example)
+		}
+		stat = &clusterStat{example: example}
+		if pe.heavyHitters > 0 && len(pe.clusters) >= pe.heavyHitters {
+			stat.count = pe.evictColdest()
+			stat.countError = stat.count
+		}
+		pe.clusters[cluster.ClusterId] = stat
+		if len(pe.clusters) > 2*pe.cfg.MaxClusters {
+			pe.pruneClusters()
+		}
+	}
+	stat.count++
+	if pe.samples > 0 {
+		pe.addSample(stat, log)
+	}
+	if !ts.IsZero() {
+		if stat.firstSeen.IsZero() || ts.Before(stat.firstSeen) {
+			stat.firstSeen = ts
+		}
+		if ts.After(stat.lastSeen) {
+			stat.lastSeen = ts
 		}
+		stat.trend = addToTrend(stat.trend, ts.Truncate(pe.trendBucket), pe.trendBuckets)
 	}
+	return cluster, update == goDrain.ClusterUpdateTypeCreated, nil
+}
 
-	return nil
+// pruneClusters drops the bookkeeping of the clusters evicted by Drain3.
+func (pe *PatternExtractor) pruneClusters() {
+	live := make(map[int64]*clusterStat, len(pe.clusters))
+	for _, c := range pe.drain.GetClusters() {
+		if stat := pe.clusters[c.ClusterId]; stat != nil {
+			live[c.ClusterId] = stat
+		}
+	}
+	pe.clusters = live
 }
 
 // GetPatterns returns the extracted patterns sorted by frequency.
-// Call this after processing all logs with AddLog.
+// It may be called at any time, including while logs are being added.
 func (pe *PatternExtractor) GetPatterns(maxPatterns int) []LogPattern {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+
 	clusters := pe.drain.GetClusters()
 	if len(clusters) == 0 {
 		return []LogPattern{}
@@ -192,17 +383,22 @@ func (pe *PatternExtractor) GetPatterns(maxPatterns int) []LogPattern {
 	totalClusterCount := 0
 
 	for _, cluster := range clusters {
-		template := formatDrainTemplate(cluster)
-		if template != "" {
-			example := pe.clusterExamples[cluster.ClusterId]
-
+		template := pe.template(cluster)
+		stat := pe.clusters[cluster.ClusterId]
+		if template != "" && stat != nil {
 			patterns = append(patterns, LogPattern{
+				ClusterID:  cluster.ClusterId,
+				Hash:       pe.templateHash(template),
 				Template:   template,
-				Count:      int(cluster.Size),
+				Count:      stat.count,
+				CountError: stat.countError,
 				Percentage: 0, // Will calculate after getting total
-				Example:    example,
+				Example:    stat.example,
+				FirstSeen:  stat.firstSeen,
+				LastSeen:   stat.lastSeen,
+				Samples:    append([]string(nil), stat.samples...),
 			})
-			totalClusterCount += int(cluster.Size)
+			totalClusterCount += stat.count
 		}
 	}
 
@@ -213,13 +409,10 @@ func (pe *PatternExtractor) GetPatterns(maxPatterns int) []LogPattern {
 		}
 	}
 
-	// Sort by count (descending), then by template alphabetically
-	sort.Slice(patterns, func(i, j int) bool {
-		if patterns[i].Count == patterns[j].Count {
-			return patterns[i].Template < patterns[j].Template
-		}
-		return patterns[i].Count > patterns[j].Count
-	})
+	sortPatterns(patterns)
+	if pe.cfg.MergeThreshold > 0 {
+		patterns = MergeSimilarPatterns(patterns, pe.cfg.MergeThreshold)
+	}
 
 	// Limit results if requested
 	if maxPatterns > 0 && len(patterns) > maxPatterns {
@@ -229,11 +422,34 @@ func (pe *PatternExtractor) GetPatterns(maxPatterns int) []LogPattern {
 	return patterns
 }
 
-// TotalLogs returns the total number of logs processed (including empty lines).
+// sortPatterns sorts by count (descending), then by template alphabetically.
+func sortPatterns(patterns []LogPattern) {
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Count == patterns[j].Count {
+			return patterns[i].Template < patterns[j].Template
+		}
+		return patterns[i].Count > patterns[j].Count
+	})
+}
+
+// TotalLogs returns the total number of non-empty logs processed by AddLog
+// and AddLogWithTimestamp.
 func (pe *PatternExtractor) TotalLogs() int {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
 	return pe.totalCount
 }
 
+// template returns the display template of cluster. Drain3 keeps the tokens
+// that never varied, so secrets are redacted from it as from the examples.
+func (pe *PatternExtractor) template(cluster *goDrain.LogCluster) string {
+	template := formatDrainTemplate(cluster)
+	if pe.sensitive != nil {
+		template = pe.sensitive.redact(template)
+	}
+	return template
+}
+
 // formatDrainTemplate formats a drain3 cluster template for display
 func formatDrainTemplate(cluster *goDrain.LogCluster) string {
 	if cluster == nil || len(cluster.LogTemplateTokens) == 0 {
@@ -248,3 +464,86 @@ func formatDrainTemplate(cluster *goDrain.LogCluster) string {
 
 	return template
 }
+
+// TemplateHash returns a stable identity for a cluster template: the hash of
+// the Pattern of the template, as returned by Pattern.Hash, so that a
+// cluster and the Parser counter of its logs share a hash whenever their
+// patterns agree. Wildcards ("*" or "<*>") and numbers aren't part of
+// patterns, so templates that differ only in whitespace, wildcard style or
+// numbers have the same hash.
+func TemplateHash(template string) string {
+	return NewPattern(template).Hash()
+}
+
+// templateHash is TemplateHash with the algorithm set by
+// WithClusterHashAlgorithm.
+func (pe *PatternExtractor) templateHash(template string) string {
+	pattern := NewPattern(template)
+	pattern.algorithm = pe.hashAlgorithm
+	return pattern.Hash()
+}
+
+// ExtractParameters returns the values of the wildcards ("*" or "<*>") of
+// template in line, in order, e.g. "USJOT" for the template
+// "Failed to get location: * | RemoteServiceException" and the line
+// "Failed to get location: USJOT | RemoteServiceException". Tokens are split
+// on spaces, as by Drain3. A wildcard normally stands for one token; if line
+// has more tokens than template, wildcards take as few tokens as possible
+// from left to right and multi-token values are joined with spaces. It fails
+// if line doesn't match template.
+func ExtractParameters(template, line string) ([]string, error) {
+	if strings.TrimSpace(template) == "" {
+		return nil, errors.New("empty template")
+	}
+	tpl := strings.Split(strings.TrimSpace(template), " ")
+	tokens := strings.Split(strings.TrimSpace(line), " ")
+
+	// failed[i*(len(tokens)+1)+j] records that tpl[i:] can't match tokens[j:],
+	// which keeps the search polynomial with many wildcards; ends[i] is the
+	// end of the tokens taken by tpl[i] in the match found.
+	failed := make([]bool, (len(tpl)+1)*(len(tokens)+1))
+	ends := make([]int, len(tpl))
+	var match func(i, j int) bool
+	match = func(i, j int) bool {
+		if i == len(tpl) {
+			return j == len(tokens)
+		}
+		if failed[i*(len(tokens)+1)+j] {
+			return false
+		}
+		if isDrainWildcard(tpl[i]) {
+			// Every remaining template token needs at least one line token.
+			for end := j + 1; end <= len(tokens)-(len(tpl)-i-1); end++ {
+				// A literal must come right after the wildcard.
+				if i+1 < len(tpl) && !isDrainWildcard(tpl[i+1]) && tokens[end] != tpl[i+1] {
+					continue
+				}
+				if match(i+1, end) {
+					ends[i] = end
+					return true
+				}
+			}
+		} else if j < len(tokens) && tokens[j] == tpl[i] && match(i+1, j+1) {
+			ends[i] = j + 1
+			return true
+		}
+		failed[i*(len(tokens)+1)+j] = true
+		return false
+	}
+	if !match(0, 0) {
+		return nil, fmt.Errorf("line doesn't match template %q", template)
+	}
+	params := []string{}
+	start := 0
+	for i, t := range tpl {
+		if isDrainWildcard(t) {
+			params = append(params, strings.Join(tokens[start:ends[i]], " "))
+		}
+		start = ends[i]
+	}
+	return params, nil
+}
+
+func isDrainWildcard(token string) bool {
+	return token == "*" || token == "<*>"
+}
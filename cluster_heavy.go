@@ -0,0 +1,48 @@
+package logparser
+
+import (
+	"fmt"
+)
+
+// heavyHitterFactor is the number of clusters kept per requested pattern in
+// heavy-hitter mode.
+const heavyHitterFactor = 10
+
+// WithHeavyHitters bounds the memory used over very large inputs when only
+// the top k patterns are needed: at most k*10 clusters, with their examples,
+// are kept, and the least frequent one is evicted for each new cluster
+// (the Space-Saving algorithm). A new cluster inherits the count of the
+// evicted one as its LogPattern.CountError.
+//
+// With m = k*10 clusters and n logs, the counts are overestimated, never
+// underestimated, by at most CountError <= n/m, and every pattern seen more
+// than n/m times is retained. The true top k are therefore retained whenever
+// the k-th most frequent pattern has more than n/m logs. It overrides the
+// MaxClusters setting.
+func WithHeavyHitters(k int) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		if k <= 0 {
+			return fmt.Errorf("heavy hitters must be positive, got %d", k)
+		}
+		pe.heavyHitters = k * heavyHitterFactor
+		return nil
+	}
+}
+
+// evictColdest drops the cluster with the lowest count, the oldest one on
+// ties, and returns its count; the caller must hold the lock.
+func (pe *PatternExtractor) evictColdest() int {
+	var coldest int64
+	var min *clusterStat
+	for id, stat := range pe.clusters {
+		if min == nil || stat.count < min.count || stat.count == min.count && id < coldest {
+			coldest, min = id, stat
+		}
+	}
+	if min == nil {
+		return 0
+	}
+	pe.drain.IdToCluster.Remove(coldest)
+	delete(pe.clusters, coldest)
+	return min.count
+}
@@ -0,0 +1,90 @@
+package logparser
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// letters returns a word made of letters only, unique for i, so that Drain3
+// doesn't treat it as a variable.
+func letters(i int) string {
+	w := []byte("aaaa")
+	for j := len(w) - 1; i > 0 && j >= 0; j-- {
+		w[j] = byte('a' + i%26)
+		i /= 26
+	}
+	return string(w)
+}
+
+// heavyHitterLogs returns the i-th log of a stream where 5 patterns make up
+// half of the logs and the rest are distinct.
+func heavyHitterLogs(i int) string {
+	switch {
+	case i%10 == 0:
+		return fmt.Sprintf("user %d logged in from 10.0.0.%d", i, i%256)
+	case i%10 == 2:
+		return fmt.Sprintf("connection to db-%d timed out after %dms", i%7, i)
+	case i%10 == 4:
+		return fmt.Sprintf("cache miss for key %d", i)
+	case i%10 == 6:
+		return fmt.Sprintf("request %d took %dms", i, i%1000)
+	case i%10 == 8:
+		return "health check ok"
+	}
+	return fmt.Sprintf("job %s %s %s finished", letters(i), letters(i+1), letters(i+2))
+}
+
+func TestPatternExtractorHeavyHitters(t *testing.T) {
+	const n = 20000
+	pe, err := NewPatternExtractor(WithHeavyHitters(5))
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, pe.AddLog(heavyHitterLogs(i)))
+		require.LessOrEqual(t, len(pe.clusters), 50)
+	}
+
+	top := pe.GetPatterns(5)
+	var templates []string
+	for _, p := range top {
+		templates = append(templates, p.Template)
+		assert.Equal(t, n/10, p.Count)
+		assert.Zero(t, p.CountError)
+	}
+	assert.ElementsMatch(t, []string{"user * logged in from *", "connection to * timed out after *", "cache miss for key *", "request * took *", "health check ok"}, templates)
+	for _, p := range pe.GetPatterns(0)[5:] {
+		assert.LessOrEqual(t, p.CountError, n/50)
+		assert.GreaterOrEqual(t, p.Count, 1)
+		assert.LessOrEqual(t, p.Count-p.CountError, 1)
+	}
+	assert.Equal(t, n, pe.TotalLogs())
+
+	_, err = NewPatternExtractor(WithHeavyHitters(0))
+	assert.Error(t, err)
+}
+
+func BenchmarkPatternExtractorHeavyHitters(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		b.Run(fmt.Sprintf("logs=%d", n), func(b *testing.B) {
+			var heap uint64
+			for i := 0; i < b.N; i++ {
+				pe, err := NewPatternExtractor(WithHeavyHitters(20))
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < n; j++ {
+					_ = pe.AddLog(heavyHitterLogs(j))
+				}
+				runtime.GC()
+				var ms runtime.MemStats
+				runtime.ReadMemStats(&ms)
+				heap = ms.HeapInuse
+				runtime.KeepAlive(pe)
+			}
+			b.ReportMetric(float64(heap), "heap-bytes")
+		})
+	}
+}
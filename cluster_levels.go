@@ -0,0 +1,72 @@
+package logparser
+
+import (
+	"strings"
+)
+
+// ExtractPatternsByLevel is like ExtractPatterns, but clusters the entries of
+// each level separately, so that frequent info messages don't push the error
+// patterns out of the top maxPatterns. Entries of LevelUnknown get the level
+// guessed from their content.
+func ExtractPatternsByLevel(entries []LogEntry, maxPatterns int) map[Level][]LogPattern {
+	pe, err := NewPatternExtractor()
+	if err != nil {
+		return map[Level][]LogPattern{}
+	}
+	for _, entry := range entries {
+		_ = pe.AddEntry(entry)
+	}
+	return pe.GetPatternsByLevel(maxPatterns)
+}
+
+// AddEntry adds the content of entry to the clusters of its level, which are
+// kept apart from each other and from the clusters of AddLog. Entries of
+// LevelUnknown get the level guessed from their content.
+func (pe *PatternExtractor) AddEntry(entry LogEntry) error {
+	if strings.TrimSpace(entry.Content) == "" {
+		return nil
+	}
+	level := entry.Level
+	if level == LevelUnknown {
+		level = GuessLevel(entry.Content)
+	}
+	l, err := pe.levelExtractor(level)
+	if err != nil {
+		return err
+	}
+	return l.AddLogWithTimestamp(entry.Content, entry.Timestamp)
+}
+
+func (pe *PatternExtractor) levelExtractor(level Level) (*PatternExtractor, error) {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	l := pe.levels[level]
+	if l == nil {
+		l = &PatternExtractor{cfg: pe.cfg, trendBucket: pe.trendBucket, trendBuckets: pe.trendBuckets, sensitive: pe.sensitive, heavyHitters: pe.heavyHitters, samples: pe.samples, hashAlgorithm: pe.hashAlgorithm}
+		if err := l.reset(); err != nil {
+			return nil, err
+		}
+		pe.levels[level] = l
+	}
+	return l, nil
+}
+
+// GetPatternsByLevel returns the patterns of the entries added with AddEntry,
+// up to maxPatterns per level (0 = all). Percentages are relative to the
+// level.
+func (pe *PatternExtractor) GetPatternsByLevel(maxPatterns int) map[Level][]LogPattern {
+	pe.lock.Lock()
+	levels := make(map[Level]*PatternExtractor, len(pe.levels))
+	for level, l := range pe.levels {
+		levels[level] = l
+	}
+	pe.lock.Unlock()
+
+	res := make(map[Level][]LogPattern, len(levels))
+	for level, l := range levels {
+		if patterns := l.GetPatterns(maxPatterns); len(patterns) > 0 {
+			res[level] = patterns
+		}
+	}
+	return res
+}
@@ -0,0 +1,62 @@
+package logparser
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractPatternsByLevel(t *testing.T) {
+	var entries []LogEntry
+	var lines []string
+	for i := 0; i < 50; i++ {
+		for _, level := range []string{"INFO", "INFO", "INFO", "ERROR"} {
+			line := fmt.Sprintf("request %d: %s calling /api/users", i, level)
+			entries = append(entries, LogEntry{Content: line})
+			lines = append(lines, line)
+		}
+	}
+	entries = append(entries, LogEntry{Content: "disk almost full", Level: LevelWarning}, LogEntry{Content: "   "})
+
+	// Without levels, the error lines join the info cluster.
+	require.Len(t, ExtractPatterns(lines, 0), 1)
+
+	patterns := ExtractPatternsByLevel(entries, 0)
+	assert.Equal(t, map[Level][]LogPattern{
+		LevelInfo:    {{ClusterID: 1, Hash: TemplateHash("request * INFO calling /api/users"), Template: "request * INFO calling /api/users", Count: 150, Percentage: 100, Example: "request 0: INFO calling /api/users"}},
+		LevelError:   {{ClusterID: 1, Hash: TemplateHash("request * ERROR calling /api/users"), Template: "request * ERROR calling /api/users", Count: 50, Percentage: 100, Example: "request 0: ERROR calling /api/users"}},
+		LevelWarning: {{ClusterID: 1, Hash: TemplateHash("disk almost full"), Template: "disk almost full", Count: 1, Percentage: 100, Example: "disk almost full"}},
+	}, patterns)
+}
+
+func TestPatternExtractorAddEntry(t *testing.T) {
+	pe, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, pe.AddLog("cache miss for key 1"))
+	require.NoError(t, pe.AddEntry(LogEntry{Content: "cache miss for key 2", Level: LevelError}))
+	require.NoError(t, pe.AddEntry(LogEntry{Content: "cache miss for key 3", Level: LevelInfo}))
+
+	// AddLog and AddEntry clusters are kept apart.
+	assert.Equal(t, 1, pe.TotalLogs())
+	require.Len(t, pe.GetPatterns(0), 1)
+	assert.Equal(t, 1, pe.GetPatterns(0)[0].Count)
+	byLevel := pe.GetPatternsByLevel(0)
+	assert.Len(t, byLevel, 2)
+	assert.Equal(t, "cache miss for key 2", byLevel[LevelError][0].Example)
+
+	// Per-level clusters survive a state round trip.
+	var buf bytes.Buffer
+	require.NoError(t, pe.SaveState(&buf))
+	restored, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, restored.LoadState(&buf))
+	assert.Equal(t, byLevel, restored.GetPatternsByLevel(0))
+	require.NoError(t, restored.AddEntry(LogEntry{Content: "cache miss for key 4", Level: LevelError}))
+	assert.Equal(t, 2, restored.GetPatternsByLevel(0)[LevelError][0].Count)
+
+	require.NoError(t, restored.Reset())
+	assert.Empty(t, restored.GetPatternsByLevel(0))
+}
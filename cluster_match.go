@@ -0,0 +1,116 @@
+package logparser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	goDrain "github.com/jaeyo/go-drain3/pkg/drain3"
+)
+
+// Match returns the learned pattern line belongs to, without changing any
+// cluster, count or template. It reports false if no cluster reaches the
+// similarity threshold, i.e. if AddLog would start a new cluster for line,
+// which makes it suitable for flagging lines unlike anything seen so far.
+// The Percentage of the returned pattern is not set.
+func (pe *PatternExtractor) Match(line string) (*LogPattern, bool) {
+	if strings.TrimSpace(line) == "" {
+		return nil, false
+	}
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	cluster := lookupCluster(pe.drain, clusterLine(line))
+	if cluster == nil {
+		return nil, false
+	}
+	return pe.pattern(cluster), true
+}
+
+// MatchOrAdd adds line like AddLog and returns its pattern, reporting
+// whether line started a new cluster. Empty lines are ignored and return a
+// nil pattern. The Percentage of the returned pattern is not set.
+func (pe *PatternExtractor) MatchOrAdd(line string) (*LogPattern, bool, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, false, nil
+	}
+	if pe.sensitive != nil {
+		pe.sensitive.observe(line, time.Time{})
+	}
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	cluster, created, err := pe.add(line, line, time.Time{})
+	if err != nil || cluster == nil {
+		return nil, false, err
+	}
+	return pe.pattern(cluster), created, nil
+}
+
+// pattern returns the LogPattern of cluster; the caller must hold the lock.
+func (pe *PatternExtractor) pattern(cluster *goDrain.LogCluster) *LogPattern {
+	res := &LogPattern{ClusterID: cluster.ClusterId, Template: pe.template(cluster)}
+	res.Hash = pe.templateHash(res.Template)
+	if stat := pe.clusters[cluster.ClusterId]; stat != nil {
+		res.Count, res.CountError, res.Example, res.FirstSeen, res.LastSeen = stat.count, stat.countError, stat.example, stat.firstSeen, stat.lastSeen
+	}
+	return res
+}
+
+// lookupCluster finds the cluster Drain3 would add content to, following the
+// same prefix tree search and similarity rule as Drain.AddLogMessage, but
+// without creating clusters or touching the LRU order. Drain.Match isn't
+// used because it requires a perfect match rather than the similarity
+// threshold.
+func lookupCluster(d *goDrain.Drain, content string) *goDrain.LogCluster {
+	content = strings.TrimSpace(content)
+	for _, delimiter := range d.ExtraDelimiters {
+		content = strings.ReplaceAll(content, delimiter, " ")
+	}
+	tokens := strings.Split(content, " ")
+
+	// The first level groups clusters by token count, the next ones by the
+	// leading tokens, falling back to the wildcard node.
+	node, ok := d.RootNode.KeyToChildNode[strconv.Itoa(len(tokens))]
+	if !ok {
+		return nil
+	}
+	depth := int64(1)
+	for _, token := range tokens {
+		if depth >= d.MaxNodeDepth || depth == int64(len(tokens)) {
+			break
+		}
+		next, ok := node.KeyToChildNode[token]
+		if !ok {
+			if next, ok = node.KeyToChildNode[d.ParamStr]; !ok {
+				return nil
+			}
+		}
+		node = next
+		depth++
+	}
+
+	var best *goDrain.LogCluster
+	bestSim, bestParams := -1.0, -1
+	for _, id := range node.ClusterIds {
+		cluster, ok := d.IdToCluster.Peek(id)
+		if !ok || len(cluster.LogTemplateTokens) != len(tokens) {
+			continue
+		}
+		same, params := 0, 0
+		for i, t := range cluster.LogTemplateTokens {
+			switch t {
+			case d.ParamStr:
+				params++
+			case tokens[i]:
+				same++
+			}
+		}
+		sim := float64(same) / float64(len(tokens))
+		if sim > bestSim || (sim == bestSim && params > bestParams) {
+			best, bestSim, bestParams = cluster, sim, params
+		}
+	}
+	if bestSim < d.SimTh {
+		return nil
+	}
+	return best
+}
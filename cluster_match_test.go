@@ -0,0 +1,93 @@
+package logparser
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternExtractorMatch(t *testing.T) {
+	pe, err := NewPatternExtractor()
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, pe.AddLog(fmt.Sprintf("user %d logged in from 10.0.0.%d", i, i)))
+		require.NoError(t, pe.AddLog(fmt.Sprintf("Failed to get location: US%03d | RemoteServiceException", i)))
+	}
+	before := pe.GetPatterns(0)
+	require.Len(t, before, 2)
+
+	p, ok := pe.Match("user 500 logged in from 192.168.1.1")
+	require.True(t, ok)
+	assert.Equal(t, &LogPattern{ClusterID: 1, Hash: TemplateHash("user * logged in from *"), Template: "user * logged in from *", Count: 100, Example: "user 0 logged in from 10.0.0.0"}, p)
+
+	// Structurally novel lines don't match, not even the nearest cluster.
+	for _, line := range []string{
+		"disk quota exceeded on /var/lib/data",
+		"user 500 was banned by admin today",
+		"panic: runtime error: index out of range",
+	} {
+		p, ok := pe.Match(line)
+		assert.False(t, ok, line)
+		assert.Nil(t, p, line)
+	}
+	_, ok = pe.Match("   ")
+	assert.False(t, ok)
+
+	// Nothing changed.
+	assert.Equal(t, before, pe.GetPatterns(0))
+	assert.Equal(t, 200, pe.TotalLogs())
+
+	p, created, err := pe.MatchOrAdd("user 501 logged in from 192.168.1.2")
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, 101, p.Count)
+	p, created, err = pe.MatchOrAdd("disk quota exceeded on /var/lib/data")
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, &LogPattern{ClusterID: 3, Hash: TemplateHash("disk quota exceeded on /var/lib/data"), Template: "disk quota exceeded on /var/lib/data", Count: 1, Example: "disk quota exceeded on /var/lib/data"}, p)
+	p, ok = pe.Match("disk quota exceeded on /var/lib/data")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), p.ClusterID)
+	p, created, err = pe.MatchOrAdd("")
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Nil(t, p)
+}
+
+// TestPatternExtractorMatchAgreesWithAdd checks that Match finds a cluster
+// exactly when adding the line would not create one.
+func TestPatternExtractorMatchAgreesWithAdd(t *testing.T) {
+	pe, err := NewPatternExtractor(WithDrainConfig(DrainConfig{Depth: 4, SimilarityThreshold: 0.6, MaxChildren: 5, MaxClusters: 100, ExtraDelimiters: []string{"="}}))
+	require.NoError(t, err)
+	lines := []string{
+		"GET /api/users 200 12ms",
+		"GET /api/orders 500 1200ms",
+		"POST /api/users 201 30ms",
+		"status=500 path=/a",
+		"status=404 path=/b",
+		"worker a started",
+		"worker b started",
+		"worker c stopped",
+		"worker d crashed unexpectedly",
+		"worker e crashed unexpectedly",
+		"connection reset by peer",
+	}
+	for i, line := range lines {
+		var state bytes.Buffer
+		require.NoError(t, pe.SaveState(&state))
+		matched, ok := pe.Match(line)
+		var after bytes.Buffer
+		require.NoError(t, pe.SaveState(&after))
+		assert.Equal(t, state.String(), after.String(), line)
+
+		added, created, err := pe.MatchOrAdd(line)
+		require.NoError(t, err)
+		assert.Equal(t, !created, ok, "line %d: %s", i, line)
+		if ok {
+			assert.Equal(t, matched.ClusterID, added.ClusterID, line)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package logparser
+
+import (
+	"strings"
+)
+
+// MergeSimilarPatterns merges the patterns whose templates are at least
+// threshold similar, e.g. Drain3 clusters differing only in a trailing
+// token. The similarity of two templates is the share of positions, out of
+// the longer template, holding the same token or a wildcard in either one.
+//
+// Patterns are taken from the most frequent and each joins the first group
+// it is similar enough to. A group keeps the more general template (the one
+// with more wildcards, then the more frequent one) with its ClusterID, Hash
+// and Example, sums the counts, count errors and percentages, spans the seen
+// times of its members and lists them in SubPatterns. The result is sorted
+// by count.
+// A threshold outside (0, 1] returns patterns unchanged.
+func MergeSimilarPatterns(patterns []LogPattern, threshold float64) []LogPattern {
+	if threshold <= 0 || threshold > 1 || len(patterns) < 2 {
+		return patterns
+	}
+	sorted := append([]LogPattern(nil), patterns...)
+	sortPatterns(sorted)
+
+	type group struct {
+		LogPattern
+		tokens  []string
+		members []LogPattern
+	}
+	var groups []*group
+	for _, p := range sorted {
+		tokens := strings.Split(p.Template, " ")
+		var g *group
+		for _, candidate := range groups {
+			if templateSimilarity(candidate.tokens, tokens) >= threshold {
+				g = candidate
+				break
+			}
+		}
+		if g == nil {
+			groups = append(groups, &group{LogPattern: p, tokens: tokens, members: []LogPattern{p}})
+			continue
+		}
+		g.members = append(g.members, p)
+		if wildcards(tokens) > wildcards(g.tokens) {
+			g.ClusterID, g.Hash, g.Template, g.Example, g.tokens = p.ClusterID, p.Hash, p.Template, p.Example, tokens
+		}
+		g.Count += p.Count
+		g.CountError += p.CountError
+		g.Percentage += p.Percentage
+		if !p.FirstSeen.IsZero() && (g.FirstSeen.IsZero() || p.FirstSeen.Before(g.FirstSeen)) {
+			g.FirstSeen = p.FirstSeen
+		}
+		if p.LastSeen.After(g.LastSeen) {
+			g.LastSeen = p.LastSeen
+		}
+	}
+
+	res := make([]LogPattern, 0, len(groups))
+	for _, g := range groups {
+		if len(g.members) > 1 {
+			g.SubPatterns = g.members
+		}
+		res = append(res, g.LogPattern)
+	}
+	sortPatterns(res)
+	return res
+}
+
+// templateSimilarity returns the share of positions of the longer template
+// where the tokens are equal or either one is a wildcard.
+func templateSimilarity(a, b []string) float64 {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	if n == 0 {
+		return 1
+	}
+	same := 0
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] || isDrainWildcard(a[i]) || isDrainWildcard(b[i]) {
+			same++
+		}
+	}
+	return float64(same) / float64(n)
+}
+
+func wildcards(tokens []string) int {
+	n := 0
+	for _, t := range tokens {
+		if isDrainWildcard(t) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSimilarPatterns(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	retried3 := LogPattern{ClusterID: 1, Template: "request to /api/users failed after 3 retries", Count: 50, Percentage: 50, Example: "request to /api/users failed after 3 retries", FirstSeen: t0.Add(time.Minute), LastSeen: t0.Add(time.Hour)}
+	retriedAny := LogPattern{ClusterID: 2, Template: "request to /api/users failed after * retries", Count: 20, Percentage: 20, Example: "request to /api/users failed after 5 retries", FirstSeen: t0, LastSeen: t0.Add(time.Minute)}
+	retriedTwice := LogPattern{ClusterID: 3, Template: "request to /api/users failed after 2 retries twice", Count: 10, Percentage: 10, Example: "request to /api/users failed after 2 retries twice"}
+	other := LogPattern{ClusterID: 4, Template: "disk quota exceeded on *", Count: 20, Percentage: 20, Example: "disk quota exceeded on /var"}
+
+	merged := MergeSimilarPatterns([]LogPattern{other, retriedTwice, retriedAny, retried3}, 0.8)
+	require.Len(t, merged, 2)
+	assert.Equal(t, LogPattern{
+		ClusterID:   2,
+		Template:    "request to /api/users failed after * retries",
+		Count:       80,
+		Percentage:  80,
+		Example:     "request to /api/users failed after 5 retries",
+		FirstSeen:   t0,
+		LastSeen:    t0.Add(time.Hour),
+		SubPatterns: []LogPattern{retried3, retriedAny, retriedTwice},
+	}, merged[0])
+	assert.Equal(t, other, merged[1])
+
+	// A stricter threshold keeps the pattern with the extra token apart.
+	merged = MergeSimilarPatterns([]LogPattern{retried3, retriedAny, retriedTwice, other}, 0.9)
+	require.Len(t, merged, 3)
+	assert.Equal(t, 70, merged[0].Count)
+	assert.Equal(t, []LogPattern{retried3, retriedAny}, merged[0].SubPatterns)
+	assert.Equal(t, other, merged[1])
+	assert.Equal(t, retriedTwice, merged[2])
+
+	input := []LogPattern{retried3, retriedAny}
+	assert.Equal(t, input, MergeSimilarPatterns(input, 0))
+	assert.Equal(t, input, MergeSimilarPatterns(input, 1.5))
+}
+
+func TestExtractPatternsMergeThreshold(t *testing.T) {
+	logs := []string{
+		"job 1 finished in 10 ms",
+		"job 2 finished in 12 ms",
+		"job 3 finished in 9 ms ok",
+		"job 4 finished in 7 ms ok",
+	}
+	patterns, err := ExtractPatternsWithConfig(logs, 0, DefaultDrainConfig())
+	require.NoError(t, err)
+	require.Len(t, patterns, 2)
+
+	cfg := DefaultDrainConfig()
+	cfg.MergeThreshold = 0.8
+	patterns, err = ExtractPatternsWithConfig(logs, 0, cfg)
+	require.NoError(t, err)
+	require.Len(t, patterns, 1)
+	assert.Equal(t, 4, patterns[0].Count)
+	assert.Equal(t, 100.0, patterns[0].Percentage)
+	assert.Len(t, patterns[0].SubPatterns, 2)
+
+	cfg.MergeThreshold = 2
+	_, err = ExtractPatternsWithConfig(logs, 0, cfg)
+	assert.EqualError(t, err, "merge threshold must be in [0, 1], got 2")
+}
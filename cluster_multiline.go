@@ -0,0 +1,103 @@
+package logparser
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExtractPatternsFromEntries is like ExtractPatterns, but first assembles
+// multiline messages, such as stack traces, from the entries with a
+// MultilineCollector, so that each message is clustered once instead of once
+// per line. Messages are clustered on their first line, and the full message
+// is kept as the pattern Example.
+func ExtractPatternsFromEntries(entries []LogEntry, maxPatterns int) []LogPattern {
+	pe, err := NewPatternExtractor()
+	if err != nil {
+		return []LogPattern{}
+	}
+	ch := make(chan LogEntry)
+	go func() {
+		defer close(ch)
+		for _, entry := range entries {
+			ch <- entry
+		}
+	}()
+	collectMessages(ch, time.Second, func(msg Message) {
+		_ = pe.addMessage(msg.Content, msg.Timestamp)
+	})
+	return pe.GetPatterns(maxPatterns)
+}
+
+// AddEntries assembles multiline messages from entries until the channel is
+// closed, and adds each message with AddMessage. A message is complete when
+// the next one starts or after multilineTimeout without new lines from its
+// source. It returns the first error of AddMessage, after all the messages
+// have been added.
+func (pe *PatternExtractor) AddEntries(entries <-chan LogEntry, multilineTimeout time.Duration) error {
+	var firstErr error
+	collectMessages(entries, multilineTimeout, func(msg Message) {
+		if err := pe.AddMessage(msg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	})
+	return firstErr
+}
+
+// AddMessage adds msg to the clusters of its level, like AddEntry. A
+// multiline message is clustered on its first line, and the full message is
+// kept as the example.
+func (pe *PatternExtractor) AddMessage(msg Message) error {
+	if strings.TrimSpace(msg.Content) == "" {
+		return nil
+	}
+	level := msg.Level
+	if level == LevelUnknown {
+		level = GuessLevel(msg.Content)
+	}
+	l, err := pe.levelExtractor(level)
+	if err != nil {
+		return err
+	}
+	return l.addMessage(msg.Content, msg.Timestamp)
+}
+
+// addMessage adds the first line of content to its cluster, keeping content
+// as the example. Sensitive detection covers the whole message.
+func (pe *PatternExtractor) addMessage(content string, ts time.Time) error {
+	first, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if strings.TrimSpace(first) == "" {
+		return nil
+	}
+	if pe.sensitive != nil {
+		pe.sensitive.observe(content, ts)
+	}
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	_, _, err := pe.add(first, content, ts)
+	return err
+}
+
+// collectMessages runs entries through a MultilineCollector and calls add, from
+// a single goroutine, for each assembled message. It returns once entries is
+// closed and all the messages have been passed to add.
+func collectMessages(entries <-chan LogEntry, timeout time.Duration, add func(Message)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	collector := NewMultilineCollector(ctx, timeout, multilineCollectorLimit)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for msg := range collector.Messages {
+			add(msg)
+		}
+	}()
+	for entry := range entries {
+		collector.Add(entry)
+	}
+	collector.Close()
+	wg.Wait()
+}
@@ -0,0 +1,74 @@
+package logparser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var javaTrace = []string{
+	"2024-01-15 10:00:00 ERROR Failed to get latest location by identifier: USJOT",
+	"p44.exception.RemoteServiceException: Failed to make remote service call.",
+	"\tat com.p44.location.LocationClient.get(LocationClient.java:42)",
+	"\tat com.p44.location.LocationService.latest(LocationService.java:17)",
+	"\tat com.p44.eta.DetectEtaChanges.run(DetectEtaChanges.java:88)",
+	"Caused by: java.net.SocketTimeoutException: Read timed out",
+	"\tat java.net.SocketInputStream.read(SocketInputStream.java:150)",
+	"\t... 3 more",
+}
+
+func TestExtractPatternsFromEntries(t *testing.T) {
+	var entries []LogEntry
+	for i, line := range javaTrace {
+		// lines of another source are interleaved with the trace
+		entries = append(entries,
+			LogEntry{Content: line, Source: "eta"},
+			LogEntry{Content: "2024-01-15 10:00:0" + string(rune('0'+i)) + " INFO heartbeat ok", Source: "web"},
+		)
+	}
+
+	patterns := ExtractPatternsFromEntries(entries, 0)
+	require.Len(t, patterns, 2)
+	var trace *LogPattern
+	for i := range patterns {
+		assert.NotContains(t, patterns[i].Template, "\tat ")
+		if strings.Contains(patterns[i].Template, "ERROR") {
+			trace = &patterns[i]
+		}
+	}
+	require.NotNil(t, trace)
+	assert.Equal(t, 1, trace.Count)
+	assert.NotContains(t, trace.Template, "\n")
+	assert.Equal(t, strings.Join(javaTrace, "\n"), trace.Example)
+	for _, p := range patterns {
+		if p.ClusterID != trace.ClusterID {
+			assert.Equal(t, 8, p.Count)
+		}
+	}
+}
+
+func TestPatternExtractorAddEntries(t *testing.T) {
+	pe, err := NewPatternExtractor()
+	require.NoError(t, err)
+
+	ch := make(chan LogEntry)
+	done := make(chan error)
+	go func() { done <- pe.AddEntries(ch, 10*time.Millisecond) }()
+	for i := 0; i < 3; i++ {
+		for _, line := range javaTrace {
+			ch <- LogEntry{Content: line}
+		}
+	}
+	close(ch)
+	require.NoError(t, <-done)
+
+	byLevel := pe.GetPatternsByLevel(0)
+	require.Len(t, byLevel, 1)
+	require.Len(t, byLevel[LevelError], 1)
+	p := byLevel[LevelError][0]
+	assert.Equal(t, 3, p.Count)
+	assert.Equal(t, strings.Join(javaTrace, "\n"), p.Example)
+}
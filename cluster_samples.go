@@ -0,0 +1,44 @@
+package logparser
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// WithClusterSamples keeps a uniform sample of up to n logs per cluster,
+// returned in LogPattern.Samples, e.g. to pass to ExtractParameters and see
+// which values fill the wildcards. Only the first line of multiline messages
+// is sampled. Samples are chosen by reservoir sampling with a fixed seed, so
+// the same input gives the same samples. They are redacted like the examples
+// with WithClusterSensitiveDetection and are not kept by SaveState.
+func WithClusterSamples(n int) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		if n <= 0 {
+			return fmt.Errorf("cluster samples must be positive, got %d", n)
+		}
+		pe.samples = n
+		return nil
+	}
+}
+
+// addSample adds log, the count-th log of stat, to the sample of stat. The
+// caller must hold the lock.
+func (pe *PatternExtractor) addSample(stat *clusterStat, log string) {
+	i := len(stat.samples)
+	if i >= pe.samples {
+		if pe.rng == nil {
+			pe.rng = rand.New(rand.NewSource(1))
+		}
+		if i = pe.rng.Intn(stat.count); i >= pe.samples {
+			return
+		}
+	}
+	if pe.sensitive != nil {
+		log = pe.sensitive.redact(log)
+	}
+	if i == len(stat.samples) {
+		stat.samples = append(stat.samples, log)
+		return
+	}
+	stat.samples[i] = log
+}
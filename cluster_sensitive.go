@@ -0,0 +1,84 @@
+package logparser
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// clusterSensitive tracks the sensitive data found by a PatternExtractor. It
+// is shared with the per-level extractors.
+type clusterSensitive struct {
+	patterns []PrecompiledPattern
+
+	lock  sync.Mutex
+	stats map[sensitivePatternKey]*sensitivePatternStat
+}
+
+// WithClusterSensitiveDetection scans every log for sensitive data with the
+// embedded patterns of at least minConfidence ("high", "medium" or "low").
+// Secrets are redacted from the cluster examples, and the findings are
+// counted per pattern name and log pattern hash (see GetSensitivePatterns).
+func WithClusterSensitiveDetection(minConfidence string) PatternExtractorOption {
+	return func(pe *PatternExtractor) error {
+		patterns, err := getOrLoadPatterns(minConfidence, slog.Default())
+		if err != nil {
+			return err
+		}
+		pe.sensitive = &clusterSensitive{patterns: patterns, stats: map[sensitivePatternKey]*sensitivePatternStat{}}
+		return nil
+	}
+}
+
+// observe counts the sensitive data in log. Findings are grouped by the
+// pattern of the redacted log, so that different secrets in the same kind of
+// log share a counter. Like for the parser, a log counts once per sensitive
+// pattern however many matches it has.
+func (s *clusterSensitive) observe(log string, ts time.Time) {
+	matches := FindAllSensitiveData(log, "", s.patterns)
+	if len(matches) == 0 {
+		return
+	}
+	sample := s.redact(log)
+	hash := NewPattern(sample).Hash()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, occurrences := range groupSensitiveMatches(matches, "") {
+		m := occurrences[0]
+		key := m.sensitivePatternKey
+		key.hash = hash
+		stat := s.stats[key]
+		if stat == nil {
+			stat = &sensitivePatternStat{sample: sample, sensitiveKey: m.MaskedValue(RedactionConfig{}), regex: m.regex, name: m.Name, hash: hash, severity: m.Severity, category: m.Category}
+			s.stats[key] = stat
+		}
+		stat.messages++
+		stat.occurrences += len(occurrences)
+		stat.seen.add(ts)
+		for _, o := range occurrences {
+			stat.values.add(o.Value)
+		}
+	}
+}
+
+func (s *clusterSensitive) redact(log string) string {
+	redacted, _ := RedactSensitiveData(log, s.patterns)
+	return redacted
+}
+
+// GetSensitivePatterns returns the sensitive data findings of the logs added
+// so far, including with AddEntry. It returns nil without
+// WithClusterSensitiveDetection.
+func (pe *PatternExtractor) GetSensitivePatterns() []SensitiveLogCounter {
+	s := pe.sensitive
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make([]SensitiveLogCounter, 0, len(s.stats))
+	for _, ps := range s.stats {
+		res = append(res, SensitiveLogCounter{Pattern: ps.sensitiveKey, Messages: ps.messages, Occurrences: ps.occurrences, UniqueValues: ps.values.count(), Sample: ps.sample, Regex: ps.regex, Name: ps.name, Hash: ps.hash, Severity: ps.severity, Category: ps.category, FirstSeen: ps.seen.first, LastSeen: ps.seen.last})
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternExtractorSensitiveDetection(t *testing.T) {
+	pe, err := NewPatternExtractor(WithClusterSensitiveDetection("medium"))
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	keys := []string{"AKIAI44QH8DHBQWERTYU", "AKIAJ55RI9EICRXFSUZV", "AKIAI44QH8DHBQWERTYU"}
+	for i, key := range keys {
+		require.NoError(t, pe.AddLogWithTimestamp("uploading with access key "+key+" to bucket logs", t0.Add(time.Duration(i)*time.Minute)))
+	}
+	require.NoError(t, pe.AddEntry(LogEntry{Content: "ERROR upload failed for key " + keys[1], Level: LevelError}))
+	require.NoError(t, pe.AddLog("upload finished"))
+
+	patterns := pe.GetPatterns(0)
+	require.Len(t, patterns, 2)
+	assert.Equal(t, 3, patterns[0].Count)
+	for _, p := range append(patterns, pe.GetPatternsByLevel(0)[LevelError]...) {
+		for _, key := range keys {
+			assert.NotContains(t, p.Example, key)
+			assert.NotContains(t, p.Template, key)
+		}
+	}
+
+	counters := pe.GetSensitivePatterns()
+	require.NotEmpty(t, counters)
+	byHash := map[string]SensitiveLogCounter{}
+	for _, c := range counters {
+		if c.Name != "AWS" {
+			continue
+		}
+		assert.Equal(t, "critical", c.Severity)
+		for _, key := range keys {
+			assert.NotContains(t, c.Sample, key)
+			assert.NotContains(t, c.Pattern, key)
+		}
+		byHash[c.Hash] = c
+	}
+	upload := byHash[NewPattern("uploading with access key **** to bucket logs").Hash()]
+	assert.Equal(t, 3, upload.Messages)
+	assert.Equal(t, 2, upload.UniqueValues)
+	assert.Equal(t, t0, upload.FirstSeen)
+	assert.Equal(t, t0.Add(2*time.Minute), upload.LastSeen)
+	assert.Equal(t, 1, byHash[NewPattern("ERROR upload failed for key ****").Hash()].Messages)
+
+	plain, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, plain.AddLog("uploading with access key "+keys[0]))
+	assert.Nil(t, plain.GetSensitivePatterns())
+	assert.Contains(t, plain.GetPatterns(0)[0].Example, keys[0])
+}
@@ -0,0 +1,144 @@
+package logparser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	goDrain "github.com/jaeyo/go-drain3/pkg/drain3"
+)
+
+// PatternExtractorStateVersion is the version of the state written by
+// SaveState. LoadState rejects states of any other version.
+const PatternExtractorStateVersion = 1
+
+// patternExtractorState is the JSON document written by SaveState.
+type patternExtractorState struct {
+	Version   int                 `json:"version"`
+	TotalLogs int                 `json:"total_logs"`
+	Drain     json.RawMessage     `json:"drain"`
+	Clusters  []clusterStateEntry `json:"clusters"`
+	// Levels holds the state of the per-level extractors; their Version is
+	// not set.
+	Levels map[Level]*patternExtractorState `json:"levels,omitempty"`
+}
+
+type clusterStateEntry struct {
+	ID         int64         `json:"id"`
+	Example    string        `json:"example"`
+	Count      int           `json:"count"`
+	CountError int           `json:"count_error,omitempty"`
+	FirstSeen  time.Time     `json:"first_seen,omitempty"`
+	LastSeen   time.Time     `json:"last_seen,omitempty"`
+	Trend      []BucketCount `json:"trend,omitempty"`
+}
+
+// SaveState writes the learned clusters, including the Drain3 parse tree,
+// templates, counts and examples, to w as JSON, so that LoadState can resume
+// from them after a restart. The per-level clusters of AddEntry are included.
+func (pe *PatternExtractor) SaveState(w io.Writer) error {
+	state, err := pe.state()
+	if err != nil {
+		return err
+	}
+	state.Version = PatternExtractorStateVersion
+	return json.NewEncoder(w).Encode(state)
+}
+
+func (pe *PatternExtractor) state() (*patternExtractorState, error) {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+
+	drain, err := pe.drain.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	state := &patternExtractorState{
+		TotalLogs: pe.totalCount,
+		Drain:     drain,
+		Clusters:  make([]clusterStateEntry, 0, len(pe.clusters)),
+	}
+	for _, c := range pe.drain.GetClusters() {
+		if stat := pe.clusters[c.ClusterId]; stat != nil {
+			state.Clusters = append(state.Clusters, clusterStateEntry{ID: c.ClusterId, Example: stat.example, Count: stat.count, CountError: stat.countError, FirstSeen: stat.firstSeen, LastSeen: stat.lastSeen, Trend: stat.trend})
+		}
+	}
+	for level, l := range pe.levels {
+		if state.Levels == nil {
+			state.Levels = map[Level]*patternExtractorState{}
+		}
+		if state.Levels[level], err = l.state(); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// LoadState replaces the clusters and counts with the ones saved by
+// SaveState. Logs added afterwards join the restored clusters. The clustering
+// settings stored in the state take precedence over the extractor's options
+// until Reset is called. On error the extractor is left unchanged.
+func (pe *PatternExtractor) LoadState(r io.Reader) error {
+	var state patternExtractorState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("failed to decode pattern extractor state: %w", err)
+	}
+	if state.Version != PatternExtractorStateVersion {
+		return fmt.Errorf("unsupported pattern extractor state version %d, want %d", state.Version, PatternExtractorStateVersion)
+	}
+	restored, err := pe.fromState(&state)
+	if err != nil {
+		return err
+	}
+
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	pe.drain = restored.drain
+	pe.clusters = restored.clusters
+	pe.totalCount = restored.totalCount
+	pe.levels = restored.levels
+	return nil
+}
+
+// fromState returns an extractor with the options of pe and the clusters of
+// state.
+func (pe *PatternExtractor) fromState(state *patternExtractorState) (*PatternExtractor, error) {
+	if len(state.Drain) == 0 {
+		return nil, errors.New("pattern extractor state has no drain")
+	}
+	drain := &goDrain.Drain{}
+	if err := drain.UnmarshalJSON(state.Drain); err != nil {
+		return nil, fmt.Errorf("failed to decode drain state: %w", err)
+	}
+	if drain.RootNode == nil || drain.IdToCluster == nil {
+		return nil, errors.New("invalid drain state")
+	}
+	res := &PatternExtractor{
+		cfg:          pe.cfg,
+		trendBucket:  pe.trendBucket,
+		trendBuckets: pe.trendBuckets,
+		sensitive:    pe.sensitive,
+		heavyHitters: pe.heavyHitters,
+		samples:      pe.samples,
+		drain:        drain,
+		clusters:     make(map[int64]*clusterStat, len(state.Clusters)),
+		totalCount:   state.TotalLogs,
+		levels:       make(map[Level]*PatternExtractor, len(state.Levels)),
+	}
+	for _, c := range state.Clusters {
+		res.clusters[c.ID] = &clusterStat{example: c.Example, count: c.Count, countError: c.CountError, firstSeen: c.FirstSeen, lastSeen: c.LastSeen, trend: c.Trend}
+	}
+	for level, l := range state.Levels {
+		if l == nil {
+			continue
+		}
+		restored, err := pe.fromState(l)
+		if err != nil {
+			return nil, fmt.Errorf("level %s: %w", level, err)
+		}
+		res.levels[level] = restored
+	}
+	return res, nil
+}
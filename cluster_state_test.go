@@ -0,0 +1,97 @@
+package logparser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternExtractorState(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	pe, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, pe.AddLogWithTimestamp("user 1 logged in", t0))
+	require.NoError(t, pe.AddLog("user 2 logged in"))
+	require.NoError(t, pe.AddLog("DetectEtaChanges failed | java.lang.NullPointerException"))
+
+	var buf bytes.Buffer
+	require.NoError(t, pe.SaveState(&buf))
+
+	restored, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, restored.LoadState(&buf))
+	assert.Equal(t, pe.GetPatterns(0), restored.GetPatterns(0))
+	assert.Equal(t, 3, restored.TotalLogs())
+
+	// New logs continue the restored clusters.
+	require.NoError(t, restored.AddLogWithTimestamp("user 3 logged in", t0.Add(time.Hour)))
+	require.NoError(t, restored.AddLog("cache miss"))
+	patterns := restored.GetPatterns(0)
+	require.Len(t, patterns, 3)
+	assert.Equal(t, LogPattern{ClusterID: 1, Hash: TemplateHash("user * logged in"), Template: "user * logged in", Count: 3, Percentage: 60, Example: "user 1 logged in", FirstSeen: t0, LastSeen: t0.Add(time.Hour)}, patterns[0])
+	assert.Equal(t, int64(2), patterns[1].ClusterID)
+	assert.Equal(t, int64(3), patterns[2].ClusterID)
+	assert.Equal(t, "cache miss", patterns[2].Template)
+	assert.Equal(t, 5, restored.TotalLogs())
+}
+
+func TestPatternExtractorStateEmpty(t *testing.T) {
+	pe, err := NewPatternExtractor()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, pe.SaveState(&buf))
+
+	restored, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, restored.AddLog("stale"))
+	require.NoError(t, restored.LoadState(&buf))
+	assert.Empty(t, restored.GetPatterns(0))
+	assert.Equal(t, 0, restored.TotalLogs())
+	require.NoError(t, restored.AddLog("fresh"))
+	assert.Equal(t, "fresh", restored.GetPatterns(0)[0].Example)
+}
+
+func TestPatternExtractorStateLarge(t *testing.T) {
+	pe, err := NewPatternExtractor()
+	require.NoError(t, err)
+	for i := 0; i < 20000; i++ {
+		require.NoError(t, pe.AddLog(fmt.Sprintf("service%c%c failed with code %d", 'a'+i%26, 'a'+i/26%26, i)))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, pe.SaveState(&buf))
+
+	restored, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, restored.LoadState(&buf))
+	expected := pe.GetPatterns(0)
+	assert.Len(t, expected, 50) // up to MaxChildren prefixes, then the catch-all one
+	assert.Equal(t, expected, restored.GetPatterns(0))
+	assert.Equal(t, 20000, restored.TotalLogs())
+}
+
+func TestPatternExtractorStateErrors(t *testing.T) {
+	pe, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, pe.AddLog("user 1 logged in"))
+
+	for _, tc := range []struct {
+		state string
+		err   string
+	}{
+		{state: `{"version":2,"drain":{}}`, err: "unsupported pattern extractor state version 2, want 1"},
+		{state: `{"drain":{}}`, err: "unsupported pattern extractor state version 0, want 1"},
+		{state: `{"version":1}`, err: "pattern extractor state has no drain"},
+		{state: `{"version":1,"drain":{"MaxClusters":10}}`, err: "invalid drain state"},
+		{state: `not json`, err: "failed to decode pattern extractor state: invalid character 'o' in literal null (expecting 'u')"},
+	} {
+		assert.EqualError(t, pe.LoadState(strings.NewReader(tc.state)), tc.err)
+	}
+	// Failed loads leave the extractor unchanged.
+	assert.Equal(t, 1, pe.TotalLogs())
+	assert.Len(t, pe.GetPatterns(0), 1)
+}
@@ -1,9 +1,15 @@
 package logparser
 
 import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExtractPatterns_RemoteServiceException(t *testing.T) {
@@ -105,7 +111,7 @@ func TestExtractPatterns_WithUUIDs(t *testing.T) {
 
 func TestExtractPatterns_SortedByFrequency(t *testing.T) {
 	logs := []string{
-		"Database connection failed to host-123", // 1 occurrence
+		"Database connection failed to host-123",                                           // 1 occurrence
 		"Network timeout on endpoint /api/users", "Network timeout on endpoint /api/users", // 2 occurrences
 		"NullPointerException in service.process()", "NullPointerException in service.process()", "NullPointerException in service.process()", // 3 occurrences
 	}
@@ -223,3 +229,215 @@ func TestPatternExtractor_MaxPatternsLimit(t *testing.T) {
 	patterns := extractor.GetPatterns(3)
 	assert.LessOrEqual(t, len(patterns), 3, "Should respect maxPatterns limit")
 }
+
+// TestPatternExtractor_Concurrent tests that concurrent AddLog calls are all counted
+func TestPatternExtractor_Concurrent(t *testing.T) {
+	extractor, err := NewPatternExtractor()
+	require.NoError(t, err)
+
+	const goroutines, perGoroutine = 8, 12500
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				var log string
+				switch i % 4 {
+				case 0, 1:
+					log = fmt.Sprintf("Failed to get location: US%05d | RemoteServiceException", g*perGoroutine+i)
+				case 2:
+					log = fmt.Sprintf("connection to 10.0.%d.%d refused after %d retries", g, i%256, i%5)
+				default:
+					log = "DetectEtaChanges failed | java.lang.NullPointerException"
+				}
+				assert.NoError(t, extractor.AddLog(log))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.Equal(t, goroutines*perGoroutine, extractor.TotalLogs())
+	patterns := extractor.GetPatterns(0)
+	require.Len(t, patterns, 3)
+	total := 0
+	for _, p := range patterns {
+		total += p.Count
+	}
+	assert.Equal(t, goroutines*perGoroutine, total)
+	assert.Equal(t, 50000, patterns[0].Count)
+	assert.Equal(t, "Failed to get location: * | RemoteServiceException", patterns[0].Template)
+	assert.Equal(t, 25000, patterns[1].Count)
+	assert.Equal(t, 25000, patterns[2].Count)
+}
+
+// TestPatternExtractor_TimestampsAndReset tests per-pattern timestamps and Reset
+func TestPatternExtractor_TimestampsAndReset(t *testing.T) {
+	extractor, err := NewPatternExtractor(WithSimilarityThreshold(0.4), WithMaxClusters(10))
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, extractor.AddLogWithTimestamp("user 1 logged in", t0.Add(time.Minute)))
+	require.NoError(t, extractor.AddLogWithTimestamp("user 2 logged in", t0))
+	require.NoError(t, extractor.AddLog("user 3 logged in"))
+
+	patterns := extractor.GetPatterns(0)
+	require.Len(t, patterns, 1)
+	assert.Equal(t, LogPattern{ClusterID: 1, Hash: TemplateHash("user * logged in"), Template: "user * logged in", Count: 3, Percentage: 100, Example: "user 1 logged in", FirstSeen: t0, LastSeen: t0.Add(time.Minute)}, patterns[0])
+
+	require.NoError(t, extractor.Reset())
+	assert.Equal(t, 0, extractor.TotalLogs())
+	assert.Empty(t, extractor.GetPatterns(0))
+	require.NoError(t, extractor.AddLog("user 4 logged in"))
+	assert.Equal(t, "user 4 logged in", extractor.GetPatterns(0)[0].Example)
+
+	_, err = NewPatternExtractor(WithSimilarityThreshold(0))
+	assert.EqualError(t, err, "similarity threshold must be in (0, 1], got 0")
+	_, err = NewPatternExtractor(WithMaxClusters(-1))
+	assert.EqualError(t, err, "max clusters must be positive, got -1")
+}
+
+func TestExtractPatternsWithConfig(t *testing.T) {
+	logs := []string{
+		"request failed: timeout on db",
+		"request failed: refused by db",
+		"request failed: timeout on db",
+	}
+
+	// 3 of 5 tokens match: merged at the default threshold of 0.5.
+	patterns, err := ExtractPatternsWithConfig(logs, 0, DefaultDrainConfig())
+	require.NoError(t, err)
+	require.Len(t, patterns, 1)
+	assert.Equal(t, "request failed: * * db", patterns[0].Template)
+	assert.Equal(t, 3, patterns[0].Count)
+	assert.Equal(t, ExtractPatterns(logs, 0), patterns)
+
+	cfg := DefaultDrainConfig()
+	cfg.SimilarityThreshold = 0.7
+	patterns, err = ExtractPatternsWithConfig(logs, 0, cfg)
+	require.NoError(t, err)
+	require.Len(t, patterns, 2)
+	assert.Equal(t, LogPattern{ClusterID: 1, Hash: TemplateHash("request failed: timeout on db"), Template: "request failed: timeout on db", Count: 2, Percentage: 200.0 / 3, Example: logs[0]}, patterns[0])
+	assert.Equal(t, LogPattern{ClusterID: 2, Hash: TemplateHash("request failed: refused by db"), Template: "request failed: refused by db", Count: 1, Percentage: 100.0 / 3, Example: logs[1]}, patterns[1])
+
+	cfg = DefaultDrainConfig()
+	cfg.ExtraDelimiters = []string{"="}
+	patterns, err = ExtractPatternsWithConfig([]string{"status=500 path=/a", "status=404 path=/b"}, 0, cfg)
+	require.NoError(t, err)
+	require.Len(t, patterns, 1)
+	assert.Equal(t, "status * path *", patterns[0].Template)
+
+	for _, tc := range []struct {
+		cfg func(*DrainConfig)
+		err string
+	}{
+		{cfg: func(c *DrainConfig) { c.Depth = 2 }, err: "drain depth must be at least 3, got 2"},
+		{cfg: func(c *DrainConfig) { c.SimilarityThreshold = 0 }, err: "similarity threshold must be in (0, 1], got 0"},
+		{cfg: func(c *DrainConfig) { c.SimilarityThreshold = 1.5 }, err: "similarity threshold must be in (0, 1], got 1.5"},
+		{cfg: func(c *DrainConfig) { c.MaxChildren = 0 }, err: "max children must be positive, got 0"},
+		{cfg: func(c *DrainConfig) { c.MaxClusters = 0 }, err: "max clusters must be positive, got 0"},
+	} {
+		cfg := DefaultDrainConfig()
+		tc.cfg(&cfg)
+		_, err := ExtractPatternsWithConfig(logs, 0, cfg)
+		assert.EqualError(t, err, tc.err)
+	}
+}
+
+func TestExtractParameters(t *testing.T) {
+	logs := []string{
+		"Failed to get latest location by identifier: USJOT | p44.exception.RemoteServiceException: Failed to make remote service call.",
+		"Failed to get latest location by identifier: USCVG | p44.exception.RemoteServiceException: Failed to make remote service call.",
+		"Failed to get latest location by identifier: USSLC | p44.exception.RemoteServiceException: Failed to make remote service call.",
+	}
+	patterns := ExtractPatterns(logs, 0)
+	require.Len(t, patterns, 1)
+	assert.Equal(t, int64(1), patterns[0].ClusterID)
+	for i, code := range []string{"USJOT", "USCVG", "USSLC"} {
+		params, err := ExtractParameters(patterns[0].Template, logs[i])
+		require.NoError(t, err)
+		assert.Equal(t, []string{code}, params)
+	}
+
+	for _, tc := range []struct {
+		template, line string
+		params         []string
+	}{
+		{template: "user <*> logged in from *", line: "user 42 logged in from 10.0.0.1", params: []string{"42", "10.0.0.1"}},
+		{template: "connection closed", line: "connection closed", params: []string{}},
+		// Wildcards spanning several tokens take as few as possible.
+		{template: "Failed to get location: * | *", line: "Failed to get location: San Jose | timeout after 5s", params: []string{"San Jose", "timeout after 5s"}},
+		{template: "* * done", line: "a b c done", params: []string{"a", "b c"}},
+	} {
+		params, err := ExtractParameters(tc.template, tc.line)
+		require.NoError(t, err, tc.line)
+		assert.Equal(t, tc.params, params, tc.line)
+	}
+
+	_, err := ExtractParameters("user * logged in", "user 42 logged out")
+	assert.EqualError(t, err, `line doesn't match template "user * logged in"`)
+	_, err = ExtractParameters("user * logged in", "user logged in")
+	assert.Error(t, err)
+	_, err = ExtractParameters(" ", "anything")
+	assert.EqualError(t, err, "empty template")
+
+	// Many wildcards against a long non-matching line stay fast.
+	_, err = ExtractParameters(strings.Repeat("* ", 30)+"end", strings.Repeat("x ", 2000)+"stop")
+	assert.Error(t, err)
+}
+
+func TestLogPatternHash(t *testing.T) {
+	logs := func(users ...string) []string {
+		var res []string
+		for i, user := range users {
+			res = append(res,
+				fmt.Sprintf("user %s logged in from 10.0.0.%d", user, i),
+				fmt.Sprintf("connection to db-%d timed out after %dms", i, 100*i),
+				"cache warmed up",
+			)
+		}
+		return res
+	}
+	hashes := func(logs []string) map[string]string {
+		res := map[string]string{}
+		for _, p := range ExtractPatterns(logs, 0) {
+			assert.Equal(t, TemplateHash(p.Template), p.Hash)
+			res[strings.Fields(p.Template)[0]] = p.Hash
+		}
+		return res
+	}
+
+	want := hashes(logs("alice", "bob", "carol"))
+	require.Len(t, want, 3)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		shuffled := logs("dave", "erin", "frank")
+		rnd.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		assert.Equal(t, want, hashes(shuffled))
+	}
+
+	assert.Equal(t, TemplateHash("user * logged in"), TemplateHash("  user <*>   logged in "))
+	assert.Equal(t, TemplateHash("retry 1 of 3"), TemplateHash("retry 2 of 5"))
+	assert.NotEqual(t, TemplateHash("user * logged in"), TemplateHash("user * logged out"))
+	assert.Len(t, TemplateHash("cache warmed up"), 32)
+
+	// A template hashes like the Pattern of its logs.
+	assert.Equal(t, NewPattern("user 42 logged in").Hash(), TemplateHash("user * logged in"))
+	assert.Equal(t, NewPattern("user * logged in").Hash(), TemplateHash("user * logged in"))
+
+	// and follows the configured algorithm.
+	for _, algorithm := range []HashAlgorithm{HashMD5, HashFNV64, HashSHA1} {
+		pe, err := NewPatternExtractor(WithClusterHashAlgorithm(algorithm))
+		require.NoError(t, err)
+		for _, line := range logs("alice", "bob", "carol") {
+			require.NoError(t, pe.AddLog(line))
+		}
+		for _, p := range pe.GetPatterns(0) {
+			pattern := NewPattern(p.Template)
+			pattern.algorithm = algorithm
+			assert.Equal(t, pattern.Hash(), p.Hash, algorithm)
+		}
+	}
+	_, err := NewPatternExtractor(WithClusterHashAlgorithm("crc32"))
+	assert.Error(t, err)
+}
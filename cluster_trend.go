@@ -0,0 +1,53 @@
+package logparser
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	defaultTrendBucket  = time.Minute
+	defaultTrendBuckets = 60
+)
+
+// BucketCount is the number of logs of a cluster in the time bucket starting
+// at Start.
+type BucketCount struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// GetPatternTrends returns the non-empty time buckets of the cluster with the
+// given ID, oldest first, counting the logs added with a timestamp (see
+// WithTrendBuckets). It returns nil for an unknown cluster.
+func (pe *PatternExtractor) GetPatternTrends(clusterID int64) []BucketCount {
+	pe.lock.Lock()
+	defer pe.lock.Unlock()
+	stat := pe.clusters[clusterID]
+	if stat == nil {
+		return nil
+	}
+	return append([]BucketCount{}, stat.trend...)
+}
+
+// addToTrend counts a log in the bucket starting at start, keeping at most max
+// buckets. Logs older than a full trend are dropped.
+func addToTrend(trend []BucketCount, start time.Time, max int) []BucketCount {
+	i := sort.Search(len(trend), func(i int) bool { return !trend[i].Start.Before(start) })
+	if i < len(trend) && trend[i].Start.Equal(start) {
+		trend[i].Count++
+		return trend
+	}
+	if len(trend) >= max {
+		if i == 0 {
+			return trend
+		}
+		copy(trend, trend[1:i])
+		trend[i-1] = BucketCount{Start: start, Count: 1}
+		return trend
+	}
+	trend = append(trend, BucketCount{})
+	copy(trend[i+1:], trend[i:])
+	trend[i] = BucketCount{Start: start, Count: 1}
+	return trend
+}
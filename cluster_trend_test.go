@@ -0,0 +1,60 @@
+package logparser
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternExtractorTrends(t *testing.T) {
+	pe, err := NewPatternExtractor(WithTrendBuckets(10*time.Minute, 3))
+	require.NoError(t, err)
+
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	burst := func(start time.Time, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, pe.AddLogWithTimestamp(fmt.Sprintf("upstream %d timed out", i), start.Add(time.Duration(i)*time.Second)))
+		}
+	}
+	burst(t0, 10)
+	burst(t0.Add(time.Hour), 5)
+	require.NoError(t, pe.AddLog("upstream 99 timed out")) // no timestamp: counted, but not in the trend
+
+	patterns := pe.GetPatterns(0)
+	require.Len(t, patterns, 1)
+	assert.Equal(t, 16, patterns[0].Count)
+	assert.Equal(t, t0, patterns[0].FirstSeen)
+	assert.Equal(t, t0.Add(time.Hour+4*time.Second), patterns[0].LastSeen)
+	id := patterns[0].ClusterID
+	assert.Equal(t, []BucketCount{{Start: t0, Count: 10}, {Start: t0.Add(time.Hour), Count: 5}}, pe.GetPatternTrends(id))
+
+	// A late log goes to its own bucket, in order.
+	require.NoError(t, pe.AddLogWithTimestamp("upstream 7 timed out", t0.Add(25*time.Minute)))
+	assert.Equal(t, []BucketCount{{Start: t0, Count: 10}, {Start: t0.Add(20 * time.Minute), Count: 1}, {Start: t0.Add(time.Hour), Count: 5}}, pe.GetPatternTrends(id))
+
+	// Beyond 3 buckets the oldest one is dropped, and so are logs older
+	// than the remaining ones.
+	burst(t0.Add(2*time.Hour), 2)
+	assert.Equal(t, []BucketCount{{Start: t0.Add(20 * time.Minute), Count: 1}, {Start: t0.Add(time.Hour), Count: 5}, {Start: t0.Add(2 * time.Hour), Count: 2}}, pe.GetPatternTrends(id))
+	require.NoError(t, pe.AddLogWithTimestamp("upstream 8 timed out", t0))
+	assert.Equal(t, t0.Add(20*time.Minute), pe.GetPatternTrends(id)[0].Start)
+
+	// Trends survive a state round trip.
+	var buf bytes.Buffer
+	require.NoError(t, pe.SaveState(&buf))
+	restored, err := NewPatternExtractor()
+	require.NoError(t, err)
+	require.NoError(t, restored.LoadState(&buf))
+	assert.Equal(t, pe.GetPatternTrends(id), restored.GetPatternTrends(id))
+
+	assert.Nil(t, pe.GetPatternTrends(42))
+
+	_, err = NewPatternExtractor(WithTrendBuckets(0, 1))
+	assert.EqualError(t, err, "trend bucket size must be positive, got 0s")
+	_, err = NewPatternExtractor(WithTrendBuckets(time.Minute, 0))
+	assert.EqualError(t, err, "trend buckets must be positive, got 0")
+}
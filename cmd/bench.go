@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/nudgebee/logparser"
+)
+
+// benchMinDuration is how long -bench-corpus parses its corpus over and over
+// for, so that short corpora are measured over enough runs.
+var benchMinDuration = time.Second
+
+// benchResult is the measurement of -bench-corpus.
+type benchResult struct {
+	lines    int
+	bytes    int64
+	runs     int
+	duration time.Duration
+	// allocs and allocBytes are the heap allocations of all the runs.
+	allocs     uint64
+	allocBytes uint64
+}
+
+// runBenchMode parses the lines of the -bench-corpus file with the parser of
+// the default mode, as many times as fit in benchMinDuration, and prints the
+// throughput and the allocations per line to w.
+func runBenchMode(cfg *config, w io.Writer) error {
+	var lines []string
+	readFiles([]string{cfg.benchCorpus}, func(_, line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	if len(lines) == 0 {
+		return errors.New("the benchmark corpus is empty")
+	}
+	res, err := cfg.benchLines(lines)
+	if err != nil {
+		return err
+	}
+	total := float64(res.lines * res.runs)
+	seconds := res.duration.Seconds()
+	fmt.Fprintf(w, "corpus:      %d lines, %s, %s\n", res.lines, formatBytes(res.bytes), cfg.benchCorpus)
+	fmt.Fprintf(w, "runs:        %d in %s\n", res.runs, res.duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput:  %.0f lines/s, %.2f MB/s\n", total/seconds, float64(res.bytes)*float64(res.runs)/seconds/1e6)
+	fmt.Fprintf(w, "allocations: %.1f allocs/line, %.0f B/line\n", float64(res.allocs)/total, float64(res.allocBytes)/total)
+	return nil
+}
+
+// benchLines parses lines with a new parser until benchMinDuration has passed.
+// The timestamps are extracted beforehand so that only the parser is
+// measured.
+func (cfg *config) benchLines(lines []string) (benchResult, error) {
+	timestamps := logparser.NewTimestampExtractor()
+	entries := make([]logparser.LogEntry, len(lines))
+	res := benchResult{lines: len(lines)}
+	for i, line := range lines {
+		ts, _ := entryTime(timestamps, line)
+		entries[i] = logparser.LogEntry{Timestamp: ts, Content: line, Level: logparser.LevelUnknown}
+		res.bytes += int64(len(line) + 1)
+	}
+	opts, err := cfg.parserOptions(timestamps)
+	if err != nil {
+		return res, err
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for res.runs == 0 || res.duration < benchMinDuration {
+		ch := make(chan logparser.LogEntry)
+		parser, err := logparser.NewParserWithOptions(ch, opts...)
+		if err != nil {
+			return res, err
+		}
+		for _, entry := range entries {
+			ch <- entry
+		}
+		if err := parser.StopAndDrain(time.Minute); err != nil {
+			return res, err
+		}
+		res.runs++
+		res.duration = time.Since(start)
+	}
+	runtime.ReadMemStats(&after)
+	res.allocs = after.Mallocs - before.Mallocs
+	res.allocBytes = after.TotalAlloc - before.TotalAlloc
+	return res, nil
+}
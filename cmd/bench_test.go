@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBenchMode(t *testing.T) {
+	defer func(d time.Duration) { benchMinDuration = d }(benchMinDuration)
+	benchMinDuration = 0
+
+	corpus := filepath.Join("..", "testdata", "bench", "app.log")
+	cfg, err := parseFlags([]string{"-bench-corpus", corpus, "-multiline-timeout", "1m"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	res, err := cfg.benchLines([]string{"2024-01-15 10:00:00 ERROR failed to connect to db", "2024-01-15 10:00:01 INFO served"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.lines)
+	assert.Equal(t, 1, res.runs)
+	assert.Equal(t, int64(82), res.bytes)
+	assert.NotZero(t, res.allocs)
+
+	var out bytes.Buffer
+	require.NoError(t, runBenchMode(cfg, &out))
+	assert.Contains(t, out.String(), "corpus:      852 lines")
+	assert.Contains(t, out.String(), "lines/s")
+	assert.Contains(t, out.String(), "allocs/line")
+
+	for _, args := range [][]string{
+		{"-bench-corpus", corpus, "-f"},
+		{"-bench-corpus", corpus, "-cluster"},
+		{"-bench-corpus", corpus, corpus},
+		{"diff", "-bench-corpus", corpus, corpus, corpus},
+	} {
+		_, err := parseFlags(args, &bytes.Buffer{})
+		assert.Error(t, err, args)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/nudgebee/logparser"
+)
+
+// The exit codes of threshold violations. 1 is used for runtime errors and
+// 2 for invalid flags.
+const (
+	exitErrorThreshold = 3
+	exitSensitive      = 4
+)
+
+// thresholds implements the -fail-on-sensitive, -max-errors and
+// -max-error-rate flags, evaluated once all the input is parsed.
+type thresholds struct {
+	failOnSensitive bool
+	maxErrors       int     // disabled if negative
+	maxErrorRate    float64 // disabled if negative
+}
+
+// violation is a threshold exceeded by the input, reported to stderr as JSON.
+type violation struct {
+	Rule  string  `json:"rule"`
+	Limit float64 `json:"limit"`
+	Value float64 `json:"value"`
+}
+
+// levelCounts sums the messages of counters: errors is the number of error
+// and critical messages.
+func levelCounts(counters []logparser.LogCounter) (errors, total int) {
+	for _, c := range counters {
+		total += c.Messages
+		if c.Level == logparser.LevelError || c.Level == logparser.LevelCritical {
+			errors += c.Messages
+		}
+	}
+	return errors, total
+}
+
+// check writes the violations of the thresholds to w, as a JSON object, and
+// returns the exit code: exitSensitive if sensitive data was found,
+// exitErrorThreshold if there are too many errors, 0 if the input passes.
+func (t thresholds) check(w io.Writer, errors, total, sensitive int) int {
+	var violations []violation
+	code := 0
+	if t.maxErrors >= 0 && errors > t.maxErrors {
+		violations = append(violations, violation{Rule: "max-errors", Limit: float64(t.maxErrors), Value: float64(errors)})
+		code = exitErrorThreshold
+	}
+	if t.maxErrorRate >= 0 && total > 0 {
+		if rate := float64(errors) / float64(total); rate > t.maxErrorRate {
+			violations = append(violations, violation{Rule: "max-error-rate", Limit: t.maxErrorRate, Value: rate})
+			code = exitErrorThreshold
+		}
+	}
+	if t.failOnSensitive && sensitive > 0 {
+		violations = append(violations, violation{Rule: "fail-on-sensitive", Limit: 0, Value: float64(sensitive)})
+		code = exitSensitive
+	}
+	if len(violations) > 0 {
+		data, _ := json.Marshal(map[string]any{"violations": violations, "exit_code": code})
+		fmt.Fprintln(w, string(data))
+	}
+	return code
+}
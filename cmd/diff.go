@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/nudgebee/logparser"
+)
+
+// diffPattern is a pattern of one of the inputs of the diff mode.
+type diffPattern struct {
+	level   logparser.Level
+	hash    string
+	pattern string
+	sample  string
+	count   int
+}
+
+// patternChange is a pattern that appeared, disappeared or changed between
+// the baseline and the compared input.
+type patternChange struct {
+	Level   string `json:"level"`
+	Hash    string `json:"hash"`
+	Pattern string `json:"pattern,omitempty"`
+	Sample  string `json:"sample"`
+	Before  int    `json:"before"`
+	After   int    `json:"after"`
+	// Change is the relative change of the count, e.g. 1.5 for +150%; 0
+	// for new and disappeared patterns.
+	Change float64 `json:"change,omitempty"`
+}
+
+// patternDiff is the result of the diff mode.
+type patternDiff struct {
+	New         []patternChange `json:"new"`
+	Disappeared []patternChange `json:"disappeared"`
+	Changed     []patternChange `json:"changed"`
+}
+
+// runDiffMode compares the patterns of the baseline files with the ones of
+// the other files.
+func runDiffMode(cfg *config) error {
+	before, err := cfg.diffPatterns(cfg.baseline)
+	if err != nil {
+		return err
+	}
+	after, err := cfg.diffPatterns(cfg.files)
+	if err != nil {
+		return err
+	}
+	diff := diffPatterns(before, after, cfg.diffThreshold)
+	if cfg.outputFormat == "json" {
+		return outputDiffJSON(os.Stdout, diff)
+	}
+	outputDiff(os.Stdout, diff, cfg.screenWidth)
+	return nil
+}
+
+// diffPatterns returns the patterns of files, counted by the parser or
+// clustered in the cluster mode. Every input gets its own sampler so that
+// -max-lines applies to each of them.
+func (cfg *config) diffPatterns(files []string) ([]diffPattern, error) {
+	sampler, err := newLineSampler(cfg.sampler.rate, cfg.sampler.seed, cfg.sampler.maxLines, cfg.sampler.since, cfg.sampler.until)
+	if err != nil {
+		return nil, err
+	}
+	var res []diffPattern
+	if cfg.cluster {
+		extractor, err := cfg.newExtractor()
+		if err != nil {
+			return nil, err
+		}
+		cfg.extractPatterns(extractor, files, sampler)
+		byLevel := extractor.GetPatternsByLevel(0)
+		sampler.scalePatterns(byLevel)
+		byLevel, _ = cfg.filter.patterns(byLevel)
+		for level, patterns := range byLevel {
+			for _, p := range patterns {
+				res = append(res, diffPattern{level: level, hash: p.Hash, pattern: p.Template, sample: p.Example, count: p.Count})
+			}
+		}
+		return res, nil
+	}
+	// Info and debug messages need patterns of their own to be compared.
+	counting := *cfg
+	counting.allLevels = true
+	parser, err := counting.countPatterns(files, sampler)
+	if err != nil {
+		return nil, err
+	}
+	counters := parser.GetCounters()
+	sampler.scaleCounters(counters)
+	counters, _ = cfg.filter.counters(counters)
+	for _, c := range counters {
+		if c.Sample == "" {
+			continue
+		}
+		res = append(res, diffPattern{level: c.Level, hash: c.Hash, pattern: c.Pattern, sample: c.Sample, count: c.Messages})
+	}
+	return res, nil
+}
+
+// diffPatterns matches the patterns of before and after by level and hash.
+// Patterns whose count changed by less than threshold, relative to before,
+// are left out.
+func diffPatterns(before, after []diffPattern, threshold float64) patternDiff {
+	type key struct {
+		level logparser.Level
+		hash  string
+	}
+	counts := map[key]*diffPattern{}
+	for _, p := range before {
+		k := key{level: p.level, hash: p.hash}
+		if prev := counts[k]; prev != nil {
+			// The same pattern from several sources.
+			prev.count += p.count
+			continue
+		}
+		counts[k] = &p
+	}
+	merged := map[key]*patternChange{}
+	var order []key
+	for _, p := range after {
+		k := key{level: p.level, hash: p.hash}
+		if c := merged[k]; c != nil {
+			c.After += p.count
+			continue
+		}
+		c := &patternChange{Level: p.level.String(), Hash: p.hash, Pattern: p.pattern, Sample: p.sample, After: p.count}
+		if b := counts[k]; b != nil {
+			c.Before = b.count
+		}
+		merged[k] = c
+		order = append(order, k)
+	}
+
+	res := patternDiff{New: []patternChange{}, Disappeared: []patternChange{}, Changed: []patternChange{}}
+	for _, k := range order {
+		c := merged[k]
+		if c.Before == 0 {
+			res.New = append(res.New, *c)
+			continue
+		}
+		c.Change = float64(c.After-c.Before) / float64(c.Before)
+		if c.After != c.Before && math.Abs(c.Change) >= threshold {
+			res.Changed = append(res.Changed, *c)
+		}
+	}
+	for k, p := range counts {
+		if merged[k] == nil {
+			res.Disappeared = append(res.Disappeared, patternChange{Level: p.level.String(), Hash: p.hash, Pattern: p.pattern, Sample: p.sample, Before: p.count})
+		}
+	}
+
+	sort.Slice(res.New, func(i, j int) bool { return lessChange(res.New[i], res.New[j], res.New[i].After, res.New[j].After) })
+	sort.Slice(res.Disappeared, func(i, j int) bool {
+		return lessChange(res.Disappeared[i], res.Disappeared[j], res.Disappeared[i].Before, res.Disappeared[j].Before)
+	})
+	sort.Slice(res.Changed, func(i, j int) bool {
+		return lessChange(res.Changed[i], res.Changed[j], math.Abs(res.Changed[i].Change), math.Abs(res.Changed[j].Change))
+	})
+	return res
+}
+
+// lessChange orders changes by decreasing weight, then by hash so that the
+// output is stable.
+func lessChange[T int | float64](a, b patternChange, wa, wb T) bool {
+	if wa != wb {
+		return wa > wb
+	}
+	return a.Hash < b.Hash
+}
+
+func outputDiffJSON(w io.Writer, diff patternDiff) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diff)
+}
+
+func outputDiff(w io.Writer, diff patternDiff, screenWidth int) {
+	sections := []struct {
+		title   string
+		changes []patternChange
+	}{
+		{"NEW PATTERNS", diff.New},
+		{"DISAPPEARED PATTERNS", diff.Disappeared},
+		{"CHANGED PATTERNS", diff.Changed},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(w, "=== %s (%d) ===\n", section.title, len(section.changes))
+		for _, c := range section.changes {
+			change := ""
+			if c.Change != 0 {
+				change = fmt.Sprintf("%+.0f%%", c.Change*100)
+			}
+			prefix := fmt.Sprintf("  %-8s %7d -> %-7d %6s  ", c.Level, c.Before, c.After, change)
+			fmt.Fprintf(w, "%s%s\n", prefix, firstLine(c.Sample, screenWidth-len(prefix)))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(diff.New)+len(diff.Disappeared)+len(diff.Changed) == 0 {
+		fmt.Fprintln(w, "No differences found")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nudgebee/logparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffPatterns(t *testing.T) {
+	before := []diffPattern{
+		{level: logparser.LevelError, hash: "a", sample: "db timeout", count: 10},
+		{level: logparser.LevelError, hash: "b", sample: "cache miss", count: 10},
+		{level: logparser.LevelWarning, hash: "c", sample: "slow query", count: 5},
+		{level: logparser.LevelInfo, hash: "d", sample: "request served", count: 100},
+	}
+	after := []diffPattern{
+		{level: logparser.LevelError, hash: "a", sample: "db timeout", count: 40},
+		{level: logparser.LevelError, hash: "b", sample: "cache miss", count: 12},
+		{level: logparser.LevelCritical, hash: "e", sample: "out of memory", count: 3},
+		{level: logparser.LevelInfo, hash: "d", sample: "request served", count: 20},
+	}
+	diff := diffPatterns(before, after, 0.5)
+	assert.Equal(t, []patternChange{{Level: "critical", Hash: "e", Sample: "out of memory", After: 3}}, diff.New)
+	assert.Equal(t, []patternChange{{Level: "warning", Hash: "c", Sample: "slow query", Before: 5}}, diff.Disappeared)
+	assert.Equal(t, []patternChange{
+		{Level: "error", Hash: "a", Sample: "db timeout", Before: 10, After: 40, Change: 3},
+		{Level: "info", Hash: "d", Sample: "request served", Before: 100, After: 20, Change: -0.8},
+	}, diff.Changed)
+
+	var out bytes.Buffer
+	outputDiff(&out, diff, 80)
+	assert.Contains(t, out.String(), "=== NEW PATTERNS (1) ===\n  critical       0 -> 3               out of memory\n")
+	assert.Contains(t, out.String(), "  error         10 -> 40       +300%  db timeout\n")
+
+	out.Reset()
+	require.NoError(t, outputDiffJSON(&out, diffPatterns(before, before, 0.5)))
+	var decoded map[string][]patternChange
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, map[string][]patternChange{"new": {}, "disappeared": {}, "changed": {}}, decoded)
+}
+
+func TestDiffMode(t *testing.T) {
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.log")
+	after := filepath.Join(dir, "after.log")
+	require.NoError(t, os.WriteFile(before, []byte(strings.Repeat("2024-01-15 10:00:00 ERROR failed to connect to db\n", 3)+
+		"2024-01-15 10:00:00 INFO request served\n"), 0o644))
+	require.NoError(t, os.WriteFile(after, []byte(strings.Repeat("2024-01-16 10:00:00 ERROR failed to connect to db\n", 3)+
+		"2024-01-16 10:00:01 ERROR disk /dev/sda1 is full\n"+
+		strings.Repeat("2024-01-16 10:00:02 INFO request served\n", 4)+
+		"2024-01-16 10:00:03 INFO cache warmed up\n"), 0o644))
+
+	cfg, err := parseFlags([]string{"diff", before, after}, &bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, []string{before}, cfg.baseline)
+	require.Equal(t, []string{after}, cfg.files)
+	b, err := cfg.diffPatterns(cfg.baseline)
+	require.NoError(t, err)
+	a, err := cfg.diffPatterns(cfg.files)
+	require.NoError(t, err)
+	diff := diffPatterns(b, a, cfg.diffThreshold)
+	// Info messages are compared by pattern too.
+	require.Len(t, diff.New, 2)
+	assert.Equal(t, "ERROR disk /dev/sda1 is full", diff.New[0].Sample)
+	assert.Equal(t, 1, diff.New[0].After)
+	assert.Equal(t, "INFO cache warmed up", diff.New[1].Sample)
+	assert.Empty(t, diff.Disappeared)
+	require.Len(t, diff.Changed, 1)
+	assert.Equal(t, patternChange{Level: "info", Hash: diff.Changed[0].Hash, Pattern: diff.Changed[0].Pattern, Sample: "INFO request served", Before: 1, After: 4, Change: 3}, diff.Changed[0])
+
+	_, err = parseFlags([]string{"diff", before}, &bytes.Buffer{})
+	assert.Error(t, err)
+	_, err = parseFlags([]string{"-baseline", before, "-o", "yaml", after}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nudgebee/logparser"
+)
+
+var levels = []logparser.Level{logparser.LevelCritical, logparser.LevelError, logparser.LevelWarning, logparser.LevelInfo, logparser.LevelDebug, logparser.LevelUnknown}
+
+// lineFilter implements the -level, -match, -exclude and -min-count flags.
+// Lines are filtered by match and exclude before they are parsed, patterns by
+// level and minCount before they are displayed.
+type lineFilter struct {
+	levels   map[logparser.Level]bool // all levels if empty
+	match    *regexp.Regexp
+	exclude  *regexp.Regexp
+	minCount int
+
+	filteredLines atomic.Int64
+}
+
+// newLineFilter parses the filter flags. levelNames is a comma-separated list
+// of level names, e.g. "error,critical"; empty strings disable a filter.
+func newLineFilter(levelNames, match, exclude string, minCount int) (*lineFilter, error) {
+	f := &lineFilter{levels: map[logparser.Level]bool{}, minCount: minCount}
+	if levelNames != "" {
+		for _, name := range strings.Split(levelNames, ",") {
+			level, ok := parseLevel(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("unknown level %q, want critical, error, warning, info, debug or unknown", name)
+			}
+			f.levels[level] = true
+		}
+	}
+	var err error
+	if match != "" {
+		if f.match, err = regexp.Compile(match); err != nil {
+			return nil, fmt.Errorf("invalid -match: %w", err)
+		}
+	}
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("invalid -exclude: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func parseLevel(name string) (logparser.Level, bool) {
+	for _, level := range levels {
+		if strings.EqualFold(name, level.String()) {
+			return level, true
+		}
+	}
+	if strings.EqualFold(name, "warn") {
+		return logparser.LevelWarning, true
+	}
+	return logparser.LevelUnknown, false
+}
+
+// keepLine reports whether line passes match and exclude, counting the lines
+// that don't. It is safe for concurrent use.
+func (f *lineFilter) keepLine(line string) bool {
+	if (f.match == nil || f.match.MatchString(line)) && (f.exclude == nil || !f.exclude.MatchString(line)) {
+		return true
+	}
+	f.filteredLines.Add(1)
+	return false
+}
+
+func (f *lineFilter) keepLevel(level logparser.Level) bool {
+	return len(f.levels) == 0 || f.levels[level]
+}
+
+// counters returns the counters that pass the level and minCount filters and
+// the number of messages of the others.
+func (f *lineFilter) counters(counters []logparser.LogCounter) ([]logparser.LogCounter, int) {
+	res, hidden := counters[:0:0], 0
+	for _, c := range counters {
+		if !f.keepLevel(c.Level) || c.Messages < f.minCount {
+			hidden += c.Messages
+			continue
+		}
+		res = append(res, c)
+	}
+	return res, hidden
+}
+
+// patterns is counters for the patterns of the cluster mode.
+func (f *lineFilter) patterns(byLevel map[logparser.Level][]logparser.LogPattern) (map[logparser.Level][]logparser.LogPattern, int) {
+	res, hidden := map[logparser.Level][]logparser.LogPattern{}, 0
+	for level, patterns := range byLevel {
+		for _, p := range patterns {
+			if !f.keepLevel(level) || p.Count < f.minCount {
+				hidden += p.Count
+				continue
+			}
+			res[level] = append(res[level], p)
+		}
+	}
+	return res, hidden
+}
+
+// summary describes what was filtered, or is empty if nothing was.
+func (f *lineFilter) summary(hiddenMessages int) string {
+	var parts []string
+	if n := f.filteredLines.Load(); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d lines filtered out by -match/-exclude", n))
+	}
+	if hiddenMessages > 0 {
+		parts = append(parts, fmt.Sprintf("%d messages hidden by -level/-min-count", hiddenMessages))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nudgebee/logparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineFilter(t *testing.T) {
+	f, err := newLineFilter("error, Critical", "db|cache", "timeout", 2)
+	require.NoError(t, err)
+
+	var kept []string
+	for _, line := range []string{
+		"ERROR db connection refused",
+		"ERROR db timeout",
+		"INFO cache warmed up",
+		"ERROR http 500",
+	} {
+		if f.keepLine(line) {
+			kept = append(kept, line)
+		}
+	}
+	assert.Equal(t, []string{"ERROR db connection refused", "INFO cache warmed up"}, kept)
+
+	counters, hidden := f.counters([]logparser.LogCounter{
+		{Level: logparser.LevelError, Sample: "db connection refused", Messages: 5},
+		{Level: logparser.LevelError, Sample: "rare error", Messages: 1},
+		{Level: logparser.LevelInfo, Sample: "cache warmed up", Messages: 10},
+		{Level: logparser.LevelCritical, Sample: "out of memory", Messages: 2},
+	})
+	assert.Equal(t, []logparser.LogCounter{
+		{Level: logparser.LevelError, Sample: "db connection refused", Messages: 5},
+		{Level: logparser.LevelCritical, Sample: "out of memory", Messages: 2},
+	}, counters)
+	assert.Equal(t, 11, hidden)
+
+	byLevel, hidden := f.patterns(map[logparser.Level][]logparser.LogPattern{
+		logparser.LevelError:   {{Template: "db *", Count: 3}, {Template: "rare *", Count: 1}},
+		logparser.LevelWarning: {{Template: "slow *", Count: 7}},
+	})
+	assert.Equal(t, map[logparser.Level][]logparser.LogPattern{logparser.LevelError: {{Template: "db *", Count: 3}}}, byLevel)
+	assert.Equal(t, 8, hidden)
+
+	assert.Equal(t, "2 lines filtered out by -match/-exclude, 8 messages hidden by -level/-min-count", f.summary(hidden))
+}
+
+func TestLineFilterDisabled(t *testing.T) {
+	f, err := newLineFilter("", "", "", 0)
+	require.NoError(t, err)
+	assert.True(t, f.keepLine("anything"))
+	counters, hidden := f.counters([]logparser.LogCounter{{Level: logparser.LevelDebug, Messages: 1}})
+	assert.Len(t, counters, 1)
+	assert.Zero(t, hidden)
+	assert.Empty(t, f.summary(hidden))
+}
+
+func TestLineFilterErrors(t *testing.T) {
+	_, err := newLineFilter("error,fatal", "", "", 0)
+	assert.ErrorContains(t, err, `unknown level "fatal"`)
+	_, err = newLineFilter("", "(", "", 0)
+	assert.ErrorContains(t, err, "invalid -match")
+	_, err = newLineFilter("", "", "[", 0)
+	assert.ErrorContains(t, err, "invalid -exclude")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nudgebee/logparser"
+)
+
+// followPollInterval is how often a followed file is checked for new data,
+// truncation and rotation once its end has been reached.
+const followPollInterval = 250 * time.Millisecond
+
+// counterKey identifies a pattern counter across GetCountersAndReset calls.
+type counterKey struct {
+	level  logparser.Level
+	hash   string
+	source string
+}
+
+// runFollowMode reads files as they grow, printing the patterns of every
+// interval along with the totals since the start, until interrupted. Sensitive
+// findings are printed as soon as they are detected. It returns the exit
+// code.
+func runFollowMode(cfg *config, opts []logparser.ParserOption) int {
+	files, filter, interval := cfg.files, cfg.filter, cfg.interval
+	screenWidth, maxPatterns, maxLinesPerMessage := cfg.screenWidth, cfg.maxPatterns, cfg.maxLinesPerMessage
+	for _, file := range files {
+		if file == "-" {
+			fmt.Fprintln(os.Stderr, "-f needs file arguments")
+			return 2
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No files to follow")
+		return 1
+	}
+
+	var stdout sync.Mutex
+	onSensitive := func(ts time.Time, name string, level logparser.Level, hash string, sample string) {
+		stdout.Lock()
+		defer stdout.Unlock()
+		fmt.Println(colorize(logparser.LevelCritical, "sensitive data (%s) at %s: %s", name, ts.Format(time.RFC3339), firstLine(sample, screenWidth)))
+	}
+	ch := make(chan logparser.LogEntry)
+	opts = append(opts, logparser.WithOnSensitiveMatch(onSensitive, interval))
+	parser, err := logparser.NewParserWithOptions(ch, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing parser: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	timestamps := logparser.NewTimestampExtractor()
+	var wg sync.WaitGroup
+	for _, file := range files {
+		f, err := openAtEnd(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := followFile(ctx, f, followPollInterval, func(file, line string) {
+				ts, ok := entryTime(timestamps, line)
+				if !cfg.sampler.keep(ts, ok) || !filter.keepLine(line) {
+					return
+				}
+				select {
+				case ch <- logparser.LogEntry{Timestamp: ts, Content: line, Level: logparser.LevelUnknown, Source: file}:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
+			}
+		}()
+	}
+
+	t := time.Now()
+	totals := map[counterKey]*logparser.LogCounter{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			delta := mergeCounters(totals, parser.GetCountersAndReset())
+			shown := delta[:0]
+			for _, c := range delta {
+				if filter.keepLevel(c.Level) {
+					shown = append(shown, c)
+				}
+			}
+			stdout.Lock()
+			outputInterval(shown, totals, screenWidth, maxPatterns)
+			stdout.Unlock()
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+
+	wg.Wait()
+	if err := parser.StopAndDrain(5 * time.Second); err != nil {
+		fmt.Fprintf(os.Stderr, "Error draining parser: %v\n", err)
+	}
+	mergeCounters(totals, parser.GetCountersAndReset())
+	d := time.Since(t)
+
+	counters := make([]logparser.LogCounter, 0, len(totals))
+	for _, c := range totals {
+		counters = append(counters, *c)
+	}
+	cfg.sampler.scaleCounters(counters)
+	errorCount, total := levelCounts(counters)
+	counters, hidden := filter.counters(counters)
+	sensitiveCounters := parser.GetSensitiveCounters()
+	order(counters)
+	orderSensitive(sensitiveCounters)
+	fmt.Println()
+	output(counters, screenWidth, maxLinesPerMessage, cfg.samples, false, d)
+	outputSensitive(sensitiveCounters, screenWidth, maxLinesPerMessage, d)
+	cfg.outputSummary(hidden)
+	return cfg.thresholds.check(os.Stderr, errorCount, total, sensitiveMessages(sensitiveCounters))
+}
+
+// mergeCounters adds the counts of delta to totals and returns the counters
+// of delta with messages.
+func mergeCounters(totals map[counterKey]*logparser.LogCounter, delta []logparser.LogCounter) []logparser.LogCounter {
+	res := delta[:0]
+	for _, c := range delta {
+		if c.Messages == 0 {
+			continue
+		}
+		k := counterKey{level: c.Level, hash: c.Hash, source: c.Source}
+		total := totals[k]
+		if total == nil {
+			total = &logparser.LogCounter{Level: c.Level, Hash: c.Hash, Source: c.Source, FirstSeen: c.FirstSeen}
+			totals[k] = total
+		}
+		total.Messages += c.Messages
+		total.Bytes += c.Bytes
+		if total.FirstSeen.IsZero() || !c.FirstSeen.IsZero() && c.FirstSeen.Before(total.FirstSeen) {
+			total.FirstSeen = c.FirstSeen
+		}
+		if c.LastSeen.After(total.LastSeen) {
+			total.LastSeen = c.LastSeen
+		}
+		if c.LastSpike.After(total.LastSpike) {
+			total.LastSpike = c.LastSpike
+		}
+		// The samples, rates and histogram of the parser's counters aren't
+		// reset with their counts: the latest ones cover the whole run.
+		total.Pattern, total.Sample, total.Samples, total.SampleTruncated = c.Pattern, c.Sample, c.Samples, c.SampleTruncated
+		total.ExceptionClass, total.Attributes = c.ExceptionClass, c.Attributes
+		total.RatePerMinute, total.Last5mCount, total.SpikeBaseline = c.RatePerMinute, c.Last5mCount, c.SpikeBaseline
+		total.Histogram, total.HistogramStart, total.HistogramBucket = c.Histogram, c.HistogramStart, c.HistogramBucket
+		total.HashVersion = c.HashVersion
+		res = append(res, c)
+	}
+	return res
+}
+
+// outputInterval prints the patterns with the most messages in the last
+// interval, with their totals since the start.
+func outputInterval(delta []logparser.LogCounter, totals map[counterKey]*logparser.LogCounter, screenWidth, limit int) {
+	sort.SliceStable(delta, func(i, j int) bool {
+		if delta[i].Level == delta[j].Level {
+			return delta[i].Messages > delta[j].Messages
+		}
+		return delta[i].Level < delta[j].Level
+	})
+	messages, total := 0, 0
+	for _, c := range delta {
+		messages += c.Messages
+	}
+	for _, c := range totals {
+		total += c.Messages
+	}
+	fmt.Printf("--- %s: %d new messages, %d in total ---\n", time.Now().Format(time.TimeOnly), messages, total)
+	if len(delta) > limit {
+		delta = delta[:limit]
+	}
+	for _, c := range delta {
+		k := counterKey{level: c.Level, hash: c.Hash, source: c.Source}
+		prefix := fmt.Sprintf("  %+6d %7d ", c.Messages, totals[k].Messages)
+		fmt.Println(colorize(c.Level, "%s%s", prefix, firstLine(c.Sample, screenWidth-len(prefix))))
+	}
+	fmt.Println()
+}
+
+// firstLine returns the first line of s, truncated to width.
+func firstLine(s string, width int) string {
+	line, _, more := strings.Cut(s, "\n")
+	if width > 3 && len(line) > width {
+		return cutLine(line, width-3) + "..."
+	}
+	if more {
+		line += " ..."
+	}
+	return line
+}
+
+// openAtEnd opens path for followFile, positioned at its end so that only
+// the lines written from now on are read, like tail -f.
+func openAtEnd(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// followFile calls fn with each line of f from its current offset, waiting
+// for new lines until ctx is done, and closes it. A truncated file is read
+// again from the start and a rotated one, whose path points to a new file,
+// is reopened after the rest of the old file is read.
+func followFile(ctx context.Context, f *os.File, poll time.Duration, fn func(file, line string)) error {
+	defer func() { f.Close() }()
+	path := f.Name()
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	partial := ""
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			fn(path, partial+strings.TrimSuffix(line, "\n"))
+			partial = ""
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+		partial += line
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(poll):
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			// The file was moved away and not recreated yet.
+			continue
+		}
+		current, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		switch {
+		case !os.SameFile(info, current):
+			if err := drainFile(path, reader, partial, fn); err != nil {
+				return err
+			}
+			next, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			f.Close()
+			f = next
+		case info.Size() < offset:
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		reader.Reset(f)
+		offset, partial = 0, ""
+	}
+}
+
+// drainFile calls fn with the lines left in reader, including a final line
+// without a newline.
+func drainFile(path string, reader *bufio.Reader, partial string, fn func(file, line string)) error {
+	for {
+		line, err := reader.ReadString('\n')
+		partial += line
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if partial != "" {
+			fn(path, strings.TrimSuffix(partial, "\n"))
+		}
+		if err != nil {
+			return nil
+		}
+		partial = ""
+	}
+}
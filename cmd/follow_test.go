@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nudgebee/logparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(path, []byte("zero\n"), 0o644))
+	// Only the lines written from now on are read.
+	f, err := openAtEnd(path)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	var lines []string
+	read := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, lines...)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- followFile(ctx, f, 10*time.Millisecond, func(file, line string) {
+			assert.Equal(t, path, file)
+			lock.Lock()
+			lines = append(lines, line)
+			lock.Unlock()
+		})
+	}()
+	waitFor := func(expected ...string) {
+		t.Helper()
+		assert.Eventually(t, func() bool { return assert.ObjectsAreEqual(expected, read()) }, time.Second, 5*time.Millisecond, "got %q", read())
+	}
+
+	appendFile(t, path, "one\ntw")
+	waitFor("one")
+	appendFile(t, path, "o\nthree\n")
+	waitFor("one", "two", "three")
+
+	// truncation
+	require.NoError(t, os.WriteFile(path, []byte("four\n"), 0o644))
+	waitFor("one", "two", "three", "four")
+
+	// rotation, with a last line written to the old file after the move
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path+".1", "five")
+	require.NoError(t, os.WriteFile(path, []byte("six\n"), 0o644))
+	waitFor("one", "two", "three", "four", "five", "six")
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestMergeCounters(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	totals := map[counterKey]*logparser.LogCounter{}
+	first := logparser.LogCounter{Level: logparser.LevelError, Hash: "a", Sample: "disk full", Messages: 2, Bytes: 20, FirstSeen: t0, LastSeen: t0.Add(time.Minute), RatePerMinute: 2}
+	assert.Equal(t, []logparser.LogCounter{first}, mergeCounters(totals, []logparser.LogCounter{first, {Level: logparser.LevelInfo, Hash: "b"}}))
+
+	// Counts add up, the first and last seen times widen and the rest is
+	// the latest.
+	second := logparser.LogCounter{Level: logparser.LevelError, Hash: "a", Sample: "disk full again", Messages: 3, Bytes: 30, FirstSeen: t0.Add(time.Hour), LastSeen: t0.Add(2 * time.Hour), RatePerMinute: 1}
+	mergeCounters(totals, []logparser.LogCounter{second})
+	require.Len(t, totals, 1)
+	assert.Equal(t, logparser.LogCounter{Level: logparser.LevelError, Hash: "a", Sample: "disk full again", Messages: 5, Bytes: 50, FirstSeen: t0, LastSeen: t0.Add(2 * time.Hour), RatePerMinute: 1},
+		*totals[counterKey{level: logparser.LevelError, hash: "a"}])
+}
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inputFiles expands the file arguments: directories are walked for the
+// files whose name matches glob, and patterns the shell didn't expand are
+// globbed. "-" or no arguments read stdin. Arguments that can't be expanded
+// are reported to stderr and skipped.
+func inputFiles(args []string, glob string) []string {
+	if len(args) == 0 {
+		return []string{"-"}
+	}
+	var files []string
+	for _, arg := range args {
+		if arg == "-" {
+			files = append(files, arg)
+			continue
+		}
+		paths := []string{arg}
+		if _, err := os.Stat(arg); err != nil && strings.ContainsAny(arg, "*?[") {
+			if matches, _ := filepath.Glob(arg); len(matches) > 0 {
+				paths = matches
+			}
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", path, err)
+				continue
+			}
+			if !info.IsDir() {
+				files = append(files, path)
+				continue
+			}
+			err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", p, err)
+					return nil
+				}
+				if ok, _ := filepath.Match(glob, d.Name()); ok && d.Type().IsRegular() {
+					files = append(files, p)
+				}
+				return nil
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", path, err)
+			}
+		}
+	}
+	return files
+}
+
+// readFiles calls fn with each line of files, in order, and the file it was
+// read from ("" for stdin), until fn returns false. Files ending with .gz are
+// decompressed. A file that can't be read is reported to stderr and the rest
+// are still read.
+func readFiles(files []string, fn func(file, line string) bool) {
+	for _, file := range files {
+		more, err := readFile(file, fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// readFile calls fn with each line of file; more is false if fn stopped
+// the reading.
+func readFile(file string, fn func(file, line string) bool) (more bool, err error) {
+	var r io.Reader = os.Stdin
+	source := ""
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return true, err
+		}
+		defer f.Close()
+		r, source = f, file
+		if strings.HasSuffix(file, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return true, err
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" && !fn(source, strings.TrimSuffix(line, "\n")) {
+			return false, nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return true, nil
+			}
+			return true, err
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"errors"
 	"flag"
 	"fmt"
@@ -11,99 +10,584 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nudgebee/logparser"
 )
 
-func main() {
-	screenWidth := flag.Int("w", 120, "terminal width")
-	maxLinesPerMessage := flag.Int("l", 100, "max lines per message")
-	cluster := flag.Bool("cluster", false, "use Drain3 algorithm for log clustering")
-	maxPatterns := flag.Int("max-patterns", 20, "max number of patterns to display (used with -cluster)")
+// config holds the command line flags.
+type config struct {
+	screenWidth        int
+	maxLinesPerMessage int
+	cluster            bool
+	maxPatterns        int
+	showParams         int
+	samples            int
+	sparkline          bool
+	allLevels          bool
+	stateFile          string
+	format             string
+	multilineProfile   string
+	multilineTimeout   time.Duration
+	glob               string
+	byFile             bool
+	follow             bool
+	interval           time.Duration
+	noSensitive        bool
+	sensitivePatterns  string
+	patternPacks       []string
+	sensitiveReport    string
+	report             string
+	benchCorpus        string
+	files              []string
+	baseline           []string
+	diffThreshold      float64
+	outputFormat       string
+
+	decoder    logparser.Decoder
+	filter     *lineFilter
+	sampler    *lineSampler
+	thresholds thresholds
+}
+
+// parseFlags parses the command line arguments, without the program name,
+// and validates them. Errors are also written to output, along with the usage
+// for unknown flags and invalid values.
+func parseFlags(args []string, output io.Writer) (*config, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("logparser", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.IntVar(&cfg.screenWidth, "w", 120, "terminal width")
+	fs.IntVar(&cfg.maxLinesPerMessage, "l", 100, "max lines per message")
+	fs.BoolVar(&cfg.cluster, "cluster", false, "use Drain3 algorithm for log clustering")
+	fs.IntVar(&cfg.maxPatterns, "max-patterns", 20, "max number of patterns to display (used with -cluster and -f)")
+	fs.IntVar(&cfg.showParams, "show-params", 0, "show the top N values of each wildcard of the patterns and their level breakdown (used with -cluster)")
+	fs.IntVar(&cfg.samples, "samples", 1, "number of examples printed per pattern: the first message and the most recent ones (not used with -cluster)")
+	fs.BoolVar(&cfg.sparkline, "sparkline", false, "chart when the messages of each pattern were logged, from their timestamps (not used with -cluster and -f)")
+	fs.BoolVar(&cfg.allLevels, "all-levels", false, "group info and debug messages by pattern too")
+	fs.StringVar(&cfg.stateFile, "state-file", "", "load the learned patterns from this file on start and save them on exit (used with -cluster)")
+	fs.StringVar(&cfg.format, "format", "", "input format: docker (json-file), cri, syslog, logfmt, json, base64, gzip or sanitize (strip ANSI colours), or a comma-separated chain such as cri,json or docker,sanitize; plain lines if empty or none")
+	fs.StringVar(&cfg.format, "decoder", "", "alias of -format")
+	fs.StringVar(&cfg.multilineProfile, "multiline-profile", "", "multiline rules: java, python, go, csharp or none; built-in heuristics if empty")
+	fs.DurationVar(&cfg.multilineTimeout, "multiline-timeout", time.Second, "time to wait for the next line of a multiline message")
+	fs.StringVar(&cfg.glob, "glob", "*", "pattern of the file names read from directory arguments, e.g. '*.log*'")
+	fs.BoolVar(&cfg.byFile, "by-file", false, "break the patterns down per input file (not used with -cluster)")
+	fs.BoolVar(&cfg.follow, "f", false, "follow the files from their end as they grow, like tail -f, printing the patterns every -interval until interrupted (not used with -cluster)")
+	fs.DurationVar(&cfg.interval, "interval", 10*time.Second, "time between the summaries printed with -f")
+	fs.BoolVar(&cfg.noSensitive, "no-sensitive", false, "disable sensitive data detection")
+	fs.StringVar(&cfg.sensitivePatterns, "sensitive-patterns", "", "JSON file of sensitive patterns replacing the embedded ones (not used with -cluster)")
+	packNames := fs.String("pattern-packs", "", "comma-separated packs of patterns to detect on top of the sensitive patterns: "+patternPackNames()+" (not used with -cluster)")
+	fs.StringVar(&cfg.sensitiveReport, "sensitive-report", "", "write the sensitive data findings to this file as SARIF 2.1.0, or as JSON if its name ends in .json but not .sarif.json (not used with -f and diff)")
+	fs.StringVar(&cfg.report, "report", "", "print a summary to paste into a ticket instead of the patterns: markdown or text; it lists the patterns missing from -baseline as new (not used with -f)")
+	fs.StringVar(&cfg.benchCorpus, "bench-corpus", "", "parse this file over and over for a second with the other flags and print the lines/s and the allocations per line instead of the patterns")
+	levelNames := fs.String("level", "", "comma-separated levels of the patterns to display, e.g. error,critical; all if empty")
+	match := fs.String("match", "", "only read the lines matching this regexp")
+	exclude := fs.String("exclude", "", "skip the lines matching this regexp")
+	minCount := fs.Int("min-count", 0, "hide the patterns with fewer messages")
+	baseline := fs.String("baseline", "", "compare the patterns of the input with the ones of this file or directory, like the diff command")
+	fs.Float64Var(&cfg.diffThreshold, "threshold", 0.5, "min relative change of the count of a pattern, e.g. 0.5 for 50%, reported by diff")
+	fs.StringVar(&cfg.outputFormat, "o", "text", "output format of diff: text or json")
+	fs.BoolVar(&cfg.thresholds.failOnSensitive, "fail-on-sensitive", false, "exit with code 4 if sensitive data is found")
+	fs.IntVar(&cfg.thresholds.maxErrors, "max-errors", -1, "exit with code 3 if there are more error and critical messages; disabled if negative")
+	fs.Float64Var(&cfg.thresholds.maxErrorRate, "max-error-rate", -1, "exit with code 3 if the fraction of error and critical messages is higher, e.g. 0.05; disabled if negative")
+	sample := fs.Float64("sample", 1, "fraction of the lines to read, e.g. 0.01; counts are scaled to the whole input")
+	seed := fs.Int64("seed", 0, "seed of -sample, to reproduce a run; random if 0")
+	maxLines := fs.Int("max-lines", 0, "stop after reading this many lines; no limit if 0 (not used with -f)")
+	since := fs.String("since", "", "skip the lines before this time, e.g. 2024-01-15T10:00:00Z or 1h for an hour ago")
+	until := fs.String("until", "", "skip the lines from this time on, in the format of -since")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %[1]s [flags] [file|dir ...]\n       %[1]s diff [flags] before after\n\nReads stdin if no files are given; .gz files are decompressed.\ndiff prints the patterns that are new, disappeared or changed in after.\n\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nExit codes: 0 on success, 1 on errors, 2 for invalid flags, %d if -max-errors or -max-error-rate\nis exceeded and %d if -fail-on-sensitive finds sensitive data (it wins if both apply).\nThe thresholds are checked once all the input is read and violations are printed to stderr as JSON.\n", exitErrorThreshold, exitSensitive)
+	}
 
-	flag.Parse()
+	diff := len(args) > 0 && args[0] == "diff"
+	if diff {
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*config, error) {
+		fmt.Fprintln(fs.Output(), err)
+		return nil, err
+	}
+	var err error
+	if cfg.decoder, err = newDecoder(cfg.format); err != nil {
+		return fail(err)
+	}
+	if cfg.filter, err = newLineFilter(*levelNames, *match, *exclude, *minCount); err != nil {
+		return fail(err)
+	}
+	if cfg.interval <= 0 {
+		return fail(errors.New("-interval must be positive"))
+	}
+	if cfg.showParams < 0 {
+		return fail(errors.New("-show-params can't be negative"))
+	}
+	if cfg.samples < 1 {
+		return fail(errors.New("-samples must be positive"))
+	}
+	if cfg.patternPacks, err = parsePatternPacks(*packNames); err != nil {
+		return fail(err)
+	}
+	if cfg.report != "" && cfg.report != string(logparser.ReportMarkdown) && cfg.report != string(logparser.ReportText) {
+		return fail(fmt.Errorf("unknown report format %q, want markdown or text", cfg.report))
+	}
+	timestamps := logparser.NewTimestampExtractor()
+	sinceTime, err := parseTime(timestamps, *since)
+	if err != nil {
+		return fail(fmt.Errorf("invalid -since: %w", err))
+	}
+	untilTime, err := parseTime(timestamps, *until)
+	if err != nil {
+		return fail(fmt.Errorf("invalid -until: %w", err))
+	}
+	if cfg.follow {
+		*maxLines = 0
+	}
+	if cfg.sampler, err = newLineSampler(*sample, *seed, *maxLines, sinceTime, untilTime); err != nil {
+		return fail(err)
+	}
+	args = fs.Args()
+	if cfg.benchCorpus != "" && (diff || cfg.follow || cfg.cluster || cfg.report != "" || *baseline != "" || len(args) > 0) {
+		return fail(errors.New("-bench-corpus can't be used with diff, -f, -cluster, -report, -baseline or input files"))
+	}
+	if diff && *baseline == "" {
+		if len(args) != 2 {
+			return fail(errors.New("diff needs two inputs: before and after"))
+		}
+		*baseline, args = args[0], args[1:]
+	}
+	if *baseline != "" {
+		if cfg.follow {
+			return fail(errors.New("-f can't be used with diff"))
+		}
+		if diff && cfg.report != "" {
+			return fail(errors.New("-report can't be used with diff, use -baseline instead"))
+		}
+		if cfg.outputFormat != "text" && cfg.outputFormat != "json" {
+			return fail(fmt.Errorf("unknown output format %q, want text or json", cfg.outputFormat))
+		}
+		cfg.baseline = inputFiles([]string{*baseline}, cfg.glob)
+	}
+	cfg.files = inputFiles(args, cfg.glob)
+	return cfg, nil
+}
+
+// parserOptions returns the options of the parser of the default and follow
+// modes.
+func (cfg *config) parserOptions(timestamps *logparser.TimestampExtractor) ([]logparser.ParserOption, error) {
+	opts := []logparser.ParserOption{
+		logparser.WithDecoder(cfg.decoder),
+		logparser.WithTimestampExtraction(timestamps),
+		logparser.WithMultilineTimeout(cfg.multilineTimeout),
+		logparser.WithPatternsPerLevelLimit(256),
+		logparser.WithSensitiveConfig(logparser.SensitiveConfig{Enabled: !cfg.noSensitive, MinConfidence: "medium"}),
+		logparser.WithPatternizeAllLevels(cfg.allLevels),
+		logparser.WithSamplesPerPattern(cfg.samples),
+	}
+	if !cfg.byFile {
+		// the entries keep their file so that multiline messages don't
+		// span files, but are counted together
+		opts = append(opts, logparser.WithMergedSources())
+	}
+	if cfg.sparkline {
+		opts = append(opts, logparser.WithHistogram(sparklineBuckets))
+	}
+	if cfg.multilineProfile != "" {
+		opts = append(opts, logparser.WithMultilineProfile(cfg.multilineProfile))
+	}
+	patterns, err := cfg.customSensitivePatterns()
+	if err != nil {
+		return nil, err
+	}
+	if patterns != nil {
+		opts = append(opts, logparser.WithSensitivePatterns(patterns))
+	}
+	if len(cfg.patternPacks) > 0 {
+		opts = append(opts, logparser.WithPatternPacks(cfg.patternPacks...))
+	}
+	return opts, nil
+}
+
+// patternPackNames returns the names of the available pattern packs for the
+// usage of -pattern-packs.
+func patternPackNames() string {
+	var names []string
+	for _, p := range logparser.PatternPacks() {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// parsePatternPacks parses the value of -pattern-packs.
+func parsePatternPacks(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if _, err := logparser.LoadPatternPack(name); err != nil {
+			return nil, fmt.Errorf("invalid -pattern-packs: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// customSensitivePatterns returns the patterns of -sensitive-patterns, or nil
+// if it isn't set.
+func (cfg *config) customSensitivePatterns() ([]logparser.PrecompiledPattern, error) {
+	if cfg.sensitivePatterns == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(cfg.sensitivePatterns)
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := logparser.ParsePatterns(data, "medium")
+	if err != nil {
+		return nil, fmt.Errorf("invalid -sensitive-patterns: %w", err)
+	}
+	return patterns, nil
+}
 
-	if *cluster {
-		runClusterMode(*screenWidth, *maxPatterns)
+func main() {
+	cfg, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
+	if err != nil {
+		os.Exit(2)
+	}
 
-	reader := bufio.NewReader(os.Stdin)
-	ch := make(chan logparser.LogEntry)
-	parser := logparser.NewParser(ch, nil, nil, time.Second, 256, logparser.SensitiveConfig{Enabled: true, MinConfidence: "medium"})
-	t := time.Now()
-	for {
-		line, err := reader.ReadString('\n')
+	if cfg.benchCorpus != "" {
+		if err := runBenchMode(cfg, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.baseline != nil && cfg.report == "" {
+		if err := runDiffMode(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.cluster {
+		os.Exit(runClusterMode(cfg))
+	}
+
+	if cfg.follow {
+		opts, err := cfg.parserOptions(logparser.NewTimestampExtractor())
 		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				fmt.Println(err)
-			}
-			break
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		ch <- logparser.LogEntry{Timestamp: time.Now(), Content: strings.TrimSuffix(line, "\n"), Level: logparser.LevelUnknown}
+		os.Exit(runFollowMode(cfg, opts))
+	}
+	t := time.Now()
+	parser, err := cfg.countPatterns(cfg.files, cfg.sampler)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing parser: %v\n", err)
+		os.Exit(1)
 	}
 	d := time.Since(t)
-	defer parser.Stop()
 
 	counters := parser.GetCounters()
+	cfg.sampler.scaleCounters(counters)
+	errorCount, total := levelCounts(counters)
+	counters, hidden := cfg.filter.counters(counters)
 	sensitiveCounter := parser.GetSensitiveCounters()
 
 	order(counters)
+	orderSensitive(sensitiveCounter)
 
-	output(counters, *screenWidth, *maxLinesPerMessage, d)
-	outputSensitive(sensitiveCounter, *screenWidth, *maxLinesPerMessage, d)
-}
-
-func runClusterMode(screenWidth, maxPatterns int) {
-	// Create streaming pattern extractor (memory-efficient)
-	extractor, err := logparser.NewPatternExtractor()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error initializing pattern extractor: %v\n", err)
+	if cfg.report != "" {
+		if err := cfg.outputReport(os.Stdout, counters, sensitiveCounter, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating the report: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		if cfg.byFile {
+			bySource := map[string][]logparser.LogCounter{}
+			for _, c := range counters {
+				bySource[c.Source] = append(bySource[c.Source], c)
+			}
+			outputBySource(bySource, cfg.screenWidth, cfg.maxLinesPerMessage, cfg.samples, cfg.sparkline, d)
+		} else {
+			output(counters, cfg.screenWidth, cfg.maxLinesPerMessage, cfg.samples, cfg.sparkline, d)
+		}
+		if cfg.allLevels {
+			outputTopInfoPatterns(counters, cfg.screenWidth, 10)
+		}
+		outputSensitive(sensitiveCounter, cfg.screenWidth, cfg.maxLinesPerMessage, d)
+		cfg.outputSummary(hidden)
+	}
+	if err := cfg.writeSensitiveReport(sensitiveCounter); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the sensitive report: %v\n", err)
 		os.Exit(1)
 	}
+	os.Exit(cfg.thresholds.check(os.Stderr, errorCount, total, sensitiveMessages(sensitiveCounter)))
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
-
-	// Increase buffer size for long log lines
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024) // 1MB max line size
+// sensitiveMessages returns the number of sensitive findings of counters.
+func sensitiveMessages(counters []logparser.SensitiveLogCounter) int {
+	n := 0
+	for _, c := range counters {
+		n += c.Messages
+	}
+	return n
+}
 
-	startTime := time.Now()
-	lineCount := 0
+// countPatterns runs a parser over the lines of files kept by sampler and the
+// filter, and returns it stopped.
+func (cfg *config) countPatterns(files []string, sampler *lineSampler) (*logparser.Parser, error) {
+	timestamps := logparser.NewTimestampExtractor()
+	opts, err := cfg.parserOptions(timestamps)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan logparser.LogEntry)
+	parser, err := logparser.NewParserWithOptions(ch, opts...)
+	if err != nil {
+		return nil, err
+	}
+	readFiles(files, func(file, line string) bool {
+		ts, ok := entryTime(timestamps, line)
+		if !sampler.keep(ts, ok) {
+			return !sampler.done()
+		}
+		if !cfg.filter.keepLine(line) {
+			return true
+		}
+		ch <- logparser.LogEntry{Timestamp: ts, Content: line, Level: logparser.LevelUnknown, Source: file}
+		return true
+	})
+	if err := parser.StopAndDrain(5 * time.Second); err != nil {
+		fmt.Fprintf(os.Stderr, "Error draining parser: %v\n", err)
+	}
+	return parser, nil
+}
 
-	// Stream logs one at a time (memory-efficient)
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineCount++
-		if err := extractor.AddLog(line); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to process line %d: %v\n", lineCount, err)
+// outputSummary prints what the filters and the sampler left out of the
+// input; hidden is the number of messages hidden by the filters.
+func (cfg *config) outputSummary(hidden int) {
+	for _, summary := range []string{cfg.sampler.summary(), cfg.filter.summary(hidden)} {
+		if summary != "" {
+			fmt.Println(summary)
 		}
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-		os.Exit(1)
+// runClusterMode prints the patterns of the Drain3 clustering and returns
+// the exit code.
+func runClusterMode(cfg *config) int {
+	filter := cfg.filter
+	screenWidth, maxPatterns, maxLinesPerMessage, stateFile := cfg.screenWidth, cfg.maxPatterns, cfg.maxLinesPerMessage, cfg.stateFile
+
+	// Create streaming pattern extractor (memory-efficient)
+	extractor, err := cfg.newExtractor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing pattern extractor: %v\n", err)
+		return 1
+	}
+	if stateFile != "" {
+		if err := loadState(extractor, stateFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading state: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := saveState(extractor, stateFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving state: %v\n", err)
+			}
+		}()
 	}
 
+	startTime := time.Now()
+	lineCount := cfg.extractPatterns(extractor, cfg.files, cfg.sampler)
 	if lineCount == 0 {
 		fmt.Println("No logs to process")
-		return
+		return 0
 	}
 
-	// Extract patterns from processed logs
-	patterns := extractor.GetPatterns(maxPatterns)
+	// Extract patterns from processed logs, per level
+	byLevel := extractor.GetPatternsByLevel(maxPatterns)
+	cfg.sampler.scalePatterns(byLevel)
+	byLevel, hidden := filter.patterns(byLevel)
+	sensitive := extractor.GetSensitivePatterns()
 	duration := time.Since(startTime)
 
+	all := extractor.GetPatternsByLevel(0)
+	cfg.sampler.scalePatterns(all)
+	errorCount, total := 0, 0
+	for level, patterns := range all {
+		for _, p := range patterns {
+			total += p.Count
+			if level == logparser.LevelError || level == logparser.LevelCritical {
+				errorCount += p.Count
+			}
+		}
+	}
+	code := cfg.thresholds.check(os.Stderr, errorCount, total, sensitiveMessages(sensitive))
+	if err := cfg.writeSensitiveReport(sensitive); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing the sensitive report: %v\n", err)
+		return 1
+	}
+	if cfg.report != "" {
+		var patterns []logparser.LogPattern
+		for _, level := range levels {
+			patterns = append(patterns, byLevel[level]...)
+		}
+		if err := cfg.outputReport(os.Stdout, nil, sensitive, patterns); err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating the report: %v\n", err)
+			return 1
+		}
+		return code
+	}
+
 	// Display results
 	fmt.Printf("\n=== LOG PATTERNS (Drain3 Clustering) ===\n\n")
 	fmt.Printf("Processed %d log lines in %.3f seconds\n", lineCount, duration.Seconds())
-	fmt.Printf("Found %d unique patterns\n\n", len(patterns))
+	found := 0
+	for _, patterns := range byLevel {
+		found += len(patterns)
+	}
+	fmt.Printf("Found %d unique patterns\n", found)
+	cfg.outputSummary(hidden)
 
-	if len(patterns) == 0 {
-		fmt.Println("No patterns found")
-		return
+	if len(byLevel) == 0 {
+		fmt.Println("\nNo patterns found")
+		return code
+	}
+
+	for _, level := range levels {
+		patterns := byLevel[level]
+		if len(patterns) == 0 {
+			continue
+		}
+		fmt.Printf("\n--- %s (%d patterns) ---\n", strings.ToUpper(level.String()), len(patterns))
+		outputClusterPatterns(patterns, screenWidth, cfg.showParams, levelCountsByHash(all))
+	}
+
+	if len(sensitive) > 0 {
+		fmt.Printf("\n--- SENSITIVE DATA (%d findings) ---\n", len(sensitive))
+		orderSensitive(sensitive)
+		outputSensitive(sensitive, screenWidth, maxLinesPerMessage, duration)
 	}
 
+	fmt.Print("\n" + strings.Repeat("=", screenWidth) + "\n")
+	return code
+}
+
+// newExtractor returns the pattern extractor of the cluster mode.
+func (cfg *config) newExtractor() (*logparser.PatternExtractor, error) {
+	var opts []logparser.PatternExtractorOption
+	if !cfg.noSensitive {
+		opts = append(opts, logparser.WithClusterSensitiveDetection("medium"))
+	}
+	if cfg.showParams > 0 {
+		opts = append(opts, logparser.WithClusterSamples(paramSamples))
+	}
+	return logparser.NewPatternExtractor(opts...)
+}
+
+// extractPatterns adds the lines of files kept by sampler and the filter to
+// extractor, and returns the number of lines read.
+func (cfg *config) extractPatterns(extractor *logparser.PatternExtractor, files []string, sampler *lineSampler) int {
+	timestamps := logparser.NewTimestampExtractor()
+	lineCount := 0
+
+	// Stream logs one at a time (memory-efficient), assembling multiline
+	// messages such as stack traces
+	entries := make(chan logparser.LogEntry)
+	done := make(chan error)
+	go func() { done <- extractor.AddEntries(entries, cfg.multilineTimeout) }()
+	readFiles(files, func(file, line string) bool {
+		ts, ok := entryTime(timestamps, line)
+		if !sampler.keep(ts, ok) {
+			return !sampler.done()
+		}
+		lineCount++
+		if !cfg.filter.keepLine(line) {
+			return true
+		}
+		entry := logparser.LogEntry{Timestamp: ts, Content: line, Source: file}
+		if cfg.decoder != nil {
+			var err error
+			if entry, ok, err = logparser.DecodeEntry(cfg.decoder, entry); err != nil || !ok {
+				return true
+			}
+		}
+		entries <- entry
+		return true
+	})
+	// The entries still held back by the decoder, such as a trailing CRI
+	// partial line, are complete now.
+	for _, entry := range logparser.FlushEntries(cfg.decoder, time.Now()) {
+		entries <- entry
+	}
+	close(entries)
+	if err := <-done; err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to process some messages: %v\n", err)
+	}
+	return lineCount
+}
+
+// entryTime returns the timestamp at the start of line, so that replayed
+// logs keep their original times, or the current time and false if it has
+// none.
+func entryTime(timestamps *logparser.TimestampExtractor, line string) (time.Time, bool) {
+	if ts, _, ok := timestamps.Extract(line); ok {
+		return ts, true
+	}
+	return time.Now(), false
+}
+
+// newDecoder returns the decoder of the -format flag, nil for plain lines.
+// Comma-separated formats are chained.
+func newDecoder(format string) (logparser.Decoder, error) {
+	if format == "" || format == "none" {
+		return nil, nil
+	}
+	var decoders []logparser.Decoder
+	for _, f := range strings.Split(format, ",") {
+		switch f {
+		case "docker":
+			// the level of stderr lines is guessed from their content:
+			// many programs write informational output to stderr
+			decoders = append(decoders, logparser.DockerJSONDecoder{})
+		case "cri":
+			decoders = append(decoders, logparser.NewCRIDecoder())
+		case "syslog":
+			decoders = append(decoders, logparser.SyslogDecoder{})
+		case "logfmt":
+			decoders = append(decoders, logparser.LogfmtDecoder{})
+		case "json":
+			decoders = append(decoders, logparser.JSONDecoder{})
+		case "base64":
+			decoders = append(decoders, logparser.Base64Decoder{})
+		case "gzip":
+			decoders = append(decoders, logparser.GzipDecoder{})
+		case "sanitize":
+			decoders = append(decoders, logparser.NewSanitizeDecoder())
+		default:
+			return nil, fmt.Errorf("unknown format %q, want docker, cri, syslog, logfmt, json, base64, gzip, sanitize or none", f)
+		}
+	}
+	if len(decoders) == 1 {
+		return decoders[0], nil
+	}
+	return logparser.ChainDecoder(decoders...), nil
+}
+
+// outputClusterPatterns prints patterns with bars scaled to the most frequent
+// one. If showParams is positive, the level breakdown of each pattern, taken
+// from byHash, and the top showParams values of its wildcards are shown too.
+func outputClusterPatterns(patterns []logparser.LogPattern, screenWidth, showParams int, byHash map[string]map[logparser.Level]int) {
 	// Calculate max count for bar chart
 	maxCount := 0
 	for _, p := range patterns {
@@ -126,22 +610,65 @@ func runClusterMode(screenWidth, maxPatterns int) {
 		// Format pattern template
 		template := pattern.Template
 		if len(template) > lineWidth {
-			template = template[:lineWidth-3] + "..."
+			template = cutLine(template, lineWidth-3) + "..."
 		}
 
 		// Display pattern
 		fmt.Printf("\n[%2d] %s %5d (%5.1f%%)\n", i+1, bar, pattern.Count, pattern.Percentage)
 		fmt.Printf("     Pattern: %s\n", template)
 
-		// Show example (truncated)
-		example := pattern.Example
-		if len(example) > screenWidth-10 {
-			example = example[:screenWidth-13] + "..."
+		// Show example (truncated), aligning the lines of multiline messages
+		lines := strings.Split(pattern.Example, "\n")
+		for j, line := range lines {
+			if len(line) > screenWidth-10 {
+				lines[j] = cutLine(line, screenWidth-13) + "..."
+			}
+		}
+		fmt.Printf("     Example: %s\n", strings.Join(lines, "\n              "))
+
+		if showParams > 0 {
+			fmt.Printf("     Levels:  %s\n", formatLevels(byHash[pattern.Hash]))
+			top, distinct := paramValues(pattern, showParams)
+			if len(top) > 0 {
+				fmt.Printf("     Params (in %d sampled logs):\n", len(pattern.Samples))
+				for _, line := range formatParams(top, distinct, screenWidth-7) {
+					fmt.Printf("       %s\n", line)
+				}
+			}
 		}
-		fmt.Printf("     Example: %s\n", example)
 	}
+}
 
-	fmt.Printf("\n" + strings.Repeat("=", screenWidth) + "\n")
+// loadState restores the extractor from path; a missing file is not an error.
+func loadState(extractor *logparser.PatternExtractor, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return extractor.LoadState(f)
+}
+
+// saveState writes the extractor state to path, replacing it atomically.
+func saveState(extractor *logparser.PatternExtractor, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := extractor.SaveState(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 func order(counters []logparser.LogCounter) {
@@ -154,7 +681,17 @@ func order(counters []logparser.LogCounter) {
 	})
 }
 
-func output(counters []logparser.LogCounter, screenWidth, maxLinesPerMessage int, duration time.Duration) {
+func orderSensitive(counters []logparser.SensitiveLogCounter) {
+	sort.Slice(counters, func(i, j int) bool {
+		ci, cj := counters[i], counters[j]
+		if ci.Category == cj.Category {
+			return ci.Messages > cj.Messages
+		}
+		return ci.Category < cj.Category
+	})
+}
+
+func output(counters []logparser.LogCounter, screenWidth, maxLinesPerMessage, samples int, sparklines bool, duration time.Duration) {
 	grandTotal, total, max := 0, 0, 0
 	for _, c := range counters {
 		grandTotal += c.Messages
@@ -168,6 +705,10 @@ func output(counters []logparser.LogCounter, screenWidth, maxLinesPerMessage int
 	}
 	barWidth := 20
 	lineWidth := screenWidth - barWidth
+	from, to := histogramRange(counters)
+	if sparklines {
+		lineWidth -= sparklineWidth + 1
+	}
 	messagesNumFmt := fmt.Sprintf("%%%dd", len(strconv.Itoa(max)))
 	for _, c := range counters {
 		if c.Sample == "" {
@@ -175,11 +716,14 @@ func output(counters []logparser.LogCounter, screenWidth, maxLinesPerMessage int
 		}
 		w := c.Messages * barWidth / max
 		bar := strings.Repeat("▇", w+1) + strings.Repeat(" ", barWidth-w)
-		prefix := colorize(c.Level, "%s "+messagesNumFmt+" (%2d%%) ", bar, c.Messages, int(float64(c.Messages*100)/float64(total)))
+		prefix := colorize(c.Level, "%s "+messagesNumFmt+" (%2d%%) %9s ", bar, c.Messages, int(float64(c.Messages*100)/float64(total)), formatBytes(c.Bytes))
+		if sparklines {
+			prefix += sparkline(c, from, to, sparklineWidth) + " "
+		}
 		sample := ""
 		for i, line := range strings.Split(c.Sample, "\n") {
 			if len(line) > lineWidth {
-				line = line[:lineWidth] + "..."
+				line = cutLine(line, lineWidth) + "..."
 			}
 			sample += line + "\n" + strings.Repeat(" ", len(prefix))
 			if i > maxLinesPerMessage {
@@ -189,16 +733,100 @@ func output(counters []logparser.LogCounter, screenWidth, maxLinesPerMessage int
 		}
 		sample = strings.TrimRight(sample, "\n ")
 		fmt.Printf("%s%s\n", prefix, sample)
+		for _, s := range recentSamples(c, samples-1) {
+			fmt.Printf("%s↳ %s\n", strings.Repeat(" ", len(prefix)), firstLine(s, lineWidth))
+		}
 	}
 
 	byLevel := map[logparser.Level]int{}
+	bytesByLevel := map[logparser.Level]int64{}
 	for _, c := range counters {
 		byLevel[c.Level] += c.Messages
+		bytesByLevel[c.Level] += c.Bytes
 	}
 	fmt.Println()
 	fmt.Printf("%d messages processed in %.3f seconds:\n", grandTotal, duration.Seconds())
 	for l, c := range byLevel {
-		fmt.Printf("  %s: %d\n", l, c)
+		fmt.Printf("  %s: %d (%s)\n", l, c, formatBytes(bytesByLevel[l]))
+	}
+	fmt.Println()
+}
+
+// recentSamples returns up to n of the most recent samples of c, newest
+// first, that differ from its first sample and from each other.
+func recentSamples(c logparser.LogCounter, n int) []string {
+	var res []string
+	seen := map[string]bool{c.Sample: true}
+	for i := len(c.Samples) - 1; i >= 0 && len(res) < n; i-- {
+		if s := c.Samples[i]; !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// outputBySource prints the patterns of each input file.
+func outputBySource(bySource map[string][]logparser.LogCounter, screenWidth, maxLinesPerMessage, samples int, sparklines bool, duration time.Duration) {
+	sources := make([]string, 0, len(bySource))
+	for source := range bySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		counters := bySource[source]
+		order(counters)
+		fmt.Printf("==> %s <==\n", source)
+		output(counters, screenWidth, maxLinesPerMessage, samples, sparklines, duration)
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// cutLine cuts line to at most n bytes, at a rune boundary so that no
+// character is split.
+func cutLine(line string, n int) string {
+	n = max(n, 0)
+	if len(line) <= n {
+		return line
+	}
+	for n > 0 && !utf8.RuneStart(line[n]) {
+		n--
+	}
+	return line[:n]
+}
+
+// outputTopInfoPatterns prints the info, debug and unknown level patterns
+// with the most messages.
+func outputTopInfoPatterns(counters []logparser.LogCounter, screenWidth, limit int) {
+	var top []logparser.LogCounter
+	for _, c := range counters {
+		if c.Pattern != "" && (c.Level == logparser.LevelInfo || c.Level == logparser.LevelDebug || c.Level == logparser.LevelUnknown) {
+			top = append(top, c)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Messages > top[j].Messages })
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	fmt.Println("top info patterns:")
+	for _, c := range top {
+		line := fmt.Sprintf("  %6d %s", c.Messages, c.Pattern)
+		if len(line) > screenWidth {
+			line = cutLine(line, screenWidth) + "..."
+		}
+		fmt.Println(colorize(c.Level, "%s", line))
 	}
 	fmt.Println()
 }
@@ -216,6 +844,19 @@ func colorize(level logparser.Level, format string, a ...interface{}) string {
 	return fmt.Sprintf(c+format+"\033[0m", a...)
 }
 
+func colorizeSeverity(severity string, format string, a ...interface{}) string {
+	c := "\033[37m" // grey
+	switch severity {
+	case "critical":
+		c = "\033[1;31m" // bold red
+	case "high":
+		c = "\033[31m" // red
+	case "medium":
+		c = "\033[33m" // yellow
+	}
+	return fmt.Sprintf(c+format+"\033[0m", a...)
+}
+
 func outputSensitive(counters []logparser.SensitiveLogCounter, screenWidth, maxLinesPerMessage int, duration time.Duration) {
 	grandTotal, total, max := 0, 0, 0
 	for _, c := range counters {
@@ -237,11 +878,11 @@ func outputSensitive(counters []logparser.SensitiveLogCounter, screenWidth, maxL
 		}
 		w := c.Messages * barWidth / max
 		bar := strings.Repeat("▇", w+1) + strings.Repeat(" ", barWidth-w)
-		prefix := colorize(logparser.LevelCritical, "%s "+messagesNumFmt+" (%2d%%) ", bar, c.Messages, int(float64(c.Messages*100)/float64(total)))
+		prefix := colorizeSeverity(c.Severity, "%s "+messagesNumFmt+" (%2d%%) ", bar, c.Messages, int(float64(c.Messages*100)/float64(total)))
 		sample := ""
 		for i, line := range strings.Split(c.Sample, "\n") {
 			if len(line) > lineWidth {
-				line = line[:lineWidth] + "..."
+				line = cutLine(line, lineWidth) + "..."
 			}
 			sample += line + "\n" + strings.Repeat(" ", len(prefix))
 			if i > maxLinesPerMessage {
@@ -253,14 +894,19 @@ func outputSensitive(counters []logparser.SensitiveLogCounter, screenWidth, maxL
 		fmt.Printf("%s%s%s%s\n", prefix, sample, c.Name, c.Regex)
 	}
 
-	byLevel := map[string]int{}
+	byCategory := map[string]int{}
 	for _, c := range counters {
-		byLevel[c.Pattern] += c.Messages
+		byCategory[c.Category] += c.Messages
+	}
+	categories := make([]string, 0, len(byCategory))
+	for category := range byCategory {
+		categories = append(categories, category)
 	}
+	sort.Strings(categories)
 	fmt.Println()
 	fmt.Printf("%d messages processed in %.3f seconds:\n", grandTotal, duration.Seconds())
-	for l, c := range byLevel {
-		fmt.Printf("  %s: %d\n", l, c)
+	for _, category := range categories {
+		fmt.Printf("  %s: %d\n", category, byCategory[category])
 	}
 	fmt.Println()
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nudgebee/logparser"
+)
+
+// paramSamples is the number of logs sampled per pattern for -show-params.
+const paramSamples = 100
+
+// maxParamWidth is the width beyond which a wildcard value is truncated.
+const maxParamWidth = 40
+
+// paramValue is a value of a wildcard and the number of sampled logs having
+// it.
+type paramValue struct {
+	value string
+	count int
+}
+
+// paramValues returns the n most frequent values of each wildcard of the
+// template of p in its samples, and the number of distinct values of each
+// wildcard. Samples that don't match the template, which may have been
+// generalised since they were taken, are skipped.
+func paramValues(p logparser.LogPattern, n int) ([][]paramValue, []int) {
+	var counts []map[string]int
+	for _, sample := range p.Samples {
+		params, err := logparser.ExtractParameters(p.Template, sample)
+		if err != nil {
+			continue
+		}
+		for len(counts) < len(params) {
+			counts = append(counts, map[string]int{})
+		}
+		for i, v := range params {
+			counts[i][v]++
+		}
+	}
+	top := make([][]paramValue, len(counts))
+	distinct := make([]int, len(counts))
+	for i, c := range counts {
+		for v, count := range c {
+			top[i] = append(top[i], paramValue{value: v, count: count})
+		}
+		sort.Slice(top[i], func(a, b int) bool {
+			if top[i][a].count != top[i][b].count {
+				return top[i][a].count > top[i][b].count
+			}
+			return top[i][a].value < top[i][b].value
+		})
+		distinct[i] = len(top[i])
+		if len(top[i]) > n {
+			top[i] = top[i][:n]
+		}
+	}
+	return top, distinct
+}
+
+// formatParams returns one line per wildcard listing its top values with
+// their counts, e.g. `#1: "USJOT" (12), "USNYC" (3), +4 more`. Values are
+// left out rather than going beyond width, but the first one is always shown.
+func formatParams(top [][]paramValue, distinct []int, width int) []string {
+	lines := make([]string, 0, len(top))
+	for i, values := range top {
+		line := fmt.Sprintf("#%d:", i+1)
+		shown := 0
+		for _, v := range values {
+			value := v.value
+			if len(value) > maxParamWidth {
+				value = cutLine(value, maxParamWidth-3) + "..."
+			}
+			item := fmt.Sprintf(" %q (%d)", value, v.count)
+			if shown > 0 {
+				item = "," + item
+			}
+			suffix := ""
+			if more := distinct[i] - shown - 1; more > 0 {
+				suffix = fmt.Sprintf(", +%d more", more)
+			}
+			if shown > 0 && len(line)+len(item)+len(suffix) > width {
+				break
+			}
+			line += item
+			shown++
+		}
+		if more := distinct[i] - shown; more > 0 {
+			line += fmt.Sprintf(", +%d more", more)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// levelCountsByHash returns the number of messages of each level for every
+// pattern hash of byLevel, as a pattern may be found at several levels.
+func levelCountsByHash(byLevel map[logparser.Level][]logparser.LogPattern) map[string]map[logparser.Level]int {
+	res := map[string]map[logparser.Level]int{}
+	for level, patterns := range byLevel {
+		for _, p := range patterns {
+			if res[p.Hash] == nil {
+				res[p.Hash] = map[logparser.Level]int{}
+			}
+			res[p.Hash][level] += p.Count
+		}
+	}
+	return res
+}
+
+// formatLevels returns a breakdown such as "E:12 W:3 I:0", errors including
+// critical messages. Debug and unknown levels are only shown when present.
+func formatLevels(counts map[logparser.Level]int) string {
+	res := fmt.Sprintf("E:%d W:%d I:%d", counts[logparser.LevelError]+counts[logparser.LevelCritical], counts[logparser.LevelWarning], counts[logparser.LevelInfo])
+	if n := counts[logparser.LevelDebug]; n > 0 {
+		res += fmt.Sprintf(" D:%d", n)
+	}
+	if n := counts[logparser.LevelUnknown]; n > 0 {
+		res += fmt.Sprintf(" ?:%d", n)
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nudgebee/logparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamValues(t *testing.T) {
+	p := logparser.LogPattern{
+		Template: "Failed to get location: * | code *",
+		Samples: []string{
+			"Failed to get location: USJOT | code 404",
+			"Failed to get location: USJOT | code 500",
+			"Failed to get location: USNYC | code 404",
+			"Failed to get location: DEHAM | code 404",
+			"Failed to get location: USJOT | code 404",
+			"unrelated line",
+		},
+	}
+	top, distinct := paramValues(p, 2)
+	assert.Equal(t, [][]paramValue{
+		{{"USJOT", 3}, {"DEHAM", 1}},
+		{{"404", 4}, {"500", 1}},
+	}, top)
+	assert.Equal(t, []int{3, 2}, distinct)
+	assert.Equal(t, []string{
+		`#1: "USJOT" (3), "DEHAM" (1), +1 more`,
+		`#2: "404" (4), "500" (1)`,
+	}, formatParams(top, distinct, 80))
+
+	// Values that don't fit are counted in the suffix, but one is always shown.
+	assert.Equal(t, []string{`#1: "USJOT" (3), +2 more`, `#2: "404" (4), +1 more`}, formatParams(top, distinct, 23))
+	assert.Equal(t, []string{`#1: "USJOT" (3), +2 more`, `#2: "404" (4), +1 more`}, formatParams(top, distinct, 1))
+
+	long := [][]paramValue{{{strings.Repeat("x", 100), 1}}}
+	assert.Equal(t, []string{`#1: "` + strings.Repeat("x", 37) + `..." (1)`}, formatParams(long, []int{1}, 200))
+	// Values are cut at a rune boundary.
+	long = [][]paramValue{{{strings.Repeat("é", 50), 1}}}
+	assert.Equal(t, []string{`#1: "` + strings.Repeat("é", 18) + `..." (1)`}, formatParams(long, []int{1}, 200))
+
+	top, distinct = paramValues(logparser.LogPattern{Template: "health check ok", Samples: []string{"health check ok"}}, 5)
+	assert.Empty(t, top)
+	assert.Empty(t, formatParams(top, distinct, 80))
+}
+
+func TestLevelBreakdown(t *testing.T) {
+	byHash := levelCountsByHash(map[logparser.Level][]logparser.LogPattern{
+		logparser.LevelCritical: {{Hash: "a", Count: 2}},
+		logparser.LevelError:    {{Hash: "a", Count: 10}, {Hash: "b", Count: 1}},
+		logparser.LevelWarning:  {{Hash: "a", Count: 3}},
+		logparser.LevelDebug:    {{Hash: "b", Count: 7}},
+	})
+	assert.Equal(t, "E:12 W:3 I:0", formatLevels(byHash["a"]))
+	assert.Equal(t, "E:1 W:0 I:0 D:7", formatLevels(byHash["b"]))
+	assert.Equal(t, "E:0 W:0 I:0", formatLevels(byHash["missing"]))
+}
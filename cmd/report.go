@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nudgebee/logparser"
+)
+
+// writeSensitiveReport writes counters to the file of -sensitive-report, if
+// set: as JSON if its name ends in .json but not .sarif.json, as SARIF
+// otherwise.
+func (cfg *config) writeSensitiveReport(counters []logparser.SensitiveLogCounter) error {
+	if cfg.sensitiveReport == "" {
+		return nil
+	}
+	patterns, err := cfg.customSensitivePatterns()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(cfg.sensitiveReport)
+	if err != nil {
+		return err
+	}
+	meta := logparser.ReportMeta{ToolName: "logparser", InformationURI: "https://github.com/nudgebee/logparser", Patterns: patterns}
+	name := strings.ToLower(cfg.sensitiveReport)
+	if strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".sarif.json") {
+		err = logparser.WriteSensitiveReportJSON(f, counters, meta)
+	} else {
+		err = logparser.WriteSensitiveReportSARIF(f, counters, meta)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// outputReport writes the -report summary of counters, sensitive findings and
+// clustered patterns to w, with redacted samples. The patterns that aren't in
+// the -baseline input, if any, are listed as new.
+func (cfg *config) outputReport(w io.Writer, counters []logparser.LogCounter, sensitive []logparser.SensitiveLogCounter, patterns []logparser.LogPattern) error {
+	custom, err := cfg.customSensitivePatterns()
+	if err != nil {
+		return err
+	}
+	opts := logparser.ReportOptions{Format: logparser.ReportFormat(cfg.report), Redact: true, RedactPatterns: custom}
+	if cfg.baseline != nil && !cfg.cluster {
+		sampler, err := newLineSampler(cfg.sampler.rate, cfg.sampler.seed, cfg.sampler.maxLines, cfg.sampler.since, cfg.sampler.until)
+		if err != nil {
+			return err
+		}
+		parser, err := cfg.countPatterns(cfg.baseline, sampler)
+		if err != nil {
+			return err
+		}
+		opts.Baseline = parser.GetCounters()
+	}
+	report, err := logparser.GenerateReport(counters, sensitive, patterns, opts)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, report)
+	return err
+}
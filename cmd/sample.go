@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nudgebee/logparser"
+)
+
+// lineSampler implements the -sample, -max-lines, -since and -until flags.
+// A line without a timestamp that follows one with a timestamp shares its
+// fate, so that multiline messages such as stack traces are kept or skipped
+// whole.
+type lineSampler struct {
+	rate         float64 // fraction of the lines kept, 1 to keep all
+	seed         int64
+	maxLines     int // 0 for no limit
+	since, until time.Time
+
+	lock        sync.Mutex
+	rng         *rand.Rand
+	lines       int // lines read
+	kept        int
+	outside     int // lines skipped by since and until
+	timestamped bool
+	keepNext    bool
+	outsideNext bool
+}
+
+// newLineSampler validates the sampling flags. A zero seed picks a random
+// one.
+func newLineSampler(rate float64, seed int64, maxLines int, since, until time.Time) (*lineSampler, error) {
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("-sample must be in (0, 1], got %v", rate)
+	}
+	if maxLines < 0 {
+		return nil, fmt.Errorf("-max-lines must not be negative, got %d", maxLines)
+	}
+	if !since.IsZero() && !until.IsZero() && !until.After(since) {
+		return nil, fmt.Errorf("-until must be after -since")
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &lineSampler{rate: rate, seed: seed, maxLines: maxLines, since: since, until: until, rng: rand.New(rand.NewSource(seed))}, nil
+}
+
+// parseTime parses the value of -since or -until: a timestamp in one of the
+// layouts of the timestamp extractor, or a duration before now such as 1h.
+func parseTime(timestamps *logparser.TimestampExtractor, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if ts, rest, ok := timestamps.Extract(value); ok && strings.TrimSpace(rest) == "" {
+		return ts, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, want a timestamp such as 2024-01-15T10:00:00Z or a duration such as 1h", value)
+}
+
+// done reports whether max lines have been read.
+func (s *lineSampler) done() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.maxLines > 0 && s.lines >= s.maxLines
+}
+
+// keep reports whether the line with the given timestamp should be parsed;
+// hasTimestamp is false if the line has none. It is safe for concurrent use.
+func (s *lineSampler) keep(ts time.Time, hasTimestamp bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.maxLines > 0 && s.lines >= s.maxLines {
+		return false
+	}
+	s.lines++
+	keep, outside := s.keepNext, s.outsideNext
+	switch {
+	case hasTimestamp:
+		s.timestamped = true
+		outside = (!s.since.IsZero() && ts.Before(s.since)) || (!s.until.IsZero() && !ts.Before(s.until))
+		keep = !outside && s.sample()
+		s.keepNext, s.outsideNext = keep, outside
+	case !s.timestamped:
+		keep = s.sample()
+	}
+	if outside {
+		s.outside++
+	}
+	if keep {
+		s.kept++
+	}
+	return keep
+}
+
+func (s *lineSampler) sample() bool {
+	return s.rate >= 1 || s.rng.Float64() < s.rate
+}
+
+// scale estimates the number of messages in the input from n sampled ones.
+func (s *lineSampler) scale(n int) int {
+	return int(float64(n)/s.rate + 0.5)
+}
+
+// scaleCounters scales the message counts and sizes of counters to the whole
+// input.
+func (s *lineSampler) scaleCounters(counters []logparser.LogCounter) {
+	if s.rate >= 1 {
+		return
+	}
+	for i := range counters {
+		counters[i].Messages = s.scale(counters[i].Messages)
+		counters[i].Bytes = int64(float64(counters[i].Bytes)/s.rate + 0.5)
+	}
+}
+
+// scalePatterns is scaleCounters for the patterns of the cluster mode.
+func (s *lineSampler) scalePatterns(byLevel map[logparser.Level][]logparser.LogPattern) {
+	if s.rate >= 1 {
+		return
+	}
+	for _, patterns := range byLevel {
+		for i := range patterns {
+			patterns[i].Count = s.scale(patterns[i].Count)
+		}
+	}
+}
+
+// summary describes the sampling and limits, or is empty if none applied.
+func (s *lineSampler) summary() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var parts []string
+	if s.rate < 1 {
+		parts = append(parts, fmt.Sprintf("sampled %d of %d lines at a rate of %g (-seed %d), counts are scaled by %.4gx", s.kept, s.lines, s.rate, s.seed, 1/s.rate))
+	}
+	if s.outside > 0 {
+		parts = append(parts, fmt.Sprintf("%d lines outside -since/-until", s.outside))
+	}
+	if s.maxLines > 0 && s.lines >= s.maxLines {
+		parts = append(parts, fmt.Sprintf("stopped after -max-lines %d", s.maxLines))
+	}
+	return strings.Join(parts, ", ")
+}
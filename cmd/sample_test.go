@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nudgebee/logparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleLines(s *lineSampler, lines []string) []string {
+	timestamps := logparser.NewTimestampExtractor()
+	var kept []string
+	for _, line := range lines {
+		if s.keep(entryTime(timestamps, line)) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+func TestLineSamplerDeterministic(t *testing.T) {
+	var lines []string
+	for i := 0; i < 10000; i++ {
+		lines = append(lines, fmt.Sprintf("request %d served", i))
+	}
+	s1, err := newLineSampler(0.1, 42, 0, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	s2, err := newLineSampler(0.1, 42, 0, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	kept := sampleLines(s1, lines)
+	assert.Equal(t, kept, sampleLines(s2, lines))
+	assert.InDelta(t, 1000, len(kept), 100)
+
+	s3, err := newLineSampler(0.1, 43, 0, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.NotEqual(t, kept, sampleLines(s3, lines))
+
+	assert.Equal(t, 10, s1.scale(1))
+	counters := []logparser.LogCounter{{Messages: 3, Bytes: 100}}
+	s1.scaleCounters(counters)
+	assert.Equal(t, []logparser.LogCounter{{Messages: 30, Bytes: 1000}}, counters)
+	assert.Equal(t, fmt.Sprintf("sampled %d of 10000 lines at a rate of 0.1 (-seed 42), counts are scaled by 10x", len(kept)), s1.summary())
+}
+
+func TestLineSamplerMultiline(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines,
+			fmt.Sprintf("2024-01-15 10:00:%02d ERROR request %d failed", i%60, i),
+			"\tat com.example.Handler.handle(Handler.java:42)",
+			"\tat com.example.Server.run(Server.java:7)",
+		)
+	}
+	s, err := newLineSampler(0.5, 1, 0, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	kept := sampleLines(s, lines)
+	require.NotEmpty(t, kept)
+	assert.Zero(t, len(kept)%3)
+	for i := 0; i < len(kept); i += 3 {
+		assert.Contains(t, kept[i], "ERROR request")
+		assert.Contains(t, kept[i+1], "Handler.java")
+		assert.Contains(t, kept[i+2], "Server.java")
+	}
+}
+
+func TestLineSamplerWindowAndLimit(t *testing.T) {
+	timestamps := logparser.NewTimestampExtractor()
+	since, err := parseTime(timestamps, "2024-01-15T10:00:01Z")
+	require.NoError(t, err)
+	until, err := parseTime(timestamps, "2024-01-15 10:00:03")
+	require.NoError(t, err)
+	lines := []string{
+		"2024-01-15 10:00:00 ERROR too early",
+		"\tcontinuation of too early",
+		"2024-01-15 10:00:01 ERROR in window",
+		"\tcontinuation of in window",
+		"2024-01-15 10:00:02 ERROR also in window",
+		"2024-01-15 10:00:03 ERROR too late",
+	}
+	s, err := newLineSampler(1, 0, 0, since, until)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"2024-01-15 10:00:01 ERROR in window",
+		"\tcontinuation of in window",
+		"2024-01-15 10:00:02 ERROR also in window",
+	}, sampleLines(s, lines))
+	assert.Equal(t, "3 lines outside -since/-until", s.summary())
+
+	s, err = newLineSampler(1, 0, 2, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, lines[:2], sampleLines(s, lines))
+	assert.True(t, s.done())
+	assert.Equal(t, "stopped after -max-lines 2", s.summary())
+}
+
+func TestLineSamplerFlags(t *testing.T) {
+	timestamps := logparser.NewTimestampExtractor()
+	ts, err := parseTime(timestamps, "1h")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), ts, time.Minute)
+	_, err = parseTime(timestamps, "yesterday")
+	assert.Error(t, err)
+
+	_, err = newLineSampler(0, 0, 0, time.Time{}, time.Time{})
+	assert.Error(t, err)
+	_, err = newLineSampler(1.5, 0, 0, time.Time{}, time.Time{})
+	assert.Error(t, err)
+	_, err = newLineSampler(1, 0, -1, time.Time{}, time.Time{})
+	assert.Error(t, err)
+	_, err = newLineSampler(1, 0, 0, time.Unix(10, 0), time.Unix(5, 0))
+	assert.Error(t, err)
+}
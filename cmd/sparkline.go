@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nudgebee/logparser"
+)
+
+// sparklineWidth is the number of columns of the -sparkline charts.
+const sparklineWidth = 24
+
+// sparklineBuckets is the histogram size requested from the parser, finer
+// than the charts so that patterns of different spans share a time axis.
+const sparklineBuckets = 120
+
+var sparklineLevels = []rune("▁▂▃▄▅▆▇█")
+
+// histogramRange returns the time span of the non-empty buckets of the
+// histograms of counters, zero if there are none.
+func histogramRange(counters []logparser.LogCounter) (from, to time.Time) {
+	for _, c := range counters {
+		for i, n := range c.Histogram {
+			if n == 0 {
+				continue
+			}
+			start := c.HistogramStart.Add(c.HistogramBucket * time.Duration(i))
+			if from.IsZero() || start.Before(from) {
+				from = start
+			}
+			if end := start.Add(c.HistogramBucket); end.After(to) {
+				to = end
+			}
+		}
+	}
+	return from, to
+}
+
+// sparkline renders the histogram of c over [from, to) in width columns,
+// scaled to its own maximum; columns without messages are blank.
+func sparkline(c logparser.LogCounter, from, to time.Time, width int) string {
+	span := to.Sub(from)
+	if span <= 0 || len(c.Histogram) == 0 {
+		return strings.Repeat(" ", width)
+	}
+	columns := make([]int, width)
+	peak := 0
+	for i, n := range c.Histogram {
+		if n == 0 {
+			continue
+		}
+		// Each bucket goes to the column of its middle.
+		mid := c.HistogramStart.Add(c.HistogramBucket*time.Duration(i) + c.HistogramBucket/2)
+		col := int(float64(mid.Sub(from)) / float64(span) * float64(width))
+		col = min(max(col, 0), width-1)
+		columns[col] += n
+		peak = max(peak, columns[col])
+	}
+	var sb strings.Builder
+	for _, n := range columns {
+		if n == 0 {
+			sb.WriteRune(' ')
+			continue
+		}
+		sb.WriteRune(sparklineLevels[(n*len(sparklineLevels)-1)/peak])
+	}
+	return sb.String()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nudgebee/logparser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparkline(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	counters := []logparser.LogCounter{
+		{Histogram: []int{8, 0, 4, 1}, HistogramStart: t0, HistogramBucket: time.Second},
+		// A later pattern with wider buckets.
+		{Histogram: []int{0, 2, 0, 0}, HistogramStart: t0, HistogramBucket: 2 * time.Second},
+		{},
+	}
+	from, to := histogramRange(counters)
+	assert.Equal(t, t0, from)
+	assert.Equal(t, t0.Add(4*time.Second), to)
+
+	assert.Equal(t, "█ ▄▁", sparkline(counters[0], from, to, 4))
+	assert.Equal(t, "   █", sparkline(counters[1], from, to, 4))
+	assert.Equal(t, "    ", sparkline(counters[2], from, to, 4))
+	assert.Equal(t, "█▅", sparkline(counters[0], from, to, 2))
+}
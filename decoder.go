@@ -3,7 +3,10 @@ package logparser
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type DockerLogJson struct {
@@ -14,6 +17,56 @@ type Decoder interface {
 	Decode(string) (string, error)
 }
 
+// EntryDecoder is a Decoder that decodes whole entries, so that it can set
+// the timestamp from the log or hold back an entry until the rest of its
+// message arrives. The parser uses DecodeEntry instead of Decode when the
+// decoder implements it.
+type EntryDecoder interface {
+	Decoder
+	// DecodeEntry returns the decoded entry, or ok=false if entry was
+	// consumed without completing a message yet.
+	DecodeEntry(entry LogEntry) (decoded LogEntry, ok bool, err error)
+}
+
+// FlushingDecoder is an EntryDecoder that may hold back entries
+// indefinitely, such as the first parts of a line whose last part never
+// arrives. The Parser flushes such entries after the multiline timeout and
+// when it is stopped.
+type FlushingDecoder interface {
+	EntryDecoder
+	// FlushEntries returns the entries held back since before before, as
+	// complete as they are, and forgets them.
+	FlushEntries(before time.Time) []LogEntry
+}
+
+// FlushEntries returns the entries held back by d since before before, if d
+// is a FlushingDecoder.
+func FlushEntries(d Decoder, before time.Time) []LogEntry {
+	if fd, ok := d.(FlushingDecoder); ok {
+		return fd.FlushEntries(before)
+	}
+	return nil
+}
+
+// DecodeEntry decodes entry with d, using DecodeEntry if d is an
+// EntryDecoder and Decode on the content otherwise. ok is false if the entry
+// was consumed without completing a message yet.
+func DecodeEntry(d Decoder, entry LogEntry) (LogEntry, bool, error) {
+	if ed, ok := d.(EntryDecoder); ok {
+		return ed.DecodeEntry(entry)
+	}
+	content, err := d.Decode(entry.Content)
+	if err != nil {
+		return entry, false, err
+	}
+	entry.Content = content
+	return entry, true, nil
+}
+
+// DockerJsonDecoder extracts the message of a Docker json-file log line.
+//
+// Deprecated: use DockerJSONDecoder, which also sets the entry timestamp and
+// passes lines that aren't Docker JSON through.
 type DockerJsonDecoder struct{}
 
 func (d DockerJsonDecoder) Decode(src string) (string, error) {
@@ -24,6 +77,152 @@ func (d DockerJsonDecoder) Decode(src string) (string, error) {
 	return obj.Log, nil
 }
 
+// ChainDecoder returns a decoder that applies decoders in order, each to the
+// output of the previous one, e.g. CRI framing and then JSON extraction.
+// Every stage is required: if one fails, the chain stops and returns the
+// original entry with the error, and the Parser drops the entry as it does
+// for a single decoder. Wrap a stage with OptionalDecoder to pass the entry
+// on unchanged instead. If a stage holds the entry back (see EntryDecoder),
+// the rest of the chain runs once it completes a message.
+func ChainDecoder(decoders ...Decoder) Decoder {
+	return chainDecoder(decoders)
+}
+
+type chainDecoder []Decoder
+
+func (c chainDecoder) Decode(src string) (string, error) {
+	entry, _, err := c.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+func (c chainDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	decoded := entry
+	for _, d := range c {
+		var ok bool
+		var err error
+		if decoded, ok, err = DecodeEntry(d, decoded); err != nil {
+			return entry, true, err
+		}
+		if !ok {
+			return entry, false, nil
+		}
+	}
+	return decoded, true, nil
+}
+
+// FlushEntries flushes the entries held back by every stage, and runs them
+// through the rest of the chain.
+func (c chainDecoder) FlushEntries(before time.Time) []LogEntry {
+	var res []LogEntry
+	for i, d := range c {
+		for _, entry := range FlushEntries(d, before) {
+			if decoded, ok, err := c[i+1:].DecodeEntry(entry); err == nil && ok {
+				res = append(res, decoded)
+			}
+		}
+	}
+	return res
+}
+
+// ConditionalDecoder returns a decoder that applies d to the entries whose
+// content satisfies predicate and passes the others on unchanged, e.g. to
+// run a JSON decoder only on lines starting with "{".
+func ConditionalDecoder(predicate func(string) bool, d Decoder) Decoder {
+	return conditionalDecoder{predicate: predicate, d: d}
+}
+
+type conditionalDecoder struct {
+	predicate func(string) bool
+	d         Decoder
+}
+
+func (c conditionalDecoder) Decode(src string) (string, error) {
+	entry, _, err := c.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+func (c conditionalDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	if !c.predicate(entry.Content) {
+		return entry, true, nil
+	}
+	return DecodeEntry(c.d, entry)
+}
+
+func (c conditionalDecoder) FlushEntries(before time.Time) []LogEntry {
+	return FlushEntries(c.d, before)
+}
+
+// OptionalDecoder returns a decoder that applies d and passes the entry on
+// unchanged if d fails, for chain stages that don't apply to every line.
+func OptionalDecoder(d Decoder) Decoder {
+	return optionalDecoder{d: d}
+}
+
+type optionalDecoder struct {
+	d Decoder
+}
+
+func (o optionalDecoder) Decode(src string) (string, error) {
+	entry, _, err := o.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+func (o optionalDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	decoded, ok, err := DecodeEntry(o.d, entry)
+	if err != nil {
+		return entry, true, nil
+	}
+	return decoded, ok, nil
+}
+
+func (o optionalDecoder) FlushEntries(before time.Time) []LogEntry {
+	return FlushEntries(o.d, before)
+}
+
+// DockerJSONDecoder decodes the lines written by Docker's json-file log
+// driver: {"log":"message\n","stream":"stderr","time":"<RFC3339Nano>"}. The
+// message is taken from the log field without its trailing newline, and the
+// entry timestamp is set from the time field. Lines that aren't JSON objects
+// with a log field are passed through unchanged.
+type DockerJSONDecoder struct {
+	// StderrLevel, if set, is the level of the stderr entries without a
+	// level whose level can't be guessed from the content.
+	StderrLevel Level
+}
+
+type dockerJSONLine struct {
+	Log    *string `json:"log"`
+	Stream string  `json:"stream"`
+	Time   string  `json:"time"`
+}
+
+// Decode returns the message of src.
+func (d DockerJSONDecoder) Decode(src string) (string, error) {
+	entry, _, err := d.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+// DecodeEntry returns entry with the message, timestamp and stderr level
+// hint of its Docker JSON line. It always returns ok=true.
+func (d DockerJSONDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	var line dockerJSONLine
+	if !strings.HasPrefix(strings.TrimSpace(entry.Content), "{") || json.Unmarshal([]byte(entry.Content), &line) != nil || line.Log == nil {
+		return entry, true, nil
+	}
+	entry.Content = strings.TrimSuffix(*line.Log, "\n")
+	if ts, err := time.Parse(time.RFC3339Nano, line.Time); err == nil {
+		entry.Timestamp = ts
+	}
+	if line.Stream == "stderr" && entry.Level == LevelUnknown && GuessLevel(entry.Content) == LevelUnknown {
+		entry.Level = d.StderrLevel
+	}
+	return entry, true, nil
+}
+
+// CriDecoder strips the CRI prefix of each line.
+//
+// Deprecated: use CRIDecoder, which also reassembles partial lines, sets the
+// entry timestamp and passes malformed lines through.
 type CriDecoder struct{}
 
 func (d CriDecoder) Decode(src string) (string, error) {
@@ -39,3 +238,145 @@ func (d CriDecoder) Decode(src string) (string, error) {
 	}
 	return src[i+1:], nil
 }
+
+// CRIDecoder decodes the CRI log format written by the kubelet for CRI-O and
+// containerd: "<RFC3339Nano timestamp> <stdout|stderr> <P|F> <message>".
+// The prefix is stripped and the entry timestamp is set from the log. Partial
+// (P) lines, which the runtime writes for lines longer than 16KiB, are held
+// back and joined with the rest of the line, per source and stream, until
+// the final (F) part arrives or MaxLineSize is reached; the Parser flushes
+// a line whose final part is missing after the multiline timeout (see
+// FlushingDecoder). Lines not in the CRI format are passed through
+// unchanged. It is safe for concurrent use, and its zero value is ready to
+// use.
+type CRIDecoder struct {
+	// MaxLineSize caps the size of a joined line (default 1MiB); a longer
+	// line is passed on before its final part arrives.
+	MaxLineSize int
+
+	lock sync.Mutex
+	// partial holds the parts of the unfinished line of each source and
+	// stream.
+	partial map[criStream]*criPartial
+}
+
+type criStream struct {
+	source string
+	stream string
+}
+
+type criPartial struct {
+	// entry is the decoded first part.
+	entry    LogEntry
+	received time.Time
+	parts    []string
+	size     int
+}
+
+// NewCRIDecoder returns a CRIDecoder.
+func NewCRIDecoder() *CRIDecoder {
+	return &CRIDecoder{partial: map[criStream]*criPartial{}}
+}
+
+// Decode returns the message of src. Partial lines are held back and return
+// an empty message; use DecodeEntry to tell them apart.
+func (d *CRIDecoder) Decode(src string) (string, error) {
+	entry, _, err := d.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+// DecodeEntry returns entry with the message and timestamp of its CRI line,
+// or ok=false if the line is partial.
+func (d *CRIDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	ts, stream, partial, msg, ok := parseCRILine(entry.Content)
+	if !ok {
+		return entry, true, nil
+	}
+	key := criStream{source: entry.Source, stream: stream}
+	entry.Timestamp, entry.Content = ts, msg
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p := d.partial[key]
+	if p == nil && !partial {
+		return entry, true, nil
+	}
+	if p == nil {
+		if d.partial == nil {
+			d.partial = map[criStream]*criPartial{}
+		}
+		p = &criPartial{entry: entry, received: time.Now()}
+		d.partial[key] = p
+	}
+	p.parts = append(p.parts, msg)
+	p.size += len(msg)
+	if partial && p.size < d.maxLineSize() {
+		return entry, false, nil
+	}
+	delete(d.partial, key)
+	return p.join(), true, nil
+}
+
+// FlushEntries returns the partial lines whose first part was received
+// before before, joined as far as they arrived.
+func (d *CRIDecoder) FlushEntries(before time.Time) []LogEntry {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var stale []*criPartial
+	for key, p := range d.partial {
+		if p.received.Before(before) {
+			stale = append(stale, p)
+			delete(d.partial, key)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].received.Before(stale[j].received) })
+	res := make([]LogEntry, 0, len(stale))
+	for _, p := range stale {
+		res = append(res, p.join())
+	}
+	return res
+}
+
+func (d *CRIDecoder) maxLineSize() int {
+	if d.MaxLineSize > 0 {
+		return d.MaxLineSize
+	}
+	return multilineDetectionLimit
+}
+
+// join returns the entry of the first part with the content of all parts.
+func (p *criPartial) join() LogEntry {
+	entry := p.entry
+	entry.Content = strings.Join(p.parts, "")
+	return entry
+}
+
+// parseCRILine splits a CRI log line into its fields; ok is false if line is
+// not in the CRI format.
+func parseCRILine(line string) (ts time.Time, stream string, partial bool, msg string, ok bool) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return
+	}
+	ts, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return
+	}
+	stream = fields[1]
+	if stream != "stdout" && stream != "stderr" {
+		return
+	}
+	// the tag is a ":"-separated list whose first item is P or F
+	tag, _, _ := strings.Cut(fields[2], ":")
+	switch tag {
+	case "P":
+		partial = true
+	case "F":
+	default:
+		return
+	}
+	if len(fields) == 4 {
+		msg = fields[3]
+	}
+	return ts, stream, partial, msg, true
+}
@@ -0,0 +1,282 @@
+package logparser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRIDecoder(t *testing.T) {
+	d := NewCRIDecoder()
+	t0 := time.Date(2023, 10, 6, 14, 0, 0, 123456789, time.UTC)
+	decode := func(line, source string) (LogEntry, bool) {
+		entry, ok, err := d.DecodeEntry(LogEntry{Content: line, Source: source})
+		require.NoError(t, err)
+		return entry, ok
+	}
+
+	entry, ok := decode("2023-10-06T14:00:00.123456789Z stdout F actual message", "")
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Timestamp: t0, Content: "actual message"}, entry)
+
+	// partial lines of stdout and stderr are interleaved and reassembled
+	// separately, with the timestamp of their first part
+	_, ok = decode("2023-10-06T14:00:00.123456789Z stdout P first half of a ", "pod")
+	assert.False(t, ok)
+	_, ok = decode("2023-10-06T14:00:01Z stderr P ERROR failed to ", "pod")
+	assert.False(t, ok)
+	_, ok = decode("2023-10-06T14:00:02Z stdout P long line ", "pod")
+	assert.False(t, ok)
+	entry, ok = decode("2023-10-06T14:00:03Z stderr F connect to db", "pod")
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Timestamp: t0.Add(time.Second - 123456789), Content: "ERROR failed to connect to db", Source: "pod"}, entry)
+	// another source doesn't complete the line
+	entry, ok = decode("2023-10-06T14:00:04Z stdout F other", "other-pod")
+	require.True(t, ok)
+	assert.Equal(t, "other", entry.Content)
+	entry, ok = decode("2023-10-06T14:00:05Z stdout F with a final part", "pod")
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Timestamp: t0, Content: "first half of a long line with a final part", Source: "pod"}, entry)
+
+	entry, ok = decode("2023-10-06T14:00:06+02:00 stderr F:x ", "")
+	require.True(t, ok)
+	assert.Equal(t, "", entry.Content)
+	assert.True(t, entry.Timestamp.Equal(time.Date(2023, 10, 6, 12, 0, 6, 0, time.UTC)))
+
+	for _, line := range []string{
+		"plain log line",
+		"2023-10-06 stdout F message",
+		"2023-10-06T14:00:00Z stdin F message",
+		"2023-10-06T14:00:00Z stdout X message",
+		"2023-10-06T14:00:00Z stdout",
+	} {
+		ts := time.Now()
+		entry, ok, err := d.DecodeEntry(LogEntry{Content: line, Timestamp: ts})
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, LogEntry{Content: line, Timestamp: ts}, entry, line)
+	}
+
+	msg, err := d.Decode("2023-10-06T14:00:00Z stdout F hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg)
+}
+
+func TestParserCRIDecoder(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithDecoder(NewCRIDecoder()), WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	for _, line := range []string{
+		"2023-10-06T14:00:00Z stderr P ERROR failed to connect ",
+		"2023-10-06T14:00:01Z stderr F to db",
+		"2023-10-06T14:00:02Z stdout F ERROR failed to connect to db",
+	} {
+		ch <- LogEntry{Content: line, Timestamp: time.Now()}
+	}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	counters := p.GetCounters()
+	require.Len(t, counters, 1)
+	assert.Equal(t, 2, counters[0].Messages)
+	assert.Equal(t, "ERROR failed to connect to db", counters[0].Sample)
+	assert.Equal(t, time.Date(2023, 10, 6, 14, 0, 0, 0, time.UTC), counters[0].FirstSeen)
+	assert.Equal(t, time.Date(2023, 10, 6, 14, 0, 2, 0, time.UTC), counters[0].LastSeen)
+}
+
+func TestCRIDecoderFlushEntries(t *testing.T) {
+	// the zero value is ready to use
+	d := &CRIDecoder{MaxLineSize: 10}
+	assert.Empty(t, d.FlushEntries(time.Now()))
+	decode := func(line, source string) (LogEntry, bool) {
+		entry, ok, err := d.DecodeEntry(LogEntry{Content: line, Source: source})
+		require.NoError(t, err)
+		return entry, ok
+	}
+
+	// a line reaching MaxLineSize is passed on before its final part
+	_, ok := decode("2023-10-06T14:00:00Z stdout P 12345", "")
+	assert.False(t, ok)
+	entry, ok := decode("2023-10-06T14:00:01Z stdout P 67890", "")
+	require.True(t, ok)
+	assert.Equal(t, "1234567890", entry.Content)
+
+	_, ok = decode("2023-10-06T14:00:02Z stdout P first", "a")
+	assert.False(t, ok)
+	_, ok = decode("2023-10-06T14:00:03Z stderr P second", "b")
+	assert.False(t, ok)
+	assert.Empty(t, d.FlushEntries(time.Now().Add(-time.Minute)))
+	flushed := d.FlushEntries(time.Now())
+	require.Len(t, flushed, 2)
+	assert.Equal(t, LogEntry{Timestamp: time.Date(2023, 10, 6, 14, 0, 2, 0, time.UTC), Content: "first", Source: "a"}, flushed[0])
+	assert.Equal(t, "second", flushed[1].Content)
+	assert.Empty(t, d.FlushEntries(time.Now()))
+
+	// a chain runs the flushed entries through its remaining stages
+	chain := ChainDecoder(NewCRIDecoder(), JSONDecoder{})
+	_, ok, err := DecodeEntry(chain, LogEntry{Content: `2023-10-06T14:00:00Z stdout P {"level":"error","msg":"lost"}`})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	flushed = FlushEntries(chain, time.Now())
+	require.Len(t, flushed, 1)
+	assert.Equal(t, "lost", flushed[0].Content)
+	assert.Equal(t, LevelError, flushed[0].Level)
+	assert.Nil(t, FlushEntries(JSONDecoder{}, time.Now()))
+}
+
+func TestParserCRIDecoderLongLine(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithDecoder(NewCRIDecoder()), WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	// a 40KiB line split by the runtime into 16KiB parts
+	parts := []string{"ERROR " + strings.Repeat("a", 16<<10-6), strings.Repeat("b", 16<<10), strings.Repeat("c", 8<<10)}
+	ch <- LogEntry{Content: "2023-10-06T14:00:00Z stdout P " + parts[0], Timestamp: time.Now()}
+	ch <- LogEntry{Content: "2023-10-06T14:00:00Z stdout P " + parts[1], Timestamp: time.Now()}
+	ch <- LogEntry{Content: "2023-10-06T14:00:00Z stdout F " + parts[2], Timestamp: time.Now()}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	counters := p.GetCounters()
+	require.Len(t, counters, 1)
+	assert.Equal(t, 1, counters[0].Messages)
+	assert.Len(t, counters[0].Sample, 40<<10)
+	assert.Equal(t, strings.Join(parts, ""), counters[0].Sample)
+}
+
+func TestParserCRIDecoderDanglingPartial(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithDecoder(NewCRIDecoder()), WithMultilineTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer p.Stop()
+	ch <- LogEntry{Content: "2023-10-06T14:00:00Z stderr P ERROR the container was killed mid-line", Timestamp: time.Now()}
+
+	require.Eventually(t, func() bool { return len(p.GetCounters()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	counters := p.GetCounters()
+	assert.Equal(t, "ERROR the container was killed mid-line", counters[0].Sample)
+	assert.Equal(t, LevelError, counters[0].Level)
+}
+
+func TestDockerJSONDecoder(t *testing.T) {
+	d := DockerJSONDecoder{StderrLevel: LevelError}
+	t0 := time.Date(2023, 10, 6, 14, 0, 0, 123456789, time.UTC)
+	decode := func(line string) LogEntry {
+		entry, ok, err := d.DecodeEntry(LogEntry{Content: line})
+		require.NoError(t, err)
+		require.True(t, ok)
+		return entry
+	}
+
+	assert.Equal(t, LogEntry{Timestamp: t0, Content: "actual message"},
+		decode(`{"log":"actual message\n","stream":"stdout","time":"2023-10-06T14:00:00.123456789Z"}`))
+	assert.Equal(t, LogEntry{Timestamp: t0, Content: "Ошибка: 接続に失敗しました 🚨", Level: LevelError},
+		decode(`{"log":"Ошибка: 接続に失敗しました 🚨\n","stream":"stderr","time":"2023-10-06T14:00:00.123456789Z"}`))
+	assert.Equal(t, LogEntry{Content: `failed to parse "{\"id\": 1}": unexpected "\u00e9"`, Level: LevelError},
+		decode(`{"log":"failed to parse \"{\\\"id\\\": 1}\": unexpected \"\\u00e9\"\n","stream":"stderr"}`))
+	// only the trailing newline is trimmed
+	assert.Equal(t, "line one\nline two", decode(`{"log":"line one\nline two\n","stream":"stdout"}`).Content)
+
+	entry, ok, err := d.DecodeEntry(LogEntry{Content: `{"log":"warn\n","stream":"stderr"}`, Level: LevelWarning})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Content: "warn", Level: LevelWarning}, entry)
+	entry, _, err = DockerJSONDecoder{}.DecodeEntry(LogEntry{Content: `{"log":"x","stream":"stderr"}`})
+	require.NoError(t, err)
+	assert.Equal(t, LevelUnknown, entry.Level)
+
+	for _, line := range []string{
+		"plain log line",
+		`{"log":"truncated`,
+		`{"level":"error","msg":"not a docker line"}`,
+		`["log"]`,
+	} {
+		assert.Equal(t, LogEntry{Content: line}, decode(line), line)
+	}
+
+	msg, err := d.Decode(`{"log":"hello ☃\n"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "hello ☃", msg)
+}
+
+func TestParserDockerJSONDecoder(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithDecoder(DockerJSONDecoder{StderrLevel: LevelError}), WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	ch <- LogEntry{Content: `{"log":"connection reset by peer\n","stream":"stderr","time":"2023-10-06T14:00:00Z"}`, Timestamp: time.Now()}
+	ch <- LogEntry{Content: `{"log":"INFO server started\n","stream":"stderr","time":"2023-10-06T14:00:01Z"}`, Timestamp: time.Now()}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	byLevel := map[Level]LogCounter{}
+	for _, c := range p.GetCounters() {
+		byLevel[c.Level] = c
+	}
+	require.Len(t, byLevel, 2)
+	assert.Equal(t, "connection reset by peer", byLevel[LevelError].Sample)
+	assert.Equal(t, time.Date(2023, 10, 6, 14, 0, 0, 0, time.UTC), byLevel[LevelError].FirstSeen)
+	assert.Equal(t, 1, byLevel[LevelInfo].Messages)
+}
+
+func TestChainDecoder(t *testing.T) {
+	isJSON := func(s string) bool { return strings.HasPrefix(s, "{") }
+	d := ChainDecoder(NewCRIDecoder(), ConditionalDecoder(isJSON, JSONDecoder{}))
+	decode := func(line string) (LogEntry, bool) {
+		entry, ok, err := DecodeEntry(d, LogEntry{Content: line, Source: "pod"})
+		require.NoError(t, err)
+		return entry, ok
+	}
+	t0 := time.Date(2023, 10, 6, 14, 0, 0, 0, time.UTC)
+
+	entry, ok := decode(`2023-10-06T14:00:00Z stderr F {"level":"error","msg":"connection refused","ts":1696600801}`)
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Timestamp: t0.Add(time.Second), Content: "connection refused", Level: LevelError, Source: "pod"}, entry)
+
+	// a JSON line split by the runtime is decoded once reassembled
+	_, ok = decode(`2023-10-06T14:00:00Z stdout P {"level":"info","msg":"request `)
+	assert.False(t, ok)
+	entry, ok = decode(`2023-10-06T14:00:02Z stdout F served"}`)
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Timestamp: t0, Content: "request served", Level: LevelInfo, Source: "pod"}, entry)
+
+	// non-JSON messages skip the JSON stage
+	entry, ok = decode("2023-10-06T14:00:03Z stdout F plain message")
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Timestamp: t0.Add(3 * time.Second), Content: "plain message", Source: "pod"}, entry)
+
+	// a failing required stage returns the original line with the error
+	strict := ChainDecoder(NewCRIDecoder(), DockerJsonDecoder{})
+	line := "2023-10-06T14:00:00Z stdout F not json"
+	entry, ok, err := DecodeEntry(strict, LogEntry{Content: line})
+	assert.Error(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, LogEntry{Content: line}, entry)
+	_, err = strict.Decode(line)
+	assert.Error(t, err)
+
+	// an optional stage passes the entry on
+	lenient := ChainDecoder(NewCRIDecoder(), OptionalDecoder(DockerJsonDecoder{}))
+	msg, err := lenient.Decode(line)
+	require.NoError(t, err)
+	assert.Equal(t, "not json", msg)
+	msg, err = lenient.Decode(`2023-10-06T14:00:00Z stdout F {"log":"from docker"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "from docker", msg)
+}
+
+func TestParserChainDecoder(t *testing.T) {
+	ch := make(chan LogEntry)
+	d := ChainDecoder(NewCRIDecoder(), DockerJsonDecoder{})
+	p, err := NewParserWithOptions(ch, WithDecoder(d), WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	for _, line := range []string{
+		`2023-10-06T14:00:00Z stdout F {"log":"ERROR connection refused"}`,
+		`2023-10-06T14:00:01Z stdout F not json`, // dropped by the last stage
+	} {
+		ch <- LogEntry{Content: line, Timestamp: time.Now()}
+	}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	counters := p.GetCounters()
+	require.Len(t, counters, 1)
+	assert.Equal(t, "ERROR connection refused", counters[0].Sample)
+	assert.Equal(t, 1, counters[0].Messages)
+}
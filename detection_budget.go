@@ -0,0 +1,39 @@
+package logparser
+
+import "time"
+
+// defaultDetectionBudget is the default of WithSensitiveDetectionBudget.
+const defaultDetectionBudget = time.Second
+
+// detectionBudget bounds the time spent running the sensitive patterns on a
+// message. A nil budget has no limit.
+type detectionBudget struct {
+	deadline time.Time
+	exceeded bool
+}
+
+// newDetectionBudget returns a budget of d from now, or nil if d is 0.
+func newDetectionBudget(d time.Duration) *detectionBudget {
+	if d <= 0 {
+		return nil
+	}
+	return &detectionBudget{deadline: time.Now().Add(d)}
+}
+
+// spent reports whether the budget has run out, in which case the patterns
+// not run yet are skipped.
+func (b *detectionBudget) spent() bool {
+	if b == nil {
+		return false
+	}
+	if !b.exceeded && time.Now().After(b.deadline) {
+		b.exceeded = true
+	}
+	return b.exceeded
+}
+
+// aborted reports whether a pattern was skipped because the budget was
+// spent.
+func (b *detectionBudget) aborted() bool {
+	return b != nil && b.exceeded
+}
@@ -0,0 +1,101 @@
+package logparser
+
+import "container/heap"
+
+// evictionEntry is an eviction candidate: a pattern counter and its message
+// count as of when it was last placed in the heap.
+type evictionEntry struct {
+	shard    *patternShard
+	key      patternKey
+	stat     *patternStat
+	messages int
+}
+
+// evictionHeap is a min-heap of eviction candidates by message count. Counts
+// only grow between resets, so the entries are updated lazily: an entry
+// reaching the root with an outdated count is put back with the current one,
+// and a root that is up to date is the counter with the fewest messages.
+type evictionHeap []evictionEntry
+
+func (h evictionHeap) Len() int           { return len(h) }
+func (h evictionHeap) Less(i, j int) bool { return h[i].messages < h[j].messages }
+func (h evictionHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *evictionHeap) Push(x any)        { *h = append(*h, x.(evictionEntry)) }
+
+func (h *evictionHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = evictionEntry{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+// addEvictionCandidate tracks the counter of key as an eviction candidate
+// of its limit scope. Only counters with a pattern are evicted, and only
+// under WithMaxPatterns. The caller must hold the shard lock.
+func (p *Parser) addEvictionCandidate(s *patternShard, key patternKey, stat *patternStat) {
+	if p.maxPatterns <= 0 || stat.pattern == nil {
+		return
+	}
+	p.evictionLock.Lock()
+	defer p.evictionLock.Unlock()
+	if p.evictionCandidates == nil {
+		p.evictionCandidates = map[string]*evictionHeap{}
+	}
+	scope := p.limitScope(key.source)
+	h := p.evictionCandidates[scope]
+	if h == nil {
+		h = &evictionHeap{}
+		p.evictionCandidates[scope] = h
+	}
+	heap.Push(h, evictionEntry{shard: s, key: key, stat: stat, messages: stat.messages})
+}
+
+// nextEvictionVictim removes and returns the counter with the fewest
+// messages among the candidates of the limit scope of source. It reports
+// false if there is none. The caller must hold every shard lock.
+func (p *Parser) nextEvictionVictim(source string) (evictionEntry, bool) {
+	p.evictionLock.Lock()
+	defer p.evictionLock.Unlock()
+	if p.evictionStale.Swap(false) {
+		p.rebuildEvictionCandidates()
+	}
+	h := p.evictionCandidates[p.limitScope(source)]
+	for h != nil && h.Len() > 0 {
+		e := &(*h)[0]
+		switch {
+		case e.shard.patterns[e.key] != e.stat:
+			heap.Pop(h)
+		case e.stat.messages != e.messages:
+			e.messages = e.stat.messages
+			heap.Fix(h, 0)
+		default:
+			return heap.Pop(h).(evictionEntry), true
+		}
+	}
+	return evictionEntry{}, false
+}
+
+// rebuildEvictionCandidates rebuilds the heaps from the counters, after
+// their counts were reset. The caller must hold every shard lock and
+// evictionLock.
+func (p *Parser) rebuildEvictionCandidates() {
+	candidates := map[string]*evictionHeap{}
+	for i := range p.shards {
+		s := &p.shards[i]
+		for k, ps := range s.patterns {
+			if ps.pattern == nil {
+				continue
+			}
+			scope := p.limitScope(k.source)
+			if candidates[scope] == nil {
+				candidates[scope] = &evictionHeap{}
+			}
+			*candidates[scope] = append(*candidates[scope], evictionEntry{shard: s, key: k, stat: ps, messages: ps.messages})
+		}
+	}
+	for _, h := range candidates {
+		heap.Init(h)
+	}
+	p.evictionCandidates = candidates
+}
@@ -0,0 +1,142 @@
+package logparser
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// CountersSchemaVersion is the version of the JSON document produced by
+// MarshalCountersJSON. It changes only when fields are removed or change
+// meaning; new fields may be added within a version.
+const CountersSchemaVersion = 1
+
+// CountersExport is the JSON document produced by MarshalCountersJSON.
+type CountersExport struct {
+	SchemaVersion int               `json:"schema_version"`
+	Patterns      []PatternExport   `json:"patterns"`
+	Sensitive     []SensitiveExport `json:"sensitive"`
+}
+
+// PatternExport is a LogCounter in CountersExport.
+type PatternExport struct {
+	Level           string    `json:"level"`
+	Hash            string    `json:"hash"`
+	HashVersion     int       `json:"hash_version"`
+	Template        string    `json:"template"`
+	Sample          string    `json:"sample"`
+	Messages        int       `json:"messages"`
+	Bytes           int64     `json:"bytes"`
+	Source          string    `json:"source,omitempty"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+	SampleTruncated bool      `json:"sample_truncated,omitempty"`
+}
+
+// SensitiveExport is a SensitiveLogCounter in CountersExport. Its sample is
+// always redacted.
+type SensitiveExport struct {
+	Name      string    `json:"name"`
+	Category  string    `json:"category"`
+	Severity  string    `json:"severity"`
+	Hash      string    `json:"hash"`
+	Sample    string    `json:"sample"`
+	Messages  int       `json:"messages"`
+	Source    string    `json:"source,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// MarshalCountersJSON returns the counters and sensitive counters, as of the
+// same moment, as a CountersExport JSON document. Entries are sorted so the
+// output is stable. Sensitive samples are always redacted; pattern samples
+// and their templates, only if
+// redactSamples is set. Redaction uses the parser's sensitive
+// patterns, or the embedded ones if sensitive detection is off.
+func (p *Parser) MarshalCountersJSON(redactSamples bool) ([]byte, error) {
+	redactor, err := p.exportRedactor()
+	if err != nil {
+		return nil, err
+	}
+
+	p.lockShards()
+	p.sensitiveLock.Lock()
+	counters := p.counters(time.Time{})
+	sensitive := p.sensitiveCounters(0)
+	p.sensitiveLock.Unlock()
+	p.unlockShards()
+
+	doc := CountersExport{
+		SchemaVersion: CountersSchemaVersion,
+		Patterns:      make([]PatternExport, 0, len(counters)),
+		Sensitive:     make([]SensitiveExport, 0, len(sensitive)),
+	}
+	for _, c := range counters {
+		sample, template := c.Sample, c.Pattern
+		if redactSamples {
+			sample = redactor.redact(sample)
+			if template != "" {
+				// the template is derived from the sample and may contain
+				// the same secrets
+				template = PatternTemplate(sample)
+			}
+		}
+		doc.Patterns = append(doc.Patterns, PatternExport{Level: c.Level.String(), Hash: c.Hash, HashVersion: c.HashVersion, Template: template, Sample: sample, SampleTruncated: c.SampleTruncated, Messages: c.Messages, Bytes: c.Bytes, Source: c.Source, FirstSeen: c.FirstSeen, LastSeen: c.LastSeen})
+	}
+	for _, c := range sensitive {
+		doc.Sensitive = append(doc.Sensitive, SensitiveExport{Name: c.Name, Category: c.Category, Severity: c.Severity, Hash: c.Hash, Sample: redactor.redact(c.Sample), Messages: c.Messages, Source: c.Source, FirstSeen: c.FirstSeen, LastSeen: c.LastSeen})
+	}
+	sort.Slice(doc.Patterns, func(i, j int) bool {
+		a, b := doc.Patterns[i], doc.Patterns[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Hash < b.Hash
+	})
+	sort.Slice(doc.Sensitive, func(i, j int) bool {
+		a, b := doc.Sensitive[i], doc.Sensitive[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Hash < b.Hash
+	})
+	return json.Marshal(doc)
+}
+
+// exportRedactor masks the secrets of exported samples.
+type exportRedactor struct {
+	patterns  []PrecompiledPattern
+	allowlist *Allowlist
+	keys      []string
+	cfg       RedactionConfig
+}
+
+// exportRedactor returns the redactor of exported samples: it uses the
+// parser's sensitive patterns, or the embedded ones if sensitive detection
+// is off, and keeps the values of the parser-wide allowlist.
+func (p *Parser) exportRedactor() (exportRedactor, error) {
+	p.lock.RLock()
+	r := exportRedactor{patterns: p.sensitivePatternDefinitions, allowlist: p.sensitiveAllowlist, keys: p.sensitiveJSONKeys, cfg: p.sensitiveConfig.Redaction}
+	p.lock.RUnlock()
+	if len(r.patterns) == 0 {
+		patterns, err := getOrLoadPatterns(p.sensitiveConfig.MinConfidence, p.logger)
+		if err != nil {
+			return r, err
+		}
+		r.patterns = patterns
+	}
+	return r, nil
+}
+
+// redact masks the secrets in sample, including the ones in the decoded
+// values of JSON samples and sensitive JSON fields when JSON field-aware
+// detection is enabled.
+func (r exportRedactor) redact(sample string) string {
+	return redactJSONLine(sample, r.patterns, r.keys, r.allowlist, r.cfg)
+}
@@ -0,0 +1,138 @@
+package logparser
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// The seed corpora of the fuzz targets are in testdata/fuzz. Run a target
+// with e.g. go test -run '^$' -fuzz FuzzNewPattern -fuzztime 1m.
+
+func FuzzNewPattern(f *testing.F) {
+	f.Fuzz(func(t *testing.T, input string) {
+		p := NewPattern(input)
+		for _, w := range p.words {
+			if !isWord(w) {
+				t.Fatalf("pattern of %q has the non-word %q", input, w)
+			}
+		}
+		if h := NewPattern(input).Hash(); h != p.Hash() {
+			t.Fatalf("hash of %q changed from %s to %s", input, p.Hash(), h)
+		}
+		if h := NewPattern(strings.ToValidUTF8(input, "�")).Hash(); h != p.Hash() {
+			t.Fatalf("invalid UTF-8 of %q changed its hash from %s to %s", input, p.Hash(), h)
+		}
+		if template := PatternTemplate(input); !utf8.ValidString(template) {
+			t.Fatalf("template of %q isn't valid UTF-8: %q", input, template)
+		}
+		NewPatternWithConfig(input, PatternConfig{CaseInsensitive: true, MaxConstantSpan: 16, TypedVariables: true})
+	})
+}
+
+var (
+	fuzzPatternsOnce sync.Once
+	fuzzPatterns     []PrecompiledPattern
+)
+
+func FuzzDetectSensitiveData(f *testing.F) {
+	fuzzPatternsOnce.Do(func() {
+		var err error
+		if fuzzPatterns, err = LoadPatterns("low"); err != nil {
+			f.Fatal(err)
+		}
+	})
+	f.Fuzz(func(t *testing.T, line string) {
+		check := func(matches []SensitivePatternMatch) {
+			for _, m := range matches {
+				if m.JSONPath == "" && (m.Start < 0 || m.Start > m.End || m.End > len(line) || line[m.Start:m.End] != m.Value) {
+					t.Fatalf("match %s of %q has the invalid range %d-%d", m.Name, line, m.Start, m.End)
+				}
+				m.MaskedValue(RedactionConfig{})
+			}
+		}
+		check(DetectSensitiveData(line, "hash", fuzzPatterns))
+		check(FindAllSensitiveData(line, "hash", fuzzPatterns))
+		check(DetectSensitiveDataJSON(line, "hash", fuzzPatterns, nil))
+	})
+}
+
+func FuzzMultilineCollector(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data string) {
+		const limit = 256
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m := NewMultilineCollector(ctx, time.Minute, limit)
+		done := make(chan []Message)
+		go func() {
+			var msgs []Message
+			for msg := range m.Messages {
+				msgs = append(msgs, msg)
+			}
+			done <- msgs
+		}()
+		for _, line := range strings.Split(data, "\n") {
+			m.Add(LogEntry{Content: line, Level: LevelUnknown})
+		}
+		m.Close()
+		for _, msg := range <-done {
+			if len(msg.Content) > limit {
+				t.Fatalf("message of %d bytes exceeds the limit of %d", len(msg.Content), limit)
+			}
+			if !utf8.ValidString(msg.Content) {
+				t.Fatalf("message %q isn't valid UTF-8", msg.Content)
+			}
+		}
+	})
+}
+
+// fuzzDecoder fuzzes the decoders returned by newDecoder with a line, and
+// with the line as the partial or next line of the previous one for the
+// stateful ones.
+func fuzzDecoder(f *testing.F, newDecoder func() Decoder) {
+	f.Fuzz(func(t *testing.T, line string) {
+		d := newDecoder()
+		for i := 0; i < 2; i++ {
+			entry, ok, err := DecodeEntry(d, LogEntry{Content: line, Source: "app"})
+			if err == nil && ok && len(entry.Content) > len(line)+payloadLimit(0) {
+				t.Fatalf("decoded %d bytes from a line of %d", len(entry.Content), len(line))
+			}
+		}
+		FlushEntries(d, time.Now().Add(time.Hour))
+	})
+}
+
+func FuzzDockerJSONDecoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return DockerJSONDecoder{} })
+}
+
+func FuzzCRIDecoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return NewCRIDecoder() })
+}
+
+func FuzzJSONDecoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return JSONDecoder{} })
+}
+
+func FuzzLogfmtDecoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return LogfmtDecoder{} })
+}
+
+func FuzzSyslogDecoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return SyslogDecoder{} })
+}
+
+func FuzzBase64Decoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return Base64Decoder{} })
+}
+
+func FuzzGzipDecoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return GzipDecoder{} })
+}
+
+func FuzzSanitizeDecoder(f *testing.F) {
+	fuzzDecoder(f, func() Decoder { return NewSanitizeDecoder() })
+}
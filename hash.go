@@ -0,0 +1,77 @@
+package logparser
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"hash/fnv"
+)
+
+// HashVersion is the version of the pattern hashes of LogCounter.Hash: a
+// hash of the words of the pattern of a message (see NewPattern) joined by
+// single spaces. It changes whenever a message may get another hash, so
+// that hashes kept in long-term storage can be migrated with RehashSample.
+// The golden tests of hash_test.go pin the hashes of a fixed corpus.
+const HashVersion = 1
+
+// HashAlgorithm is the algorithm of pattern hashes, see WithHashAlgorithm.
+type HashAlgorithm string
+
+const (
+	// HashMD5 hashes are the 32 hexadecimal digits of the MD5 of the
+	// pattern. It is the default.
+	HashMD5 HashAlgorithm = "md5"
+	// HashFNV64 hashes are the 16 hexadecimal digits of the 64-bit FNV-1a
+	// of the pattern, quicker to compute but more likely to collide.
+	HashFNV64 HashAlgorithm = "fnv64"
+	// HashSHA1 hashes are the first 24 hexadecimal digits of the SHA-1 of
+	// the pattern, for the least collisions.
+	HashSHA1 HashAlgorithm = "sha1"
+)
+
+// sha1HashSize is the number of bytes of the SHA-1 kept in HashSHA1 hashes.
+const sha1HashSize = 12
+
+func (a HashAlgorithm) valid() bool {
+	switch a {
+	case "", HashMD5, HashFNV64, HashSHA1:
+		return true
+	}
+	return false
+}
+
+// sum returns the hash of pattern, the string of a Pattern; an empty
+// algorithm is HashMD5.
+func (a HashAlgorithm) sum(pattern string) string {
+	switch a {
+	case HashFNV64:
+		h := fnv.New64a()
+		h.Write([]byte(pattern))
+		return fmt.Sprintf("%016x", h.Sum64())
+	case HashSHA1:
+		sum := sha1.Sum([]byte(pattern))
+		return fmt.Sprintf("%x", sum[:sha1HashSize])
+	}
+	return hashString(md5.Sum([]byte(pattern)))
+}
+
+// RehashSample returns the HashMD5 hash of version of the message sample,
+// e.g. the sample of a stored counter, so that the data keyed by the hashes
+// of an older version can be rekeyed. A sample gets the hash of its counter
+// unless it was redacted or truncated, or the counter is a stack trace
+// fingerprint or was counted with a PatternConfig other than the default.
+func RehashSample(sample string, version int) (string, error) {
+	return RehashSampleWithAlgorithm(sample, version, HashMD5)
+}
+
+// RehashSampleWithAlgorithm is RehashSample with the hashes of algorithm.
+func RehashSampleWithAlgorithm(sample string, version int, algorithm HashAlgorithm) (string, error) {
+	if !algorithm.valid() {
+		return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+	}
+	switch version {
+	case 1:
+		return algorithm.sum(NewPattern(sample).String()), nil
+	}
+	return "", fmt.Errorf("unsupported hash version %d, want 1 to %d", version, HashVersion)
+}
@@ -0,0 +1,86 @@
+package logparser
+
+import "time"
+
+// minHistogramBucket is the initial bucket width of timeHistograms.
+const minHistogramBucket = time.Second
+
+// maxHistogramSpan bounds the span of timeHistograms, well within
+// time.Duration, so that a bogus timestamp centuries away can't overflow the
+// bucket arithmetic.
+const maxHistogramSpan = 100 * 365 * 24 * time.Hour
+
+// timeHistogram counts messages in a fixed number of buckets spanning the
+// timestamps seen so far. Buckets start one second wide and double, merging
+// pairs, whenever a message falls outside of the span, so the span follows
+// the message timestamps rather than the wall clock. Once the span reaches
+// maxHistogramSpan, messages outside of it are counted in the first or last
+// bucket.
+type timeHistogram struct {
+	start   time.Time
+	bucket  time.Duration
+	buckets []int
+}
+
+// add counts n messages at ts in a histogram of size buckets. Zero
+// timestamps are ignored.
+func (h *timeHistogram) add(ts time.Time, n, size int) {
+	if ts.IsZero() || n == 0 {
+		return
+	}
+	if h.buckets == nil {
+		h.buckets = make([]int, size)
+		h.bucket = minHistogramBucket
+		h.start = ts.Truncate(h.bucket)
+	}
+	for (ts.Before(h.start) || !ts.Before(h.end())) && h.canGrow() {
+		h.grow(ts.Before(h.start))
+	}
+	i := 0
+	if !ts.Before(h.start) {
+		i = min(int(ts.Sub(h.start)/h.bucket), len(h.buckets)-1)
+	}
+	h.buckets[i] += n
+}
+
+// canGrow reports whether doubling the bucket width keeps the span within
+// maxHistogramSpan.
+func (h *timeHistogram) canGrow() bool {
+	return h.bucket <= maxHistogramSpan/time.Duration(2*len(h.buckets))
+}
+
+func (h *timeHistogram) end() time.Time {
+	return h.start.Add(h.bucket * time.Duration(len(h.buckets)))
+}
+
+// grow doubles the bucket width, extending the span backwards if before is
+// set, forwards otherwise. Bucket boundaries stay aligned, so each old
+// bucket falls within a single new one.
+func (h *timeHistogram) grow(before bool) {
+	start := h.start
+	if before {
+		start = h.start.Add(-h.bucket * time.Duration(len(h.buckets)))
+	}
+	bucket := 2 * h.bucket
+	buckets := make([]int, len(h.buckets))
+	for i, n := range h.buckets {
+		t := h.start.Add(h.bucket * time.Duration(i))
+		buckets[int(t.Sub(start)/bucket)] += n
+	}
+	h.start, h.bucket, h.buckets = start, bucket, buckets
+}
+
+// merge adds the counts of o, at the start of their buckets, to h.
+func (h *timeHistogram) merge(o timeHistogram, size int) {
+	for i, n := range o.buckets {
+		h.add(o.start.Add(o.bucket*time.Duration(i)), n, size)
+	}
+}
+
+// counts returns a copy of the buckets, nil if empty.
+func (h *timeHistogram) counts() []int {
+	if h.buckets == nil {
+		return nil
+	}
+	return append([]int(nil), h.buckets...)
+}
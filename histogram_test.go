@@ -0,0 +1,96 @@
+package logparser
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeHistogram(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return t0.Add(time.Duration(seconds) * time.Second)
+	}
+	var h timeHistogram
+	h.add(at(0), 1, 4)
+	h.add(at(1), 1, 4)
+	h.add(at(3), 1, 4)
+	assert.Equal(t, []int{1, 1, 0, 1}, h.buckets)
+	assert.Equal(t, time.Second, h.bucket)
+
+	// A later message doubles the buckets, merging pairs.
+	h.add(at(5), 1, 4)
+	assert.Equal(t, t0, h.start)
+	assert.Equal(t, 2*time.Second, h.bucket)
+	assert.Equal(t, []int{2, 1, 1, 0}, h.buckets)
+
+	// An earlier one extends the span backwards.
+	h.add(at(-1), 1, 4)
+	assert.Equal(t, at(-8), h.start)
+	assert.Equal(t, 4*time.Second, h.bucket)
+	assert.Equal(t, []int{0, 1, 3, 1}, h.buckets)
+
+	h.add(time.Time{}, 1, 4)
+	assert.Equal(t, []int{0, 1, 3, 1}, h.counts())
+
+	var merged timeHistogram
+	merged.add(at(-8), 2, 4)
+	merged.merge(h, 4)
+	assert.Equal(t, []int{2, 1, 3, 1}, merged.buckets)
+
+	// The span stops growing at maxHistogramSpan, timestamps beyond it go
+	// to the edge buckets.
+	var far timeHistogram
+	far.add(t0, 1, 60)
+	far.add(t0.AddDate(400, 0, 0), 1, 60)
+	far.add(t0.AddDate(-400, 0, 0), 1, 60)
+	assert.LessOrEqual(t, far.bucket*60, maxHistogramSpan)
+	total := 0
+	for _, n := range far.buckets {
+		total += n
+	}
+	assert.Equal(t, 3, total)
+	assert.Equal(t, 2, far.buckets[0]) // t0 and the clamped earlier one
+	assert.Equal(t, 1, far.buckets[59])
+}
+
+func TestWithHistogram(t *testing.T) {
+	var lines []string
+	burst := func(second, n int) {
+		for i := 0; i < n; i++ {
+			lines = append(lines, fmt.Sprintf("2024-01-15 10:00:%02d ERROR failed to connect to db", second))
+		}
+	}
+	burst(0, 3)
+	burst(5, 1)
+	burst(9, 2)
+	burst(30, 1)
+
+	counters, _, err := ParseLines(lines, WithHistogram(10), WithTimestampExtraction(NewTimestampExtractor()))
+	require.NoError(t, err)
+	require.Len(t, counters, 1)
+	c := counters[0]
+	assert.Equal(t, []int{3, 1, 2, 0, 0, 0, 0, 1, 0, 0}, c.Histogram)
+	assert.Equal(t, time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC), c.HistogramStart.UTC())
+	assert.Equal(t, 4*time.Second, c.HistogramBucket)
+
+	counters, _, err = ParseLines(lines)
+	require.NoError(t, err)
+	require.Len(t, counters, 1)
+	assert.Nil(t, counters[0].Histogram)
+
+	// Timestamps centuries apart don't overflow the buckets.
+	counters, _, err = ParseLines([]string{
+		"2024-01-15 10:00:00 ERROR failed to connect to db",
+		"2400-01-15 10:00:00 ERROR failed to connect to db",
+	}, WithHistogram(60), WithTimestampExtraction(NewTimestampExtractor()))
+	require.NoError(t, err)
+	require.Len(t, counters, 1)
+	assert.Len(t, counters[0].Histogram, 60)
+
+	_, err = NewParserWithOptions(make(chan LogEntry), WithHistogram(1))
+	assert.Error(t, err)
+}
@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 	"unsafe"
+
+	"github.com/stretchr/testify/require"
 )
 
 // TestResourceImpact measures actual CPU and memory impact of sensitive detection.
@@ -54,11 +56,13 @@ func TestResourceImpact(t *testing.T) {
 	parsers := make([]*Parser, 200)
 	for i := range parsers {
 		ch := make(chan LogEntry, 1)
-		parsers[i] = NewParser(ch, nil, nil, time.Second, 256, SensitiveConfig{
+		var err error
+		parsers[i], err = NewParserWithOptions(ch, WithSensitiveConfig(SensitiveConfig{
 			Enabled:       true,
 			MinConfidence: "medium",
 			MaxDetections: 100,
-		})
+		}))
+		require.NoError(t, err)
 	}
 
 	runtime.GC()
@@ -93,9 +97,9 @@ func TestResourceImpact(t *testing.T) {
 	disabledParsers := make([]*Parser, 200)
 	for i := range disabledParsers {
 		ch := make(chan LogEntry, 1)
-		disabledParsers[i] = NewParser(ch, nil, nil, time.Second, 256, SensitiveConfig{
-			Enabled: false,
-		})
+		var err error
+		disabledParsers[i], err = NewParserWithOptions(ch)
+		require.NoError(t, err)
 	}
 
 	runtime.GC()
@@ -144,7 +148,8 @@ func TestResourceImpact(t *testing.T) {
 	for _, cfg := range configs {
 		// Create a parser with this config
 		ch := make(chan LogEntry, 1000)
-		p := NewParser(ch, nil, nil, time.Second, 256, cfg.cfg)
+		p, err := NewParserWithOptions(ch, WithSensitiveConfig(cfg.cfg))
+		require.NoError(t, err)
 
 		// Warm up
 		for _, line := range sampleLines {
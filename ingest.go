@@ -0,0 +1,140 @@
+package logparser
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DropPolicy selects which entry is discarded when the ingest buffer (see
+// WithIngestBuffer) is full.
+type DropPolicy int
+
+const (
+	// DropNewest discards incoming entries while the buffer is full.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered entry to make room for the
+	// incoming one.
+	DropOldest
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	}
+	return fmt.Sprintf("DropPolicy(%d)", int(p))
+}
+
+// IngestStats describes the entries read from the parser's channel or
+// added with ProcessEntry.
+// Received = Processed + Dropped + QueueDepth, except for entries being
+// handed over at the time of the call.
+type IngestStats struct {
+	// Received is the number of entries read from the channel or added.
+	Received uint64
+	// Processed is the number of entries passed to the multiline collector.
+	Processed uint64
+	// Dropped is the number of entries discarded because the ingest buffer
+	// was full. It is always 0 without WithIngestBuffer.
+	Dropped uint64
+	// QueueDepth is the number of entries waiting in the ingest buffer.
+	QueueDepth int
+	// Truncated is the number of times an entry was cut to the max line
+	// size (see WithMaxLineSize), when read or once decoded.
+	Truncated uint64
+}
+
+// GetIngestStats returns the parser's ingestion counters.
+func (p *Parser) GetIngestStats() IngestStats {
+	stats := IngestStats{
+		Received:  p.received.Load(),
+		Processed: p.processed.Load(),
+		Dropped:   p.dropped.Load(),
+		Truncated: p.truncated.Load(),
+	}
+	if p.ingest != nil {
+		stats.QueueDepth = p.ingest.len()
+	}
+	return stats
+}
+
+// ingestQueue is a bounded FIFO of entries waiting to be processed.
+type ingestQueue struct {
+	policy DropPolicy
+
+	lock    sync.Mutex
+	entries []LogEntry // ring buffer
+	head    int
+	size    int
+	closed  bool
+	// notify has a pending value whenever entries were pushed or the queue
+	// was closed since the consumer last looked.
+	notify chan struct{}
+}
+
+func newIngestQueue(size int, policy DropPolicy) *ingestQueue {
+	return &ingestQueue{
+		policy:  policy,
+		entries: make([]LogEntry, size),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// push adds entry to the queue and reports whether an entry was dropped to
+// keep it within bounds.
+func (q *ingestQueue) push(entry LogEntry) bool {
+	q.lock.Lock()
+	dropped := false
+	if q.size == len(q.entries) {
+		dropped = true
+		if q.policy == DropNewest {
+			q.lock.Unlock()
+			return true
+		}
+		q.entries[q.head] = LogEntry{}
+		q.head = (q.head + 1) % len(q.entries)
+		q.size--
+	}
+	q.entries[(q.head+q.size)%len(q.entries)] = entry
+	q.size++
+	q.lock.Unlock()
+	q.wake()
+	return dropped
+}
+
+// pop removes the oldest entry. ok is false if the queue is empty; closed
+// is set once the queue is empty and no more entries will be pushed.
+func (q *ingestQueue) pop() (entry LogEntry, ok bool, closed bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.size == 0 {
+		return LogEntry{}, false, q.closed
+	}
+	entry = q.entries[q.head]
+	q.entries[q.head] = LogEntry{}
+	q.head = (q.head + 1) % len(q.entries)
+	q.size--
+	return entry, true, false
+}
+
+func (q *ingestQueue) close() {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.wake()
+}
+
+func (q *ingestQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size
+}
+
+func (q *ingestQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,85 @@
+package logparser
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedDecoder records the entries it decodes and blocks until gate is
+// closed, simulating a slow consumer.
+type gatedDecoder struct {
+	entered chan struct{}
+	gate    chan struct{}
+	decoded []string
+}
+
+func (d *gatedDecoder) Decode(src string) (string, error) {
+	if len(d.decoded) == 0 {
+		close(d.entered)
+	}
+	d.decoded = append(d.decoded, src)
+	<-d.gate
+	return src, nil
+}
+
+func TestParserIngestBuffer(t *testing.T) {
+	const n, size = 100, 10
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("request %d handled", i)
+	}
+	for _, tc := range []struct {
+		policy DropPolicy
+		kept   []string
+	}{
+		{policy: DropNewest, kept: lines[:1+size]},
+		{policy: DropOldest, kept: append([]string{lines[0]}, lines[n-size:]...)},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			d := &gatedDecoder{entered: make(chan struct{}), gate: make(chan struct{})}
+			ch := make(chan LogEntry)
+			p, err := NewParserWithOptions(ch, WithDecoder(d), WithMultilineTimeout(time.Minute), WithIngestBuffer(size, tc.policy))
+			require.NoError(t, err)
+
+			// The first entry blocks the consumer, the rest pile up in the
+			// buffer without blocking the sender.
+			ch <- LogEntry{Timestamp: time.Now(), Content: lines[0], Level: LevelInfo}
+			<-d.entered
+			for _, line := range lines[1:] {
+				ch <- LogEntry{Timestamp: time.Now(), Content: line, Level: LevelInfo}
+			}
+			require.Eventually(t, func() bool { return p.GetIngestStats().Received == n }, 5*time.Second, time.Millisecond)
+			assert.Equal(t, IngestStats{Received: n, Processed: 0, Dropped: n - 1 - size, QueueDepth: size}, p.GetIngestStats())
+
+			close(d.gate)
+			require.NoError(t, p.StopAndDrain(5*time.Second))
+			assert.Equal(t, IngestStats{Received: n, Processed: 1 + size, Dropped: n - 1 - size}, p.GetIngestStats())
+			assert.Equal(t, tc.kept, d.decoded)
+			total := 0
+			for _, c := range p.GetCounters() {
+				total += c.Messages
+			}
+			assert.Equal(t, 1+size, total)
+		})
+	}
+}
+
+func TestParserIngestStatsBlocking(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		ch <- LogEntry{Timestamp: time.Now(), Content: fmt.Sprintf("request %d handled", i), Level: LevelInfo}
+	}
+	require.NoError(t, p.StopAndDrain(5*time.Second))
+	assert.Equal(t, IngestStats{Received: 5, Processed: 5}, p.GetIngestStats())
+
+	_, err = NewParserWithOptions(ch, WithIngestBuffer(0, DropOldest))
+	assert.EqualError(t, err, "ingest buffer size must be positive, got 0")
+	_, err = NewParserWithOptions(ch, WithIngestBuffer(1, DropPolicy(7)))
+	assert.EqualError(t, err, "unknown drop policy DropPolicy(7)")
+}
@@ -0,0 +1,217 @@
+package logparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	defaultJSONMessageFields = []string{"msg", "message", "log", "error.message"}
+	defaultJSONLevelFields   = []string{"level", "severity", "lvl", "log.level"}
+	defaultJSONTimeFields    = []string{"time", "ts", "timestamp", "@timestamp"}
+)
+
+// NumericLevels selects how JSONDecoder maps numeric levels.
+type NumericLevels int
+
+const (
+	// NumericLevelsAuto maps numbers from 10 up as bunyan and pino levels
+	// (10 trace ... 60 fatal) and 0 to 7 as syslog severities.
+	NumericLevelsAuto NumericLevels = iota
+	// NumericLevelsSyslog maps syslog severities (0 emerg ... 7 debug).
+	NumericLevelsSyslog
+	// NumericLevelsZap maps zap levels (-1 debug ... 5 fatal).
+	NumericLevelsZap
+)
+
+// JSONDecoder decodes JSON structured logs. The content is the first string
+// found among MessageFields, so that lines differing only in their other
+// fields share a pattern; lines without one are kept whole. The first level
+// found among LevelFields, a name or a number (see NumericLevels), sets the
+// level, which takes precedence over the level guessed from the content.
+// The first time found among TimeFields, an RFC3339 string or a Unix time in
+// seconds, milliseconds, microseconds or nanoseconds, sets the timestamp.
+//
+// Fields are matched case-insensitively, and a dot path such as
+// "error.message" selects a nested field, unless a field with the dotted name
+// exists. Lines that aren't JSON objects, or that nest objects and arrays
+// more than 64 levels deep, are passed through unchanged.
+type JSONDecoder struct {
+	// MessageFields defaults to msg, message, log and error.message.
+	MessageFields []string
+	// LevelFields defaults to level, severity, lvl and log.level.
+	LevelFields []string
+	// TimeFields defaults to time, ts, timestamp and @timestamp.
+	TimeFields    []string
+	NumericLevels NumericLevels
+}
+
+// Decode returns the message of src.
+func (d JSONDecoder) Decode(src string) (string, error) {
+	entry, _, err := d.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+// DecodeEntry returns entry with the message, level and timestamp of its JSON
+// line. It always returns ok=true.
+func (d JSONDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	if !strings.HasPrefix(strings.TrimSpace(entry.Content), "{") || jsonTooDeep(entry.Content) {
+		return entry, true, nil
+	}
+	var obj map[string]any
+	dec := json.NewDecoder(bytes.NewReader([]byte(entry.Content)))
+	dec.UseNumber()
+	if dec.Decode(&obj) != nil {
+		return entry, true, nil
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		// trailing data after the object
+		return entry, true, nil
+	}
+
+	for _, field := range orDefault(d.MessageFields, defaultJSONMessageFields) {
+		if msg, ok := jsonField(obj, field).(string); ok {
+			entry.Content = msg
+			break
+		}
+	}
+	for _, field := range orDefault(d.LevelFields, defaultJSONLevelFields) {
+		if level := d.level(jsonField(obj, field)); level != LevelUnknown {
+			entry.Level = level
+			break
+		}
+	}
+	for _, field := range orDefault(d.TimeFields, defaultJSONTimeFields) {
+		if ts, ok := jsonTime(jsonField(obj, field)); ok {
+			entry.Timestamp = ts
+			break
+		}
+	}
+	return entry, true, nil
+}
+
+func orDefault(fields, defaults []string) []string {
+	if len(fields) == 0 {
+		return defaults
+	}
+	return fields
+}
+
+// jsonField returns the value at path in obj, or nil. Each dot in path
+// either belongs to a field name or separates nested fields.
+func jsonField(obj map[string]any, path string) any {
+	if v, ok := jsonKey(obj, path); ok {
+		return v
+	}
+	for i := 1; i < len(path)-1; i++ {
+		if path[i] != '.' {
+			continue
+		}
+		if nested, ok := jsonKey(obj, path[:i]); ok {
+			if m, ok := nested.(map[string]any); ok {
+				if v := jsonField(m, path[i+1:]); v != nil {
+					return v
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonKey looks key up in obj, falling back to a case-insensitive match.
+func jsonKey(obj map[string]any, key string) (any, bool) {
+	if v, ok := obj[key]; ok {
+		return v, true
+	}
+	for k, v := range obj {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// level maps a level name or number to a Level.
+func (d JSONDecoder) level(v any) Level {
+	switch v := v.(type) {
+	case string:
+		switch strings.ToLower(v) {
+		case "trace":
+			return LevelDebug
+		case "panic", "dpanic":
+			return LevelCritical
+		}
+		return GuessLevel(v)
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return LevelUnknown
+		}
+		return d.numericLevel(n)
+	}
+	return LevelUnknown
+}
+
+func (d JSONDecoder) numericLevel(n int64) Level {
+	switch d.NumericLevels {
+	case NumericLevelsZap:
+		switch {
+		case n <= -1:
+			return LevelDebug
+		case n == 0:
+			return LevelInfo
+		case n == 1:
+			return LevelWarning
+		case n == 2:
+			return LevelError
+		default:
+			return LevelCritical
+		}
+	case NumericLevelsAuto:
+		if n >= 10 {
+			switch {
+			case n < 30:
+				return LevelDebug
+			case n < 40:
+				return LevelInfo
+			case n < 50:
+				return LevelWarning
+			case n < 60:
+				return LevelError
+			default:
+				return LevelCritical
+			}
+		}
+	}
+	return SyslogMessage{Severity: int(n)}.Level()
+}
+
+// jsonTime parses an RFC3339 string or a Unix time number.
+func jsonTime(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		ts, err := time.Parse(time.RFC3339Nano, v)
+		return ts, err == nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil || f <= 0 {
+			return time.Time{}, false
+		}
+		// the unit is guessed from the magnitude
+		switch {
+		case f >= 1e17:
+			return time.Unix(0, int64(f)).UTC(), true
+		case f >= 1e14:
+			return time.UnixMicro(int64(f)).UTC(), true
+		case f >= 1e11:
+			return time.UnixMilli(int64(f)).UTC(), true
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).UTC(), true
+	}
+	return time.Time{}, false
+}
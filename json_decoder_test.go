@@ -0,0 +1,111 @@
+package logparser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONDecoder(t *testing.T) {
+	t0 := time.Date(2023, 10, 6, 14, 0, 0, 0, time.UTC)
+	decode := func(d JSONDecoder, line string) LogEntry {
+		entry, ok, err := d.DecodeEntry(LogEntry{Content: line})
+		require.NoError(t, err)
+		require.True(t, ok)
+		return entry
+	}
+
+	for _, tc := range []struct {
+		name string
+		d    JSONDecoder
+		line string
+		want LogEntry
+	}{
+		{
+			name: "zap",
+			line: `{"level":"error","ts":1696600800.123,"caller":"db/conn.go:42","msg":"connection refused","addr":"10.0.0.1:5432","stacktrace":"main.run\n\tmain.go:10"}`,
+			want: LogEntry{Timestamp: t0.Add(123 * time.Millisecond), Content: "connection refused", Level: LevelError},
+		},
+		{
+			name: "zap dpanic",
+			line: `{"level":"dpanic","ts":1696600800,"msg":"invariant violated"}`,
+			want: LogEntry{Timestamp: t0, Content: "invariant violated", Level: LevelCritical},
+		},
+		{
+			name: "zap numeric levels",
+			d:    JSONDecoder{NumericLevels: NumericLevelsZap},
+			line: `{"level":-1,"ts":1696600800000,"msg":"cache hit"}`,
+			want: LogEntry{Timestamp: t0, Content: "cache hit", Level: LevelDebug},
+		},
+		{
+			name: "logrus",
+			line: `{"level":"warning","msg":"disk almost full","time":"2023-10-06T16:00:00+02:00","mount":"/var"}`,
+			want: LogEntry{Timestamp: t0.In(time.FixedZone("", 2*3600)), Content: "disk almost full", Level: LevelWarning},
+		},
+		{
+			name: "bunyan",
+			line: `{"name":"api","hostname":"web-1","pid":42,"level":50,"msg":"request failed","time":"2023-10-06T14:00:00.000Z","v":0}`,
+			want: LogEntry{Timestamp: t0, Content: "request failed", Level: LevelError},
+		},
+		{
+			name: "pino trace",
+			line: `{"level":10,"time":1696600800000,"msg":"entering handler"}`,
+			want: LogEntry{Timestamp: t0, Content: "entering handler", Level: LevelDebug},
+		},
+		{
+			name: "numeric syslog severity",
+			line: `{"severity":2,"message":"RAID degraded","timestamp":"2023-10-06T14:00:00Z"}`,
+			want: LogEntry{Timestamp: t0, Content: "RAID degraded", Level: LevelCritical},
+		},
+		{
+			name: "nested and dotted fields",
+			line: `{"log.level":"ERROR","error":{"message":"timeout","type":"io"},"@timestamp":"2023-10-06T14:00:00Z"}`,
+			want: LogEntry{Timestamp: t0, Content: "timeout", Level: LevelError},
+		},
+		{
+			name: "custom fields",
+			d:    JSONDecoder{MessageFields: []string{"event.text", "body"}, LevelFields: []string{"Sev"}, TimeFields: []string{"at"}},
+			line: `{"event":{"text":"user signed in"},"body":"ignored","SEV":"info","at":1696600800000000}`,
+			want: LogEntry{Timestamp: t0, Content: "user signed in", Level: LevelInfo},
+		},
+		{
+			name: "no message",
+			line: `{"level":"info","status":200}`,
+			want: LogEntry{Content: `{"level":"info","status":200}`, Level: LevelInfo},
+		},
+		{
+			name: "unknown level",
+			line: `{"level":"verbose","msg":"x","ts":"yesterday"}`,
+			want: LogEntry{Content: "x"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := decode(tc.d, tc.line)
+			assert.True(t, tc.want.Timestamp.Equal(got.Timestamp), got.Timestamp)
+			got.Timestamp = tc.want.Timestamp
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	for _, line := range []string{
+		"plain log line",
+		`{"msg":"truncated`,
+		`["msg","array"]`,
+		`{"msg":"a"} trailing`,
+	} {
+		assert.Equal(t, LogEntry{Content: line}, decode(JSONDecoder{}, line), line)
+	}
+
+	msg, err := JSONDecoder{}.Decode(`{"message":"hello"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg)
+
+	// too deeply nested to be decoded, unless the brackets are in a string
+	deep := `{"msg":"deep","a":` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + "}"
+	assert.Equal(t, LogEntry{Content: deep}, decode(JSONDecoder{}, deep))
+	quoted := `{"msg":"quoted","a":"` + strings.Repeat("[", 100) + `"}`
+	assert.Equal(t, LogEntry{Content: "quoted"}, decode(JSONDecoder{}, quoted))
+}
@@ -0,0 +1,302 @@
+// Package k8s analyses the logs of a Kubernetes pod with a logparser.Parser
+// and a logparser.PatternExtractor. It lives in a module of its own so that
+// the parser doesn't depend on client-go.
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nudgebee/logparser"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// drainTimeout bounds the time AnalyzePodLogs waits for the parser to count
+// the lines read.
+const drainTimeout = time.Minute
+
+// Analysis is the result of AnalyzePodLogs.
+type Analysis struct {
+	Namespace string
+	Pod       string
+	Container string
+	// Lines is the number of log lines read.
+	Lines int
+	// Restarts is the number of times the log stream was reopened after a
+	// transient error.
+	Restarts  int
+	Counters  []logparser.LogCounter
+	Patterns  map[logparser.Level][]logparser.LogPattern
+	Sensitive []logparser.SensitiveLogCounter
+}
+
+// Option configures AnalyzePodLogs.
+type Option func(*config) error
+
+type config struct {
+	follow           bool
+	previous         bool
+	tailLines        *int64
+	sinceSeconds     *int64
+	maxPatterns      int
+	retries          int
+	backoff          time.Duration
+	parserOptions    []logparser.ParserOption
+	extractorOptions []logparser.PatternExtractorOption
+}
+
+// WithFollow keeps reading the logs as they are written, until the
+// container stops or the context is done.
+func WithFollow() Option {
+	return func(c *config) error {
+		c.follow = true
+		return nil
+	}
+}
+
+// WithPrevious reads the logs of the previous instance of the container,
+// e.g. of a container in a crash loop.
+func WithPrevious() Option {
+	return func(c *config) error {
+		c.previous = true
+		return nil
+	}
+}
+
+// WithTailLines only reads the last n lines of the logs.
+func WithTailLines(n int64) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return fmt.Errorf("tail lines must be positive, got %d", n)
+		}
+		c.tailLines = &n
+		return nil
+	}
+}
+
+// WithSince only reads the logs of the last d, rounded up to the second.
+func WithSince(d time.Duration) Option {
+	return func(c *config) error {
+		if d <= 0 {
+			return fmt.Errorf("since must be positive, got %s", d)
+		}
+		seconds := int64((d + time.Second - 1) / time.Second)
+		c.sinceSeconds = &seconds
+		return nil
+	}
+}
+
+// WithMaxPatterns sets the number of patterns per level of the analysis, 20
+// by default; 0 keeps them all.
+func WithMaxPatterns(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("max patterns can't be negative, got %d", n)
+		}
+		c.maxPatterns = n
+		return nil
+	}
+}
+
+// WithRetries sets how many times in a row the log stream is reopened after
+// a transient API error, 5 by default, waiting backoff before the first
+// attempt and twice as long before each of the next ones.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("retries can't be negative, got %d", n)
+		}
+		c.retries, c.backoff = n, backoff
+		return nil
+	}
+}
+
+// WithParserOptions adds options to the parser. Sensitive detection with
+// medium confidence is enabled by default.
+func WithParserOptions(opts ...logparser.ParserOption) Option {
+	return func(c *config) error {
+		c.parserOptions = append(c.parserOptions, opts...)
+		return nil
+	}
+}
+
+// WithExtractorOptions adds options to the pattern extractor.
+func WithExtractorOptions(opts ...logparser.PatternExtractorOption) Option {
+	return func(c *config) error {
+		c.extractorOptions = append(c.extractorOptions, opts...)
+		return nil
+	}
+}
+
+// AnalyzePodLogs streams the logs of a container of a pod through a parser
+// and a pattern extractor, and returns their counters, top patterns per
+// level and sensitive findings. Multiline messages are assembled before they
+// are counted. The stream is reopened where it stopped after a transient API
+// error. If ctx is done first, e.g. while following the logs, the analysis of
+// the lines read so far is returned along with the context error.
+func AnalyzePodLogs(ctx context.Context, clientset kubernetes.Interface, namespace, pod, container string, opts ...Option) (*Analysis, error) {
+	cfg := config{maxPatterns: 20, retries: 5, backoff: time.Second}
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	extractor, err := logparser.NewPatternExtractor(cfg.extractorOptions...)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(chan logparser.LogEntry)
+	parserOptions := append([]logparser.ParserOption{
+		logparser.WithSensitiveConfig(logparser.SensitiveConfig{Enabled: true, MinConfidence: "medium"}),
+	}, cfg.parserOptions...)
+	parser, err := logparser.NewParserWithOptions(entries, parserOptions...)
+	if err != nil {
+		return nil, err
+	}
+	messages := make(chan logparser.LogEntry)
+	extracted := make(chan error, 1)
+	go func() {
+		extracted <- extractor.AddEntries(messages, time.Second)
+	}()
+
+	a := &Analysis{Namespace: namespace, Pod: pod, Container: container}
+	streamErr := streamLogs(ctx, clientset, namespace, pod, container, cfg, a, func(entry logparser.LogEntry) {
+		entries <- entry
+		messages <- entry
+	})
+	close(messages)
+	if err := parser.StopAndDrain(drainTimeout); err != nil {
+		return nil, err
+	}
+	if err := <-extracted; err != nil {
+		return nil, err
+	}
+	a.Counters = parser.GetCounters()
+	a.Sensitive = parser.GetSensitiveCounters()
+	a.Patterns = extractor.GetPatternsByLevel(cfg.maxPatterns)
+	if streamErr != nil && ctx.Err() == nil {
+		return nil, streamErr
+	}
+	return a, streamErr
+}
+
+// streamLogs reads the log lines of the container and passes them to add,
+// reopening the stream after transient errors. Lines are read with their
+// timestamps so that a reopened stream resumes after the last line read.
+func streamLogs(ctx context.Context, clientset kubernetes.Interface, namespace, pod, container string, cfg config, a *Analysis, add func(logparser.LogEntry)) error {
+	logOptions := corev1.PodLogOptions{Container: container, Follow: cfg.follow, Previous: cfg.previous, Timestamps: true, TailLines: cfg.tailLines, SinceSeconds: cfg.sinceSeconds}
+	var last time.Time
+	atLast := 0 // the number of lines read with the timestamp last
+	failures := 0
+	for {
+		var skipUntil time.Time
+		skip := 0
+		if !last.IsZero() {
+			// The stream resumes from the second of the last line read,
+			// whose lines up to it are skipped.
+			logOptions.TailLines, logOptions.SinceSeconds = nil, nil
+			logOptions.SinceTime = &metav1.Time{Time: last}
+			skipUntil, skip = last, atLast
+		}
+		err := readLogs(ctx, clientset, namespace, pod, logOptions, func(ts time.Time, content string) {
+			if !ts.IsZero() && !skipUntil.IsZero() {
+				if ts.Before(skipUntil) {
+					return
+				}
+				if ts.Equal(skipUntil) && skip > 0 {
+					skip--
+					return
+				}
+				skipUntil = time.Time{}
+			}
+			failures = 0
+			a.Lines++
+			if ts.IsZero() {
+				ts = time.Now()
+			} else if ts.Equal(last) {
+				atLast++
+			} else {
+				last, atLast = ts, 1
+			}
+			add(logparser.LogEntry{Timestamp: ts, Content: content, Level: logparser.LevelUnknown})
+		})
+		if err == nil || ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isTransient(err) || failures >= cfg.retries {
+			return err
+		}
+		t := time.NewTimer(cfg.backoff << failures)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		failures++
+		a.Restarts++
+	}
+}
+
+// readLogs opens a log stream and calls add with every line until the end
+// of the stream. The timestamp of the lines without one is zero.
+func readLogs(ctx context.Context, clientset kubernetes.Interface, namespace, pod string, opts corev1.PodLogOptions, add func(ts time.Time, content string)) error {
+	stream, err := clientset.CoreV1().Pods(namespace).GetLogs(pod, &opts).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	// Unblock the read of a stream that doesn't end with ctx.
+	defer context.AfterFunc(ctx, func() { stream.Close() })()
+	r := bufio.NewReader(stream)
+	for {
+		line, err := r.ReadString('\n')
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			ts, content := splitTimestamp(line)
+			add(ts, content)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// splitTimestamp splits the RFC 3339 timestamp added to a line by the
+// Timestamps log option from its content.
+func splitTimestamp(line string) (time.Time, string) {
+	prefix, content, ok := strings.Cut(line, " ")
+	if ok {
+		if ts, err := time.Parse(time.RFC3339Nano, prefix); err == nil {
+			return ts, content
+		}
+	}
+	return time.Time{}, line
+}
+
+// isTransient reports whether err may go away if the stream is reopened.
+func isTransient(err error) bool {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		code := status.Status().Code
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
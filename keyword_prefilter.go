@@ -0,0 +1,153 @@
+package logparser
+
+// keywordPrefilter is an Aho-Corasick automaton over the anchors of a whole
+// pattern set. A single pass over a lowercased line yields the set of
+// patterns whose anchors are present, replacing one strings.Contains scan
+// per anchor per pattern.
+type keywordPrefilter struct {
+	// first and n identify the pattern slice the prefilter was built for, so
+	// a copied or re-sliced set falls back to per-pattern checks.
+	first *PrecompiledPattern
+	n     int
+
+	classes    [256]uint8 // byte -> alphabet class; 0 means "not in any keyword"
+	numClasses int
+	next       []int32 // DFA transitions: next[state*numClasses+class]
+	outputs    [][]int32
+
+	keywordPatterns [][]int32 // keyword id -> indexes of patterns owning it
+	anchorCounts    []int     // pattern index -> number of distinct anchors
+}
+
+// newKeywordPrefilter builds a prefilter for patterns. It returns nil when
+// no pattern has anchors.
+func newKeywordPrefilter(patterns []PrecompiledPattern) *keywordPrefilter {
+	if len(patterns) == 0 {
+		return nil
+	}
+	pf := &keywordPrefilter{first: &patterns[0], n: len(patterns), anchorCounts: make([]int, len(patterns))}
+
+	ids := map[string]int32{}
+	var keywords []string
+	for i := range patterns {
+		for _, a := range patterns[i].Anchors {
+			if a == "" {
+				continue
+			}
+			id, ok := ids[a]
+			if !ok {
+				id = int32(len(keywords))
+				ids[a] = id
+				keywords = append(keywords, a)
+				pf.keywordPatterns = append(pf.keywordPatterns, nil)
+			}
+			pf.keywordPatterns[id] = append(pf.keywordPatterns[id], int32(i))
+			pf.anchorCounts[i]++
+		}
+	}
+	if len(keywords) == 0 {
+		return nil
+	}
+
+	pf.numClasses = 1
+	for _, k := range keywords {
+		for i := 0; i < len(k); i++ {
+			if pf.classes[k[i]] == 0 {
+				pf.classes[k[i]] = uint8(pf.numClasses)
+				pf.numClasses++
+			}
+		}
+	}
+
+	// Build the trie.
+	pf.addState()
+	for id, k := range keywords {
+		s := int32(0)
+		for i := 0; i < len(k); i++ {
+			c := int(pf.classes[k[i]])
+			if pf.next[int(s)*pf.numClasses+c] == 0 {
+				pf.next[int(s)*pf.numClasses+c] = pf.addState()
+			}
+			s = pf.next[int(s)*pf.numClasses+c]
+		}
+		pf.outputs[s] = append(pf.outputs[s], int32(id))
+	}
+
+	// Compute failure links breadth-first and turn the trie into a DFA.
+	fail := make([]int32, len(pf.outputs))
+	queue := make([]int32, 0, len(pf.outputs))
+	for c := 1; c < pf.numClasses; c++ {
+		if s := pf.next[c]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		pf.outputs[s] = append(pf.outputs[s], pf.outputs[fail[s]]...)
+		for c := 1; c < pf.numClasses; c++ {
+			t := pf.next[int(s)*pf.numClasses+c]
+			if t == 0 {
+				pf.next[int(s)*pf.numClasses+c] = pf.next[int(fail[s])*pf.numClasses+c]
+				continue
+			}
+			fail[t] = pf.next[int(fail[s])*pf.numClasses+c]
+			queue = append(queue, t)
+		}
+	}
+	return pf
+}
+
+func (pf *keywordPrefilter) addState() int32 {
+	pf.next = append(pf.next, make([]int32, pf.numClasses)...)
+	pf.outputs = append(pf.outputs, nil)
+	return int32(len(pf.outputs) - 1)
+}
+
+// usableFor reports whether the prefilter was built for exactly this slice.
+func (pf *keywordPrefilter) usableFor(patterns []PrecompiledPattern) bool {
+	return pf != nil && len(patterns) == pf.n && &patterns[0] == pf.first
+}
+
+// candidates returns, for each pattern, whether its anchors appear in the
+// lowercased line according to its any/all semantics. Patterns without
+// anchors are always candidates.
+func (pf *keywordPrefilter) candidates(lowerLine string, patterns []PrecompiledPattern) []bool {
+	seen := make([]bool, len(pf.keywordPatterns))
+	hits := make([]int, pf.n)
+	s := int32(0)
+	for i := 0; i < len(lowerLine); i++ {
+		s = pf.next[int(s)*pf.numClasses+int(pf.classes[lowerLine[i]])]
+		for _, id := range pf.outputs[s] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			for _, p := range pf.keywordPatterns[id] {
+				hits[p]++
+			}
+		}
+	}
+
+	res := make([]bool, pf.n)
+	for i := range res {
+		switch {
+		case pf.anchorCounts[i] == 0:
+			res[i] = true
+		case patterns[i].AllAnchors:
+			res[i] = hits[i] == pf.anchorCounts[i]
+		default:
+			res[i] = hits[i] > 0
+		}
+	}
+	return res
+}
+
+// attachPrefilter builds a prefilter for patterns and stores it on every
+// element so that detection can find it.
+func attachPrefilter(patterns []PrecompiledPattern) {
+	pf := newKeywordPrefilter(patterns)
+	for i := range patterns {
+		patterns[i].prefilter = pf
+	}
+}
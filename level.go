@@ -1,6 +1,8 @@
 package logparser
 
 import (
+	"fmt"
+	"regexp"
 	"strings"
 	"unicode"
 )
@@ -61,7 +63,16 @@ func LevelByPriority(priority string) Level {
 	return LevelUnknown
 }
 
+// GuessLevel guesses the level of line from glog and klog headers, level
+// keywords, such as "ERROR", "WARN", "SEVERE" or "fatal", among its first
+// fields, and redis log markers.
 func GuessLevel(line string) Level {
+	return guessLevel(line, nil)
+}
+
+// guessLevel is GuessLevel with keywords, matching whole lowercase subfields
+// at a level position, checked before the built-in ones.
+func guessLevel(line string, keywords map[string]Level) Level {
 	if len(line) > maxLineLenForGuessingLevel {
 		line = line[:maxLineLenForGuessingLevel]
 	}
@@ -78,14 +89,35 @@ func GuessLevel(line string) Level {
 		return l
 	}
 
+	leading := true
 	for _, f := range fields[:limit] {
+		// the leading token is the first one past the timestamp
+		first := leading && !unicode.IsDigit(rune(f[0]))
+		if first {
+			leading = false
+		}
 		subfields := strings.FieldsFunc(f, func(r rune) bool {
 			return r == ']' || r == ')' || r == ';' || r == '|' || r == ':' || r == ',' || r == '.'
 		})
-		for _, sf := range subfields {
+		for i, sf := range subfields {
+			bracketed := strings.IndexAny(sf, "[(<") == 0
 			sf = strings.TrimLeft(strings.ToLower(sf), "\"[(<'")
+			explicit := strings.HasPrefix(sf, "level=")
 			sf = strings.TrimPrefix(sf, "level=")
 
+			// whole words, which can be common ones, only count at a
+			// level position
+			if first && i == 0 || bracketed || explicit {
+				if level, ok := keywords[sf]; ok {
+					return level
+				}
+				switch sf {
+				case "trace":
+					return LevelDebug
+				case "severe":
+					return LevelError
+				}
+			}
 			if l := len(sf); l == 3 {
 				switch sf {
 				case "dbg", "trc":
@@ -128,6 +160,94 @@ func GuessLevel(line string) Level {
 	return LevelUnknown
 }
 
+// LevelRule assigns Level to the lines starting with Prefix, or matching
+// Regexp if Prefix is empty.
+type LevelRule struct {
+	Prefix string
+	Regexp *regexp.Regexp
+	Level  Level
+}
+
+func (r LevelRule) match(line string) bool {
+	if r.Prefix != "" {
+		return strings.HasPrefix(line, r.Prefix)
+	}
+	return r.Regexp.MatchString(line)
+}
+
+// LevelDetector guesses the level of lines like GuessLevel, after trying
+// custom rules, in order, and with extra level keywords. A nil LevelDetector
+// is equivalent to GuessLevel.
+type LevelDetector struct {
+	rules    []LevelRule
+	keywords map[string]Level
+}
+
+// NewLevelDetector returns a LevelDetector with rules, which are evaluated
+// before the built-in heuristics, and keywords, which extend the built-in
+// level keywords. A keyword matches a whole word, case-insensitively, at a
+// level position: the first token past the timestamp, in brackets, as in
+// "[TRACE]", or after "level=". It takes precedence over the built-in
+// keywords.
+func NewLevelDetector(rules []LevelRule, keywords map[string]Level) (*LevelDetector, error) {
+	d := &LevelDetector{}
+	if err := d.addRules(rules); err != nil {
+		return nil, err
+	}
+	if err := d.addKeywords(keywords); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *LevelDetector) addRules(rules []LevelRule) error {
+	for i, r := range rules {
+		if r.Prefix == "" && r.Regexp == nil {
+			return fmt.Errorf("level rule %d has neither a prefix nor a regexp", i)
+		}
+		if r.Level == LevelUnknown {
+			return fmt.Errorf("level rule %d has no level", i)
+		}
+	}
+	d.rules = append(d.rules, rules...)
+	return nil
+}
+
+func (d *LevelDetector) addKeywords(keywords map[string]Level) error {
+	for k, level := range keywords {
+		if k == "" || strings.ContainsAny(k, " \t") {
+			return fmt.Errorf("invalid level keyword %q", k)
+		}
+		if level == LevelUnknown {
+			return fmt.Errorf("level keyword %q has no level", k)
+		}
+		if d.keywords == nil {
+			d.keywords = map[string]Level{}
+		}
+		d.keywords[strings.ToLower(k)] = level
+	}
+	return nil
+}
+
+// Detect returns the level of line.
+func (d *LevelDetector) Detect(line string) Level {
+	if d == nil {
+		return GuessLevel(line)
+	}
+	if len(d.rules) > 0 {
+		head := line
+		if len(head) > maxLineLenForGuessingLevel {
+			head = head[:maxLineLenForGuessingLevel]
+		}
+		for _, r := range d.rules {
+			if r.match(head) {
+				return r.Level
+			}
+		}
+	}
+	return guessLevel(line, d.keywords)
+}
+
 func tryGlog(fields []string) Level {
 	firstField := fields[0]
 	if len(firstField) != 5 {
@@ -1,8 +1,12 @@
 package logparser
 
 import (
-	"github.com/stretchr/testify/assert"
+	"regexp"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGuessLevelGlog(t *testing.T) {
@@ -41,3 +45,72 @@ func TestGuessLevel(t *testing.T) {
 	assert.Equal(t, LevelCritical, GuessLevel(`2022/05/14 07:08:37 [crit] 6689#6689: *16721837 SSL_do_handshake() failed (SSL: error:1420918C:SSL routines:tls_early_post_process_client_hello:version too low) while SSL handshaking`))
 	assert.Equal(t, LevelError, GuessLevel(`2009/01/01 19:45:44 [error]  29874#0: *98 open() "/var/www/one/nonexistent.html" failed (2: No such file or directory), client: 11.22.33.44, server: one.org, request: "GET /nonexistent.html HTTP/1.1", host: "one.org"`))
 }
+
+func TestGuessLevelKeywords(t *testing.T) {
+	// Rails
+	assert.Equal(t, LevelCritical, GuessLevel(`F, [2024-01-02T15:04:05.123456 #1234] FATAL -- : boom`))
+	assert.Equal(t, LevelWarning, GuessLevel(`W, [2024-01-02T15:04:05.123456 #1234]  WARN -- : slow query`))
+	// java.util.logging
+	assert.Equal(t, LevelError, GuessLevel(`SEVERE: Servlet.service() for servlet [dispatcher] threw exception`))
+	// Python logging
+	assert.Equal(t, LevelCritical, GuessLevel(`CRITICAL:root:database unreachable`))
+	assert.Equal(t, LevelDebug, GuessLevel(`2024-01-02 15:04:05 TRACE c.e.App entering`))
+	assert.Equal(t, LevelInfo, GuessLevel(`2024-01-02 15:04:05 NOTICE config reloaded`))
+	assert.Equal(t, LevelDebug, GuessLevel(`[2024-01-02 15:04:05] [TRACE] entering`))
+	assert.Equal(t, LevelDebug, GuessLevel(`ts=2024-01-02T15:04:05Z level=trace msg=entering`))
+	assert.Equal(t, LevelUnknown, GuessLevel(`Traceback (most recent call last):`))
+	// only at a level position
+	assert.Equal(t, LevelUnknown, GuessLevel(`Failed to print stack trace`))
+	assert.Equal(t, LevelUnknown, GuessLevel(`2024-01-02 15:04:05 disk usage severe: 97%`))
+	// glog and klog
+	assert.Equal(t, LevelError, GuessLevel(`E1006 15:04:05.123456       1 controller.go:12] sync failed`))
+	assert.Equal(t, LevelWarning, GuessLevel(`W1006 15:04:05.123456       1 controller.go:12] retrying`))
+}
+
+func TestLevelDetector(t *testing.T) {
+	_, err := NewLevelDetector([]LevelRule{{Level: LevelError}}, nil)
+	assert.Error(t, err)
+	_, err = NewLevelDetector([]LevelRule{{Prefix: "x"}}, nil)
+	assert.Error(t, err)
+	_, err = NewLevelDetector(nil, map[string]Level{"two words": LevelError})
+	assert.Error(t, err)
+
+	d, err := NewLevelDetector([]LevelRule{
+		{Prefix: "!! ", Level: LevelCritical},
+		{Regexp: regexp.MustCompile(`\bstatus=5\d\d\b`), Level: LevelError},
+		{Prefix: "ERROR (expected)", Level: LevelInfo},
+	}, map[string]Level{"Oops": LevelWarning, "info": LevelDebug})
+	require.NoError(t, err)
+	assert.Equal(t, LevelCritical, d.Detect("!! disk full"))
+	assert.Equal(t, LevelError, d.Detect("GET /api status=503 took=12ms"))
+	// custom rules and keywords take precedence over the built-ins
+	assert.Equal(t, LevelInfo, d.Detect("ERROR (expected) cache miss"))
+	assert.Equal(t, LevelDebug, d.Detect("INFO cache warmed"))
+	assert.Equal(t, LevelWarning, d.Detect("[OOPS] retrying"))
+	assert.Equal(t, LevelError, d.Detect("ERROR failed"))
+	assert.Equal(t, LevelUnknown, d.Detect("plain message"))
+	assert.Equal(t, LevelUnknown, d.Detect("request failed: oops"))
+
+	var nilDetector *LevelDetector
+	assert.Equal(t, LevelError, nilDetector.Detect("ERROR failed"))
+}
+
+func TestParserLevelRules(t *testing.T) {
+	_, err := NewParserWithOptions(make(chan LogEntry), WithLevelRules([]LevelRule{{Level: LevelError}}))
+	assert.Error(t, err)
+
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch,
+		WithLevelRules([]LevelRule{{Prefix: "E! ", Level: LevelError}}),
+		WithLevelKeywords(map[string]Level{"boom": LevelCritical}),
+	)
+	require.NoError(t, err)
+	ch <- LogEntry{Content: "E! telegraf output failed", Timestamp: time.Now()}
+	ch <- LogEntry{Content: "boom: reactor overheated", Timestamp: time.Now()}
+	require.NoError(t, p.StopAndDrain(time.Second))
+	levels := map[string]Level{}
+	for _, c := range p.GetCounters() {
+		levels[c.Sample] = c.Level
+	}
+	assert.Equal(t, map[string]Level{"E! telegraf output failed": LevelError, "boom: reactor overheated": LevelCritical}, levels)
+}
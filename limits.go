@@ -0,0 +1,70 @@
+package logparser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxLineSize is the default size limit of the entries read by a
+	// Parser; see WithMaxLineSize.
+	DefaultMaxLineSize = 1024 * 1024
+	// maxJSONDepth is the nesting of objects and arrays above which a line
+	// isn't decoded as JSON, so that a line of brackets can't make the
+	// decoder build a deep tree of maps.
+	maxJSONDepth = 64
+)
+
+// truncateLine cuts s to at most max bytes, at a rune boundary, and reports
+// whether it was cut.
+func truncateLine(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max], true
+}
+
+// validUTF8 returns s with its invalid UTF-8 sequences replaced with U+FFFD,
+// so that they can't end up split or merged with the next bytes in patterns
+// and templates.
+func validUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
+
+// clusterLine returns log as the PatternExtractor clusters it: valid UTF-8,
+// so that templates survive SaveState, and truncated to DefaultMaxLineSize.
+func clusterLine(log string) string {
+	log, _ = truncateLine(validUTF8(log), DefaultMaxLineSize)
+	return log
+}
+
+// jsonTooDeep reports whether the objects and arrays of the JSON document s
+// nest deeper than maxJSONDepth. Brackets in strings don't count; s doesn't
+// need to be valid JSON.
+func jsonTooDeep(s string) bool {
+	depth := 0
+	inString := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			if depth++; depth > maxJSONDepth {
+				return true
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package logparser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogfmtPair is a key=value pair of a logfmt line.
+type LogfmtPair struct {
+	Key   string
+	Value string
+}
+
+// ParseLogfmt splits a logfmt line, such as
+// `level=error msg="connection refused" addr=10.0.0.1:5432`, into its pairs
+// in order. Values are bare, ending at the next space, or double-quoted with
+// Go escapes such as \" and \\. ok is false unless every field of line is a
+// key=value pair.
+func ParseLogfmt(line string) (pairs []LogfmtPair, ok bool) {
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		eq := strings.IndexAny(rest, "= \"")
+		if eq <= 0 || rest[eq] != '=' {
+			return nil, false
+		}
+		pair := LogfmtPair{Key: rest[:eq]}
+		rest = rest[eq+1:]
+		if strings.HasPrefix(rest, `"`) {
+			end := quotedValueEnd(rest)
+			if end < 0 {
+				return nil, false
+			}
+			value, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil, false
+			}
+			pair.Value, rest = value, rest[end+1:]
+			if rest != "" && rest[0] != ' ' {
+				return nil, false
+			}
+		} else if i := strings.IndexByte(rest, ' '); i >= 0 {
+			pair.Value, rest = rest[:i], rest[i:]
+		} else {
+			pair.Value, rest = rest, ""
+		}
+		pairs = append(pairs, pair)
+		rest = strings.TrimLeft(rest, " ")
+	}
+	return pairs, len(pairs) > 0
+}
+
+// quotedValueEnd returns the index of the quote closing the value starting
+// at s[0], or -1 if it is unterminated.
+func quotedValueEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// LogfmtDecoder decodes logfmt lines (see ParseLogfmt). The content is the
+// value of the msg (or message) key, so that lines differing only in their
+// other keys share a pattern; lines without one are kept whole. The level
+// key (level, lvl or severity) sets the level, which takes precedence over
+// the level guessed from the content, and the time key (time or ts), in
+// RFC3339, sets the timestamp. Lines that aren't logfmt are passed through
+// unchanged. Use ParseLogfmt to get the other keys.
+type LogfmtDecoder struct{}
+
+// Decode returns the message of src.
+func (d LogfmtDecoder) Decode(src string) (string, error) {
+	entry, _, err := d.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+// DecodeEntry returns entry with the message, level and timestamp of its
+// logfmt line. It always returns ok=true.
+func (d LogfmtDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	pairs, ok := ParseLogfmt(entry.Content)
+	if !ok {
+		return entry, true, nil
+	}
+	for _, p := range pairs {
+		switch strings.ToLower(p.Key) {
+		case "msg", "message":
+			entry.Content = p.Value
+		case "level", "lvl", "severity":
+			if level := GuessLevel(p.Value); level != LevelUnknown {
+				entry.Level = level
+			}
+		case "time", "ts":
+			if ts, err := time.Parse(time.RFC3339Nano, p.Value); err == nil {
+				entry.Timestamp = ts
+			}
+		}
+	}
+	return entry, true, nil
+}
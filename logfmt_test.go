@@ -0,0 +1,84 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	pairs, ok := ParseLogfmt(`level=error msg="failed to query \"users\": connection refused" addr=10.0.0.1:5432 empty= path="C:\\data" retry=3`)
+	require.True(t, ok)
+	assert.Equal(t, []LogfmtPair{
+		{Key: "level", Value: "error"},
+		{Key: "msg", Value: `failed to query "users": connection refused`},
+		{Key: "addr", Value: "10.0.0.1:5432"},
+		{Key: "empty", Value: ""},
+		{Key: "path", Value: `C:\data`},
+		{Key: "retry", Value: "3"},
+	}, pairs)
+
+	for _, line := range []string{
+		"",
+		"connection refused",
+		"2023-10-06 level=error msg=x",
+		`msg="unterminated`,
+		`msg="a"b`,
+		`=value`,
+		`{"level":"error"}`,
+	} {
+		_, ok := ParseLogfmt(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestLogfmtDecoder(t *testing.T) {
+	d := LogfmtDecoder{}
+	entry, ok, err := d.DecodeEntry(LogEntry{Content: `ts=2023-10-06T14:00:00Z level=info msg="retrying after error" attempt=2`})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Timestamp: time.Date(2023, 10, 6, 14, 0, 0, 0, time.UTC), Content: "retrying after error", Level: LevelInfo}, entry)
+
+	// no msg key: the line is kept whole
+	entry, _, err = d.DecodeEntry(LogEntry{Content: `lvl=warn component=cache evicted=12`})
+	require.NoError(t, err)
+	assert.Equal(t, LogEntry{Content: `lvl=warn component=cache evicted=12`, Level: LevelWarning}, entry)
+
+	ts := time.Now()
+	entry, _, err = d.DecodeEntry(LogEntry{Content: "plain ERROR line", Timestamp: ts})
+	require.NoError(t, err)
+	assert.Equal(t, LogEntry{Content: "plain ERROR line", Timestamp: ts}, entry)
+
+	// lines differing only in addr share a pattern
+	a, err := d.Decode(`level=error msg="connection refused" addr=10.0.0.1:5432`)
+	require.NoError(t, err)
+	b, err := d.Decode(`level=error msg="connection refused" addr=db.internal:6543`)
+	require.NoError(t, err)
+	assert.Equal(t, NewPattern(a).Hash(), NewPattern(b).Hash())
+}
+
+func TestParserLogfmtDecoder(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithDecoder(LogfmtDecoder{}), WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	for _, line := range []string{
+		`level=error msg="connection refused" addr=10.0.0.1:5432`,
+		`level=error msg="connection refused" addr=db.internal:6543`,
+		`level=info msg="retrying after error" attempt=1`,
+	} {
+		ch <- LogEntry{Content: line, Timestamp: time.Now()}
+	}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	byLevel := map[Level]LogCounter{}
+	for _, c := range p.GetCounters() {
+		byLevel[c.Level] = c
+	}
+	require.Len(t, byLevel, 2)
+	assert.Equal(t, 2, byLevel[LevelError].Messages)
+	assert.Equal(t, "connection refused", byLevel[LevelError].Sample)
+	// the level key overrides the "error" in the message
+	assert.Equal(t, 1, byLevel[LevelInfo].Messages)
+}
@@ -0,0 +1,96 @@
+package logparser
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// detectionLatencyBuckets are the upper bounds, in seconds, of the buckets
+// of Metrics.DetectionLatency.
+var detectionLatencyBuckets = [...]float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}
+
+// Metrics describes the work done by a parser since it was created. Unlike
+// counters, the values only ever increase: they are not affected by
+// GetCountersAndReset or eviction, so they can be exported as monotonic
+// counters (see the metrics sub-package).
+type Metrics struct {
+	// Messages is the number of messages counted per level.
+	Messages map[Level]uint64
+	// Patterns is the current number of pattern counters.
+	Patterns int
+	// SensitiveMatches is the number of sensitive matches per pattern name.
+	SensitiveMatches map[string]uint64
+	// MultilineMessages is the number of messages assembled from more than
+	// one line.
+	MultilineMessages uint64
+	// Dropped is the number of entries discarded by the ingest buffer.
+	Dropped uint64
+	// DetectionLatency is the time spent on sensitive detection per message.
+	DetectionLatency Histogram
+}
+
+// Histogram is a snapshot of a distribution of durations in seconds.
+type Histogram struct {
+	// Buckets maps the upper bound of each bucket to the cumulative number
+	// of observations less than or equal to it.
+	Buckets map[float64]uint64
+	Count   uint64
+	Sum     float64
+}
+
+// GetMetrics returns the parser metrics.
+func (p *Parser) GetMetrics() Metrics {
+	m := Metrics{
+		Messages:          map[Level]uint64{},
+		Patterns:          int(p.patternCount.Load()),
+		SensitiveMatches:  map[string]uint64{},
+		MultilineMessages: p.multilineMessages.Load(),
+		Dropped:           p.dropped.Load(),
+		DetectionLatency:  p.detectionLatency.snapshot(),
+	}
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.lock.Lock()
+		if s.messages > 0 {
+			m.Messages[Level(i)] = s.messages
+		}
+		s.lock.Unlock()
+	}
+	p.sensitiveLock.Lock()
+	for name, n := range p.sensitiveMatches {
+		m.SensitiveMatches[name] = n
+	}
+	p.sensitiveLock.Unlock()
+	return m
+}
+
+// latencyHistogram is a lock-free histogram of durations over
+// detectionLatencyBuckets.
+type latencyHistogram struct {
+	counts [len(detectionLatencyBuckets) + 1]atomic.Uint64 // the last one is +Inf
+	count  atomic.Uint64
+	sumNs  atomic.Int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := 0
+	for i < len(detectionLatencyBuckets) && s > detectionLatencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+func (h *latencyHistogram) snapshot() Histogram {
+	res := Histogram{Buckets: make(map[float64]uint64, len(detectionLatencyBuckets))}
+	var cumulative uint64
+	for i, le := range detectionLatencyBuckets {
+		cumulative += h.counts[i].Load()
+		res.Buckets[le] = cumulative
+	}
+	res.Count = h.count.Load()
+	res.Sum = time.Duration(h.sumNs.Load()).Seconds()
+	return res
+}
@@ -0,0 +1,87 @@
+// Package metrics exports the internals of a logparser.Parser as Prometheus
+// metrics. It lives in a module of its own so that the parser doesn't depend
+// on the Prometheus client.
+package metrics
+
+import (
+	"github.com/nudgebee/logparser"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Source provides the metrics to export; *logparser.Parser implements it.
+type Source interface {
+	GetMetrics() logparser.Metrics
+}
+
+var (
+	messagesDesc = prometheus.NewDesc(
+		"logparser_messages_total",
+		"Number of messages counted by level.",
+		[]string{"level"}, nil,
+	)
+	patternsDesc = prometheus.NewDesc(
+		"logparser_patterns",
+		"Current number of pattern counters.",
+		nil, nil,
+	)
+	sensitiveMatchesDesc = prometheus.NewDesc(
+		"logparser_sensitive_matches_total",
+		"Number of sensitive data matches by pattern name.",
+		[]string{"pattern"}, nil,
+	)
+	multilineMessagesDesc = prometheus.NewDesc(
+		"logparser_multiline_messages_total",
+		"Number of messages assembled from more than one line.",
+		nil, nil,
+	)
+	droppedDesc = prometheus.NewDesc(
+		"logparser_dropped_entries_total",
+		"Number of entries discarded because the ingest buffer was full.",
+		nil, nil,
+	)
+	detectionLatencyDesc = prometheus.NewDesc(
+		"logparser_sensitive_detection_duration_seconds",
+		"Time spent on sensitive data detection per message.",
+		nil, nil,
+	)
+)
+
+// Collector is a prometheus.Collector reading its values from a Source on
+// every scrape.
+type Collector struct {
+	src Source
+}
+
+// NewCollector returns a Collector for src.
+func NewCollector(src Source) *Collector {
+	return &Collector{src: src}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- messagesDesc
+	ch <- patternsDesc
+	ch <- sensitiveMatchesDesc
+	ch <- multilineMessagesDesc
+	ch <- droppedDesc
+	ch <- detectionLatencyDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	m := c.src.GetMetrics()
+	for level, n := range m.Messages {
+		ch <- prometheus.MustNewConstMetric(messagesDesc, prometheus.CounterValue, float64(n), level.String())
+	}
+	ch <- prometheus.MustNewConstMetric(patternsDesc, prometheus.GaugeValue, float64(m.Patterns))
+	for name, n := range m.SensitiveMatches {
+		ch <- prometheus.MustNewConstMetric(sensitiveMatchesDesc, prometheus.CounterValue, float64(n), name)
+	}
+	ch <- prometheus.MustNewConstMetric(multilineMessagesDesc, prometheus.CounterValue, float64(m.MultilineMessages))
+	ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(m.Dropped))
+	h := m.DetectionLatency
+	ch <- prometheus.MustNewConstHistogram(detectionLatencyDesc, h.Count, h.Sum, h.Buckets)
+}
+
+// RegisterMetrics registers a Collector for src with reg.
+func RegisterMetrics(reg prometheus.Registerer, src Source) error {
+	return reg.Register(NewCollector(src))
+}
@@ -0,0 +1,178 @@
+package logparser
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MultiParser parses many named streams, e.g. the containers of a node,
+// with a single Parser: the streams share its goroutines and sensitive
+// patterns, while their messages are assembled and counted apart, the
+// stream name being the Source of their entries and counters. The pattern
+// limits (WithMaxPatterns, WithPatternsPerLevelLimit) apply to each stream,
+// so that a noisy stream can't take the patterns of the others. An idle
+// stream only costs its name.
+type MultiParser struct {
+	parser *Parser
+
+	lock    sync.RWMutex
+	streams map[string]*stream
+}
+
+// stream is the state of a MultiParser stream.
+type stream struct {
+	// ch is the channel returned by Stream, nil until it is called.
+	ch      chan LogEntry
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewMultiParser returns a MultiParser whose streams are parsed with opts.
+func NewMultiParser(opts ...ParserOption) (*MultiParser, error) {
+	p, err := NewParserWithOptions(make(chan LogEntry), append([]ParserOption{withLimitsPerSource()}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiParser{parser: p, streams: map[string]*stream{}}, nil
+}
+
+// withLimitsPerSource applies the pattern limits of the parser to each
+// Source apart.
+func withLimitsPerSource() ParserOption {
+	return func(p *Parser) error {
+		p.limitsPerSource = true
+		return nil
+	}
+}
+
+// stream returns the stream name, creating it if needed.
+func (m *MultiParser) stream(name string) *stream {
+	m.lock.RLock()
+	s := m.streams[name]
+	m.lock.RUnlock()
+	if s != nil {
+		return s
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if s = m.streams[name]; s == nil {
+		s = &stream{stopped: make(chan struct{})}
+		m.streams[name] = s
+	}
+	return s
+}
+
+// Stream returns the channel of the stream name, on which its entries can
+// be sent like to a Parser. The channel is read until it is closed; the
+// entries sent once the stream is stopped are discarded, so that senders
+// don't block.
+func (m *MultiParser) Stream(name string) chan<- LogEntry {
+	s := m.stream(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan LogEntry)
+		go m.forward(name, s)
+	}
+	return s.ch
+}
+
+// forward adds the entries sent on the channel of s until it is closed.
+// ProcessEntry ignores them once s is stopped.
+func (m *MultiParser) forward(name string, s *stream) {
+	for entry := range s.ch {
+		m.ProcessEntry(name, entry)
+	}
+}
+
+// ProcessEntry adds entry to the stream name, like Parser.ProcessEntry. The
+// entries of a stream must be added in order from one goroutine. Entries of
+// stopped streams are ignored.
+func (m *MultiParser) ProcessEntry(name string, entry LogEntry) {
+	select {
+	case <-m.stream(name).stopped:
+		return
+	default:
+	}
+	entry.Source = name
+	m.parser.ProcessEntry(entry)
+}
+
+// ProcessLine adds a raw line to the stream name, like Parser.ProcessLine.
+func (m *MultiParser) ProcessLine(name, line string) {
+	entry := LogEntry{Content: line, Level: LevelUnknown}
+	if m.parser.timestamps == nil {
+		entry.Timestamp = time.Now()
+	}
+	m.ProcessEntry(name, entry)
+}
+
+// StopStream stops reading the stream name and counts the message it was
+// collecting. Its counters remain readable and the other streams are not
+// affected.
+func (m *MultiParser) StopStream(name string) {
+	s := m.stream(name)
+	s.once.Do(func() {
+		close(s.stopped)
+		m.parser.multilineCollector.flushSource(name)
+	})
+}
+
+// Streams returns the names of the streams, sorted.
+func (m *MultiParser) Streams() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	res := make([]string, 0, len(m.streams))
+	for name := range m.streams {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Counters returns the counters of the stream name.
+func (m *MultiParser) Counters(name string) []LogCounter {
+	return m.parser.sourceCounters(name)
+}
+
+// SensitiveCounters returns the sensitive counters of the stream name.
+func (m *MultiParser) SensitiveCounters(name string) []SensitiveLogCounter {
+	var res []SensitiveLogCounter
+	for _, c := range m.parser.GetSensitiveCounters() {
+		if c.Source == name {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// Parser returns the underlying parser, whose counters are the combined
+// view of all the streams.
+func (m *MultiParser) Parser() *Parser {
+	return m.parser
+}
+
+// Stop stops all the streams, like Parser.Stop.
+func (m *MultiParser) Stop() {
+	m.stopStreams()
+	m.parser.Stop()
+}
+
+// StopAndDrain stops all the streams once the entries already added are
+// counted, like Parser.StopAndDrain.
+func (m *MultiParser) StopAndDrain(timeout time.Duration) error {
+	err := m.parser.StopAndDrain(timeout)
+	m.stopStreams()
+	return err
+}
+
+// stopStreams stops the streams; their forwarding goroutines discard
+// entries until their channel is closed.
+func (m *MultiParser) stopStreams() {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, s := range m.streams {
+		s.once.Do(func() { close(s.stopped) })
+	}
+}
@@ -2,130 +2,335 @@ package logparser
 
 import (
 	"context"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 	"unicode/utf8"
 )
 
 var (
+	// multilineCollectorLimit is the default byte limit of messages.
 	multilineCollectorLimit = 64 * 1024
+	// multilineDetectionLimit bounds the untruncated content kept for
+	// sensitive detection when a message is truncated.
+	multilineDetectionLimit = 1024 * 1024
 )
 
 type Message struct {
+	// Timestamp is the timestamp of the message's first line.
 	Timestamp time.Time
 	Content   string
 	Level     Level
+	Source    string
+	// Lines is the number of lines the message was assembled from,
+	// including lines dropped by truncation.
+	Lines int
+	// Truncated is set if the message exceeded the line or byte limit of
+	// the multiline collector and Content was cut.
+	Truncated bool
+
+	// cut holds the lines or ends of lines of a truncated message that were
+	// cut off, if the collector keeps them for sensitive detection. cutSep
+	// separates the first one from Content: a newline, or what was trimmed
+	// off the end of Content if it continues its last line.
+	cut    []string
+	cutSep string
+}
+
+// detectionContent returns the content sensitive detection should run on:
+// Content followed by the parts cut off by truncation, if they were kept.
+// They are only copied in here, when detection needs them.
+func (m Message) detectionContent() string {
+	if len(m.cut) == 0 {
+		return m.Content
+	}
+	size := len(m.Content) + len(m.cutSep)
+	for _, part := range m.cut {
+		size += len(part) + 1
+	}
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteString(m.Content)
+	b.WriteString(m.cutSep)
+	for i, part := range m.cut {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
 }
 
 type MultilineCollector struct {
 	Messages chan Message
 
 	timeout time.Duration
+	maxAge  time.Duration
 	limit   int
+	// maxLines, if positive, caps the number of lines of a message.
+	maxLines int
+	// keepCut keeps the parts of messages cut off by truncation, up to
+	// multilineDetectionLimit, for sensitive detection.
+	keepCut bool
+
+	ctx    context.Context
+	done   chan struct{}
+	lock   sync.Mutex
+	closed bool
+	// buffers holds the message being collected for each source, so that
+	// interleaved lines from different sources are never merged.
+	buffers map[string]*multilineBuffer
+	// levels guesses the level of messages; nil uses GuessLevel.
+	levels *LevelDetector
+	// rules customize the detection of continuation lines; nil uses the
+	// default heuristics.
+	rules *multilineRules
+}
+
+type multilineBuffer struct {
+	source string
 
 	ts    time.Time
 	level Level
 	lines []string
 	size  int
 
-	lock            sync.Mutex
-	closed          bool
-	lastReceiveTime time.Time
+	truncated bool
+	lineCount int
+	// cut holds the parts cut off by truncation if keepCut is set, see
+	// Message.cut.
+	keepCut      bool
+	cut          []string
+	cutSize      int
+	cutContinues bool
+
+	firstReceiveTime time.Time
+	lastReceiveTime  time.Time
 
 	isFirstLineContainsTimestamp bool
 	pythonTraceback              bool
 	pythonTracebackExpected      bool
+	// pemBlock is set between a PEM BEGIN marker and its END marker, so the
+	// base64 body of a key or certificate is kept in one message.
+	pemBlock bool
 }
 
+// NewMultilineCollector returns a collector assembling the entries passed to
+// Add into messages. A message is emitted when its next one starts, after
+// timeout without new lines from its source, or on Flush or Close, and is
+// truncated to limit bytes.
 func NewMultilineCollector(ctx context.Context, timeout time.Duration, limit int) *MultilineCollector {
+	return newMultilineCollector(ctx, timeout, limit, 0)
+}
+
+// newMultilineCollector is NewMultilineCollector with maxAge, which, if
+// positive, bounds the time between the first line of a message and its
+// emission, even while more lines keep arriving.
+func newMultilineCollector(ctx context.Context, timeout time.Duration, limit int, maxAge time.Duration) *MultilineCollector {
 	m := &MultilineCollector{
+		maxAge:   maxAge,
 		timeout:  timeout,
 		limit:    limit,
 		Messages: make(chan Message, 1),
+		ctx:      ctx,
+		done:     make(chan struct{}),
+		buffers:  map[string]*multilineBuffer{},
 	}
 	go m.dispatch(ctx)
 	return m
 }
 
 func (m *MultilineCollector) dispatch(ctx context.Context) {
-	ticker := time.NewTicker(m.timeout)
+	interval := m.timeout
+	if m.maxAge > 0 && m.maxAge < interval {
+		interval = m.maxAge
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	defer close(m.Messages)
 
 	for {
 		select {
 		case <-ctx.Done():
+			m.lock.Lock()
 			m.closed = true
+			m.lock.Unlock()
+			return
+		case <-m.done:
 			return
 		case t := <-ticker.C:
 			m.lock.Lock()
-			if t.Sub(m.lastReceiveTime) > m.timeout {
-				m.flushMessage()
-			}
+			m.flush(func(b *multilineBuffer) bool {
+				return t.Sub(b.lastReceiveTime) > m.timeout || m.maxAge > 0 && len(b.lines) > 0 && t.Sub(b.firstReceiveTime) > m.maxAge
+			})
 			m.lock.Unlock()
 		}
 	}
 }
 
-func (m *MultilineCollector) Add(entry LogEntry) {
-	if !utf8.ValidString(entry.Content) {
+// Close flushes the messages being collected and closes Messages once they
+// have been delivered. Entries added after Close are dropped.
+func (m *MultilineCollector) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
 		return
 	}
+	m.flush(func(*multilineBuffer) bool { return true })
+	m.closed = true
+	close(m.done)
+}
+
+// Flush emits the messages being collected right away, instead of waiting
+// for their next line or the timeout. It returns once they have been
+// delivered to Messages.
+func (m *MultilineCollector) Flush() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.flush(func(*multilineBuffer) bool { return true })
+}
 
+// flushSource emits the message being collected for source, if any.
+func (m *MultilineCollector) flushSource(source string) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	if b := m.buffers[source]; b != nil {
+		m.flushMessage(b)
+		delete(m.buffers, source)
+	}
+}
+
+// flush emits the messages of the buffers selected by pred, in the order of
+// their sources. The caller must hold the lock.
+func (m *MultilineCollector) flush(pred func(*multilineBuffer) bool) {
+	sources := make([]string, 0, len(m.buffers))
+	for source := range m.buffers {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if b := m.buffers[source]; pred(b) {
+			m.flushMessage(b)
+			delete(m.buffers, source)
+		}
+	}
+}
+
+// Add adds a line to the message of its source. Invalid UTF-8 sequences are
+// replaced with U+FFFD rather than dropping the line, so that the rest of it
+// is still counted and scanned for sensitive data.
+func (m *MultilineCollector) Add(entry LogEntry) {
+	entry.Content = validUTF8(entry.Content)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return
+	}
+
+	b := m.buffers[entry.Source]
+	if b == nil {
+		b = &multilineBuffer{source: entry.Source, keepCut: m.keepCut}
+		m.buffers[entry.Source] = b
+	}
 
 	entry.Content = strings.TrimSuffix(entry.Content, "\n")
 	if entry.Content == "" {
-		if len(m.lines) > 0 {
-			m.add(entry)
+		if len(b.lines) > 0 {
+			b.add(entry, m.limit, m.maxLines, m.levels)
 		}
 		return
 	}
-	if m.isNextMessage(entry.Content) {
-		pythonTraceback := m.pythonTraceback
-		m.flushMessage()
-		m.pythonTraceback = pythonTraceback
+	if b.isNextMessage(entry.Content, m.rules) {
+		pythonTraceback := b.pythonTraceback
+		m.flushMessage(b)
+		b.pythonTraceback = pythonTraceback
+	}
+	b.add(entry, m.limit, m.maxLines, m.levels)
+	if strings.Contains(entry.Content, "-----BEGIN ") && !strings.Contains(entry.Content, "-----END ") {
+		b.pemBlock = true
 	}
-	m.add(entry)
 }
 
-func (m *MultilineCollector) add(entry LogEntry) {
-	remaining := m.limit - m.size
-	if remaining <= 0 {
+func (b *multilineBuffer) add(entry LogEntry, limit, maxLines int, levels *LevelDetector) {
+	b.lineCount++
+	remaining := limit - b.size
+	if remaining <= 0 || maxLines > 0 && len(b.lines) >= maxLines {
+		b.truncated = true
+		b.addCut(entry.Content, false)
 		return
 	}
-	if len(m.lines) == 0 {
-		m.ts = entry.Timestamp
-		m.level = GuessLevel(entry.Content)
-		if m.level == LevelUnknown && entry.Level != LevelUnknown {
-			m.level = entry.Level
+	if len(b.lines) == 0 {
+		b.ts = entry.Timestamp
+		b.firstReceiveTime = time.Now()
+		// the level of the first line applies to the whole message
+		b.level = entry.Level
+		if b.level == LevelUnknown {
+			b.level = levels.Detect(entry.Content)
 		}
-		m.isFirstLineContainsTimestamp = containsTimestamp(entry.Content)
+		b.isFirstLineContainsTimestamp = containsTimestamp(entry.Content)
 	}
 	content := entry.Content
 	if len(content) > remaining {
 		for remaining > 0 && !utf8.RuneStart(content[remaining]) {
 			remaining--
 		}
+		b.truncated = true
 		if remaining == 0 {
+			b.addCut(content, false)
 			return
 		}
+		b.addCut(content[remaining:], true)
 		content = content[:remaining]
 	}
-	m.lines = append(m.lines, content)
-	m.size += len(content) + 1
-	m.lastReceiveTime = time.Now()
+	b.lines = append(b.lines, content)
+	b.size += len(content) + 1
+	b.lastReceiveTime = time.Now()
+}
+
+// addCut keeps part, cut off by truncation, if the buffer keeps such parts
+// and they stay under multilineDetectionLimit. continues tells whether part
+// is the end of the last kept line.
+func (b *multilineBuffer) addCut(part string, continues bool) {
+	if !b.keepCut || b.cutSize+len(part) >= multilineDetectionLimit {
+		return
+	}
+	if len(b.cut) == 0 {
+		b.cutContinues = continues
+	}
+	b.cut = append(b.cut, part)
+	b.cutSize += len(part) + 1
 }
 
-func (m *MultilineCollector) isNextMessage(l string) bool {
-	if l == "" || l == "}" || strings.HasPrefix(l, "\t") || strings.HasPrefix(l, "  ") {
+func (b *multilineBuffer) isNextMessage(l string, rules *multilineRules) bool {
+	if l == "" {
+		return false
+	}
+	if b.pemBlock {
+		if strings.HasPrefix(l, "-----END ") {
+			b.pemBlock = false
+			return false
+		}
+		if isPEMBodyLine(l) {
+			return false
+		}
+		b.pemBlock = false
+	}
+
+	if rules != nil {
+		if continuation, ok := rules.isContinuation(l); ok {
+			return !continuation
+		}
+	}
+	if l == "}" || strings.HasPrefix(l, "\t") || strings.HasPrefix(l, "  ") {
 		return false
 	}
 
-	if m.isFirstLineContainsTimestamp {
-		return containsTimestamp(l)
+	if b.isFirstLineContainsTimestamp {
+		return containsTimestamp(l) || hasLevelPrefix(l)
 	}
 
 	if strings.HasPrefix(l, "Caused by: ") {
@@ -137,48 +342,126 @@ func (m *MultilineCollector) isNextMessage(l string) bool {
 	}
 
 	if strings.HasPrefix(l, "Traceback ") {
-		m.pythonTraceback = true
-		if m.pythonTracebackExpected {
-			m.pythonTracebackExpected = false
+		b.pythonTraceback = true
+		if b.pythonTracebackExpected {
+			b.pythonTracebackExpected = false
 			return false
 		}
-		return len(m.lines) > 0
+		return len(b.lines) > 0
 	}
 	if l == "The above exception was the direct cause of the following exception:" || l == "During handling of the above exception, another exception occurred:" {
-		m.pythonTracebackExpected = true
+		b.pythonTracebackExpected = true
 		return false
 	}
-	if m.pythonTraceback {
-		m.pythonTraceback = false
-		return false
+	if b.pythonTraceback {
+		b.pythonTraceback = false
+		return hasLevelPrefix(l)
 	}
 
 	return true
 }
 
-func (m *MultilineCollector) flushMessage() {
+func (m *MultilineCollector) flushMessage(b *multilineBuffer) {
 	if m.closed {
 		return
 	}
-	if len(m.lines) == 0 {
+	if len(b.lines) == 0 {
 		return
 	}
-	content := strings.TrimSpace(strings.Join(m.lines, "\n"))
+	joined := strings.Join(b.lines, "\n")
+	content := strings.TrimSpace(joined)
 	msg := Message{
-		Timestamp: m.ts,
+		Timestamp: b.ts,
 		Content:   content,
-		Level:     m.level,
+		Level:     b.level,
+		Source:    b.source,
+		Lines:     b.lineCount,
+		Truncated: b.truncated,
 	}
-	m.reset()
-	m.Messages <- msg
+	if len(b.cut) > 0 {
+		// The message takes over the cut parts.
+		msg.cut, msg.cutSep = b.cut, "\n"
+		if b.cutContinues {
+			msg.cutSep = joined[len(strings.TrimRightFunc(joined, unicode.IsSpace)):]
+		}
+	}
+	b.reset()
+	select {
+	case m.Messages <- msg:
+	case <-m.ctx.Done():
+	}
+}
+
+func (b *multilineBuffer) reset() {
+	b.ts = time.Time{}
+	b.level = LevelUnknown
+	b.lines = b.lines[:0]
+	b.size = 0
+	b.cut = nil
+	b.cutSize = 0
+	b.cutContinues = false
+	b.truncated = false
+	b.lineCount = 0
+	b.isFirstLineContainsTimestamp = false
+	b.pythonTraceback = false
+	b.pythonTracebackExpected = false
+	b.pemBlock = false
 }
 
-func (m *MultilineCollector) reset() {
-	m.ts = time.Time{}
-	m.level = LevelUnknown
-	m.lines = m.lines[:0]
-	m.size = 0
-	m.isFirstLineContainsTimestamp = false
-	m.pythonTraceback = false
-	m.pythonTracebackExpected = false
+// isPEMBodyLine reports whether l looks like a line of a PEM block body:
+// base64 data or an RFC 1421 header such as "Proc-Type: 4,ENCRYPTED".
+func isPEMBodyLine(l string) bool {
+	if strings.HasPrefix(l, "Proc-Type:") || strings.HasPrefix(l, "DEK-Info:") {
+		return true
+	}
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// truncatedSample returns the content of a truncated message with a marker
+// telling how many of its lines were cut, or just an ellipsis if only the
+// last kept line was cut.
+func truncatedSample(msg Message) string {
+	more := msg.Lines - (strings.Count(msg.Content, "\n") + 1)
+	switch {
+	case more == 1:
+		return msg.Content + "\n… (1 more line)"
+	case more > 1:
+		return msg.Content + "\n… (" + strconv.Itoa(more) + " more lines)"
+	}
+	return msg.Content + "…"
+}
+
+// levelPrefixes are the uppercase level names that start a new record when
+// they are the first word of a line, e.g. "WARN retrying" or "[ERROR] ...".
+// Lowercase and capitalized words are not included, as exception lines such
+// as "Error: boom" continue a message.
+var levelPrefixes = map[string]bool{
+	"TRACE": true, "DEBUG": true, "DBG": true,
+	"INFO": true, "INF": true, "NOTICE": true,
+	"WARN": true, "WARNING": true, "WRN": true,
+	"ERROR": true, "ERR": true, "SEVERE": true,
+	"CRITICAL": true, "CRIT": true, "FATAL": true, "FTL": true, "PANIC": true, "ALERT": true, "EMERG": true,
+}
+
+// hasLevelPrefix reports whether l starts with an uppercase level name, so
+// that it looks like the first line of a record on its own.
+func hasLevelPrefix(l string) bool {
+	word := l
+	if i := strings.IndexAny(word, " \t"); i >= 0 {
+		word = word[:i]
+	}
+	word = strings.TrimLeft(word, "[<(")
+	if i := strings.IndexAny(word, "]>):,|"); i >= 0 {
+		word = word[:i]
+	}
+	return levelPrefixes[word]
 }
@@ -0,0 +1,84 @@
+package logparser
+
+import (
+	"regexp"
+	"sort"
+)
+
+// multilineProfiles are the continuation rules of the built-in multiline
+// profiles, which replace the default heuristics. A line matching none of
+// them starts a new message.
+var multilineProfiles = map[string][]*regexp.Regexp{
+	"java": {
+		regexp.MustCompile(`^\s`),
+		regexp.MustCompile(`^(Caused by|Suppressed): `),
+		regexp.MustCompile(`^\.\.\. \d+ (more|common frames omitted)`),
+		regexp.MustCompile(`^([\w$]+\.)*[\w$]*(Exception|Error|Throwable)(: |$)`),
+	},
+	"python": {
+		regexp.MustCompile(`^\s`),
+		regexp.MustCompile(`^Traceback \(most recent call last\):`),
+		regexp.MustCompile(`^(The above exception was the direct cause of the following exception|During handling of the above exception, another exception occurred):`),
+		regexp.MustCompile(`^(\w+\.)*[A-Z]\w*(Error|Exception|Exit|Interrupt|Warning)(: |$)`),
+	},
+	"go": {
+		regexp.MustCompile(`^\s`),
+		regexp.MustCompile(`^\[signal `),
+		regexp.MustCompile(`^goroutine \d+ \[`),
+		regexp.MustCompile(`^\S*\(.*\)$`), // a stack frame, e.g. main.main()
+		regexp.MustCompile(`^created by `),
+		regexp.MustCompile(`^exit status \d+$`),
+	},
+	"csharp": {
+		regexp.MustCompile(`^\s`),
+		regexp.MustCompile(`^--- End of `),
+		regexp.MustCompile(`^(Unhandled exception\. )?([\w]+\.)*\w*Exception(: |$)`),
+	},
+	"none": {},
+}
+
+// multilineRules customizes how the multiline collector tells the first line
+// of a message from its continuation lines.
+type multilineRules struct {
+	// continuation lines are checked first.
+	continuation []*regexp.Regexp
+	// start, if set, matches the first lines; other lines are
+	// continuations.
+	start *regexp.Regexp
+	// profile is the name of the built-in profile replacing the default
+	// heuristics, if any.
+	profile string
+}
+
+// isContinuation reports whether l continues the message, and ok=false if
+// the default heuristics should decide.
+func (r *multilineRules) isContinuation(l string) (continuation, ok bool) {
+	for _, re := range r.continuation {
+		if re.MatchString(l) {
+			return true, true
+		}
+	}
+	if r.start != nil {
+		return !r.start.MatchString(l), true
+	}
+	if r.profile == "" {
+		return false, false
+	}
+	for _, re := range multilineProfiles[r.profile] {
+		if re.MatchString(l) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// MultilineProfiles returns the names of the built-in multiline profiles
+// accepted by WithMultilineProfile.
+func MultilineProfiles() []string {
+	names := make([]string, 0, len(multilineProfiles))
+	for name := range multilineProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,173 @@
+package logparser
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectWithRules feeds the lines of data to a parser with opts and returns
+// the assembled messages.
+func collectWithRules(t *testing.T, data string, opts ...ParserOption) []Message {
+	var lock sync.Mutex
+	var msgs []Message
+	ch := make(chan LogEntry)
+	opts = append([]ParserOption{
+		WithMultilineTimeout(time.Minute),
+		WithOnMessageDetails(func(msg Message, _ string) {
+			lock.Lock()
+			defer lock.Unlock()
+			msgs = append(msgs, msg)
+		}),
+	}, opts...)
+	p, err := NewParserWithOptions(ch, opts...)
+	require.NoError(t, err)
+	for _, line := range strings.Split(data, "\n") {
+		ch <- LogEntry{Content: line, Timestamp: time.Now()}
+	}
+	require.NoError(t, p.StopAndDrain(time.Second))
+	lock.Lock()
+	defer lock.Unlock()
+	return msgs
+}
+
+func messageContents(msgs []Message) []string {
+	res := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		res = append(res, m.Content)
+	}
+	return res
+}
+
+func TestMultilineProfilePython(t *testing.T) {
+	traceback := `ERROR:root:failed to process order
+Traceback (most recent call last):
+  File "/app/worker.py", line 12, in process
+    charge(order)
+  File "/app/billing.py", line 40, in charge
+    raise PaymentError("card declined")
+billing.PaymentError: card declined
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "/app/worker.py", line 14, in process
+    raise RuntimeError("giving up")
+RuntimeError: giving up`
+	data := traceback + "\nINFO:root:next order"
+
+	msgs := collectWithRules(t, data, WithMultilineProfile("python"))
+	assert.Equal(t, []string{traceback, "INFO:root:next order"}, messageContents(msgs))
+	assert.Equal(t, 14, msgs[0].Lines)
+}
+
+func TestMultilineProfileGo(t *testing.T) {
+	panicMsg := `panic: runtime error: invalid memory address or nil pointer dereference
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x10a4f2b]
+
+goroutine 17 [running]:
+net/http.(*conn).serve.func1()
+	/usr/local/go/src/net/http/server.go:1868 +0xb0
+main.handler(0x0, {0x1034cab30, 0x1400239a0e0})
+	/app/main.go:25 +0x1b
+created by net/http.(*Server).Serve in goroutine 1
+	/usr/local/go/src/net/http/server.go:3086 +0x4cc
+exit status 2`
+	data := panicMsg + "\nlistening on :8080\nshutting down"
+
+	msgs := collectWithRules(t, data, WithMultilineProfile("go"))
+	assert.Equal(t, []string{panicMsg, "listening on :8080", "shutting down"}, messageContents(msgs))
+}
+
+func TestMultilineProfiles(t *testing.T) {
+	java := `Exception in thread "main" java.lang.IllegalStateException: boom
+	at com.example.App.run(App.java:10)
+	... 3 more
+Caused by: java.io.IOException: disk full
+	at com.example.Store.write(Store.java:42)`
+	msgs := collectWithRules(t, java+"\nstarted", WithMultilineProfile("java"))
+	assert.Equal(t, []string{java, "started"}, messageContents(msgs))
+
+	csharp := `Unhandled exception. System.InvalidOperationException: boom
+ ---> System.IO.IOException: disk full
+   at App.Store.Write() in /src/Store.cs:line 42
+   --- End of inner exception stack trace ---
+   at App.Program.Main() in /src/Program.cs:line 10`
+	msgs = collectWithRules(t, csharp+"\nstarted", WithMultilineProfile("csharp"))
+	assert.Equal(t, []string{csharp, "started"}, messageContents(msgs))
+
+	msgs = collectWithRules(t, "first\n  indented\nsecond", WithMultilineProfile("none"))
+	assert.Equal(t, []string{"first", "indented", "second"}, messageContents(msgs))
+
+	_, err := NewParserWithOptions(make(chan LogEntry), WithMultilineProfile("cobol"))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"csharp", "go", "java", "none", "python"}, MultilineProfiles())
+}
+
+func TestMultilineStartAndContinuationPatterns(t *testing.T) {
+	data := `[2024-01-02 15:04:05] ERROR query failed
+SELECT *
+FROM orders
+[2024-01-02 15:04:06] INFO done`
+	msgs := collectWithRules(t, data, WithMultilineStartPattern(regexp.MustCompile(`^\[\d{4}-`)))
+	assert.Equal(t, []string{
+		"[2024-01-02 15:04:05] ERROR query failed\nSELECT *\nFROM orders",
+		"[2024-01-02 15:04:06] INFO done",
+	}, messageContents(msgs))
+
+	// continuation patterns are checked before the start pattern and the
+	// default heuristics
+	data = "ERROR request failed\n> retry 1\n> retry 2\n[2024-01-02 15:04:06] next"
+	msgs = collectWithRules(t, data, WithMultilineContinuationPatterns([]*regexp.Regexp{regexp.MustCompile(`^> `)}))
+	assert.Equal(t, []string{"ERROR request failed\n> retry 1\n> retry 2", "[2024-01-02 15:04:06] next"}, messageContents(msgs))
+	msgs = collectWithRules(t, data,
+		WithMultilineStartPattern(regexp.MustCompile(`^(\[|ERROR|> retry 2)`)),
+		WithMultilineContinuationPatterns([]*regexp.Regexp{regexp.MustCompile(`^> `)}),
+	)
+	assert.Equal(t, []string{"ERROR request failed\n> retry 1\n> retry 2", "[2024-01-02 15:04:06] next"}, messageContents(msgs))
+
+	_, err := NewParserWithOptions(make(chan LogEntry), WithMultilineStartPattern(nil))
+	assert.Error(t, err)
+	_, err = NewParserWithOptions(make(chan LogEntry), WithMultilineContinuationPatterns([]*regexp.Regexp{nil}))
+	assert.Error(t, err)
+}
+
+func TestMultilineRulesTimeoutAndLimit(t *testing.T) {
+	var lock sync.Mutex
+	var msgs []Message
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch,
+		WithMultilineTimeout(20*time.Millisecond),
+		WithMultilineStartPattern(regexp.MustCompile(`^START`)),
+		WithOnMessageDetails(func(msg Message, _ string) {
+			lock.Lock()
+			defer lock.Unlock()
+			msgs = append(msgs, msg)
+		}),
+	)
+	require.NoError(t, err)
+	defer p.Stop()
+
+	// the start pattern never matches again: the message is truncated to
+	// the collector limit and flushed after the timeout
+	ch <- LogEntry{Content: "START dump", Timestamp: time.Now()}
+	line := strings.Repeat("x", 1023)
+	for i := 0; i < 2*multilineCollectorLimit/1024; i++ {
+		ch <- LogEntry{Content: line, Timestamp: time.Now()}
+	}
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(msgs) == 1
+	}, time.Second, 5*time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.True(t, msgs[0].Truncated)
+	assert.LessOrEqual(t, len(msgs[0].Content), multilineCollectorLimit)
+	assert.Equal(t, 1+2*multilineCollectorLimit/1024, msgs[0].Lines)
+}
@@ -0,0 +1,55 @@
+package logparser
+
+import "time"
+
+// defaultNewPatternGracePeriod is the time after the creation of a parser
+// during which new patterns are learnt without calling the new pattern
+// callback.
+const defaultNewPatternGracePeriod = time.Minute
+
+// patternEvents are the callbacks due after a message is counted, called
+// once the locks are released.
+type patternEvents struct {
+	created *newPattern
+	spike   *SpikeEvent
+}
+
+// newPattern is a pattern due for the new pattern callback.
+type newPattern struct {
+	level     Level
+	hash      string
+	sample    string
+	firstSeen time.Time
+}
+
+// checkNewPattern records the key of a counter just created and returns its
+// pattern if it was never seen before and is due for the new pattern
+// callback. The caller must hold the shard lock, which makes sure a pattern
+// is reported once even if its first messages are counted concurrently.
+func (p *Parser) checkNewPattern(s *patternShard, key patternKey, stat *patternStat, firstSeen time.Time) *newPattern {
+	if p.onNewPatternCb == nil || key.level == LevelUnknown || key.level > p.onNewPatternMinLevel {
+		return nil
+	}
+	if _, ok := s.known[key]; ok {
+		return nil
+	}
+	if s.known == nil {
+		s.known = map[patternKey]struct{}{}
+	}
+	s.known[key] = struct{}{}
+	if time.Since(p.created) < p.onNewPatternGrace {
+		return nil
+	}
+	return &newPattern{level: key.level, hash: key.hash, sample: stat.sample, firstSeen: firstSeen}
+}
+
+// notifyPatternEvents calls the new pattern and spike callbacks due. It runs
+// outside the locks, so the callbacks may block or call back into the parser.
+func (p *Parser) notifyPatternEvents(events patternEvents) {
+	if np := events.created; np != nil {
+		p.onNewPatternCb(np.level, np.hash, np.sample, np.firstSeen)
+	}
+	if events.spike != nil {
+		p.spikeDetector.OnSpike(*events.spike)
+	}
+}
@@ -0,0 +1,90 @@
+package logparser
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type newPatternCall struct {
+	level  Level
+	hash   string
+	sample string
+}
+
+func TestNewPatternCallback(t *testing.T) {
+	var lock sync.Mutex
+	var calls []newPatternCall
+	var p *Parser
+	p, err := NewParserWithOptions(make(chan LogEntry),
+		WithNewPatternGracePeriod(0),
+		WithNewPatternCallback(func(level Level, hash, sample string, firstSeen time.Time) {
+			// The callback runs outside the locks.
+			_ = p.GetCounters()
+			lock.Lock()
+			defer lock.Unlock()
+			calls = append(calls, newPatternCall{level: level, hash: hash, sample: sample})
+		}),
+	)
+	require.NoError(t, err)
+	defer p.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.CountMessage(Message{Content: "ERROR failed to connect to 10.0.0.1:5432", Level: LevelError})
+		}()
+	}
+	wg.Wait()
+	require.Len(t, calls, 1)
+	assert.Equal(t, LevelError, calls[0].level)
+	assert.Equal(t, "ERROR failed to connect to 10.0.0.1:5432", calls[0].sample)
+
+	res := p.CountMessage(Message{Content: "WARN disk usage at 91%", Level: LevelWarning})
+	p.CountMessage(Message{Content: "INFO request served in 12ms", Level: LevelInfo})
+	require.Len(t, calls, 2)
+	assert.Equal(t, newPatternCall{level: LevelWarning, hash: res.PatternHash, sample: "WARN disk usage at 91%"}, calls[1])
+
+	// A pattern reset and created again isn't new.
+	p.GetCountersAndReset()
+	p.CountMessage(Message{Content: "ERROR failed to connect to 10.0.0.2:5432", Level: LevelError})
+	assert.Len(t, calls, 2)
+}
+
+func TestNewPatternCallbackGracePeriod(t *testing.T) {
+	var calls []newPatternCall
+	p, err := NewParserWithOptions(make(chan LogEntry),
+		WithPatternizeAllLevels(true),
+		WithNewPatternMinLevel(LevelInfo),
+		WithNewPatternCallback(func(level Level, hash, sample string, firstSeen time.Time) {
+			calls = append(calls, newPatternCall{level: level, hash: hash, sample: sample})
+		}),
+	)
+	require.NoError(t, err)
+	defer p.Stop()
+
+	p.CountMessage(Message{Content: "ERROR failed to connect to 10.0.0.1:5432", Level: LevelError})
+	p.CountMessage(Message{Content: "INFO request served in 12ms", Level: LevelInfo})
+	assert.Empty(t, calls)
+
+	// The patterns learnt during the grace period aren't reported once it
+	// is over.
+	p.created = p.created.Add(-2 * defaultNewPatternGracePeriod)
+	p.CountMessage(Message{Content: "ERROR failed to connect to 10.0.0.2:5432", Level: LevelError})
+	p.CountMessage(Message{Content: "INFO user logged in", Level: LevelInfo})
+	p.CountMessage(Message{Content: "DEBUG cache miss", Level: LevelDebug})
+	require.Len(t, calls, 1)
+	assert.Equal(t, LevelInfo, calls[0].level)
+}
+
+func TestNewPatternOptions(t *testing.T) {
+	_, err := NewParserWithOptions(make(chan LogEntry), WithNewPatternMinLevel(LevelUnknown))
+	assert.Error(t, err)
+	_, err = NewParserWithOptions(make(chan LogEntry), WithNewPatternGracePeriod(-time.Second))
+	assert.Error(t, err)
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package logparser
+
+const raceEnabled = false
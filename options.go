@@ -0,0 +1,744 @@
+package logparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	defaultMultilineTimeout      = time.Second
+	defaultMultilineMaxAge       = 30 * time.Second
+	defaultPatternsPerLevelLimit = 256
+	defaultLowLevelPatternsLimit = 64
+
+	// stopFlushTimeout bounds the time Stop waits for the messages being
+	// collected to be counted.
+	stopFlushTimeout = time.Second
+)
+
+// ParserOption configures a Parser. Options validate their arguments and
+// return an error instead of applying an invalid setting.
+type ParserOption func(*Parser) error
+
+// NewParserWithOptions creates a parser reading log entries from ch.
+// Without options it uses a one-second multiline timeout, 256 patterns per
+// level and no sensitive data detection. It fails if an option is invalid or,
+// unless WithDegradedSensitiveDetection is given, if the sensitive patterns
+// can't be loaded.
+func NewParserWithOptions(ch <-chan LogEntry, opts ...ParserOption) (*Parser, error) {
+	return NewParserWithContext(context.Background(), ch, opts...)
+}
+
+// NewParserWithContext is like NewParserWithOptions, but the parser stops
+// when ctx is canceled, as if Stop was called.
+func NewParserWithContext(ctx context.Context, ch <-chan LogEntry, opts ...ParserOption) (*Parser, error) {
+	if ch == nil {
+		return nil, errors.New("log entry channel is nil")
+	}
+	p := newParser()
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.loadSensitivePatterns(); err != nil {
+		if !p.degradeSensitive {
+			return nil, err
+		}
+		p.logger.Error("sensitive data detection degraded", "error", err)
+	}
+	p.start(ctx, ch)
+	return p, nil
+}
+
+// WithDecoder sets the decoder applied to each entry before it is parsed.
+func WithDecoder(decoder Decoder) ParserOption {
+	return func(p *Parser) error {
+		p.decoder = decoder
+		return nil
+	}
+}
+
+// WithSanitize makes the parser strip ANSI escape sequences and control
+// characters from every entry (see SanitizeDecoder), after the decoder set
+// with WithDecoder, so it also cleans up the messages of JSON and CRI logs.
+func WithSanitize(enabled bool) ParserOption {
+	return func(p *Parser) error {
+		p.sanitizer = nil
+		if enabled {
+			p.sanitizer = NewSanitizeDecoder()
+		}
+		return nil
+	}
+}
+
+// WithTimestampExtraction makes the parser read the timestamp of entries
+// without one from the start of their content with e, or with the built-in
+// layouts if e is nil. The timestamp is also stripped from the messages,
+// after multiline assembly, so it doesn't end up in patterns and samples.
+func WithTimestampExtraction(e *TimestampExtractor) ParserOption {
+	return func(p *Parser) error {
+		if e == nil {
+			e = NewTimestampExtractor()
+		}
+		p.timestamps = e
+		return nil
+	}
+}
+
+// WithLevelRules adds rules that assign a level to the lines they match,
+// evaluated in order before the built-in level heuristics (see
+// LevelDetector).
+func WithLevelRules(rules []LevelRule) ParserOption {
+	return func(p *Parser) error {
+		if p.levels == nil {
+			p.levels = &LevelDetector{}
+		}
+		return p.levels.addRules(rules)
+	}
+}
+
+// WithLevelKeywords extends the built-in level keywords, such as "error" or
+// "warn", with keywords (see NewLevelDetector).
+func WithLevelKeywords(keywords map[string]Level) ParserOption {
+	return func(p *Parser) error {
+		if p.levels == nil {
+			p.levels = &LevelDetector{}
+		}
+		return p.levels.addKeywords(keywords)
+	}
+}
+
+// WithOnMessage registers cb to be called for every parsed message.
+func WithOnMessage(cb OnMsgCallbackF) ParserOption {
+	return func(p *Parser) error {
+		p.onMsgCb = cb
+		return nil
+	}
+}
+
+// WithOnMessageDetails registers cb to be called for every parsed message
+// with the whole Message, e.g. to tell multiline and truncated messages
+// apart. It can be combined with WithOnMessage.
+func WithOnMessageDetails(cb OnMessageCallbackF) ParserOption {
+	return func(p *Parser) error {
+		p.onMessageCb = cb
+		return nil
+	}
+}
+
+// WithNewPatternCallback registers cb to be called once for every pattern
+// of warning level and above seen for the first time, e.g. to flag a new
+// kind of error right away. Patterns created during the first minute of the
+// parser are learnt without calling cb, see WithNewPatternGracePeriod. The
+// keys of the patterns seen are kept for the life of the parser, so a
+// pattern evicted or reset by GetCountersAndReset isn't reported again.
+func WithNewPatternCallback(cb NewPatternCallbackF) ParserOption {
+	return func(p *Parser) error {
+		p.onNewPatternCb = cb
+		return nil
+	}
+}
+
+// WithNewPatternMinLevel sets the least severe level of the patterns
+// reported by the new pattern callback, LevelWarning by default. Info and
+// debug patterns only have counters of their own with
+// WithPatternizeAllLevels.
+func WithNewPatternMinLevel(level Level) ParserOption {
+	return func(p *Parser) error {
+		if level < LevelCritical || level > LevelDebug {
+			return fmt.Errorf("invalid new pattern level %d", level)
+		}
+		p.onNewPatternMinLevel = level
+		return nil
+	}
+}
+
+// WithNewPatternGracePeriod sets how long after its creation the parser
+// learns the patterns of the startup burst without calling the new pattern
+// callback, a minute by default; 0 reports every pattern.
+func WithNewPatternGracePeriod(d time.Duration) ParserOption {
+	return func(p *Parser) error {
+		if d < 0 {
+			return fmt.Errorf("new pattern grace period must not be negative, got %s", d)
+		}
+		p.onNewPatternGrace = d
+		return nil
+	}
+}
+
+// WithMultilineTimeout sets how long the multiline collector waits for the
+// next line of a message before flushing it.
+func WithMultilineTimeout(timeout time.Duration) ParserOption {
+	return func(p *Parser) error {
+		if timeout <= 0 {
+			return fmt.Errorf("multiline timeout must be positive, got %s", timeout)
+		}
+		p.multilineTimeout = timeout
+		return nil
+	}
+}
+
+// WithMultilineProfile replaces the default multiline heuristics with the
+// rules of a built-in profile: "java" (indented frames, "Caused by:" and
+// exception lines), "python" (tracebacks), "go" (panics and goroutine
+// dumps), "csharp" (.NET exceptions and stack traces) or "none" (every line
+// is a message). Lines matching none of the rules start a new message.
+func WithMultilineProfile(name string) ParserOption {
+	return func(p *Parser) error {
+		if _, ok := multilineProfiles[name]; !ok {
+			return fmt.Errorf("unknown multiline profile %q, want one of %v", name, MultilineProfiles())
+		}
+		p.ensureMultilineRules().profile = name
+		return nil
+	}
+}
+
+// WithMultilineStartPattern makes lines matching re start a new message, and
+// the other lines continue the current one, instead of the default
+// heuristics or the multiline profile. Messages are still flushed after the
+// multiline timeout and truncated to the collector limit.
+func WithMultilineStartPattern(re *regexp.Regexp) ParserOption {
+	return func(p *Parser) error {
+		if re == nil {
+			return errors.New("multiline start pattern is nil")
+		}
+		p.ensureMultilineRules().start = re
+		return nil
+	}
+}
+
+// WithMultilineContinuationPatterns makes lines matching any of res continue
+// the current message. They are checked before the start pattern, the
+// multiline profile or the default heuristics, which decide for the other
+// lines.
+func WithMultilineContinuationPatterns(res []*regexp.Regexp) ParserOption {
+	return func(p *Parser) error {
+		for i, re := range res {
+			if re == nil {
+				return fmt.Errorf("multiline continuation pattern %d is nil", i)
+			}
+		}
+		rules := p.ensureMultilineRules()
+		rules.continuation = append(rules.continuation, res...)
+		return nil
+	}
+}
+
+func (p *Parser) ensureMultilineRules() *multilineRules {
+	if p.multiline == nil {
+		p.multiline = &multilineRules{}
+	}
+	return p.multiline
+}
+
+// WithMultilineMaxAge bounds the time a message is collected: a message is
+// flushed at most maxAge after its first line, even while more lines keep
+// arriving within the multiline timeout, and its later lines start a new
+// message. The default is 30 seconds.
+func WithMultilineMaxAge(maxAge time.Duration) ParserOption {
+	return func(p *Parser) error {
+		if maxAge <= 0 {
+			return fmt.Errorf("multiline max age must be positive, got %s", maxAge)
+		}
+		p.multilineMaxAge = maxAge
+		return nil
+	}
+}
+
+// WithMultilineLimits caps the messages assembled by the multiline
+// collector to maxLines lines, 0 for no limit, and maxBytes bytes, 0 for the
+// default of 64KiB. Longer messages are truncated, their LogCounter sample is
+// marked with SampleTruncated and ends with the number of lines cut.
+// Sensitive data detection still covers up to 1MiB of the whole message.
+func WithMultilineLimits(maxLines, maxBytes int) ParserOption {
+	return func(p *Parser) error {
+		if maxLines < 0 || maxBytes < 0 {
+			return fmt.Errorf("multiline limits must not be negative, got %d lines and %d bytes", maxLines, maxBytes)
+		}
+		p.multilineMaxLines = maxLines
+		p.multilineMaxBytes = multilineCollectorLimit
+		if maxBytes > 0 {
+			p.multilineMaxBytes = maxBytes
+		}
+		return nil
+	}
+}
+
+// WithMergedSources counts the messages of all sources together: the
+// LogEntry.Source of entries still keeps their lines from being merged into
+// one multiline message, but the counters, the sensitive counters and the
+// callbacks get an empty Source.
+func WithMergedSources() ParserOption {
+	return func(p *Parser) error {
+		p.mergeSources = true
+		return nil
+	}
+}
+
+// WithMaxLineSize caps the size of the entries read by the parser to n
+// bytes (default DefaultMaxLineSize, 1MiB), so that a single huge line, e.g.
+// a dumped payload or a line without newlines, can't exhaust the memory of
+// the decoders and detectors. Longer entries are truncated at a UTF-8
+// boundary when they are read and again once decoded, as decoding may expand
+// them; see IngestStats.Truncated. The multiline limits still apply to the
+// messages.
+func WithMaxLineSize(n int) ParserOption {
+	return func(p *Parser) error {
+		if n <= 0 {
+			return fmt.Errorf("max line size must be positive, got %d", n)
+		}
+		p.maxLineSize = n
+		return nil
+	}
+}
+
+// WithPatternsPerLevelLimit caps the number of patterns per level; further
+// patterns are counted in the level's catch-all counter.
+func WithPatternsPerLevelLimit(n int) ParserOption {
+	return func(p *Parser) error {
+		if n <= 0 {
+			return fmt.Errorf("patterns per level limit must be positive, got %d", n)
+		}
+		p.patternsPerLevelLimit = n
+		return nil
+	}
+}
+
+// WithLogger sets the logger used for the parser's internal errors. The
+// default is slog.Default(); use a logger with slog.DiscardHandler to
+// silence them.
+func WithLogger(l *slog.Logger) ParserOption {
+	return func(p *Parser) error {
+		if l == nil {
+			return errors.New("logger is nil")
+		}
+		p.logger = l
+		return nil
+	}
+}
+
+// WithDegradedSensitiveDetection makes the constructor log sensitive pattern
+// errors instead of returning them: if the patterns fail to load the parser
+// runs without sensitive detection, and if WithDisabledPatterns or
+// WithOnlyPatterns name unknown patterns they are ignored.
+func WithDegradedSensitiveDetection() ParserOption {
+	return func(p *Parser) error {
+		p.degradeSensitive = true
+		return nil
+	}
+}
+
+// WithPatternizeAllLevels makes the parser group info, debug and unknown
+// level messages by pattern, like warnings and errors, instead of counting
+// them in a single counter per level. These levels have their own limit on
+// the number of patterns, see WithLowLevelPatternsLimit.
+func WithPatternizeAllLevels(enabled bool) ParserOption {
+	return func(p *Parser) error {
+		p.patternizeAllLevels = enabled
+		return nil
+	}
+}
+
+// WithPatternConfig sets how messages are grouped into patterns (see
+// PatternConfig). The default is the zero config, the patterns of
+// NewPattern.
+func WithPatternConfig(cfg PatternConfig) ParserOption {
+	return func(p *Parser) error {
+		p.patternConfig = cfg
+		return nil
+	}
+}
+
+// WithMergeThreshold counts the messages whose pattern is at least threshold
+// similar (see Pattern.Similarity) to an existing pattern with the most
+// similar one, in [0, 1]. Lower values merge more aggressively. By default,
+// or with 0, patterns are merged if they have the same number of words and
+// differ in at most one (see Pattern.WeakEqual).
+func WithMergeThreshold(threshold float64) ParserOption {
+	return func(p *Parser) error {
+		if threshold < 0 || threshold > 1 {
+			return fmt.Errorf("merge threshold must be in [0, 1], got %g", threshold)
+		}
+		p.mergeThreshold = threshold
+		return nil
+	}
+}
+
+// WithStackTraceFingerprint counts the Java and Python stack traces by their
+// exception class and up to frames of their innermost application frames
+// instead of their first line, so that the same exception thrown from
+// different places gets different counters, while changes in the message or
+// the line numbers don't. Frames starting with one of frameworkPrefixes, or
+// DefaultFrameworkPrefixes if none are given, are skipped.
+func WithStackTraceFingerprint(frames int, frameworkPrefixes ...string) ParserOption {
+	return func(p *Parser) error {
+		if frames <= 0 {
+			return fmt.Errorf("stack trace frames must be positive, got %d", frames)
+		}
+		if len(frameworkPrefixes) == 0 {
+			frameworkPrefixes = DefaultFrameworkPrefixes
+		}
+		p.stackTraceFrames, p.frameworkPrefixes = frames, frameworkPrefixes
+		return nil
+	}
+}
+
+// WithAttributeExtractors replaces the extractors of the Attributes of the
+// counters (default DefaultAttributeExtractors), which run on the first
+// message of each pattern. Append to DefaultAttributeExtractors to extend
+// them; no extractors disable the extraction.
+func WithAttributeExtractors(extractors ...AttributeExtractor) ParserOption {
+	return func(p *Parser) error {
+		if err := validateAttributeExtractors(extractors); err != nil {
+			return err
+		}
+		p.attributes = extractors
+		return nil
+	}
+}
+
+// WithSamplesPerPattern keeps the n most recent messages of each pattern in
+// LogCounter.Samples, redacted and truncated like Sample, so up to n times
+// the multiline byte limit (see WithMultilineLimits) per pattern. The
+// default of 1 only keeps the first message, and avoids redacting the
+// messages of existing patterns.
+func WithSamplesPerPattern(n int) ParserOption {
+	return func(p *Parser) error {
+		if n < 1 {
+			return fmt.Errorf("samples per pattern must be positive, got %d", n)
+		}
+		p.samplesPerPattern = n
+		return nil
+	}
+}
+
+// WithLowLevelPatternsLimit caps the number of patterns per level for info,
+// debug and unknown messages when WithPatternizeAllLevels is set; further
+// patterns are counted in the level's catch-all counter. The default is 64.
+func WithLowLevelPatternsLimit(n int) ParserOption {
+	return func(p *Parser) error {
+		if n <= 0 {
+			return fmt.Errorf("low level patterns limit must be positive, got %d", n)
+		}
+		p.lowLevelPatternsLimit = n
+		return nil
+	}
+}
+
+// WithSensitiveConfig sets the sensitive data detection configuration.
+func WithSensitiveConfig(cfg SensitiveConfig) ParserOption {
+	return func(p *Parser) error {
+		if cfg.SampleRate < 0 || cfg.MaxDetections < 0 {
+			return errors.New("sensitive sample rate and max detections must not be negative")
+		}
+		p.sensitiveConfig = cfg
+		return nil
+	}
+}
+
+// WithSensitiveDetection turns sensitive data detection on or off.
+func WithSensitiveDetection(enabled bool) ParserOption {
+	return func(p *Parser) error {
+		p.sensitiveConfig.Enabled = enabled
+		return nil
+	}
+}
+
+// WithSensitivePatterns replaces the embedded sensitive patterns with
+// patterns, e.g. ones loaded with ParsePatterns. The private key detector
+// (see NewPrivateKeyDetector) is added unless patterns has one.
+func WithSensitivePatterns(patterns []PrecompiledPattern) ParserOption {
+	return func(p *Parser) error {
+		for i := range patterns {
+			if patterns[i].Pattern == nil {
+				return fmt.Errorf("sensitive pattern %q has no regex", patterns[i].Name)
+			}
+		}
+		defs := append([]PrecompiledPattern(nil), patterns...)
+		attachPrefilter(defs)
+		p.sensitivePatternDefinitions = defs
+		p.customPatterns = true
+		return nil
+	}
+}
+
+// WithSensitiveAllowlist suppresses sensitive findings whose secret equals
+// one of entries, for every pattern. Entries wrapped in slashes ("/.../")
+// are treated as regexes.
+func WithSensitiveAllowlist(entries ...string) ParserOption {
+	return func(p *Parser) error {
+		return p.addSensitiveAllowlist(entries...)
+	}
+}
+
+// WithPatternPacks adds the patterns of the named packs (see PatternPacks)
+// to the embedded sensitive patterns, or to the ones set by
+// WithSensitivePatterns. Patterns turned off by WithDisabledPatterns or
+// WithOnlyPatterns stay off.
+func WithPatternPacks(names ...string) ParserOption {
+	return func(p *Parser) error {
+		for _, name := range names {
+			if err := checkPatternPack(name); err != nil {
+				return err
+			}
+			if !slices.Contains(p.patternPacks, name) {
+				p.patternPacks = append(p.patternPacks, name)
+			}
+		}
+		return nil
+	}
+}
+
+// WithEntropyDetection enables the entropy-based generic secret detector
+// (see NewEntropyDetector) in addition to the regex patterns. It is off by
+// default and only takes effect when sensitive detection is enabled.
+func WithEntropyDetection(minLength int, threshold float64) ParserOption {
+	return func(p *Parser) error {
+		if minLength < 0 || threshold < 0 {
+			return errors.New("entropy detection parameters must not be negative")
+		}
+		d := NewEntropyDetector(minLength, threshold)
+		p.entropyDetector = &d
+		return nil
+	}
+}
+
+// WithMaxSensitiveCounters caps the number of distinct sensitive counters.
+// Once the limit is reached, findings that would need a new counter are
+// merged into a single overflow counter, reported by GetSensitiveCounters
+// with Overflow set. 0 means no limit.
+func WithMaxSensitiveCounters(n int) ParserOption {
+	return func(p *Parser) error {
+		if n < 0 {
+			return fmt.Errorf("max sensitive counters must not be negative, got %d", n)
+		}
+		p.maxSensitiveStats = n
+		return nil
+	}
+}
+
+// WithOnSensitiveMatch registers cb to be called when sensitive data is
+// detected. To avoid callback storms it fires at most once per (sensitive
+// pattern, log pattern hash) pair per interval; interval <= 0 fires on every
+// match. Patterns listed in alwaysNotify are never rate-limited, which suits
+// rare, high-impact findings such as private keys.
+func WithOnSensitiveMatch(cb OnSensitiveMatchCallbackF, interval time.Duration, alwaysNotify ...string) ParserOption {
+	return func(p *Parser) error {
+		p.onSensitiveMatchCb = cb
+		p.onSensitiveMatchInterval = interval
+		p.onSensitiveMatchAlways = map[string]bool{}
+		for _, name := range alwaysNotify {
+			p.onSensitiveMatchAlways[canonicalPatternName(name)] = true
+		}
+		p.onSensitiveMatchLast = map[sensitivePatternKey]time.Time{}
+		return nil
+	}
+}
+
+// WithDisabledPatterns turns off the sensitive patterns with the given names
+// (see ListPatternNames). Unknown names are reported as an error.
+func WithDisabledPatterns(names ...string) ParserOption {
+	return func(p *Parser) error {
+		if p.disabledPatterns == nil {
+			p.disabledPatterns = map[string]bool{}
+		}
+		for _, name := range names {
+			p.disabledPatterns[canonicalPatternName(name)] = true
+		}
+		return nil
+	}
+}
+
+// WithOnlyPatterns restricts sensitive detection to the patterns with the
+// given names (see ListPatternNames). Unknown names are reported as an
+// error.
+func WithOnlyPatterns(names ...string) ParserOption {
+	return func(p *Parser) error {
+		p.onlyPatterns = patternNameSet(names)
+		return nil
+	}
+}
+
+// WithMaxPatterns caps the number of pattern counters. When a new pattern
+// would exceed the cap, the pattern with the fewest messages is evicted and
+// its messages are merged into the level's catch-all counter. 0 (the
+// default) means no limit.
+func WithMaxPatterns(n int) ParserOption {
+	return func(p *Parser) error {
+		if n < 0 {
+			return fmt.Errorf("max patterns must not be negative, got %d", n)
+		}
+		p.maxPatterns = n
+		return nil
+	}
+}
+
+// WithSensitiveWorkers runs sensitive data detection in a pool of n
+// goroutines instead of the goroutine counting messages, so detection can use
+// several cores. Sensitive counters then lag slightly behind the pattern
+// counters; StopAndDrain waits for the pool to finish.
+func WithSensitiveWorkers(n int) ParserOption {
+	return func(p *Parser) error {
+		if n <= 0 {
+			return fmt.Errorf("sensitive workers must be positive, got %d", n)
+		}
+		p.sensitiveWorkers = n
+		return nil
+	}
+}
+
+// WithSensitiveDetectionBudget caps the time spent matching the sensitive
+// patterns against a message at d, 1s by default, so that a pathological
+// pattern on huge lines can't stall the pipeline. Go regexes run in linear
+// time but can't be interrupted: once the budget is spent, the patterns not
+// run yet are skipped for the message and GetStats().DetectionTimeouts is
+// incremented. 0 disables the budget.
+func WithSensitiveDetectionBudget(d time.Duration) ParserOption {
+	return func(p *Parser) error {
+		if d < 0 {
+			return fmt.Errorf("sensitive detection budget must not be negative, got %s", d)
+		}
+		p.detectionBudget = d
+		return nil
+	}
+}
+
+// WithSensitiveDetectionCache sets the number of pattern hashes whose
+// sensitive detection outcome is cached, 0 (disabled) by default. As the
+// messages of a pattern almost always have the same outcome, later messages
+// of a hash that had secrets only run the sensitive patterns that matched it
+// before, to extract their values, and skip the others. Hashes without
+// secrets aren't cached, and every 16th message of a cached hash, or one
+// without the secrets found before, runs every pattern again; a secret of
+// another kind showing up in a pattern that already matched may still be
+// missed until then. Messages inspected field by field (see
+// WithJSONFieldDetection) aren't cached.
+func WithSensitiveDetectionCache(size int) ParserOption {
+	return func(p *Parser) error {
+		if size < 0 {
+			return fmt.Errorf("sensitive detection cache size must not be negative, got %d", size)
+		}
+		p.detectionCache = nil
+		if size > 0 {
+			p.detectionCache = newDetectionCache(size)
+		}
+		return nil
+	}
+}
+
+// WithIngestBuffer makes the parser read entries from its channel into a
+// buffer of size entries, so that senders never block while the parser
+// catches up. When the buffer is full an entry is dropped according to
+// policy; see GetIngestStats for the number of dropped entries. Without this
+// option the parser reads entries one at a time and senders block.
+func WithIngestBuffer(size int, policy DropPolicy) ParserOption {
+	return func(p *Parser) error {
+		if size <= 0 {
+			return fmt.Errorf("ingest buffer size must be positive, got %d", size)
+		}
+		if policy != DropNewest && policy != DropOldest {
+			return fmt.Errorf("unknown drop policy %s", policy)
+		}
+		p.ingestBufferSize = size
+		p.ingestPolicy = policy
+		return nil
+	}
+}
+
+// WithRateWindow sets the number of one-minute buckets used to compute
+// LogCounter.RatePerMinute. The default is 60.
+func WithRateWindow(minutes int) ParserOption {
+	return func(p *Parser) error {
+		if minutes <= 0 {
+			return fmt.Errorf("rate window must be positive, got %d", minutes)
+		}
+		p.rateWindow = minutes
+		return nil
+	}
+}
+
+// WithHistogram counts the messages of each pattern over time in
+// LogCounter.Histogram, e.g. to draw sparklines, with the given number of
+// buckets. The buckets are sized from the message timestamps, so replayed
+// files give the same histograms as live ingestion.
+func WithHistogram(buckets int) ParserOption {
+	return func(p *Parser) error {
+		if buckets < 2 {
+			return fmt.Errorf("histogram needs at least 2 buckets, got %d", buckets)
+		}
+		p.histogramBuckets = buckets
+		return nil
+	}
+}
+
+// WithSpikeDetector flags the patterns whose rate spikes with d, see
+// SpikeDetector. The baseline and last spike of every pattern are reported
+// in LogCounter.SpikeBaseline and LogCounter.LastSpike.
+func WithSpikeDetector(d *SpikeDetector) ParserOption {
+	return func(p *Parser) error {
+		if d == nil {
+			return errors.New("spike detector is nil")
+		}
+		if err := d.validate(); err != nil {
+			return err
+		}
+		p.spikeDetector = d
+		return nil
+	}
+}
+
+// WithStateRedaction makes SaveState mask the secrets of the samples it
+// writes with the sensitive patterns, e.g. when the state is kept on a
+// shared volume. The samples of the parser are already masked if
+// SensitiveConfig.Redact is set.
+func WithStateRedaction(enabled bool) ParserOption {
+	return func(p *Parser) error {
+		p.redactState = enabled
+		return nil
+	}
+}
+
+// WithHashAlgorithm sets the algorithm of the pattern hashes of the
+// counters and sensitive counters, HashMD5 by default. Changing it changes
+// every hash, so data keyed by the former ones has to be rekeyed, see
+// RehashSampleWithAlgorithm.
+func WithHashAlgorithm(algorithm HashAlgorithm) ParserOption {
+	return func(p *Parser) error {
+		if !algorithm.valid() {
+			return fmt.Errorf("unknown hash algorithm %q", algorithm)
+		}
+		p.hashAlgorithm = algorithm
+		return nil
+	}
+}
+
+// WithJSONFieldDetection makes the parser decode JSON log lines and inspect
+// them field by field (see DetectSensitiveDataJSON). Fields named after one
+// of keys are reported as SensitiveJSONFieldName; with no keys,
+// DefaultSensitiveJSONKeys is used.
+func WithJSONFieldDetection(keys ...string) ParserOption {
+	return func(p *Parser) error {
+		if len(keys) == 0 {
+			keys = nil
+		}
+		p.sensitiveJSONKeys = normalizeJSONKeys(keys)
+		return nil
+	}
+}
+
+// setKeys returns the keys of set in sorted order.
+func setKeys(set map[string]bool) []string {
+	res := make([]string, 0, len(set))
+	for k := range set {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,149 @@
+// Package otel adapts a logparser.Parser to OpenTelemetry log pipelines:
+// OTelProcessor counts log records and annotates them with their pattern hash
+// and sensitive data findings. It only depends on the minimal Record
+// interface, so it can be used without the OpenTelemetry Collector modules,
+// e.g. by wrapping a plog.LogRecord.
+package otel
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nudgebee/logparser"
+)
+
+// The attributes set on the processed records.
+const (
+	// AttributePatternHash is the hash of the pattern of the record.
+	AttributePatternHash = "logparser.pattern_hash"
+	// AttributeSensitive is set to true on the records with sensitive data.
+	AttributeSensitive = "logparser.sensitive"
+	// AttributeSensitivePatterns is the sorted list of the names of the
+	// sensitive patterns matched by the record.
+	AttributeSensitivePatterns = "logparser.sensitive_patterns"
+)
+
+// Record is the part of an OTLP log record used by OTelProcessor.
+type Record interface {
+	// Body is the body of the record as a string.
+	Body() string
+	// SeverityNumber is the OTLP severity number, from 1 (TRACE) to 24
+	// (FATAL4), or 0 if unspecified.
+	SeverityNumber() int32
+	Timestamp() time.Time
+	// SetAttribute is called back with the attributes found by the
+	// processor: strings, booleans and string slices.
+	SetAttribute(key string, value any)
+}
+
+// LogRecord is a Record backed by a struct, for callers that don't have an
+// OTLP record of their own.
+type LogRecord struct {
+	Text       string
+	Severity   int32
+	Time       time.Time
+	Attributes map[string]any
+}
+
+func (r *LogRecord) Body() string          { return r.Text }
+func (r *LogRecord) SeverityNumber() int32 { return r.Severity }
+func (r *LogRecord) Timestamp() time.Time  { return r.Time }
+
+func (r *LogRecord) SetAttribute(key string, value any) {
+	if r.Attributes == nil {
+		r.Attributes = map[string]any{}
+	}
+	r.Attributes[key] = value
+}
+
+// SeverityToLevel maps an OTLP severity number to a level: TRACE and DEBUG
+// are LevelDebug, FATAL is LevelCritical and unspecified or unknown numbers
+// are LevelUnknown.
+func SeverityToLevel(n int32) logparser.Level {
+	switch {
+	case n >= 1 && n <= 8:
+		return logparser.LevelDebug
+	case n >= 9 && n <= 12:
+		return logparser.LevelInfo
+	case n >= 13 && n <= 16:
+		return logparser.LevelWarning
+	case n >= 17 && n <= 20:
+		return logparser.LevelError
+	case n >= 21 && n <= 24:
+		return logparser.LevelCritical
+	}
+	return logparser.LevelUnknown
+}
+
+// OTelProcessor counts the records of a log pipeline with a parser. Records
+// are complete messages: they aren't decoded or joined into multiline
+// messages. Records without a severity get the level detected from their
+// body, and the ones without a timestamp the current time. It is safe for concurrent use.
+type OTelProcessor struct {
+	parser *logparser.Parser
+	source string
+}
+
+// NewOTelProcessor returns a processor counting records with a parser
+// configured by opts.
+func NewOTelProcessor(opts ...logparser.ParserOption) (*OTelProcessor, error) {
+	p, err := logparser.NewParserWithOptions(make(chan logparser.LogEntry), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &OTelProcessor{parser: p}, nil
+}
+
+// WithSource returns a processor sharing the parser of p whose records are
+// counted under source, e.g. the service.name of their resource, so that
+// they are never merged with the records of other sources.
+func (p *OTelProcessor) WithSource(source string) *OTelProcessor {
+	return &OTelProcessor{parser: p.parser, source: source}
+}
+
+// Process counts rec and sets its AttributePatternHash and, if it contains
+// sensitive data, AttributeSensitive and AttributeSensitivePatterns. Info
+// and debug records only get a pattern hash with
+// logparser.WithPatternizeAllLevels.
+func (p *OTelProcessor) Process(rec Record) {
+	body := rec.Body()
+	ts := rec.Timestamp()
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	res := p.parser.CountMessage(logparser.Message{
+		Timestamp: ts,
+		Content:   body,
+		Level:     SeverityToLevel(rec.SeverityNumber()),
+		Source:    p.source,
+		Lines:     strings.Count(body, "\n") + 1,
+	})
+	if res.PatternHash != "" {
+		rec.SetAttribute(AttributePatternHash, res.PatternHash)
+	}
+	if len(res.Sensitive) == 0 {
+		return
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range res.Sensitive {
+		if !seen[m.Name] {
+			seen[m.Name] = true
+			names = append(names, m.Name)
+		}
+	}
+	sort.Strings(names)
+	rec.SetAttribute(AttributeSensitive, true)
+	rec.SetAttribute(AttributeSensitivePatterns, names)
+}
+
+// Parser returns the parser of p, e.g. to read its counters.
+func (p *OTelProcessor) Parser() *logparser.Parser {
+	return p.parser
+}
+
+// Shutdown stops the parser of p.
+func (p *OTelProcessor) Shutdown() {
+	p.parser.Stop()
+}
@@ -2,12 +2,17 @@ package logparser
 
 import (
 	"context"
+	"crypto/md5"
 	_ "embed"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,6 +22,9 @@ var sensitivePatternsJSON []byte
 var (
 	unclassifiedPatternLabel = "unclassified pattern (pattern limit reached)"
 	unclassifiedPatternHash  = "00000000000000000000000000000000"
+
+	sensitiveOverflowName = "overflow (sensitive counter limit reached)"
+	sensitiveOverflowKey  = sensitivePatternKey{name: sensitiveOverflowName, hash: unclassifiedPatternHash}
 )
 
 // Shared pattern caches: compiled once, shared across all parsers.
@@ -29,30 +37,119 @@ var (
 type LogEntry struct {
 	Timestamp time.Time
 	Content   string
-	Level     Level
+	// Level, if known, is the level of the message starting with this
+	// entry, e.g. from a journald priority or a structured level field. The
+	// level is only guessed from the content for entries of LevelUnknown.
+	Level Level
+	// Source optionally identifies where the entry came from (e.g. a
+	// container). Entries from different sources are never merged into one
+	// multiline message and are counted separately.
+	Source string
 }
 
 type LogCounter struct {
-	Level    Level
-	Hash     string
-	Sample   string
-	Messages int
+	Level Level
+	Hash  string
+	// Pattern is the template of the pattern (see PatternTemplate), rendered
+	// from Sample. It is empty for the counters that don't group messages by
+	// pattern: catch-all counters, and info and debug counters unless
+	// WithPatternizeAllLevels is set.
+	Pattern string
+	// Sample is the first message of the pattern.
+	Sample string
+	// Samples are the most recent messages of the pattern, oldest first, up
+	// to the WithSamplesPerPattern limit. With the default limit of 1, it
+	// holds Sample. It is empty if Sample is.
+	Samples []string
+	// SampleTruncated is set if the message of Sample exceeded the
+	// multiline limits (see WithMultilineLimits); Sample then ends with a
+	// marker such as "… (12 more lines)".
+	SampleTruncated bool
+	Messages        int
+	// ExceptionClass is the class of the exception of Sample if it is a
+	// Java or Python stack trace, e.g. "java.lang.NullPointerException".
+	ExceptionClass string
+	// Attributes are the structured fields extracted from Sample (see
+	// WithAttributeExtractors), e.g. "exception" or "http_status".
+	Attributes map[string]string
+	// Bytes is the total size of the counted messages.
+	Bytes  int64
+	Source string
+	// FirstSeen and LastSeen are the earliest and latest timestamps of the
+	// counted messages.
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// RatePerMinute is the average number of messages per minute over the
+	// rate window (see WithRateWindow), or over the time since the first
+	// message if shorter. Last5mCount is the number of messages in the last
+	// five minutes. Both are relative to the newest message timestamp.
+	RatePerMinute float64
+	Last5mCount   int
+	// Histogram counts the messages over time when WithHistogram is set:
+	// bucket i covers HistogramBucket from HistogramStart + i ×
+	// HistogramBucket. The buckets span the message timestamps seen, so
+	// the trailing ones may be empty.
+	Histogram       []int
+	HistogramStart  time.Time
+	HistogramBucket time.Duration
+	// HashVersion is the version of Hash, see HashVersion.
+	HashVersion int
+	// SpikeBaseline is the usual number of messages per minute of the
+	// pattern and LastSpike the timestamp of its last spike, when
+	// WithSpikeDetector is set.
+	SpikeBaseline float64
+	LastSpike     time.Time
 }
 
 type SensitiveLogCounter struct {
-	Sample   string
+	Sample string
+	// Messages is the number of messages with at least one match: a message
+	// repeating a secret, e.g. on every line of a stack trace, counts once.
 	Messages int
-	Pattern  string
+	// Occurrences is the number of matches in these messages.
+	Occurrences int
+	// UniqueValues is the number of distinct matched values, capped at
+	// maxUniqueValues.
+	UniqueValues int
+	// Pattern is the first matched value, masked.
+	Pattern string
+	// Overflow marks the bucket that absorbs findings once the parser's
+	// sensitive counter limit is reached (see WithMaxSensitiveCounters).
+	Overflow bool
 	Regex    string
 	Name     string
 	Hash     string
+	Severity string
+	Category string
+	// JSONPath is the JSON field of the first finding when JSON
+	// field-aware detection is enabled (see WithJSONFieldDetection).
+	JSONPath  string
+	Source    string
+	FirstSeen time.Time
+	LastSeen  time.Time
 }
 
 type PrecompiledPattern struct {
 	Name       string
 	Pattern    *regexp.Regexp
 	Anchors    []string // lowercased literal strings for pre-filtering
+	AllAnchors bool     // require every anchor to appear instead of any one
 	Confidence string   // "high", "medium", "low"
+	Severity   string   // "critical", "high", "medium", "low"
+	Category   string
+	Allowlist  *Allowlist
+	// Validator, if set, is run on the secret part of each match; the match
+	// is dropped when it returns false (e.g. a failed Luhn checksum).
+	Validator func(match string) bool
+	// SecretGroup is the index of the capture group holding the secret part
+	// of a match, the part that is masked. 0 means the group named "secret".
+	// The whole match is the secret when the pattern has no such group or it
+	// didn't participate in the match.
+	SecretGroup int
+
+	// prefilter is the keyword automaton shared by the pattern set this
+	// pattern was loaded with.
+	prefilter *keywordPrefilter
 }
 
 // SensitiveConfig controls sensitive data detection behavior.
@@ -70,237 +167,1156 @@ type SensitiveConfig struct {
 	// MaxDetections caps unique sensitive patterns tracked per parser.
 	// 0 means no limit.
 	MaxDetections int
+	// Redact masks detected secrets in the samples kept for LogCounter and
+	// SensitiveLogCounter, so raw secrets are never held in memory.
+	Redact bool
+	// Redaction controls the mask used when Redact is set.
+	Redaction RedactionConfig
 }
 
 type Parser struct {
-	decoder Decoder
+	decoder   Decoder
+	sanitizer *SanitizeDecoder // applied after decoder; nil if disabled
+	// timestamps sets missing entry timestamps and strips them from
+	// messages; nil if disabled.
+	timestamps *TimestampExtractor
+	// levels guesses the level of messages; nil uses GuessLevel.
+	levels *LevelDetector
+	// multiline customizes the multiline heuristics; nil uses the defaults.
+	multiline *multilineRules
 
-	patterns              map[patternKey]*patternStat
-	patternsPerLevel      map[Level]int
+	// shards hold the pattern counters, one per level, each with its own
+	// lock.
+	shards       [levelShards]patternShard
+	patternCount atomic.Int64
+	// evictionCandidates are the counters makeRoomForPattern may evict,
+	// per limit scope, guarded by evictionLock. evictionStale is set when
+	// counts are reset, for the heaps to be rebuilt.
+	evictionLock          sync.Mutex
+	evictionCandidates    map[string]*evictionHeap
+	evictionStale         atomic.Bool
 	patternsPerLevelLimit int
-	lock                  sync.RWMutex
+	// limitsPerSource applies WithMaxPatterns and WithPatternsPerLevelLimit
+	// to each Source apart, see MultiParser. sourcePatterns then holds the
+	// number of counters of each source, as *atomic.Int64.
+	limitsPerSource bool
+	sourcePatterns  sync.Map
+	// mergeSources counts the messages of all sources together, see
+	// WithMergedSources.
+	mergeSources bool
+	// patternizeAllLevels groups info, debug and unknown messages by pattern
+	// too, up to lowLevelPatternsLimit patterns per level.
+	patternizeAllLevels   bool
+	lowLevelPatternsLimit int
+	patternConfig         PatternConfig
+	// mergeThreshold is the similarity above which a new pattern is counted
+	// with an existing one; 0 merges patterns differing in one word.
+	mergeThreshold float64
+	// stackTraceFrames is the number of frames in stack trace fingerprints,
+	// 0 if disabled; frames starting with frameworkPrefixes are skipped.
+	stackTraceFrames  int
+	frameworkPrefixes []string
+	// attributes extract the Attributes of the counters.
+	attributes []AttributeExtractor
+	// samplesPerPattern is the number of recent samples kept per pattern.
+	samplesPerPattern int
+	// detectionCache caches the sensitive detection outcome per pattern
+	// hash, nil if disabled by WithSensitiveDetectionCache.
+	detectionCache *detectionCache
+	// histogramBuckets is the size of the message histograms, 0 if
+	// disabled.
+	histogramBuckets int
+	// lock guards the sensitive pattern definitions and allowlist, which
+	// can be replaced at runtime. Counting holds it for reading.
+	lock sync.RWMutex
 
 	multilineCollector *MultilineCollector
 
-	stop func()
+	ctx       context.Context
+	stop      func()
+	stopOnce  sync.Once
+	stopping  chan struct{} // closed by Stop
+	drainOnce sync.Once
+	draining  chan struct{} // closed by StopAndDrain
+	drained   chan struct{} // closed once every collected message is counted
+	done      chan struct{} // closed once the counting goroutine returns
+	// inputLock is held for reading by ProcessEntry and for writing to set
+	// inputClosed once the entries stop being read, so that no entry is
+	// added to a closed collector.
+	inputLock   sync.RWMutex
+	inputClosed bool
+
+	sensitiveWorkers int // 0 detects sensitive data in the counting goroutine
+	sensitiveJobs    chan sensitiveJob
+	sensitiveWG      sync.WaitGroup
+
+	ingestBufferSize int // 0 reads entries synchronously
+	ingestPolicy     DropPolicy
+	ingest           *ingestQueue
+	received         atomic.Uint64
+	processed        atomic.Uint64
+	dropped          atomic.Uint64
+	// maxLineSize caps the size of entries, before and after decoding.
+	maxLineSize int
+	truncated   atomic.Uint64
+
+	// detectionBudget caps the time spent on the sensitive detection of a
+	// message, 0 for no limit.
+	detectionBudget   time.Duration
+	detectionTimeouts atomic.Uint64
 
 	onMsgCb                     OnMsgCallbackF
+	onMessageCb                 OnMessageCallbackF
 	sensitivePatternDefinitions []PrecompiledPattern
 
-	sensitivePatterns map[sensitivePatternKey]*sensitivePatternStat
-	sensitiveConfig   SensitiveConfig
-	sensitiveCounter  uint64
+	// onNewPatternCb is called for the patterns of onNewPatternMinLevel
+	// and above seen for the first time once onNewPatternGrace has passed
+	// since created.
+	onNewPatternCb       NewPatternCallbackF
+	onNewPatternMinLevel Level
+	onNewPatternGrace    time.Duration
+	created              time.Time
+	spikeDetector        *SpikeDetector
+	// redactState masks the samples written by SaveState.
+	redactState   bool
+	hashAlgorithm HashAlgorithm
+
+	logger           *slog.Logger
+	multilineTimeout time.Duration
+	multilineMaxAge  time.Duration
+	// multilineMaxLines (0 for no limit) and multilineMaxBytes cap the size
+	// of messages.
+	multilineMaxLines int
+	multilineMaxBytes int
+	rateWindow        int // minutes; 0 selects defaultRateWindow
+	maxPatterns       int
+	evictions         atomic.Uint64
+	totalMessages     atomic.Uint64
+	// multilineMessages and detectionLatency feed GetMetrics.
+	multilineMessages atomic.Uint64
+	detectionLatency  latencyHistogram
+	snapshotSeq       uint64 // guarded by the shard locks
+
+	// sensitiveLock guards sensitivePatterns, sensitiveMatches,
+	// sensitiveCounter and onSensitiveMatchLast.
+	sensitiveLock      sync.Mutex
+	sensitivePatterns  map[sensitivePatternKey]*sensitivePatternStat
+	sensitiveMatches   map[string]uint64 // by pattern name; never reset
+	sensitiveConfig    SensitiveConfig
+	sensitiveCounter   uint64
+	sensitiveAllowlist *Allowlist
+	maxSensitiveStats  int
+	sensitiveJSONKeys  []string // normalized; nil disables JSON field-aware detection
+	customPatterns     bool     // sensitivePatternDefinitions set by WithSensitivePatterns
+	degradeSensitive   bool     // run without the patterns that fail to load instead of failing
+	entropyDetector    *PrecompiledPattern
+	patternPacks       []string // added by WithPatternPacks
+	onlyPatterns       map[string]bool
+	disabledPatterns   map[string]bool
+
+	onSensitiveMatchCb       OnSensitiveMatchCallbackF
+	onSensitiveMatchInterval time.Duration
+	onSensitiveMatchAlways   map[string]bool
+	onSensitiveMatchLast     map[sensitivePatternKey]time.Time
 }
 
 type OnMsgCallbackF func(ts time.Time, level Level, patternHash string, msg string)
 
-func NewParser(ch <-chan LogEntry, decoder Decoder, onMsgCallback OnMsgCallbackF, multilineCollectorTimeout time.Duration, patternsPerLevelLimit int, sensitiveCfg SensitiveConfig) *Parser {
+// OnMessageCallbackF is called for every parsed message, like OnMsgCallbackF,
+// with the assembled message including its line count and truncation flag.
+type OnMessageCallbackF func(msg Message, patternHash string)
+
+// NewPatternCallbackF is called when a pattern is seen for the first time.
+// sample is the redacted sample of its counter.
+type NewPatternCallbackF func(level Level, hash, sample string, firstSeen time.Time)
+
+// OnSensitiveMatchCallbackF is called when sensitive data is detected.
+// sample is the message with all detected secrets masked.
+type OnSensitiveMatchCallbackF func(ts time.Time, patternName string, level Level, patternHash string, redactedSample string)
+
+// NewParser creates a parser reading from ch. It is equivalent to
+// NewParserWithOptions with the decoder, callback, multiline timeout,
+// patterns-per-level limit and sensitive configuration passed as options,
+// followed by opts.
+func NewParser(ch <-chan LogEntry, decoder Decoder, onMsgCallback OnMsgCallbackF, multilineCollectorTimeout time.Duration, patternsPerLevelLimit int, sensitiveCfg SensitiveConfig, opts ...ParserOption) (*Parser, error) {
+	opts = append([]ParserOption{
+		WithDecoder(decoder),
+		WithOnMessage(onMsgCallback),
+		WithMultilineTimeout(multilineCollectorTimeout),
+		WithPatternsPerLevelLimit(patternsPerLevelLimit),
+		WithSensitiveConfig(sensitiveCfg),
+	}, opts...)
+	return NewParserWithOptions(ch, opts...)
+}
+
+func newParser() *Parser {
 	p := &Parser{
-		decoder:               decoder,
-		patterns:              map[patternKey]*patternStat{},
-		patternsPerLevel:      map[Level]int{},
-		patternsPerLevelLimit: patternsPerLevelLimit,
-		onMsgCb:               onMsgCallback,
+		patternsPerLevelLimit: defaultPatternsPerLevelLimit,
+		lowLevelPatternsLimit: defaultLowLevelPatternsLimit,
+		multilineTimeout:      defaultMultilineTimeout,
+		multilineMaxAge:       defaultMultilineMaxAge,
+		multilineMaxBytes:     multilineCollectorLimit,
+		maxLineSize:           DefaultMaxLineSize,
+		detectionBudget:       defaultDetectionBudget,
+		logger:                slog.Default(),
+		attributes:            DefaultAttributeExtractors,
+		samplesPerPattern:     1,
+		onNewPatternMinLevel:  LevelWarning,
+		onNewPatternGrace:     defaultNewPatternGracePeriod,
+		created:               time.Now(),
 		sensitivePatterns:     map[sensitivePatternKey]*sensitivePatternStat{},
-		sensitiveConfig:       sensitiveCfg,
+		sensitiveMatches:      map[string]uint64{},
+	}
+	for i := range p.shards {
+		p.shards[i].patterns = map[patternKey]*patternStat{}
+		p.shards[i].aliases = map[patternKey]patternKey{}
+		p.shards[i].classified = map[string]int{}
+		p.shards[i].digests = map[digestKey]patternKey{}
 	}
-	if sensitiveCfg.Enabled {
-		patterns, err := getOrLoadPatterns(sensitiveCfg.MinConfidence)
+	return p
+}
+
+// loadSensitivePatterns loads the embedded patterns, unless they were set by
+// WithSensitivePatterns, and applies the pattern packs, the entropy detector
+// and pattern filters. If filtering fails the patterns are left unfiltered.
+func (p *Parser) loadSensitivePatterns() error {
+	base := p.sensitivePatternDefinitions
+	if !p.customPatterns && (p.sensitiveConfig.Enabled || p.sensitiveConfig.Redact) {
+		patterns, err := getOrLoadPatterns(p.sensitiveConfig.MinConfidence, p.logger)
 		if err != nil {
-			log.Printf("Error loading sensitive patterns: %v", err)
+			return err
 		}
-		p.sensitivePatternDefinitions = patterns
+		base = patterns
+	}
+	defs, err := p.buildSensitivePatterns(base, p.customPatterns)
+	if err != nil {
+		return err
+	}
+	p.sensitivePatternDefinitions = defs
+	if len(p.onlyPatterns) == 0 && len(p.disabledPatterns) == 0 {
+		return nil
+	}
+	defs, err = filterPatternsByName(p.sensitivePatternDefinitions, setKeys(p.onlyPatterns), setKeys(p.disabledPatterns), p.logger)
+	if err != nil {
+		p.onlyPatterns, p.disabledPatterns = nil, nil
+		return err
+	}
+	p.sensitivePatternDefinitions = defs
+	return nil
+}
+
+// buildSensitivePatterns returns base with the detectors every pattern set
+// of the parser gets: the private key detector if base is custom and lacks
+// it, the pattern packs and the entropy detector. base is returned as is if
+// there is nothing to add, so that parsers share the embedded patterns.
+func (p *Parser) buildSensitivePatterns(base []PrecompiledPattern, custom bool) ([]PrecompiledPattern, error) {
+	var extra []PrecompiledPattern
+	if custom && !slices.ContainsFunc(base, func(pp PrecompiledPattern) bool { return canonicalPatternName(pp.Name) == PrivateKeyDetectorName }) {
+		extra = append(extra, NewPrivateKeyDetector())
+	}
+	for _, name := range p.patternPacks {
+		patterns, err := loadPatternPack(name, p.logger)
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, patterns...)
+	}
+	if p.entropyDetector != nil {
+		extra = append(extra, *p.entropyDetector)
+	}
+	if len(extra) == 0 {
+		return base, nil
+	}
+	defs := append(append([]PrecompiledPattern(nil), base...), extra...)
+	attachPrefilter(defs)
+	return defs, nil
+}
+
+// start launches the goroutines reading entries from ch and counting the
+// collected messages. They run until ctx is canceled, Stop or StopAndDrain
+// is called, or ch is closed and drained.
+func (p *Parser) start(ctx context.Context, ch <-chan LogEntry) {
+	ctx, stop := context.WithCancel(ctx)
+	p.ctx, p.stop = ctx, stop
+	p.stopping = make(chan struct{})
+	p.draining = make(chan struct{})
+	p.drained = make(chan struct{})
+	p.done = make(chan struct{})
+	p.multilineCollector = newMultilineCollector(ctx, p.multilineTimeout, p.multilineMaxBytes, p.multilineMaxAge)
+	p.multilineCollector.maxLines = p.multilineMaxLines
+	p.multilineCollector.keepCut = p.sensitiveConfig.Enabled
+	p.multilineCollector.levels = p.levels
+	p.multilineCollector.rules = p.multiline
+	if _, ok := p.decoder.(FlushingDecoder); ok {
+		go p.flushStaleEntries(ctx)
+	}
+	if p.ingestBufferSize > 0 {
+		p.ingest = newIngestQueue(p.ingestBufferSize, p.ingestPolicy)
+		go p.processQueue(ctx)
 	}
-	ctx, stop := context.WithCancel(context.Background())
-	p.stop = stop
-	p.multilineCollector = NewMultilineCollector(ctx, multilineCollectorTimeout, multilineCollectorLimit)
 	go func() {
-		var err error
+		defer func() {
+			p.inputLock.Lock()
+			p.inputClosed = true
+			p.inputLock.Unlock()
+			if p.ingest != nil {
+				p.ingest.close()
+			} else {
+				p.closeCollector()
+			}
+		}()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case entry := <-ch:
-				if p.decoder != nil {
-					if entry.Content, err = p.decoder.Decode(entry.Content); err != nil {
-						continue
+			case <-p.stopping:
+				return
+			case <-p.draining:
+				// Take the entries already sent, but don't wait for more.
+				for {
+					select {
+					case entry, ok := <-ch:
+						if !ok {
+							return
+						}
+						p.receive(entry)
+					default:
+						return
 					}
 				}
-				p.multilineCollector.Add(entry)
+			case entry, ok := <-ch:
+				if !ok {
+					return
+				}
+				p.receive(entry)
 			}
 		}
 	}()
 
+	if p.sensitiveWorkers > 0 {
+		p.sensitiveJobs = make(chan sensitiveJob, p.sensitiveWorkers*sensitiveJobsPerWorker)
+		p.sensitiveWG.Add(p.sensitiveWorkers)
+		for i := 0; i < p.sensitiveWorkers; i++ {
+			go p.runSensitiveWorker(ctx)
+		}
+	}
+
 	go func() {
+		defer close(p.done)
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case msg := <-p.multilineCollector.Messages:
+			case msg, ok := <-p.multilineCollector.Messages:
+				if !ok {
+					if p.sensitiveJobs != nil {
+						close(p.sensitiveJobs)
+						p.sensitiveWG.Wait()
+					}
+					close(p.drained)
+					// Nothing is left to count, e.g. the channel was
+					// closed: release the other goroutines.
+					stop()
+					return
+				}
 				p.inc(msg)
 			}
 		}
 	}()
+}
 
-	return p
+// receive handles an entry read from the channel: it is queued if an ingest
+// buffer is configured and processed right away otherwise.
+func (p *Parser) receive(entry LogEntry) {
+	p.received.Add(1)
+	p.limitLine(&entry)
+	if p.ingest == nil {
+		p.add(entry)
+		return
+	}
+	if p.ingest.push(entry) {
+		p.dropped.Add(1)
+	}
+}
+
+// processQueue processes the entries in the ingest buffer until it is closed
+// and empty, then closes the multiline collector.
+func (p *Parser) processQueue(ctx context.Context) {
+	defer p.closeCollector()
+	for {
+		entry, ok, closed := p.ingest.pop()
+		if ok {
+			p.add(entry)
+			continue
+		}
+		if closed {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.ingest.notify:
+		}
+	}
+}
+
+// add decodes entry and passes it to the multiline collector.
+func (p *Parser) add(entry LogEntry) {
+	defer p.processed.Add(1)
+	if p.decoder != nil {
+		var ok bool
+		var err error
+		if entry, ok, err = DecodeEntry(p.decoder, entry); err != nil || !ok {
+			return
+		}
+	}
+	p.collect(entry)
+}
+
+// collect passes a decoded entry to the multiline collector.
+func (p *Parser) collect(entry LogEntry) {
+	p.limitLine(&entry)
+	if p.sanitizer != nil {
+		entry, _, _ = p.sanitizer.DecodeEntry(entry)
+	}
+	if p.timestamps != nil && entry.Timestamp.IsZero() {
+		if ts, _, ok := p.timestamps.Extract(entry.Content); ok {
+			entry.Timestamp = ts
+		}
+	}
+	p.multilineCollector.Add(entry)
+}
+
+// limitLine truncates the content of entry to the max line size.
+func (p *Parser) limitLine(entry *LogEntry) {
+	var truncated bool
+	if entry.Content, truncated = truncateLine(entry.Content, p.maxLineSize); truncated {
+		p.truncated.Add(1)
+	}
+}
+
+// flushDecoder collects the entries held back by the decoder since before
+// before, such as partial lines whose last part never arrived.
+func (p *Parser) flushDecoder(before time.Time) {
+	for _, entry := range FlushEntries(p.decoder, before) {
+		p.collect(entry)
+	}
+}
+
+// flushStaleEntries flushes the entries held back by the decoder for longer
+// than the multiline timeout, until ctx is canceled.
+func (p *Parser) flushStaleEntries(ctx context.Context) {
+	ticker := time.NewTicker(p.multilineTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			p.flushDecoder(t.Add(-p.multilineTimeout))
+		}
+	}
+}
+
+// closeCollector flushes the entries held back by the decoder and closes
+// the multiline collector, once no more entries will be added.
+func (p *Parser) closeCollector() {
+	p.flushDecoder(time.Now())
+	p.multilineCollector.Close()
 }
 
+// Stop stops reading entries and flushes the multiline collector, so that
+// the messages being collected, e.g. the last error of a quiet source, are
+// counted, waiting for them at most stopFlushTimeout, then stops the parser.
+// Entries still in the channel are discarded; use StopAndDrain to count
+// them.
 func (p *Parser) Stop() {
-	p.stop()
+	p.stopOnce.Do(func() { close(p.stopping) })
+	defer p.stop()
+	t := time.NewTimer(stopFlushTimeout)
+	defer t.Stop()
+	select {
+	case <-p.drained:
+	case <-p.ctx.Done():
+	case <-t.C:
+	}
 }
 
-func (p *Parser) inc(msg Message) {
+// StopAndDrain stops reading new entries, flushes the multiline collector and
+// waits until every pending message is counted, including by the sensitive
+// detection workers, then stops the parser. Only
+// entries already sent on the channel are processed. If draining takes longer
+// than timeout, the parser is stopped anyway and an error is returned.
+func (p *Parser) StopAndDrain(timeout time.Duration) error {
+	p.drainOnce.Do(func() { close(p.draining) })
+	defer p.stop()
+	select {
+	case <-p.drained:
+		return nil
+	default:
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-p.drained:
+		return nil
+	case <-p.ctx.Done():
+		select {
+		case <-p.drained:
+			// The parser stops itself once drained.
+			return nil
+		default:
+		}
+		return errors.New("parser stopped before it was drained")
+	case <-t.C:
+		return fmt.Errorf("parser not drained after %s", timeout)
+	}
+}
+
+// Done returns a channel closed once the parser has stopped: after Stop,
+// StopAndDrain or the cancellation of its context, or once the entry
+// channel is closed and every entry sent on it is counted. The counters
+// remain readable.
+func (p *Parser) Done() <-chan struct{} {
+	return p.done
+}
+
+// ReloadSensitivePatterns atomically replaces the sensitive pattern
+// definitions used for detection and redaction, as WithSensitivePatterns
+// does: the private key detector, the pattern packs and the entropy detector
+// are kept, and patterns turned off by WithDisabledPatterns or
+// WithOnlyPatterns stay off. Counters collected so far are preserved.
+func (p *Parser) ReloadSensitivePatterns(patterns []PrecompiledPattern) error {
+	for i := range patterns {
+		if patterns[i].Pattern == nil {
+			return fmt.Errorf("sensitive pattern %q has no regex", patterns[i].Name)
+		}
+	}
+	defs, err := p.buildSensitivePatterns(patterns, true)
+	if err != nil {
+		return err
+	}
 	p.lock.Lock()
 	defer p.lock.Unlock()
+	p.sensitivePatternDefinitions = filterPatterns(defs, p.onlyPatterns, p.disabledPatterns)
+	if p.detectionCache != nil {
+		p.detectionCache.reset()
+	}
+	return nil
+}
 
-	if msg.Level == LevelUnknown || msg.Level == LevelDebug || msg.Level == LevelInfo {
-		key := patternKey{level: msg.Level, hash: ""}
-		if stat := p.patterns[key]; stat == nil {
-			p.patterns[key] = &patternStat{}
-		}
-		p.patterns[key].messages++
-		if p.onMsgCb != nil {
-			p.onMsgCb(msg.Timestamp, msg.Level, "", msg.Content)
+// AddSensitiveAllowlist adds entries to the parser-wide sensitive allowlist
+// at runtime. See WithSensitiveAllowlist for the entry format.
+func (p *Parser) AddSensitiveAllowlist(entries ...string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.addSensitiveAllowlist(entries...)
+}
+
+func (p *Parser) addSensitiveAllowlist(entries ...string) error {
+	a := &Allowlist{}
+	if p.sensitiveAllowlist != nil {
+		*a = *p.sensitiveAllowlist
+	}
+	if err := a.add(entries...); err != nil {
+		return err
+	}
+	p.sensitiveAllowlist = a
+	return nil
+}
+
+func (p *Parser) inc(msg Message) {
+	if p.mergeSources {
+		msg.Source = ""
+	}
+	if p.timestamps != nil {
+		msg.Content = p.timestamps.Strip(msg.Content)
+	}
+	notify, patterns, job, events := p.count(msg, nil)
+	p.notifyPatternEvents(events)
+	if job != nil {
+		select {
+		case p.sensitiveJobs <- *job:
+		case <-p.ctx.Done():
 		}
-		pattern := NewPattern(msg.Content)
-		p.processSensitivePattern(msg, pattern)
 		return
 	}
+	p.notifySensitiveMatches(msg, notify, patterns)
+}
 
-	pattern := NewPattern(msg.Content)
-	stat, key := p.getPatternStat(msg.Level, pattern, msg.Content)
-	if p.onMsgCb != nil {
-		p.onMsgCb(msg.Timestamp, msg.Level, key.hash, msg.Content)
+// notifySensitiveMatches calls the sensitive match callback for matches.
+// Callbacks run outside the lock and get a redacted sample, so they may
+// block or call back into the parser.
+func (p *Parser) notifySensitiveMatches(msg Message, matches []SensitivePatternMatch, patterns []PrecompiledPattern) {
+	if len(matches) == 0 {
+		return
 	}
-	stat.messages++
-	p.processSensitivePattern(msg, pattern)
+	p.lock.RLock()
+	allowlist := p.sensitiveAllowlist
+	p.lock.RUnlock()
+	sample := redactJSONLine(msg.Content, patterns, p.sensitiveJSONKeys, allowlist, p.sensitiveConfig.Redaction)
+	for _, m := range matches {
+		p.onSensitiveMatchCb(msg.Timestamp, m.Name, msg.Level, m.hash, sample)
+	}
+}
+
+// messagePattern returns the pattern of msg, its stack trace fingerprint if
+// enabled by WithStackTraceFingerprint, hashed with the algorithm of
+// WithHashAlgorithm.
+func (p *Parser) messagePattern(msg Message) *Pattern {
+	var pattern *Pattern
+	if p.stackTraceFrames > 0 && msg.Lines > 1 {
+		if class, frames, ok := parseStackTrace(msg.Content); ok {
+			pattern = stackTracePattern(class, frames, p.stackTraceFrames, p.frameworkPrefixes)
+		}
+	}
+	if pattern == nil {
+		pattern = NewPatternWithConfig(msg.Content, p.patternConfig)
+	}
+	pattern.algorithm = p.hashAlgorithm
+	return pattern
+}
+
+// lazyPattern derives the pattern of a message only when it is needed: the
+// messages of existing patterns are counted from the digest of their
+// pattern, which doesn't allocate.
+type lazyPattern struct {
+	parser   *Parser
+	msg      Message
+	pattern  *Pattern
+	digest   [md5.Size]byte
+	digested bool
+}
+
+func (l *lazyPattern) get() *Pattern {
+	if l.pattern == nil {
+		l.pattern = l.parser.messagePattern(l.msg)
+	}
+	return l.pattern
+}
+
+// digestKey returns the key of the message in patternShard.digests. ok is
+// false for stack trace fingerprints, which have no digest.
+func (l *lazyPattern) digestKey() (key digestKey, ok bool) {
+	if l.parser.stackTraceFrames > 0 && l.msg.Lines > 1 {
+		return key, false
+	}
+	if !l.digested {
+		l.digest, l.digested = patternDigest(l.msg.Content, l.parser.patternConfig), true
+	}
+	return digestKey{source: l.msg.Source, level: l.msg.Level, digest: l.digest}, true
+}
+
+// count updates the counters for msg. It returns the sensitive matches that
+// should be reported to the sensitive match callback, together with the
+// pattern set they were detected with. With sensitive workers, detection is
+// left to them and count returns the job to hand over instead, unless res is
+// set: the pattern hash and every sensitive match of msg are then stored in
+// res. The new pattern and spike callbacks due are returned as well.
+func (p *Parser) count(msg Message, res *MessageResult) ([]SensitivePatternMatch, []PrecompiledPattern, *sensitiveJob, patternEvents) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	lp := lazyPattern{parser: p, msg: msg}
+	var events patternEvents
+	for full := false; ; {
+		hash, ev, ok := p.countPattern(msg, &lp, full)
+		if ok {
+			events = ev
+			if res != nil {
+				res.PatternHash = hash
+			}
+			break
+		}
+		full = !p.makeRoomForPattern(msg.Source)
+	}
+	p.totalMessages.Add(1)
+	if msg.Lines > 1 {
+		p.multilineMessages.Add(1)
+	}
+
+	p.sensitiveLock.Lock()
+	detect := p.shouldDetectSensitive()
+	p.sensitiveLock.Unlock()
+	if !detect {
+		return nil, nil, nil, events
+	}
+	pattern := lp.get()
+	job := sensitiveJob{msg: msg, pattern: pattern, patterns: p.sensitivePatternDefinitions, allowlist: p.sensitiveAllowlist, cache: p.detectionCache}
+	if p.sensitiveJobs != nil && res == nil {
+		return nil, nil, &job, events
+	}
+	matches := p.detect(job)
+	if res != nil {
+		res.Sensitive = matches
+	}
+	p.sensitiveLock.Lock()
+	defer p.sensitiveLock.Unlock()
+	return p.recordSensitiveMatches(msg, pattern, matches), job.patterns, nil, events
 }
 
-func (p *Parser) processSensitivePattern(msg Message, pattern *Pattern) {
+// shouldDetectSensitive applies sensitive detection sampling and the
+// detection cap to the next message. The caller must hold sensitiveLock.
+func (p *Parser) shouldDetectSensitive() bool {
 	if !p.sensitiveConfig.Enabled {
-		return
+		return false
 	}
 
 	// Sampling: only check 1-in-N lines.
 	p.sensitiveCounter++
 	if p.sensitiveConfig.SampleRate > 1 && p.sensitiveCounter%uint64(p.sensitiveConfig.SampleRate) != 0 {
-		return
+		return false
 	}
 
 	// Detection cap: stop scanning once we've tracked enough unique patterns.
 	if p.sensitiveConfig.MaxDetections > 0 && len(p.sensitivePatterns) >= p.sensitiveConfig.MaxDetections {
-		return
+		return false
 	}
+	return true
+}
 
-	matches := DetectSensitiveData(msg.Content, pattern.Hash(), p.sensitivePatternDefinitions)
-	for _, match := range matches {
-		sKey := match.sensitivePatternKey
-		stat := p.sensitivePatterns[sKey]
-		if stat == nil {
-			for k, ps := range p.sensitivePatterns {
-				if k.pattern == sKey.pattern && ps.pattern.WeakEqual(pattern) {
-					stat = ps
-					break
-				}
-			}
-			if stat == nil {
-				stat = &sensitivePatternStat{pattern: pattern, sample: msg.Content, sensitiveKey: sKey.pattern, regex: match.regex, name: match.name, hash: sKey.hash}
-				p.sensitivePatterns[sKey] = stat
-			}
+// recordSensitiveMatches updates the sensitive counters with the matches
+// found in msg. It returns the matches due for a callback. The caller must
+// hold sensitiveLock and the read lock.
+func (p *Parser) recordSensitiveMatches(msg Message, pattern *Pattern, matches []SensitivePatternMatch) []SensitivePatternMatch {
+	var notify []SensitivePatternMatch
+	for _, occurrences := range groupSensitiveMatches(matches, msg.Source) {
+		match := occurrences[0]
+		p.sensitiveMatches[match.Name]++
+		p.addSensitiveMatch(msg, pattern, occurrences)
+		if p.shouldNotifySensitiveMatch(match) {
+			notify = append(notify, match)
+		}
+	}
+	return notify
+}
+
+// groupSensitiveMatches groups the matches found in a message of source by
+// pattern name, in the order of their first match.
+func groupSensitiveMatches(matches []SensitivePatternMatch, source string) [][]SensitivePatternMatch {
+	var groups [][]SensitivePatternMatch
+	for _, m := range matches {
+		m.sensitivePatternKey.source = source
+		i := 0
+		for i < len(groups) && groups[i][0].Name != m.Name {
+			i++
+		}
+		if i == len(groups) {
+			groups = append(groups, nil)
 		}
-		stat.messages++
+		groups[i] = append(groups[i], m)
 	}
+	return groups
 }
 
-func (p *Parser) getPatternStat(level Level, pattern *Pattern, sample string) (*patternStat, patternKey) {
-	key := patternKey{level: level, hash: pattern.Hash()}
-	if stat := p.patterns[key]; stat != nil {
-		return stat, key
+// shouldNotifySensitiveMatch rate-limits the sensitive match callback to
+// one call per (pattern name, log pattern hash) per interval.
+func (p *Parser) shouldNotifySensitiveMatch(match SensitivePatternMatch) bool {
+	if p.onSensitiveMatchCb == nil {
+		return false
+	}
+	if p.onSensitiveMatchInterval <= 0 || p.onSensitiveMatchAlways[match.Name] {
+		return true
+	}
+	now := time.Now()
+	if last, ok := p.onSensitiveMatchLast[match.sensitivePatternKey]; ok && now.Sub(last) < p.onSensitiveMatchInterval {
+		return false
 	}
-	for k, ps := range p.patterns {
-		if k.level != level || ps.pattern == nil {
+	if len(p.onSensitiveMatchLast) >= maxSensitiveMatchNotifications {
+		p.expireSensitiveMatchNotifications(now)
+	}
+	p.onSensitiveMatchLast[match.sensitivePatternKey] = now
+	return true
+}
+
+// maxSensitiveMatchNotifications caps the number of keys whose last
+// sensitive match callback is remembered for rate limiting.
+const maxSensitiveMatchNotifications = 4096
+
+// expireSensitiveMatchNotifications forgets the callbacks older than the
+// interval, which no longer rate-limit anything, or the oldest one if none
+// is. The caller must hold sensitiveLock.
+func (p *Parser) expireSensitiveMatchNotifications(now time.Time) {
+	var oldest sensitivePatternKey
+	var oldestAt time.Time
+	expired := false
+	for k, last := range p.onSensitiveMatchLast {
+		if now.Sub(last) >= p.onSensitiveMatchInterval {
+			delete(p.onSensitiveMatchLast, k)
+			expired = true
 			continue
 		}
-		if ps.pattern.WeakEqual(pattern) {
-			return ps, k
+		if oldestAt.IsZero() || last.Before(oldestAt) {
+			oldest, oldestAt = k, last
 		}
 	}
+	if !expired {
+		delete(p.onSensitiveMatchLast, oldest)
+	}
+}
 
-	if p.patternsPerLevel[level] >= p.patternsPerLevelLimit {
-		fallbackKey := patternKey{level: level, hash: unclassifiedPatternHash}
-		stat := p.patterns[fallbackKey]
-		if stat == nil {
-			stat = &patternStat{sample: unclassifiedPatternLabel}
-			p.patterns[fallbackKey] = stat
+// addSensitiveMatch counts msg in the sensitive counter of the pattern of
+// occurrences, its matches of one sensitive pattern. The first one sets the
+// counter key and sample.
+func (p *Parser) addSensitiveMatch(msg Message, pattern *Pattern, occurrences []SensitivePatternMatch) {
+	match := occurrences[0]
+	sKey := match.sensitivePatternKey
+	stat := p.sensitivePatterns[sKey]
+	if stat == nil {
+		// As for the pattern counters, the most similar pattern wins.
+		var best sensitivePatternKey
+		bestSimilarity := -1.0
+		for k, ps := range p.sensitivePatterns {
+			if k.source != sKey.source || k.name != sKey.name || ps.pattern == nil || !ps.pattern.weakEqual(pattern, p.mergeThreshold) {
+				continue
+			}
+			if sim := ps.pattern.Similarity(pattern); sim > bestSimilarity || sim == bestSimilarity && k.hash < best.hash {
+				best, bestSimilarity = k, sim
+			}
+		}
+		if bestSimilarity >= 0 {
+			stat = p.sensitivePatterns[best]
+		}
+	}
+	if stat == nil {
+		if p.sensitiveConfig.MaxDetections > 0 && len(p.sensitivePatterns) >= p.sensitiveConfig.MaxDetections {
+			return
+		}
+		if p.maxSensitiveStats > 0 && len(p.sensitivePatterns) >= p.maxSensitiveStats {
+			stat = p.sensitivePatterns[sensitiveOverflowKey]
+			if stat == nil {
+				stat = &sensitivePatternStat{name: sensitiveOverflowName, hash: unclassifiedPatternHash, category: uncategorizedPatternCategory, overflow: true}
+				p.sensitivePatterns[sensitiveOverflowKey] = stat
+			}
+			stat.messages++
+			stat.occurrences += len(occurrences)
+			stat.seen.add(msg.Timestamp)
+			return
 		}
-		return stat, fallbackKey
+		sample := p.redact(msg.Content)
+		stat = &sensitivePatternStat{pattern: pattern, sample: sample, jsonPath: match.JSONPath, sensitiveKey: match.MaskedValue(p.sensitiveConfig.Redaction), regex: match.regex, name: match.Name, hash: sKey.hash, source: sKey.source, severity: match.Severity, category: match.Category}
+		p.sensitivePatterns[sKey] = stat
 	}
+	stat.messages++
+	stat.occurrences += len(occurrences)
+	stat.seen.add(msg.Timestamp)
+	for _, o := range occurrences {
+		stat.values.add(o.Value)
+	}
+}
 
-	stat := &patternStat{pattern: pattern, sample: sample}
-	p.patterns[key] = stat
-	p.patternsPerLevel[level]++
-	return stat, key
+// redact masks secrets in a sample before it is stored, if enabled,
+// including the ones in the decoded values of JSON samples. Allowlisted
+// values are kept. The caller must hold the read lock.
+func (p *Parser) redact(sample string) string {
+	if !p.sensitiveConfig.Redact {
+		return sample
+	}
+	return redactJSONLine(sample, p.sensitivePatternDefinitions, p.sensitiveJSONKeys, p.sensitiveAllowlist, p.sensitiveConfig.Redaction)
 }
 
 func (p *Parser) GetCounters() []LogCounter {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	res := make([]LogCounter, 0, len(p.patterns))
-	for k, ps := range p.patterns {
-		res = append(res, LogCounter{Level: k.level, Hash: k.hash, Sample: ps.sample, Messages: ps.messages})
+	return p.GetCountersSince(time.Time{})
+}
+
+// GetCountersSince returns the counters whose last message is not older
+// than since. A zero since returns all counters. Shards are read one at a
+// time, so counting is never blocked for the whole call.
+func (p *Parser) GetCountersSince(since time.Time) []LogCounter {
+	res := make([]LogCounter, 0, p.patternCount.Load())
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.lock.Lock()
+		res = shardCounters(res, s, since)
+		s.lock.Unlock()
+	}
+	return res
+}
+
+// counters builds the counter list; the caller must hold all shard locks.
+func (p *Parser) counters(since time.Time) []LogCounter {
+	res := make([]LogCounter, 0, p.patternCount.Load())
+	for i := range p.shards {
+		res = shardCounters(res, &p.shards[i], since)
+	}
+	return res
+}
+
+// GetCountersBySource returns the counters grouped by LogEntry.Source.
+// Entries without a source are grouped under "".
+func (p *Parser) GetCountersBySource() map[string][]LogCounter {
+	res := map[string][]LogCounter{}
+	for _, c := range p.GetCounters() {
+		res[c.Source] = append(res[c.Source], c)
 	}
 	return res
 }
 
 func (p *Parser) GetSensitiveCounters() []SensitiveLogCounter {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
+	return p.GetSensitiveCountersMinSeverity("")
+}
+
+// GetSensitiveCountersMinSeverity returns the sensitive counters whose
+// severity is at least minSeverity ("critical", "high", "medium" or "low").
+// An empty minSeverity returns all counters.
+func (p *Parser) GetSensitiveCountersMinSeverity(minSeverity string) []SensitiveLogCounter {
+	minLevel := 0
+	if minSeverity != "" {
+		minLevel = severityLevel(minSeverity)
+	}
+	p.sensitiveLock.Lock()
+	defer p.sensitiveLock.Unlock()
+	return p.sensitiveCounters(minLevel)
+}
+
+// sensitiveCounters builds the sensitive counter list; the caller must hold
+// sensitiveLock.
+func (p *Parser) sensitiveCounters(minLevel int) []SensitiveLogCounter {
 	res := make([]SensitiveLogCounter, 0, len(p.sensitivePatterns))
-	for k, ps := range p.sensitivePatterns {
-		res = append(res, SensitiveLogCounter{Pattern: k.pattern, Messages: ps.messages, Sample: ps.sample, Regex: ps.regex, Name: ps.name, Hash: ps.hash})
+	for _, ps := range p.sensitivePatterns {
+		if !ps.overflow && severityLevel(ps.severity) < minLevel {
+			continue
+		}
+		res = append(res, SensitiveLogCounter{Pattern: ps.sensitiveKey, Messages: ps.messages, Occurrences: ps.occurrences, UniqueValues: ps.values.count(), Sample: ps.sample, Regex: ps.regex, Name: ps.name, Hash: ps.hash, Severity: ps.severity, Category: ps.category, JSONPath: ps.jsonPath, Source: ps.source, FirstSeen: ps.seen.first, LastSeen: ps.seen.last, Overflow: ps.overflow})
+	}
+	return res
+}
+
+// GetCountersAndReset returns the counters and zeroes their message counts,
+// atomically with respect to incoming messages: a message is counted in
+// exactly one interval. Patterns and samples are kept, so hashes stay stable
+// across intervals.
+func (p *Parser) GetCountersAndReset() []LogCounter {
+	res := make([]LogCounter, 0, p.patternCount.Load())
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.lock.Lock()
+		res = shardCounters(res, s, time.Time{})
+		p.resetShard(s)
+		s.lock.Unlock()
+	}
+	return res
+}
+
+// GetSensitiveCountersAndReset is GetCountersAndReset for sensitive counters.
+// Unique value counts are reset along with message counts.
+func (p *Parser) GetSensitiveCountersAndReset() []SensitiveLogCounter {
+	p.sensitiveLock.Lock()
+	defer p.sensitiveLock.Unlock()
+	res := p.sensitiveCounters(0)
+	p.resetSensitiveCounters()
+	return res
+}
+
+// LevelStats is the volume of messages of one level.
+type LevelStats struct {
+	Messages int
+	Bytes    int64
+}
+
+// GetLevelStats returns the number and total size of the counted messages
+// per level.
+func (p *Parser) GetLevelStats() map[Level]LevelStats {
+	res := map[Level]LevelStats{}
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.lock.Lock()
+		for k, ps := range s.patterns {
+			st := res[k.level]
+			st.Messages += ps.messages
+			st.Bytes += ps.bytes
+			res[k.level] = st
+		}
+		s.lock.Unlock()
+	}
+	return res
+}
+
+// ParserStats describes the internal state of a parser.
+type ParserStats struct {
+	// Patterns is the current number of pattern counters, including
+	// catch-all counters.
+	Patterns int
+	// Evictions is the number of patterns evicted by the WithMaxPatterns
+	// limit.
+	Evictions uint64
+	// Messages is the total number of messages counted.
+	Messages uint64
+	// DetectionTimeouts is the number of messages whose sensitive detection
+	// skipped patterns because it ran out of time (see
+	// WithSensitiveDetectionBudget).
+	DetectionTimeouts uint64
+}
+
+// GetStats returns the parser statistics.
+func (p *Parser) GetStats() ParserStats {
+	return ParserStats{Patterns: int(p.patternCount.Load()), Evictions: p.evictions.Load(), Messages: p.totalMessages.Load(), DetectionTimeouts: p.detectionTimeouts.Load()}
+}
+
+// Snapshot is a consistent view of all counters of a parser.
+type Snapshot struct {
+	// Seq increases by one with every Snapshot call, starting at 1.
+	Seq               uint64
+	Counters          []LogCounter
+	SensitiveCounters []SensitiveLogCounter
+}
+
+// Snapshot returns the counters and sensitive counters as of the same
+// moment.
+func (p *Parser) Snapshot() Snapshot {
+	p.lockShards()
+	defer p.unlockShards()
+	p.sensitiveLock.Lock()
+	defer p.sensitiveLock.Unlock()
+	p.snapshotSeq++
+	return Snapshot{Seq: p.snapshotSeq, Counters: p.counters(time.Time{}), SensitiveCounters: p.sensitiveCounters(0)}
+}
+
+func (p *Parser) resetSensitiveCounters() {
+	for _, ps := range p.sensitivePatterns {
+		ps.messages = 0
+		ps.occurrences = 0
+		ps.values = uniqueValues{}
+	}
+}
+
+// GetSensitiveCountersByCategory returns the number of messages with
+// sensitive findings per pattern category, e.g. {"credentials": 12, "pii": 4}.
+// A message with findings of several patterns is counted once per pattern.
+func (p *Parser) GetSensitiveCountersByCategory() map[string]int {
+	p.sensitiveLock.Lock()
+	defer p.sensitiveLock.Unlock()
+	res := map[string]int{}
+	for _, ps := range p.sensitivePatterns {
+		res[ps.category] += ps.messages
+	}
+	return res
+}
+
+// GetSensitiveCountersBySource returns the sensitive counters grouped by
+// LogEntry.Source. The overflow counter is shared by all sources and is
+// grouped under "".
+func (p *Parser) GetSensitiveCountersBySource() map[string][]SensitiveLogCounter {
+	res := map[string][]SensitiveLogCounter{}
+	for _, c := range p.GetSensitiveCounters() {
+		res[c.Source] = append(res[c.Source], c)
 	}
 	return res
 }
 
 type patternKey struct {
-	level Level
-	hash  string
+	source string
+	level  Level
+	hash   string
 }
 
 type patternStat struct {
-	pattern  *Pattern
-	sample   string
-	messages int
+	pattern         *Pattern
+	template        string
+	sample          string
+	sampleTruncated bool
+	exceptionClass  string
+	attributes      map[string]string
+	// recent is a ring of the most recent samples, recentNext the index of
+	// the oldest one once it is full.
+	recent     []string
+	recentNext int
+	messages   int
+	bytes      int64
+	seen       seenRange
+	rate       rateWindow
+	histogram  timeHistogram
+	spike      spikeState
+	// aliases are the keys merged into this pattern by WeakEqual.
+	aliases []patternKey
+	// digests are the keys of patternShard.digests pointing to this
+	// pattern.
+	digests []digestKey
 }
 
 type sensitivePatternStat struct {
 	pattern      *Pattern
 	sample       string
 	messages     int
-	sensitiveKey string
+	occurrences  int
+	values       uniqueValues
+	sensitiveKey string // masked matched value of the first occurrence
 	regex        string
 	name         string
 	hash         string
+	severity     string
+	category     string
+	jsonPath     string
+	source       string
+	seen         seenRange
+	overflow     bool
 }
 
+// sensitivePatternKey aggregates findings by source, sensitive pattern name
+// and log pattern hash, so distinct secrets of the same kind share one
+// counter.
 type sensitivePatternKey struct {
-	pattern string
-	hash    string
+	source string
+	name   string
+	hash   string
 }
 
 type SensitivePattern struct {
 	Name       string `json:"name"`
 	Pattern    string `json:"pattern"`
 	Confidence string `json:"confidence,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	Category   string `json:"category,omitempty"`
+	// Keywords are used for pre-filtering: at least one of them (or all of
+	// them, with AllKeywords) must appear in a line, case-insensitively, for
+	// the regex to run. Without keywords, anchors are extracted from the regex.
+	Keywords    []string         `json:"keywords,omitempty"`
+	AllKeywords bool             `json:"all_keywords,omitempty"`
+	Allowlist   *AllowlistConfig `json:"allowlist,omitempty"`
+	Validator   string           `json:"validator,omitempty"`
+	// SecretGroup is the index of the capture group holding the secret, for
+	// patterns that also match its context, e.g. 2 for "(pass|pwd)=(\S+)";
+	// see PrecompiledPattern.SecretGroup. A group named "secret", as in
+	// "pass=(?P<secret>\S+)", does the same without counting groups.
+	SecretGroup int `json:"secret_group,omitempty"`
 }
 
+// SensitivePatternMatch describes one finding of a sensitive pattern in a line.
 type SensitivePatternMatch struct {
+	// Name is the name of the pattern that matched.
+	Name string
+	// Severity is the severity of the pattern that matched.
+	Severity string
+	// Category is the category of the pattern that matched, such as
+	// "credentials" or "pii".
+	Category string
+	// Start and End are the byte offsets of the match within the line.
+	Start, End int
+	// SecretStart and SecretEnd are the byte offsets of the secret part of
+	// the match (see PrecompiledPattern.SecretGroup), the region masked by
+	// redaction. They are Start and End when the whole match is the secret.
+	SecretStart, SecretEnd int
+	// Value is the matched substring, line[Start:End]. It contains the raw
+	// secret; use MaskedValue before logging or storing it.
+	Value string
+	// JSONPath is the path of the JSON field the match was found in (e.g.
+	// "$.auth.token"), set only by JSON field-aware detection.
+	JSONPath string
+
 	sensitivePatternKey sensitivePatternKey
 	regex               string
-	name                string
 	hash                string
 }
 
+// MaskedValue returns Value with the secret part masked according to cfg.
+func (m SensitivePatternMatch) MaskedValue(cfg RedactionConfig) string {
+	s, e := m.SecretStart-m.Start, m.SecretEnd-m.Start
+	if s < 0 || e > len(m.Value) || s >= e {
+		return maskSecret(m.Value, m.Name, cfg)
+	}
+	return m.Value[:s] + maskSecret(m.Value[s:e], m.Name, cfg) + m.Value[e:]
+}
+
 // confidenceLevel returns a numeric level for sorting: high=3, medium=2, low=1.
 func confidenceLevel(c string) int {
 	switch c {
@@ -315,52 +1331,204 @@ func confidenceLevel(c string) int {
 	}
 }
 
+// severityLevel returns a numeric level for sorting: critical=4, high=3,
+// medium=2, low=1.
+func severityLevel(s string) int {
+	switch s {
+	case "critical":
+		return 4
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	default:
+		return 2 // default to medium
+	}
+}
+
 // DetectSensitiveData scans a log line against precompiled patterns using
 // anchor-based pre-filtering to skip patterns that can't possibly match.
+// It stops at the first matching pattern; use DetectAllSensitiveData to get
+// every pattern that matches the line.
 func DetectSensitiveData(line string, hash string, precompiledPatterns []PrecompiledPattern) []SensitivePatternMatch {
-	var matches []SensitivePatternMatch
+	return detectSensitiveData(line, hash, precompiledPatterns, detectFirst, nil, nil)
+}
+
+// DetectAllSensitiveData is like DetectSensitiveData but reports one match for
+// every pattern that matches the line, so a line carrying both an AWS key and
+// a GitHub token yields two matches.
+func DetectAllSensitiveData(line string, hash string, precompiledPatterns []PrecompiledPattern) []SensitivePatternMatch {
+	return detectSensitiveData(line, hash, precompiledPatterns, detectPerPattern, nil, nil)
+}
+
+// FindAllSensitiveData reports every occurrence of every pattern in the line,
+// ordered by pattern and then by position.
+func FindAllSensitiveData(line string, hash string, precompiledPatterns []PrecompiledPattern) []SensitivePatternMatch {
+	return detectSensitiveData(line, hash, precompiledPatterns, detectAll, nil, nil)
+}
+
+type detectMode int
+
+const (
+	detectFirst      detectMode = iota // first occurrence of the first matching pattern
+	detectPerPattern                   // first occurrence of each matching pattern
+	detectAll                          // every occurrence of each matching pattern
+)
+
+// detectSensitiveData implements the Detect*/Find* functions. Matches allowed
+// by the pattern's own allowlist or by the global allowlist are dropped. The
+// patterns not run yet once budget is spent are skipped.
+func detectSensitiveData(line string, hash string, precompiledPatterns []PrecompiledPattern, mode detectMode, global *Allowlist, budget *detectionBudget) []SensitivePatternMatch {
 	lowerLine := strings.ToLower(line)
 
+	// Pre-filter: a pattern's regex only runs if its anchors appear in the
+	// line. Pattern sets built by LoadPatterns share a keyword automaton that
+	// finds all anchors in a single pass over the line.
+	var candidates []bool
+	if len(precompiledPatterns) > 0 && precompiledPatterns[0].prefilter.usableFor(precompiledPatterns) {
+		candidates = precompiledPatterns[0].prefilter.candidates(lowerLine, precompiledPatterns)
+	}
+	return detectCandidates(line, lowerLine, hash, precompiledPatterns, candidates, mode, global, budget)
+}
+
+// detectMatchedBefore is detectSensitiveData in detectAll mode, only running
+// the patterns set in matched (see detectionCache).
+func detectMatchedBefore(line string, hash string, precompiledPatterns []PrecompiledPattern, matched []bool, global *Allowlist, budget *detectionBudget) []SensitivePatternMatch {
+	for _, m := range matched {
+		if m {
+			return detectCandidates(line, strings.ToLower(line), hash, precompiledPatterns, matched, detectAll, global, budget)
+		}
+	}
+	return nil
+}
+
+// detectCandidates runs the patterns accepted by the pre-filter, the ones
+// set in candidates if not nil.
+func detectCandidates(line, lowerLine string, hash string, precompiledPatterns []PrecompiledPattern, candidates []bool, mode detectMode, global *Allowlist, budget *detectionBudget) []SensitivePatternMatch {
+	var matches []SensitivePatternMatch
+
 	for i := range precompiledPatterns {
 		p := &precompiledPatterns[i]
 
-		// Pre-filter: if the pattern has anchors, at least one must appear in the line.
-		if len(p.Anchors) > 0 && !anchorMatchesLine(lowerLine, p.Anchors) {
+		if candidates != nil {
+			if !candidates[i] {
+				continue
+			}
+		} else if !p.anchorsMatch(lowerLine) {
 			continue
 		}
+		if budget.spent() {
+			break
+		}
 
-		if p.Pattern.MatchString(line) {
-			sensitivePart := p.Pattern.FindString(line)
-
-			// Post-match validation for low-confidence patterns:
-			// reject matches where the captured value doesn't look like a real secret
-			// (e.g., SQL table names, cache keys, enum values).
-			if p.Confidence == "low" && !looksLikeSecret(sensitivePart) {
+		found := false
+		for _, idx := range p.Pattern.FindAllStringSubmatchIndex(line, -1) {
+			if idx[0] == idx[1] || !p.acceptMatch(line, idx, global) {
 				continue
 			}
-
+			sensitivePart := line[idx[0]:idx[1]]
+			secretStart, secretEnd := p.secretBounds(idx)
 			key := sensitivePatternKey{
-				pattern: sensitivePart,
-				hash:    hash,
+				name: p.Name,
+				hash: hash,
 			}
-			matches = append(matches, SensitivePatternMatch{name: p.Name, sensitivePatternKey: key, regex: p.Pattern.String(), hash: hash})
+			matches = append(matches, SensitivePatternMatch{
+				Name:                p.Name,
+				Severity:            p.Severity,
+				Category:            p.Category,
+				Start:               idx[0],
+				End:                 idx[1],
+				SecretStart:         secretStart,
+				SecretEnd:           secretEnd,
+				Value:               sensitivePart,
+				sensitivePatternKey: key,
+				regex:               p.Pattern.String(),
+				hash:                hash,
+			})
+			found = true
+			if mode != detectAll {
+				break
+			}
+		}
+		if found && mode == detectFirst {
 			break
 		}
 	}
 	return matches
 }
 
+// anchorsMatch reports whether the pattern's pre-filter accepts the
+// lowercased line. Patterns without anchors always pass.
+func (p *PrecompiledPattern) anchorsMatch(lowerLine string) bool {
+	if len(p.Anchors) == 0 {
+		return true
+	}
+	if p.AllAnchors {
+		return allAnchorsMatchLine(lowerLine, p.Anchors)
+	}
+	return anchorMatchesLine(lowerLine, p.Anchors)
+}
+
+// secretBounds returns the byte range of the secret within a match returned by
+// FindStringSubmatchIndex: its SecretGroup if it participated in the match,
+// otherwise the whole match.
+func (p *PrecompiledPattern) secretBounds(idx []int) (int, int) {
+	if g := p.secretGroup(); 2*g+1 < len(idx) && idx[2*g] >= 0 && idx[2*g+1] > idx[2*g] {
+		return idx[2*g], idx[2*g+1]
+	}
+	return idx[0], idx[1]
+}
+
+// secretGroup resolves SecretGroup to the index of a capture group, 0 (the
+// whole match) when neither SecretGroup nor a "secret" group is set: the
+// first group of many patterns captures only a part of the secret, e.g. the
+// algorithm of a JWT.
+func (p *PrecompiledPattern) secretGroup() int {
+	if p.SecretGroup > 0 {
+		return p.SecretGroup
+	}
+	if g := p.Pattern.SubexpIndex("secret"); g > 0 {
+		return g
+	}
+	return 0
+}
+
+// acceptMatch applies the post-match checks shared by detection and redaction:
+// the low-confidence heuristic, the pattern's validator and the allowlists.
+func (p *PrecompiledPattern) acceptMatch(line string, idx []int, global *Allowlist) bool {
+	match := line[idx[0]:idx[1]]
+	start, end := p.secretBounds(idx)
+	secret := line[start:end]
+
+	// Post-match validation for low-confidence patterns:
+	// reject matches where the captured value doesn't look like a real secret
+	// (e.g., SQL table names, cache keys, enum values).
+	if p.Confidence == "low" && !looksLikeSecret(match) {
+		return false
+	}
+	if p.Validator != nil && !p.Validator(secret) {
+		return false
+	}
+	if p.Allowlist.Allowed(secret, match) || global.Allowed(secret, match) {
+		return false
+	}
+	return true
+}
+
 // getOrLoadPatterns returns a shared, cached pattern set for the given
 // confidence level. Compiled regexes are loaded once and reused across all
 // parsers — avoids duplicating ~2 MB of compiled regex state per container.
-func getOrLoadPatterns(minConfidence string) ([]PrecompiledPattern, error) {
+// Invalid patterns are logged to logger when the set is first loaded.
+func getOrLoadPatterns(minConfidence string, logger *slog.Logger) ([]PrecompiledPattern, error) {
 	patternCacheMu.Lock()
 	defer patternCacheMu.Unlock()
 
 	if cached, ok := patternCache[minConfidence]; ok {
 		return cached, nil
 	}
-	patterns, err := LoadPatterns(minConfidence)
+	patterns, err := loadPatterns(minConfidence, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -369,10 +1537,46 @@ func getOrLoadPatterns(minConfidence string) ([]PrecompiledPattern, error) {
 }
 
 // LoadPatterns loads and compiles sensitive data patterns, filtering by
-// minimum confidence level. Patterns below minConfidence are excluded.
+// minimum confidence level. Patterns below minConfidence are excluded. The
+// private key detector (see NewPrivateKeyDetector) is always included.
+// Invalid patterns are skipped and logged to slog.Default().
 func LoadPatterns(minConfidence string) ([]PrecompiledPattern, error) {
+	return loadPatterns(minConfidence, slog.Default())
+}
+
+// loadPatterns is LoadPatterns, logging invalid patterns to logger.
+func loadPatterns(minConfidence string, logger *slog.Logger) ([]PrecompiledPattern, error) {
+	patterns, err := compilePatterns(sensitivePatternsJSON, minConfidence, false, logger)
+	if err != nil {
+		return nil, err
+	}
+	patterns = append(patterns, NewPrivateKeyDetector())
+	attachPrefilter(patterns)
+	return patterns, nil
+}
+
+// ParsePatterns compiles sensitive patterns from JSON in the same format as
+// the embedded sensitive_patterns.json. Unlike LoadPatterns it fails on the
+// first invalid pattern, so callers can validate a definition file before
+// using it. As the patterns may come from users, each one must also be at
+// most MaxSensitivePatternLength bytes long and either have keywords or a
+// literal of at least 3 characters to pre-filter lines on, such as the
+// "ghp_" of "ghp_[0-9a-zA-Z]{36}", so that it doesn't run on every line.
+func ParsePatterns(data []byte, minConfidence string) ([]PrecompiledPattern, error) {
+	return compilePatterns(data, minConfidence, true, nil)
+}
+
+// MaxSensitivePatternLength is the maximum length of the regex of the
+// patterns compiled by ParsePatterns.
+const MaxSensitivePatternLength = 1024
+
+// compilePatterns compiles the patterns in data that meet minConfidence.
+// Invalid patterns are logged to logger and skipped, unless they are
+// external, i.e. user-supplied: then they are returned as an error, and the
+// patterns must also pass the checks of ParsePatterns.
+func compilePatterns(data []byte, minConfidence string, external bool, logger *slog.Logger) ([]PrecompiledPattern, error) {
 	var patterns []SensitivePattern
-	err := json.Unmarshal(sensitivePatternsJSON, &patterns)
+	err := json.Unmarshal(data, &patterns)
 	if err != nil {
 		return nil, err
 	}
@@ -388,18 +1592,79 @@ func LoadPatterns(minConfidence string) ([]PrecompiledPattern, error) {
 		if confidenceLevel(confidence) < minLevel {
 			continue
 		}
-
-		re, err := regexp.Compile(pattern.Pattern)
+		pp, err := compilePattern(pattern, confidence)
+		if err == nil && external {
+			err = checkExternalPattern(pattern, pp)
+		}
 		if err != nil {
-			log.Printf("Error compiling pattern '%s': %v", pattern.Name, err)
+			if external {
+				return nil, err
+			}
+			logger.Warn("skipping sensitive pattern", "pattern", pattern.Name, "error", err)
 			continue
 		}
-		precompiled = append(precompiled, PrecompiledPattern{
-			Name:       pattern.Name,
-			Pattern:    re,
-			Anchors:    extractAnchors(pattern.Pattern),
-			Confidence: confidence,
-		})
+		precompiled = append(precompiled, pp)
 	}
+	attachPrefilter(precompiled)
 	return precompiled, nil
 }
+
+// checkExternalPattern checks the limits of ParsePatterns on pattern,
+// compiled as pp.
+func checkExternalPattern(pattern SensitivePattern, pp PrecompiledPattern) error {
+	if len(pattern.Pattern) > MaxSensitivePatternLength {
+		return fmt.Errorf("pattern %q is %d bytes long, more than %d", pattern.Name, len(pattern.Pattern), MaxSensitivePatternLength)
+	}
+	if len(pp.Anchors) == 0 {
+		return fmt.Errorf("pattern %q has no literal of at least 3 characters to pre-filter lines on, set its keywords", pattern.Name)
+	}
+	return nil
+}
+
+func compilePattern(pattern SensitivePattern, confidence string) (PrecompiledPattern, error) {
+	severity := pattern.Severity
+	if severity == "" {
+		severity = "medium"
+	}
+	re, err := regexp.Compile(pattern.Pattern)
+	if err != nil {
+		return PrecompiledPattern{}, fmt.Errorf("pattern %q: %w", pattern.Name, err)
+	}
+	allowlist, err := pattern.Allowlist.compile()
+	if err != nil {
+		return PrecompiledPattern{}, fmt.Errorf("allowlist of pattern %q: %w", pattern.Name, err)
+	}
+	category := pattern.Category
+	if category == "" {
+		category = uncategorizedPatternCategory
+	}
+	if pattern.SecretGroup < 0 || pattern.SecretGroup > re.NumSubexp() {
+		return PrecompiledPattern{}, fmt.Errorf("pattern %q has no capture group %d", pattern.Name, pattern.SecretGroup)
+	}
+	var validator func(string) bool
+	if pattern.Validator != "" {
+		if validator = Validators[pattern.Validator]; validator == nil {
+			return PrecompiledPattern{}, fmt.Errorf("unknown validator %q for pattern %q", pattern.Validator, pattern.Name)
+		}
+	}
+	anchors := extractAnchors(pattern.Pattern)
+	if len(pattern.Keywords) > 0 {
+		anchors = make([]string, 0, len(pattern.Keywords))
+		for _, k := range pattern.Keywords {
+			anchors = append(anchors, strings.ToLower(k))
+		}
+		anchors = dedupStrings(anchors)
+	}
+	return PrecompiledPattern{
+		Name:        pattern.Name,
+		Pattern:     re,
+		Anchors:     anchors,
+		AllAnchors:  pattern.AllKeywords && len(pattern.Keywords) > 0,
+		Confidence:  confidence,
+		Severity:    severity,
+		Category:    category,
+		Allowlist:   allowlist,
+		Validator:   validator,
+		SecretGroup: pattern.SecretGroup,
+	}, nil
+}
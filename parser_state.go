@@ -0,0 +1,417 @@
+package logparser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ParserStateVersion is the version of the state written by
+// Parser.SaveState. Parser.LoadState rejects states of any other version.
+const ParserStateVersion = 1
+
+// ErrParserStateVersion is wrapped by the ParserStateError of a state of
+// another version than ParserStateVersion.
+var ErrParserStateVersion = errors.New("unsupported parser state version")
+
+// ParserStateError is returned by Parser.LoadState for a state that can't be
+// restored, because it is corrupt or of another version.
+type ParserStateError struct {
+	// Version is the version of the state, 0 if it couldn't be decoded.
+	Version int
+	Err     error
+}
+
+func (e *ParserStateError) Error() string {
+	return "invalid parser state: " + e.Err.Error()
+}
+
+func (e *ParserStateError) Unwrap() error {
+	return e.Err
+}
+
+// parserState is the JSON document written by SaveState.
+type parserState struct {
+	Version           int                   `json:"version"`
+	HashVersion       int                   `json:"hash_version"`
+	HashAlgorithm     HashAlgorithm         `json:"hash_algorithm,omitempty"`
+	Messages          uint64                `json:"messages"`
+	MultilineMessages uint64                `json:"multiline_messages,omitempty"`
+	Evictions         uint64                `json:"evictions,omitempty"`
+	LevelMessages     map[Level]uint64      `json:"level_messages,omitempty"`
+	Patterns          []patternStateEntry   `json:"patterns"`
+	Known             []patternStateKey     `json:"known,omitempty"`
+	Sensitive         []sensitiveStateEntry `json:"sensitive"`
+	SensitiveMatches  map[string]uint64     `json:"sensitive_matches,omitempty"`
+}
+
+// patternWords is a saved Pattern; a counter without one, such as a
+// catch-all counter, has none.
+type patternWords struct {
+	Words []string `json:"words"`
+	Exact bool     `json:"exact,omitempty"`
+}
+
+type patternStateKey struct {
+	Source string `json:"source,omitempty"`
+	Level  Level  `json:"level"`
+	Hash   string `json:"hash"`
+}
+
+type patternStateEntry struct {
+	patternStateKey
+	Pattern         *patternWords     `json:"pattern,omitempty"`
+	Template        string            `json:"template,omitempty"`
+	Sample          string            `json:"sample"`
+	SampleTruncated bool              `json:"sample_truncated,omitempty"`
+	ExceptionClass  string            `json:"exception_class,omitempty"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+	Recent          []string          `json:"recent,omitempty"`
+	RecentNext      int               `json:"recent_next,omitempty"`
+	Messages        int               `json:"messages"`
+	Bytes           int64             `json:"bytes"`
+	FirstSeen       time.Time         `json:"first_seen"`
+	LastSeen        time.Time         `json:"last_seen"`
+	// Aliases are the hashes of the patterns merged into this one.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+type sensitiveStateEntry struct {
+	Source      string        `json:"source,omitempty"`
+	Name        string        `json:"name"`
+	Hash        string        `json:"hash"`
+	Pattern     *patternWords `json:"pattern,omitempty"`
+	Sample      string        `json:"sample"`
+	Messages    int           `json:"messages"`
+	Occurrences int           `json:"occurrences,omitempty"`
+	Values      []uint64      `json:"values,omitempty"`
+	MaskedValue string        `json:"masked_value,omitempty"`
+	Regex       string        `json:"regex,omitempty"`
+	Severity    string        `json:"severity,omitempty"`
+	Category    string        `json:"category,omitempty"`
+	JSONPath    string        `json:"json_path,omitempty"`
+	FirstSeen   time.Time     `json:"first_seen"`
+	LastSeen    time.Time     `json:"last_seen"`
+	Overflow    bool          `json:"overflow,omitempty"`
+}
+
+// SaveState writes the pattern counters, with their patterns and samples,
+// and the sensitive counters to w as JSON, so that LoadState can resume
+// from them after a restart. Samples are masked with the sensitive patterns
+// if WithStateRedaction is set. Rates, histograms and spike baselines aren't
+// saved.
+func (p *Parser) SaveState(w io.Writer) error {
+	var redactor exportRedactor
+	if p.redactState {
+		var err error
+		if redactor, err = p.exportRedactor(); err != nil {
+			return err
+		}
+	}
+	redact := func(sample string) string {
+		if !p.redactState || sample == unclassifiedPatternLabel {
+			return sample
+		}
+		return redactor.redact(sample)
+	}
+	state := p.state(redact)
+	state.Version = ParserStateVersion
+	return json.NewEncoder(w).Encode(state)
+}
+
+func (p *Parser) state(redact func(string) string) *parserState {
+	p.lockShards()
+	defer p.unlockShards()
+	p.sensitiveLock.Lock()
+	defer p.sensitiveLock.Unlock()
+
+	state := &parserState{
+		HashVersion:       HashVersion,
+		HashAlgorithm:     p.hashAlgorithm,
+		Messages:          p.totalMessages.Load(),
+		MultilineMessages: p.multilineMessages.Load(),
+		Evictions:         p.evictions.Load(),
+		LevelMessages:     map[Level]uint64{},
+		Patterns:          make([]patternStateEntry, 0, p.patternCount.Load()),
+		Sensitive:         make([]sensitiveStateEntry, 0, len(p.sensitivePatterns)),
+		SensitiveMatches:  map[string]uint64{},
+	}
+	for i := range p.shards {
+		s := &p.shards[i]
+		if s.messages > 0 {
+			state.LevelMessages[Level(i)] = s.messages
+		}
+		for k, ps := range s.patterns {
+			e := patternStateEntry{
+				patternStateKey: patternStateKey{Source: k.source, Level: k.level, Hash: k.hash},
+				Template:        ps.template,
+				Sample:          redact(ps.sample),
+				SampleTruncated: ps.sampleTruncated,
+				ExceptionClass:  ps.exceptionClass,
+				Attributes:      ps.attributes,
+				RecentNext:      ps.recentNext,
+				Messages:        ps.messages,
+				Bytes:           ps.bytes,
+				FirstSeen:       ps.seen.first,
+				LastSeen:        ps.seen.last,
+			}
+			for _, r := range ps.recent {
+				e.Recent = append(e.Recent, redact(r))
+			}
+			if p.redactState && ps.template != "" {
+				e.Template = PatternTemplate(e.Sample)
+			}
+			e.Pattern = p.statePattern(ps.pattern, ps.sample, e.Sample)
+			for _, a := range ps.aliases {
+				e.Aliases = append(e.Aliases, a.hash)
+			}
+			state.Patterns = append(state.Patterns, e)
+		}
+		for k := range s.known {
+			state.Known = append(state.Known, patternStateKey{Source: k.source, Level: k.level, Hash: k.hash})
+		}
+	}
+	for k, ps := range p.sensitivePatterns {
+		e := sensitiveStateEntry{
+			Source:      k.source,
+			Name:        k.name,
+			Hash:        k.hash,
+			Sample:      redact(ps.sample),
+			Messages:    ps.messages,
+			Occurrences: ps.occurrences,
+			MaskedValue: ps.sensitiveKey,
+			Regex:       ps.regex,
+			Severity:    ps.severity,
+			Category:    ps.category,
+			JSONPath:    ps.jsonPath,
+			FirstSeen:   ps.seen.first,
+			LastSeen:    ps.seen.last,
+			Overflow:    ps.overflow,
+		}
+		e.Pattern = p.statePattern(ps.pattern, ps.sample, e.Sample)
+		for v := range ps.values.seen {
+			e.Values = append(e.Values, v)
+		}
+		sort.Slice(e.Values, func(i, j int) bool { return e.Values[i] < e.Values[j] })
+		state.Sensitive = append(state.Sensitive, e)
+	}
+	for name, n := range p.sensitiveMatches {
+		state.SensitiveMatches[name] = n
+	}
+
+	sort.Slice(state.Patterns, func(i, j int) bool {
+		return state.Patterns[i].patternStateKey.less(state.Patterns[j].patternStateKey)
+	})
+	sort.Slice(state.Known, func(i, j int) bool {
+		return state.Known[i].less(state.Known[j])
+	})
+	sort.Slice(state.Sensitive, func(i, j int) bool {
+		a, b := state.Sensitive[i], state.Sensitive[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Hash < b.Hash
+	})
+	return state
+}
+
+// statePattern returns the saved form of pattern, the pattern of sample. If
+// sample was redacted to saved, the words of the redacted sample are saved
+// instead, unless pattern is a stack trace fingerprint, which has no
+// message content.
+func (p *Parser) statePattern(pattern *Pattern, sample, saved string) *patternWords {
+	if pattern == nil {
+		return nil
+	}
+	if saved != sample && !pattern.exact {
+		pattern = NewPatternWithConfig(saved, p.patternConfig)
+	}
+	return &patternWords{Words: pattern.words, Exact: pattern.exact}
+}
+
+func (k patternStateKey) less(o patternStateKey) bool {
+	if k.Level != o.Level {
+		return k.Level < o.Level
+	}
+	if k.Source != o.Source {
+		return k.Source < o.Source
+	}
+	return k.Hash < o.Hash
+}
+
+// LoadState restores the counters saved by SaveState, so that the messages
+// counted afterwards add up to the restored counts and their patterns aren't
+// reported as new by the new pattern callback. The state must have the
+// hashes of the parser, see WithHashAlgorithm. It must be called before the
+// parser counts any message, and at most once; it fails otherwise. A corrupt
+// state or a state of another version is reported as a *ParserStateError.
+// On error the parser is left unchanged.
+func (p *Parser) LoadState(r io.Reader) error {
+	var state parserState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return &ParserStateError{Err: err}
+	}
+	if state.Version != ParserStateVersion {
+		return &ParserStateError{Version: state.Version, Err: fmt.Errorf("%w %d, want %d", ErrParserStateVersion, state.Version, ParserStateVersion)}
+	}
+	if err := state.validate(); err != nil {
+		return &ParserStateError{Version: state.Version, Err: err}
+	}
+	if state.HashVersion != HashVersion || state.HashAlgorithm != p.hashAlgorithm {
+		return &ParserStateError{Version: state.Version, Err: fmt.Errorf("hashes of version %d and algorithm %q, want %d and %q", state.HashVersion, state.HashAlgorithm, HashVersion, p.hashAlgorithm)}
+	}
+
+	p.lockShards()
+	defer p.unlockShards()
+	p.sensitiveLock.Lock()
+	defer p.sensitiveLock.Unlock()
+	if p.totalMessages.Load() > 0 || p.patternCount.Load() > 0 || len(p.sensitivePatterns) > 0 {
+		return errors.New("parser state must be loaded before any message is counted")
+	}
+
+	for _, e := range state.Patterns {
+		s := p.shard(e.Level)
+		k := patternKey{source: e.Source, level: e.Level, hash: e.Hash}
+		ps := &patternStat{
+			pattern:         e.Pattern.pattern(p.hashAlgorithm),
+			template:        e.Template,
+			sample:          e.Sample,
+			sampleTruncated: e.SampleTruncated,
+			exceptionClass:  e.ExceptionClass,
+			attributes:      e.Attributes,
+			recent:          e.Recent,
+			recentNext:      e.RecentNext,
+			messages:        e.Messages,
+			bytes:           e.Bytes,
+			seen:            seenRange{first: e.FirstSeen, last: e.LastSeen},
+		}
+		for _, hash := range e.Aliases {
+			alias := patternKey{source: e.Source, level: e.Level, hash: hash}
+			s.aliases[alias] = k
+			ps.aliases = append(ps.aliases, alias)
+			p.knowPattern(s, alias)
+		}
+		p.newPatternStat(s, k, ps)
+		if ps.pattern != nil {
+			s.classified[p.limitScope(k.source)]++
+		}
+		p.knowPattern(s, k)
+	}
+	for _, k := range state.Known {
+		p.knowPattern(p.shard(k.Level), patternKey{source: k.Source, level: k.Level, hash: k.Hash})
+	}
+	for level, n := range state.LevelMessages {
+		p.shard(level).messages = n
+	}
+	for _, e := range state.Sensitive {
+		ps := &sensitivePatternStat{
+			pattern:      e.Pattern.pattern(p.hashAlgorithm),
+			sample:       e.Sample,
+			messages:     e.Messages,
+			occurrences:  max(e.Occurrences, e.Messages), // 0 in states saved before they were tracked
+			sensitiveKey: e.MaskedValue,
+			regex:        e.Regex,
+			name:         e.Name,
+			hash:         e.Hash,
+			severity:     e.Severity,
+			category:     e.Category,
+			jsonPath:     e.JSONPath,
+			source:       e.Source,
+			seen:         seenRange{first: e.FirstSeen, last: e.LastSeen},
+			overflow:     e.Overflow,
+		}
+		for _, v := range e.Values {
+			if ps.values.seen == nil {
+				ps.values.seen = map[uint64]struct{}{}
+			}
+			ps.values.seen[v] = struct{}{}
+		}
+		p.sensitivePatterns[sensitivePatternKey{source: e.Source, name: e.Name, hash: e.Hash}] = ps
+	}
+	for name, n := range state.SensitiveMatches {
+		p.sensitiveMatches[name] = n
+	}
+	p.totalMessages.Store(state.Messages)
+	p.multilineMessages.Store(state.MultilineMessages)
+	p.evictions.Store(state.Evictions)
+	return nil
+}
+
+// knowPattern records k as seen for the new pattern callback. The caller
+// must hold the shard lock.
+func (p *Parser) knowPattern(s *patternShard, k patternKey) {
+	if p.onNewPatternCb == nil {
+		return
+	}
+	if s.known == nil {
+		s.known = map[patternKey]struct{}{}
+	}
+	s.known[k] = struct{}{}
+}
+
+func (w *patternWords) pattern(algorithm HashAlgorithm) *Pattern {
+	if w == nil {
+		return nil
+	}
+	return &Pattern{words: w.Words, exact: w.Exact, algorithm: algorithm}
+}
+
+// validate checks the consistency of a decoded state, so that a corrupt one
+// is rejected before it replaces any counter.
+func (s *parserState) validate() error {
+	validLevel := func(l Level) bool {
+		return l >= LevelUnknown && l <= LevelDebug
+	}
+	for l := range s.LevelMessages {
+		if !validLevel(l) {
+			return fmt.Errorf("invalid level %d", l)
+		}
+	}
+	keys := map[patternKey]bool{}
+	for _, e := range s.Patterns {
+		k := patternKey{source: e.Source, level: e.Level, hash: e.Hash}
+		switch {
+		case !validLevel(e.Level):
+			return fmt.Errorf("pattern %s: invalid level %d", e.Hash, e.Level)
+		case keys[k]:
+			return fmt.Errorf("duplicate pattern %s", e.Hash)
+		case e.Messages < 0 || e.Bytes < 0:
+			return fmt.Errorf("pattern %s: negative count", e.Hash)
+		case len(e.Recent) > 0 && (e.RecentNext < 0 || e.RecentNext >= len(e.Recent)):
+			return fmt.Errorf("pattern %s: invalid recent sample index %d", e.Hash, e.RecentNext)
+		}
+		keys[k] = true
+	}
+	for _, e := range s.Patterns {
+		for _, hash := range e.Aliases {
+			if keys[patternKey{source: e.Source, level: e.Level, hash: hash}] {
+				return fmt.Errorf("pattern %s: alias %s is a pattern", e.Hash, hash)
+			}
+		}
+	}
+	for _, k := range s.Known {
+		if !validLevel(k.Level) {
+			return fmt.Errorf("known pattern %s: invalid level %d", k.Hash, k.Level)
+		}
+	}
+	sensitive := map[sensitivePatternKey]bool{}
+	for _, e := range s.Sensitive {
+		k := sensitivePatternKey{source: e.Source, name: e.Name, hash: e.Hash}
+		switch {
+		case e.Name == "":
+			return fmt.Errorf("sensitive counter %s has no name", e.Hash)
+		case sensitive[k]:
+			return fmt.Errorf("duplicate sensitive counter %s %s", e.Name, e.Hash)
+		case e.Messages < 0 || e.Occurrences < 0:
+			return fmt.Errorf("sensitive counter %s %s: negative count", e.Name, e.Hash)
+		}
+		sensitive[k] = true
+	}
+	return nil
+}
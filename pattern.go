@@ -9,6 +9,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"unicode"
 )
 
 const (
@@ -23,8 +24,20 @@ var (
 			return new(bytes.Buffer)
 		},
 	}
+	scratches = sync.Pool{
+		New: func() interface{} {
+			return new(patternScratch)
+		},
+	}
 )
 
+// patternScratch holds the buffers used to derive a pattern, so that
+// patternDigest doesn't allocate.
+type patternScratch struct {
+	buf   bytes.Buffer
+	words []byte
+}
+
 var (
 	squote  = '\''
 	dquote  = '"'
@@ -45,6 +58,11 @@ type Pattern struct {
 	words []string
 	str   *string
 	hash  *string
+	// exact patterns, such as stack trace fingerprints, only match
+	// themselves.
+	exact bool
+	// algorithm is the algorithm of Hash, HashMD5 if empty.
+	algorithm HashAlgorithm
 }
 
 func (p *Pattern) String() string {
@@ -66,57 +84,278 @@ func (p *Pattern) String() string {
 
 func (p *Pattern) Hash() string {
 	if p.hash == nil {
-		h := fmt.Sprintf("%x", md5.Sum([]byte(p.String())))
+		h := p.algorithm.sum(p.String())
 		p.hash = &h
 	}
 	return *p.hash
 }
 
+// hashString returns the hexadecimal form of a pattern digest, its Hash.
+func hashString(digest [md5.Size]byte) string {
+	const digits = "0123456789abcdef"
+	var res [2 * md5.Size]byte
+	for i, b := range digest {
+		res[2*i], res[2*i+1] = digits[b>>4], digits[b&0xf]
+	}
+	return string(res[:])
+}
+
+// WeakEqual reports whether p and other have the same number of words and
+// differ in at most one of them. A typed placeholder (see
+// PatternConfig.TypedVariables) only matches the same placeholder.
 func (p *Pattern) WeakEqual(other *Pattern) bool {
-	if len(p.words) != len(other.words) {
+	return p.weakEqual(other, 0)
+}
+
+// Similarity returns the share of positions, out of the longer pattern,
+// holding the same word in both patterns, in [0, 1]. Two empty patterns are
+// identical.
+func (p *Pattern) Similarity(other *Pattern) float64 {
+	n, m := len(p.words), len(other.words)
+	if n < m {
+		n, m = m, n
+	}
+	if n == 0 {
+		return 1
+	}
+	same := 0
+	for i := 0; i < m; i++ {
+		if p.words[i] == other.words[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(n)
+}
+
+// weakEqual is WeakEqual with the patterns at least threshold similar
+// instead, if threshold is positive.
+func (p *Pattern) weakEqual(other *Pattern, threshold float64) bool {
+	if p.exact || other.exact {
+		return false
+	}
+	if threshold <= 0 && len(p.words) != len(other.words) {
 		return false
 	}
 	var diffs int
-	for i := range other.words {
+	for i := 0; i < len(p.words) && i < len(other.words); i++ {
 		if p.words[i] != other.words[i] {
+			// A typed placeholder only matches the same type.
+			if isTypedPlaceholder(p.words[i]) || isTypedPlaceholder(other.words[i]) {
+				return false
+			}
 			diffs++
-			if diffs > patternMaxDiff {
+			if threshold <= 0 && diffs > patternMaxDiff {
 				return false
 			}
 		}
 	}
-	return true
+	return threshold <= 0 || p.Similarity(other) >= threshold
 }
 
+// PatternConfig tunes how NewPatternWithConfig derives patterns. The zero
+// value gives the patterns of NewPattern.
+type PatternConfig struct {
+	// CaseInsensitive lowercases the words of patterns, so that messages
+	// differing only in the case of their constant parts, e.g. "Connection
+	// refused" and "connection refused", share a pattern and a hash. It
+	// changes the hashes of patterns with upper-case letters; see
+	// MigratePatternHashes.
+	CaseInsensitive bool
+	// MaxConstantSpan keeps the quoted and bracketed spans of at most this
+	// many bytes, delimiters included, as part of patterns, e.g. "[main]" or
+	// "(cached)". Longer spans, such as the dumps of objects, are variables.
+	// If 0, every span is a variable, as with NewPattern.
+	MaxConstantSpan int
+	// TypedVariables keeps IP addresses, durations, byte sizes, hex literals
+	// and email addresses in patterns as the placeholders "<ip>", "<dur>",
+	// "<size>", "<hex>" and "<email>" instead of dropping them or keeping
+	// their unit, so that e.g. "took 1.283s" and "took 950ms" share a
+	// pattern.
+	TypedVariables bool
+}
+
+// NewPattern returns the pattern of input: its words, without the variable
+// parts such as numbers, ids and quoted or bracketed text. Words are split on
+// any run of whitespace, so tabs, repeated or trailing spaces don't change the
+// pattern or its hash.
 func NewPattern(input string) *Pattern {
-	pattern := &Pattern{}
-	buf := buffers.Get().(*bytes.Buffer)
+	return NewPatternWithConfig(input, PatternConfig{})
+}
 
+// NewPatternWithConfig is like NewPattern, with the normalization set by cfg.
+func NewPatternWithConfig(input string, cfg PatternConfig) *Pattern {
+	sc := scratches.Get().(*patternScratch)
+	sc.words = appendPatternWords(sc.words[:0], input, cfg, &sc.buf)
+	s := string(sc.words)
+	scratches.Put(sc)
+
+	pattern := &Pattern{str: &s}
+	if s != "" {
+		pattern.words = strings.Split(s, " ")
+	}
+	return pattern
+}
+
+// patternDigest returns the md5 digest of the pattern of input, the one
+// the HashMD5 Hash is the hexadecimal form of, without allocating the
+// pattern.
+func patternDigest(input string, cfg PatternConfig) [md5.Size]byte {
+	sc := scratches.Get().(*patternScratch)
+	sc.words = appendPatternWords(sc.words[:0], input, cfg, &sc.buf)
+	digest := md5.Sum(sc.words)
+	scratches.Put(sc)
+	return digest
+}
+
+// appendPatternWords appends the words of the pattern of input to dst,
+// separated by spaces. buf holds input without its spans while the words
+// are read.
+func appendPatternWords(dst []byte, input string, cfg PatternConfig, buf *bytes.Buffer) []byte {
 	if strings.HasPrefix(strings.TrimSpace(input), "{") {
 		input = normalizeJSONLog(input)
 	}
-	buf.Reset()
-	for _, p := range strings.Fields(removeQuotedAndBrackets(input, buf)) {
+	words := 0
+	var p string
+	for rest := removeSpans(input, cfg.MaxConstantSpan, buf); words < patternMaxWords; {
+		if p, rest = nextField(rest); p == "" {
+			break
+		}
 		p = strings.TrimRight(p, "=:],;")
 
 		if len(p) < patterMinWordLen {
 			continue
 		}
+		mark := len(dst)
+		if mark > 0 {
+			dst = append(dst, ' ')
+		}
+		if cfg.TypedVariables {
+			if t := variableType(p); t != "" {
+				dst = append(dst, t...)
+				words++
+				continue
+			}
+		}
 		if hexWithPrefix.MatchString(p) || hex.MatchString(p) || uuid.MatchString(p) {
+			dst = dst[:mark]
+			continue
+		}
+		start := len(dst)
+		for i := 0; i < len(p); i++ {
+			c := p[i]
+			switch {
+			case c >= '0' && c <= '9':
+				continue
+			case cfg.CaseInsensitive && c >= 'A' && c <= 'Z':
+				// Words are ASCII, so this is strings.ToLower.
+				c += 'a' - 'A'
+			}
+			dst = append(dst, c)
+		}
+		if !isWord(dst[start:]) {
+			dst = dst[:mark]
+			continue
+		}
+		words++
+	}
+	return dst
+}
+
+// nextField returns the first run of non-space characters of s, as split by
+// strings.Fields, and the rest of s. The field is empty if there is none.
+func nextField(s string) (field, rest string) {
+	start := strings.IndexFunc(s, isNotSpace)
+	if start < 0 {
+		return "", ""
+	}
+	s = s[start:]
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+func isNotSpace(r rune) bool {
+	return !unicode.IsSpace(r)
+}
+
+// MigratePatternHashes maps the hashes given by NewPattern to the ones given
+// with cfg, for the messages in samples, such as the samples of stored
+// counters, so that data keyed by the old hashes can be rekeyed when
+// switching to cfg. Several old hashes may map to the same new one.
+func MigratePatternHashes(samples []string, cfg PatternConfig) map[string]string {
+	res := make(map[string]string, len(samples))
+	for _, sample := range samples {
+		res[NewPattern(sample).Hash()] = NewPatternWithConfig(sample, cfg).Hash()
+	}
+	return res
+}
+
+// Placeholders used by PatternTemplate for the variable parts of a message.
+const (
+	templateVariable = "<*>"
+	templateNumber   = "<num>"
+)
+
+// PatternTemplate renders the pattern of input as a human-readable template:
+// the words that make up the pattern are kept and variable tokens are
+// replaced with "<num>" for numbers, the typed placeholders of
+// PatternConfig.TypedVariables, such as "<ip>" or "<dur>", or "<*>" for the
+// others (ids, paths, ...), e.g. "Failed to get location <*>". Quoted and
+// bracketed parts are dropped, as they are for the pattern itself. The
+// result depends only on input.
+func PatternTemplate(input string) string {
+	buf := buffers.Get().(*bytes.Buffer)
+	defer buffers.Put(buf)
+
+	if strings.HasPrefix(strings.TrimSpace(input), "{") {
+		input = normalizeJSONLog(input)
+	}
+	buf.Reset()
+	var tokens []string
+	words := 0
+	for _, p := range strings.Fields(removeQuotedAndBrackets(input, buf)) {
+		p = strings.TrimRight(p, "=:],;")
+		token := templateVariable
+		switch t := variableType(p); {
+		case t != "":
+			token = t
+		case isNumber(p):
+			token = templateNumber
+		case len(p) < patterMinWordLen:
 			continue
+		case hexWithPrefix.MatchString(p) || hex.MatchString(p) || uuid.MatchString(p):
+		default:
+			if w := removeDigits(p, buf); isWord(w) {
+				token = w
+				words++
+			}
 		}
-		p = removeDigits(p, buf)
-		if !isWord(p) {
+		if l := len(tokens); l > 0 && token == templateVariable && tokens[l-1] == templateVariable {
 			continue
 		}
-		pattern.words = append(pattern.words, p)
-		if len(pattern.words) >= patternMaxWords {
+		tokens = append(tokens, token)
+		if words >= patternMaxWords {
 			break
 		}
 	}
+	return strings.Join(tokens, " ")
+}
 
-	buffers.Put(buf)
-	return pattern
+// isNumber reports whether s is a decimal number such as "42" or "3.14".
+func isNumber(s string) bool {
+	digits := 0
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case (r == '.' || r == ',') && i > 0 && i < len(s)-1:
+		default:
+			return false
+		}
+	}
+	return digits > 0
 }
 
 func NewPatternFromWords(input string) *Pattern {
@@ -124,10 +363,11 @@ func NewPatternFromWords(input string) *Pattern {
 }
 
 // like regexp match to `^[a-zA-Z][a-zA-Z._-]*[a-zA-Z]$`, but much faster
-func isWord(s string) bool {
+func isWord[T string | []byte](s T) bool {
 	l := len(s) - 1
 	var firstLast int
-	for i, r := range s {
+	for i := 0; i < len(s); i++ {
+		r := s[i]
 		switch i {
 		case 0, l:
 			switch {
@@ -164,31 +404,51 @@ func removeDigits(s string, buf *bytes.Buffer) string {
 	return buf.String()
 }
 
+// maxSpanDepth bounds the nesting of brackets tracked by removeSpans; deeper
+// brackets are only counted.
+const maxSpanDepth = 32
+
 func removeQuotedAndBrackets(s string, buf *bytes.Buffer) string {
+	return removeSpans(s, 0, buf)
+}
+
+// removeSpans removes the quoted and bracketed spans of s, except the ones of
+// at most maxConstant bytes, whose content is kept, with its quotes and
+// brackets replaced by spaces.
+func removeSpans(s string, maxConstant int, buf *bytes.Buffer) string {
+	if strings.IndexAny(s, `'"[({`) < 0 {
+		// Closing brackets without opening ones are kept.
+		return s
+	}
 	buf.Reset()
 	var quote, prev rune
-	var seenBrackets []rune
+	var stack [maxSpanDepth]rune
+	seenBrackets := stack[:0]
+	var deeper, start int
 	var l int
 	for i, r := range s {
+		opening := quote == 0 && len(seenBrackets) == 0
+		closed := false
 		switch r {
 		case lsbrack, lpar, lcur:
 			if quote == 0 {
-				seenBrackets = append(seenBrackets, r)
+				if len(seenBrackets) < maxSpanDepth {
+					seenBrackets = append(seenBrackets, r)
+				} else {
+					deeper++
+				}
 			}
-		case rsbrack:
-			if l = len(seenBrackets); l > 0 && seenBrackets[l-1] == lsbrack {
-				seenBrackets = seenBrackets[:l-1]
+		case rsbrack, rpar, rcur:
+			if deeper > 0 {
+				deeper--
 				continue
 			}
-		case rpar:
-			if l = len(seenBrackets); l > 0 && seenBrackets[l-1] == lpar {
+			if l = len(seenBrackets); l > 0 && seenBrackets[l-1] == openingBracket(r) {
 				seenBrackets = seenBrackets[:l-1]
-				continue
-			}
-		case rcur:
-			if l = len(seenBrackets); l > 0 && seenBrackets[l-1] == lcur {
-				seenBrackets = seenBrackets[:l-1]
-				continue
+				closed = l == 1
+				if !closed {
+					continue
+				}
 			}
 		case dquote, squote:
 			prev = 0
@@ -200,11 +460,22 @@ func removeQuotedAndBrackets(s string, buf *bytes.Buffer) string {
 					quote = r
 				} else if quote == r {
 					quote = 0
-					continue
+					closed = true
 				}
 			}
 		}
+		if closed {
+			if i+1-start <= maxConstant {
+				buf.WriteByte(' ')
+				buf.WriteString(strings.Map(spanDelimiterToSpace, s[start+1:i]))
+				buf.WriteByte(' ')
+			}
+			continue
+		}
 		if quote != 0 || len(seenBrackets) > 0 {
+			if opening {
+				start = i
+			}
 			continue
 		}
 		buf.WriteRune(r)
@@ -212,6 +483,24 @@ func removeQuotedAndBrackets(s string, buf *bytes.Buffer) string {
 	return buf.String()
 }
 
+func openingBracket(r rune) rune {
+	switch r {
+	case rsbrack:
+		return lsbrack
+	case rpar:
+		return lpar
+	}
+	return lcur
+}
+
+func spanDelimiterToSpace(r rune) rune {
+	switch r {
+	case squote, dquote, lsbrack, rsbrack, lpar, rpar, lcur, rcur:
+		return ' '
+	}
+	return r
+}
+
 // jsonMessageKeys lists the JSON field names (lowercase) used for pattern extraction.
 // Following industry standard (Datadog, New Relic, Elastic, Better Stack), pattern
 // hashing uses only the message/error content, not metadata fields like timestamps,
@@ -223,6 +512,9 @@ var jsonMessageKeys = []string{"msg", "message", "error", "err", "reason", "log"
 const maxFallbackFieldLen = 200
 
 func normalizeJSONLog(line string) string {
+	if jsonTooDeep(line) {
+		return line
+	}
 	var m map[string]interface{}
 	if err := json.Unmarshal([]byte(line), &m); err != nil {
 		return line
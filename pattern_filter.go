@@ -0,0 +1,126 @@
+package logparser
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// uncategorizedPatternCategory is the category of patterns that don't
+// declare one.
+const uncategorizedPatternCategory = "uncategorized"
+
+// PatternInfo describes a built-in sensitive pattern.
+type PatternInfo struct {
+	Name     string
+	Category string
+}
+
+// ListPatternNames returns the names and categories of all built-in
+// sensitive patterns, regardless of confidence, sorted by name. Errors
+// loading them are logged to slog.Default().
+func ListPatternNames() []PatternInfo {
+	res, err := builtinPatternNames(slog.Default())
+	if err != nil {
+		slog.Default().Error("error loading sensitive patterns", "error", err)
+	}
+	return res
+}
+
+// builtinPatternNames is ListPatternNames, logging invalid patterns to
+// logger and returning the error if the patterns can't be loaded.
+func builtinPatternNames(logger *slog.Logger) ([]PatternInfo, error) {
+	patterns, err := getOrLoadPatterns("low", logger)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]PatternInfo, 0, len(patterns)+1)
+	for _, p := range patterns {
+		res = append(res, PatternInfo{Name: p.Name, Category: p.Category})
+	}
+	entropy := NewEntropyDetector(0, 0)
+	res = append(res, PatternInfo{Name: entropy.Name, Category: entropy.Category})
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// FilterPatterns returns the patterns whose name is in only (if non-empty)
+// and not in disabled. Names must refer to a built-in pattern, possibly by
+// a former name such as "private-key", or to one of patterns; an unknown
+// name is reported as an error so that typos don't silently leave a pattern
+// enabled.
+func FilterPatterns(patterns []PrecompiledPattern, only, disabled []string) ([]PrecompiledPattern, error) {
+	return filterPatternsByName(patterns, only, disabled, slog.Default())
+}
+
+// filterPatternsByName is FilterPatterns, logging the invalid built-in
+// patterns to logger.
+func filterPatternsByName(patterns []PrecompiledPattern, only, disabled []string, logger *slog.Logger) ([]PrecompiledPattern, error) {
+	builtin, err := builtinPatternNames(logger)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, info := range builtin {
+		known[info.Name] = true
+	}
+	for _, p := range patterns {
+		known[p.Name] = true
+	}
+	var unknown []string
+	for _, name := range append(append([]string{}, only...), disabled...) {
+		if !known[canonicalPatternName(name)] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown sensitive pattern names: %s", strings.Join(unknown, ", "))
+	}
+	return filterPatterns(patterns, patternNameSet(only), patternNameSet(disabled)), nil
+}
+
+// filterPatterns is FilterPatterns without name validation. A nil only
+// keeps every pattern that is not disabled.
+func filterPatterns(patterns []PrecompiledPattern, only, disabled map[string]bool) []PrecompiledPattern {
+	if len(only) == 0 && len(disabled) == 0 {
+		return patterns
+	}
+	res := make([]PrecompiledPattern, 0, len(patterns))
+	for _, p := range patterns {
+		if name := canonicalPatternName(p.Name); (len(only) > 0 && !only[name]) || disabled[name] {
+			continue
+		}
+		res = append(res, p)
+	}
+	attachPrefilter(res)
+	return res
+}
+
+// patternNameSet returns the set of the current names of the patterns
+// called names, see canonicalPatternName.
+func patternNameSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	res := make(map[string]bool, len(names))
+	for _, name := range names {
+		res[canonicalPatternName(name)] = true
+	}
+	return res
+}
+
+// patternNameAliases maps the former names of built-in patterns to their
+// current ones, so that the lists of pattern names written for earlier
+// versions keep working.
+var patternNameAliases = map[string]string{
+	"private-key": PrivateKeyDetectorName,
+}
+
+// canonicalPatternName returns the current name of the pattern called name.
+func canonicalPatternName(name string) string {
+	if current, ok := patternNameAliases[name]; ok {
+		return current
+	}
+	return name
+}
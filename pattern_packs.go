@@ -0,0 +1,65 @@
+package logparser
+
+import (
+	"embed"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+//go:embed pattern_packs/*.json
+var patternPackFiles embed.FS
+
+// PatternPack describes a set of sensitive patterns that isn't part of the
+// embedded ones, such as the identifiers of a country, for deployments that
+// need them. Load one with LoadPatternPack or WithPatternPacks.
+type PatternPack struct {
+	Name        string
+	Description string
+}
+
+// patternPacks is the registry of the packs in pattern_packs, one JSON file
+// per pack in the format of sensitive_patterns.json.
+var patternPacks = []PatternPack{
+	{Name: "pii", Description: "international phone numbers and IBANs"},
+	{Name: "pii_in", Description: "Indian Aadhaar numbers and PANs"},
+	{Name: "pii_uk", Description: "UK National Insurance numbers and phone numbers"},
+	{Name: "pii_us", Description: "US Social Security Numbers and phone numbers"},
+}
+
+// PatternPacks returns the available pattern packs, ordered by name.
+func PatternPacks() []PatternPack {
+	return append([]PatternPack(nil), patternPacks...)
+}
+
+// LoadPatternPack compiles the patterns of the pack called name, whatever
+// their confidence. They can be appended to the embedded patterns, e.g.
+// before WithSensitivePatterns.
+func LoadPatternPack(name string) ([]PrecompiledPattern, error) {
+	return loadPatternPack(name, slog.Default())
+}
+
+// loadPatternPack is LoadPatternPack, logging invalid patterns to logger.
+func loadPatternPack(name string, logger *slog.Logger) ([]PrecompiledPattern, error) {
+	if err := checkPatternPack(name); err != nil {
+		return nil, err
+	}
+	data, err := patternPackFiles.ReadFile("pattern_packs/" + name + ".json")
+	if err != nil {
+		return nil, err
+	}
+	return compilePatterns(data, "low", false, logger)
+}
+
+// checkPatternPack returns an error listing the available packs if none is
+// called name.
+func checkPatternPack(name string) error {
+	names := make([]string, 0, len(patternPacks))
+	for _, p := range patternPacks {
+		if p.Name == name {
+			return nil
+		}
+		names = append(names, p.Name)
+	}
+	return fmt.Errorf("unknown pattern pack %q, expected one of %s", name, strings.Join(names, ", "))
+}
@@ -2,12 +2,17 @@ package logparser
 
 import (
 	"bytes"
+	"crypto/md5"
 	"encoding/json"
+	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPattern(t *testing.T) {
@@ -92,6 +97,20 @@ func TestPatternWeakEqual(t *testing.T) {
 	assert.False(t, NewPattern("foo bar baz").WeakEqual(NewPattern("baz bar foo")))
 }
 
+func TestPatternSimilarity(t *testing.T) {
+	p := NewPattern("user alice logged in from web")
+	assert.Equal(t, 1.0, p.Similarity(p))
+	assert.InDelta(t, 4.0/6, p.Similarity(NewPattern("user bob logged out from web")), 1e-9)
+	assert.InDelta(t, 3.0/6, p.Similarity(NewPattern("user alice logged")), 1e-9)
+	assert.Equal(t, 0.0, p.Similarity(NewPattern("web from in logged alice user")))
+	assert.Equal(t, 1.0, NewPattern("").Similarity(NewPattern("42")))
+
+	other := NewPattern("user bob logged out from web")
+	assert.False(t, p.WeakEqual(other))
+	assert.True(t, p.weakEqual(other, 0.5))
+	assert.False(t, p.weakEqual(other, 0.8))
+}
+
 func BenchmarkPatternWeakEqual(b *testing.B) {
 	p1 := NewPattern("foo one two bar buz")
 	p2 := NewPattern("foo three four bar buz")
@@ -116,6 +135,34 @@ func TestPatternRemoveQuotedAndBrackets(t *testing.T) {
 	assert.Equal(t,
 		"Jun 16 21:41:24 host01 kubelet: W0616 21:41:24.642736     961 reflector.go:341]",
 		removeQuotedAndBrackets(`Jun 16 21:41:24 host01 kubelet[961]: W0616 21:41:24.642736     961 reflector.go:341]`, buf))
+
+	// Spans nested deeper than maxSpanDepth are still removed as a whole.
+	deep := strings.Repeat("([{", 40) + "x" + strings.Repeat("}])", 40)
+	assert.Equal(t, "foo  bar", removeQuotedAndBrackets("foo "+deep+" bar", buf))
+
+	assert.Equal(t, "foo  cached  bar ", removeSpans(`foo (cached) bar [a much longer span]`, 10, buf))
+	assert.Equal(t, "foo   a ,  b   bar", removeSpans(`foo ['a', "b"] bar`, 10, buf))
+}
+
+func TestPatternBlobs(t *testing.T) {
+	dumps := []string{
+		"Failed to merge location: LocationDto(id=null, specifiedId=null, name=Jolly, code=USJOT, tags=[port, hub])",
+		"Failed to merge location: LocationDto(id=42, specifiedId=LOC-7, name=Hamburg, code=DEHAM, tags={primary: true})",
+	}
+	for _, cfg := range []PatternConfig{{}, {MaxConstantSpan: 16}} {
+		p := NewPatternWithConfig(dumps[0], cfg)
+		assert.Equal(t, "Failed to merge location LocationDto", p.String())
+		assert.Equal(t, p.Hash(), NewPatternWithConfig(dumps[1], cfg).Hash())
+	}
+
+	// Short spans are kept with MaxConstantSpan, so messages differing in
+	// them get their own patterns.
+	cfg := PatternConfig{MaxConstantSpan: 16}
+	assert.Equal(t, "GET served", NewPattern(`GET "/" served (from cache)`).String())
+	assert.Equal(t, "GET served from cache", NewPatternWithConfig(`GET "/" served (from cache)`, cfg).String())
+	assert.Equal(t, "thread main started", NewPatternWithConfig(`thread [main] started`, cfg).String())
+	assert.Equal(t, "thread started", NewPattern(`thread [main] started`).String())
+	assert.NotEqual(t, NewPatternWithConfig(`thread [main] started`, cfg).Hash(), NewPatternWithConfig(`thread [worker] started`, cfg).Hash())
 }
 
 func TestJsonPattern(t *testing.T) {
@@ -367,3 +414,148 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+func TestPatternTemplate(t *testing.T) {
+	for input, template := range map[string]string{
+		"Failed to get location 4f1c2a9e-5b7d-4c2e-9f3a-1b2c3d4e5f60":                                 "Failed to get location <*>",
+		"2019-07-24 12:06:21,688 package.name [DEBUG] got 10 things in 3.1s":                          "<*> package.name got <num> things in <dur>",
+		"WARN client 192.168.1.8:57600 closed connection after 1.000s":                                "WARN client <ip> closed connection after <dur>",
+		`query "{app!=[xz,xz3],name=[long.name]}" for app="xzxzx" done in 0.016s`:                     "query for app done in <dur>",
+		"sent 1.4MiB to admin@example.com from 0x7f3a9c":                                              "sent <size> to <email> from <hex>",
+		"connection pool exhausted: 50 of 50 in use, retrying in 3 s":                                 "connection pool exhausted <num> of <num> in use retrying in <num>",
+		`{"level":"error","msg":"payment 1234 declined","ts":"2024-01-15T10:30:45Z"}`:                 "payment <num> declined",
+		"ERROR 1 --- [nio-8080-exec-9] o.h.engine.jdbc.spi.SqlExceptionHelper : Too many connections": "ERROR <num> <*> o.h.engine.jdbc.spi.SqlExceptionHelper Too many connections",
+	} {
+		assert.Equal(t, template, PatternTemplate(input), input)
+	}
+	// Messages of the same pattern share a template.
+	assert.Equal(t, PatternTemplate("user 17 logged in from 10.0.0.1"), PatternTemplate("user 42 logged in from 10.0.0.7"))
+}
+
+func TestPatternWhitespaceAndCase(t *testing.T) {
+	variants := []string{
+		"ERROR Connection refused by upstream db-1",
+		"ERROR\tConnection refused by upstream db-1",
+		"ERROR  Connection   refused by upstream db-1 ",
+		" ERROR Connection refused by upstream db-1\r",
+	}
+	hash := NewPattern(variants[0]).Hash()
+	for _, v := range variants {
+		assert.Equal(t, hash, NewPattern(v).Hash(), "%q", v)
+		assert.Equal(t, hash, NewPatternWithConfig(v, PatternConfig{}).Hash(), "%q", v)
+	}
+	// The hashes don't change without the option.
+	assert.Equal(t, "ERROR Connection refused by upstream", NewPattern(variants[0]).String())
+	assert.NotEqual(t, hash, NewPattern("error connection REFUSED by upstream db-2").Hash())
+
+	cfg := PatternConfig{CaseInsensitive: true}
+	folded := NewPatternWithConfig(variants[0], cfg)
+	assert.Equal(t, "error connection refused by upstream", folded.String())
+	assert.Equal(t, folded.Hash(), NewPatternWithConfig("error connection REFUSED by upstream db-2", cfg).Hash())
+	for _, v := range variants {
+		assert.Equal(t, folded.Hash(), NewPatternWithConfig(v, cfg).Hash(), "%q", v)
+	}
+
+	migration := MigratePatternHashes([]string{variants[0], variants[1], "error connection refused by upstream db-3", "health check ok"}, cfg)
+	assert.Equal(t, map[string]string{
+		hash: folded.Hash(),
+		NewPattern("error connection refused by upstream db-3").Hash(): folded.Hash(),
+		NewPattern("health check ok").Hash():                           NewPattern("health check ok").Hash(),
+	}, migration)
+}
+
+func TestPatternTypedVariables(t *testing.T) {
+	for token, typ := range map[string]string{
+		"10.42.3.17":       "<ip>",
+		"10.42.3.17:5432":  "<ip>",
+		"fe80::1ff:fe23":   "<ip>",
+		"2001:db8::8a2e:1": "<ip>",
+		"1.283s":           "<dur>",
+		"950ms":            "<dur>",
+		"2m30s":            "<dur>",
+		"1.4MiB":           "<size>",
+		"512KB":            "<size>",
+		"0x7f3a9c":         "<hex>",
+		"deadbeef42":       "<hex>",
+		"ops@example.com":  "<email>",
+		"42":               "",
+		"1234":             "",
+		"added":            "",
+		"12:30:45":         "",
+		"connection":       "",
+	} {
+		assert.Equal(t, typ, variableType(token), token)
+	}
+
+	messages := []string{
+		"connection to 10.42.3.17:5432 timed out after 1.283s",
+		"connection to 10.42.3.18:5432 timed out after 950ms",
+		"connection to [fe80::1]:5432 timed out after 2m30s",
+		"connection to 2001:db8::8a2e:1 timed out after 3s",
+	}
+	// By default, durations leave their unit behind and fragment the
+	// patterns.
+	assert.NotEqual(t, NewPattern(messages[0]).Hash(), NewPattern(messages[1]).Hash())
+
+	cfg := PatternConfig{TypedVariables: true}
+	p := NewPatternWithConfig(messages[0], cfg)
+	assert.Equal(t, "connection to <ip> timed out after <dur>", p.String())
+	for _, m := range messages[1:] {
+		other := NewPatternWithConfig(m, cfg)
+		if m == messages[2] {
+			// The bracketed address is dropped.
+			assert.Equal(t, "connection to timed out after <dur>", other.String())
+			continue
+		}
+		assert.Equal(t, p.Hash(), other.Hash(), m)
+	}
+
+	// Typed placeholders only match the same type.
+	assert.True(t, p.WeakEqual(NewPatternWithConfig("connection to 10.0.0.1 timed out before 1s", cfg)))
+	assert.False(t, p.WeakEqual(NewPatternWithConfig("connection to 10.0.0.1 timed out after 1KB", cfg)))
+	assert.False(t, p.WeakEqual(NewPatternWithConfig("connection to 10.0.0.1 timed out after never", cfg)))
+}
+
+func TestPatternDigest(t *testing.T) {
+	inputs := []string{
+		"",
+		"ERROR failed to connect to db-1 port 5432",
+		"  WARN\ttook 1.283s for GET /api/v1/orders/42   user=Alice ",
+		`ERROR [main] upstream "GET /items/7" returned 503 (cached) {retry=3}`,
+		`{"level":"error","msg":"Connection refused","host":"10.0.0.1:5432"}`,
+		"ERROR 0xdeadbeef 9ea963cd-7ba3-411f-8a3f-b01d569574bf ok",
+	}
+	configs := []PatternConfig{{}, {CaseInsensitive: true}, {MaxConstantSpan: 10}, {TypedVariables: true}}
+	for _, cfg := range configs {
+		for _, input := range inputs {
+			p := NewPatternWithConfig(input, cfg)
+			digest := patternDigest(input, cfg)
+			assert.Equal(t, p.Hash(), hashString(digest), "%q %+v", input, cfg)
+			assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte(p.String()))), p.Hash())
+			assert.Equal(t, p.String(), strings.Join(p.words, " "))
+		}
+	}
+	assert.Nil(t, NewPattern(" 42 ").words)
+
+	for _, s := range []string{"", " a\tbb  c d \n", "x"} {
+		fields := []string{}
+		for field, rest := nextField(s); field != ""; field, rest = nextField(rest) {
+			fields = append(fields, field)
+		}
+		assert.Equal(t, strings.Fields(s), fields, "%q", s)
+	}
+}
+
+func TestParserIncAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	p, err := NewParserWithOptions(make(chan LogEntry))
+	require.NoError(t, err)
+	defer p.Stop()
+	msg := Message{Timestamp: time.Now(), Content: "ERROR failed to connect to db-1 port 5432", Level: LevelError, Lines: 1}
+	p.inc(msg)
+	// Messages of existing patterns are counted without allocating.
+	assert.Zero(t, testing.AllocsPerRun(100, func() { p.inc(msg) }))
+	assert.Equal(t, 102, p.GetCounters()[0].Messages)
+}
@@ -0,0 +1,65 @@
+package logparser
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Typed placeholders of the variables recognized by variableType.
+const (
+	templateIP       = "<ip>"
+	templateDuration = "<dur>"
+	templateSize     = "<size>"
+	templateHex      = "<hex>"
+	templateEmail    = "<email>"
+)
+
+var (
+	ipv4WithPort = regexp.MustCompile(`^\d{1,3}(\.\d{1,3}){3}(:\d{1,5})?$`)
+	byteSize     = regexp.MustCompile(`^\d+(\.\d+)?([kKmMgGtTpP]i?[bB]|[bB])$`)
+	email        = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+)
+
+// variableType returns the typed placeholder of token if it is an IP address
+// (with an optional port), a Go duration such as "1.283s" or "2m30s", a byte
+// size such as "1.4MiB", a hex literal such as "0x7f3a9c" or a hex id mixing
+// letters and digits, or an email address, and "" otherwise.
+func variableType(token string) string {
+	switch {
+	case ipv4WithPort.MatchString(token):
+		return templateIP
+	case strings.Count(token, ":") >= 2 && net.ParseIP(token) != nil:
+		return templateIP
+	case isDuration(token):
+		return templateDuration
+	case byteSize.MatchString(token):
+		return templateSize
+	case hexWithPrefix.MatchString(token), hex.MatchString(token) && strings.ContainsAny(token, "0123456789") && !isNumber(token):
+		return templateHex
+	case strings.Contains(token, "@") && email.MatchString(token):
+		return templateEmail
+	}
+	return ""
+}
+
+// isDuration reports whether token is a Go duration with a unit, e.g. "950ms";
+// plain numbers are not durations.
+func isDuration(token string) bool {
+	if token == "" || token[0] < '0' || token[0] > '9' || !strings.ContainsAny(token, "hmsuµn") {
+		return false
+	}
+	_, err := time.ParseDuration(token)
+	return err == nil
+}
+
+// isTypedPlaceholder reports whether word is one of the placeholders of
+// variableType.
+func isTypedPlaceholder(word string) bool {
+	switch word {
+	case templateIP, templateDuration, templateSize, templateHex, templateEmail:
+		return true
+	}
+	return false
+}
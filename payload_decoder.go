@@ -0,0 +1,118 @@
+package logparser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultPayloadLimit is the default size limit of Base64Decoder and
+// GzipDecoder.
+const defaultPayloadLimit = 1024 * 1024
+
+// gzipMagic starts every gzip stream.
+const gzipMagic = "\x1f\x8b"
+
+// minBase64Length is the length of the shortest line Base64Decoder decodes.
+const minBase64Length = 16
+
+// ErrPayloadTooLarge is returned by Base64Decoder and GzipDecoder when the
+// decoded payload exceeds their size limit.
+var ErrPayloadTooLarge = errors.New("decoded payload exceeds the size limit")
+
+// Base64Decoder decodes lines that are a base64 payload, in the standard or
+// URL alphabet, with or without padding. As plain words can be valid base64
+// too, a line is only decoded if it is at least minBase64Length characters
+// long, a multiple of 4, and decodes to printable UTF-8 text or a gzip
+// stream, for a GzipDecoder to decompress. Other lines, such as lines with
+// spaces, are passed through unchanged.
+type Base64Decoder struct {
+	// MaxSize is the maximum decoded size in bytes (default 1MiB); larger
+	// payloads fail with ErrPayloadTooLarge.
+	MaxSize int
+}
+
+var base64Encodings = []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding}
+
+// Decode returns the payload of src.
+func (d Base64Decoder) Decode(src string) (string, error) {
+	if len(src) < minBase64Length || len(src)%4 != 0 || strings.IndexFunc(src, func(r rune) bool { return !isBase64Char(r) }) >= 0 {
+		return src, nil
+	}
+	// checked before decoding, as the size follows from the length
+	if base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(src, "="))) > payloadLimit(d.MaxSize) {
+		return "", fmt.Errorf("base64 payload of %d bytes: %w", len(src), ErrPayloadTooLarge)
+	}
+	for _, enc := range base64Encodings {
+		if decoded, err := enc.DecodeString(src); err == nil {
+			if !isPayload(decoded) {
+				break
+			}
+			return string(decoded), nil
+		}
+	}
+	return src, nil
+}
+
+// isPayload reports whether decoded base64 is printable UTF-8 text or a
+// gzip stream, rather than the bytes an ordinary word happens to decode to.
+func isPayload(decoded []byte) bool {
+	if bytes.HasPrefix(decoded, []byte(gzipMagic)) {
+		return true
+	}
+	if !utf8.Valid(decoded) {
+		return false
+	}
+	return bytes.IndexFunc(decoded, func(r rune) bool {
+		return !unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r'
+	}) < 0
+}
+
+func isBase64Char(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '+' || r == '/' || r == '-' || r == '_' || r == '='
+}
+
+// GzipDecoder decompresses lines that are a gzip stream, e.g. the output of
+// a Base64Decoder in a ChainDecoder. Lines without the gzip magic number are
+// passed through unchanged; a corrupt stream is an error.
+type GzipDecoder struct {
+	// MaxSize is the maximum decompressed size in bytes (default 1MiB);
+	// larger payloads fail with ErrPayloadTooLarge instead of being
+	// decompressed further.
+	MaxSize int
+}
+
+// Decode returns the decompressed src.
+func (d GzipDecoder) Decode(src string) (string, error) {
+	if !strings.HasPrefix(src, gzipMagic) {
+		return src, nil
+	}
+	r, err := gzip.NewReader(strings.NewReader(src))
+	if err != nil {
+		return "", fmt.Errorf("invalid gzip payload: %w", err)
+	}
+	defer r.Close()
+	limit := payloadLimit(d.MaxSize)
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return "", fmt.Errorf("invalid gzip payload: %w", err)
+	}
+	if n > int64(limit) {
+		return "", fmt.Errorf("gzip payload: %w", ErrPayloadTooLarge)
+	}
+	return buf.String(), nil
+}
+
+func payloadLimit(max int) int {
+	if max <= 0 {
+		return defaultPayloadLimit
+	}
+	return max
+}
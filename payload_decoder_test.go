@@ -0,0 +1,126 @@
+package logparser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipString(t testing.TB, s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.String()
+}
+
+func TestBase64Decoder(t *testing.T) {
+	d := Base64Decoder{}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		msg, err := d.Decode(enc.EncodeToString([]byte("ERROR payment failed: card declined ??>")))
+		require.NoError(t, err)
+		assert.Equal(t, "ERROR payment failed: card declined ??>", msg)
+	}
+
+	for _, line := range []string{
+		"",
+		"connection refused",
+		"hello",
+		"ERROR: failed",
+		"path=/var/log",
+		// ordinary words that are valid base64
+		"healthy",
+		"Started",
+		"shutdown",
+		"done",
+		"OK",
+		"internationalization", // long enough, but decodes to binary
+		"ConnectionRefusedByPeer1",
+		// binary that isn't gzip
+		base64.StdEncoding.EncodeToString([]byte("\x00\x01\x02\x03\xff\xfe\xfd\xfc\x00\x01\x02\x03")),
+	} {
+		msg, err := d.Decode(line)
+		require.NoError(t, err)
+		assert.Equal(t, line, msg, line)
+	}
+
+	gz := gzipString(t, "ERROR disk full")
+	msg, err := d.Decode(base64.StdEncoding.EncodeToString([]byte(gz)))
+	require.NoError(t, err)
+	assert.Equal(t, gz, msg)
+
+	encoded := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("x"), 101))
+	_, err = Base64Decoder{MaxSize: 100}.Decode(encoded)
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+	msg, err = Base64Decoder{MaxSize: 101}.Decode(encoded)
+	require.NoError(t, err)
+	assert.Len(t, msg, 101)
+}
+
+func TestGzipDecoder(t *testing.T) {
+	d := GzipDecoder{}
+	msg, err := d.Decode(gzipString(t, "ERROR disk full"))
+	require.NoError(t, err)
+	assert.Equal(t, "ERROR disk full", msg)
+
+	for _, line := range []string{"", "plain line", "\x1f not gzip"} {
+		msg, err := d.Decode(line)
+		require.NoError(t, err)
+		assert.Equal(t, line, msg)
+	}
+
+	_, err = d.Decode(gzipMagic + "\x08\x00corrupt")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrPayloadTooLarge)
+	truncated := gzipString(t, strings.Repeat("log line ", 100))
+	_, err = d.Decode(truncated[:len(truncated)-10])
+	assert.Error(t, err)
+
+	// 64MiB of zeros compress to about 128KiB
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	zeros := make([]byte, 1024*1024)
+	for i := 0; i < 64; i++ {
+		_, err := w.Write(zeros)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.Less(t, buf.Len(), 256*1024)
+	_, err = d.Decode(buf.String())
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+	_, err = GzipDecoder{MaxSize: 10}.Decode(gzipString(t, "ERROR disk full"))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func TestParserPayloadDecoders(t *testing.T) {
+	// the payload field of a JSON envelope is base64 of gzip or plain text
+	d := ChainDecoder(JSONDecoder{MessageFields: []string{"payload"}}, Base64Decoder{}, GzipDecoder{MaxSize: 1024})
+	envelope := func(payload string) string {
+		return `{"vendor":"acme","payload":"` + base64.StdEncoding.EncodeToString([]byte(payload)) + `"}`
+	}
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithDecoder(d), WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	for _, line := range []string{
+		envelope(gzipString(t, "ERROR upstream timeout")),
+		envelope("ERROR upstream timeout"),
+		envelope(gzipString(t, strings.Repeat("a", 2048))), // dropped: over the limit
+		"ERROR plain line",
+	} {
+		ch <- LogEntry{Content: line, Timestamp: time.Now()}
+	}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	bySample := map[string]int{}
+	for _, c := range p.GetCounters() {
+		bySample[c.Sample] += c.Messages
+	}
+	assert.Equal(t, map[string]int{"ERROR upstream timeout": 2, "ERROR plain line": 1}, bySample)
+}
@@ -0,0 +1,95 @@
+package logparser
+
+import (
+	"strings"
+	"time"
+)
+
+// parseLinesTimeout bounds the time ParseLines waits for the lines to be
+// counted.
+const parseLinesTimeout = time.Minute
+
+// ProcessEntry adds entry to the parser like sending it on the channel, for
+// embedders that don't want to manage one. It is safe for concurrent use,
+// including with the channel; the lines of a multiline message must still
+// be added in order from one goroutine. Entries added after Stop or
+// StopAndDrain are ignored.
+func (p *Parser) ProcessEntry(entry LogEntry) {
+	select {
+	case <-p.stopping:
+		return
+	case <-p.draining:
+		return
+	default:
+	}
+	p.inputLock.RLock()
+	defer p.inputLock.RUnlock()
+	if p.inputClosed {
+		return
+	}
+	p.receive(entry)
+}
+
+// ProcessLine adds a raw line with ProcessEntry. Its timestamp is read from
+// the line with WithTimestampExtraction and is the current time otherwise.
+func (p *Parser) ProcessLine(line string) {
+	entry := LogEntry{Content: line, Level: LevelUnknown}
+	if p.timestamps == nil {
+		entry.Timestamp = time.Now()
+	}
+	p.ProcessEntry(entry)
+}
+
+// MessageResult is what CountMessage found in a message.
+type MessageResult struct {
+	// PatternHash is the hash of the counter of the message; empty for the
+	// info and debug messages that aren't grouped by pattern (see
+	// WithPatternizeAllLevels).
+	PatternHash string
+	// Sensitive holds the sensitive data matches of the message, if it was
+	// inspected: every occurrence of each pattern.
+	Sensitive []SensitivePatternMatch
+}
+
+// CountMessage counts msg, a complete message, and returns its pattern hash
+// and sensitive matches. It is for embedders whose messages are already
+// assembled, e.g. the records of a log pipeline: msg skips the decoder and
+// the multiline collector, and sensitive detection runs in the calling
+// goroutine even with WithSensitiveWorkers. The level of msg is detected
+// from its content if it is LevelUnknown. It is safe for concurrent use.
+func (p *Parser) CountMessage(msg Message) MessageResult {
+	if msg.Level == LevelUnknown {
+		msg.Level = p.levels.Detect(msg.Content)
+	}
+	if msg.Lines == 0 {
+		msg.Lines = strings.Count(msg.Content, "\n") + 1
+	}
+	if p.mergeSources {
+		msg.Source = ""
+	}
+	if p.timestamps != nil {
+		msg.Content = p.timestamps.Strip(msg.Content)
+	}
+	var res MessageResult
+	notify, patterns, _, events := p.count(msg, &res)
+	p.notifyPatternEvents(events)
+	p.notifySensitiveMatches(msg, notify, patterns)
+	return res
+}
+
+// ParseLines is a one-shot batch analysis of lines: it parses them with a
+// parser configured by opts and returns its counters and sensitive counters
+// once they are all counted.
+func ParseLines(lines []string, opts ...ParserOption) ([]LogCounter, []SensitiveLogCounter, error) {
+	p, err := NewParserWithOptions(make(chan LogEntry), opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, line := range lines {
+		p.ProcessLine(line)
+	}
+	if err := p.StopAndDrain(parseLinesTimeout); err != nil {
+		return nil, nil, err
+	}
+	return p.GetCounters(), p.GetSensitiveCounters(), nil
+}
@@ -0,0 +1,80 @@
+package logparser
+
+import "time"
+
+// defaultRateWindow is the number of one-minute buckets kept per pattern.
+const defaultRateWindow = 60
+
+// rateWindow counts messages in one-minute buckets over a sliding window.
+// The window advances with message timestamps rather than the wall clock,
+// so replaying historical logs yields the same rates as live ingestion.
+type rateWindow struct {
+	buckets []int
+	// head is the minute (Unix time / 60) of the newest message, first the
+	// minute of the oldest one still counted.
+	head, first int64
+}
+
+// add counts a message at ts in a window of size buckets. Messages older than
+// the window are dropped; zero timestamps are ignored.
+func (w *rateWindow) add(ts time.Time, size int) {
+	if ts.IsZero() {
+		return
+	}
+	if size <= 0 {
+		size = defaultRateWindow
+	}
+	minute := ts.Unix() / 60
+	if w.buckets == nil {
+		w.buckets = make([]int, size)
+		w.head, w.first = minute, minute
+	}
+	n := int64(len(w.buckets))
+	switch {
+	case minute > w.head:
+		for m := w.head + 1; m <= minute && m <= w.head+n; m++ {
+			w.buckets[bucketIndex(m, n)] = 0
+		}
+		w.head = minute
+		if w.first < w.head-n+1 {
+			w.first = w.head - n + 1
+		}
+	case minute <= w.head-n:
+		return
+	}
+	if minute < w.first {
+		w.first = minute
+	}
+	w.buckets[bucketIndex(minute, n)]++
+}
+
+// last returns the number of messages in the newest minutes buckets.
+func (w *rateWindow) last(minutes int) int {
+	n := int64(len(w.buckets))
+	if int64(minutes) > n {
+		minutes = int(n)
+	}
+	total := 0
+	for m := w.head - int64(minutes) + 1; m <= w.head; m++ {
+		total += w.buckets[bucketIndex(m, n)]
+	}
+	return total
+}
+
+// perMinute returns the average number of messages per minute over the part
+// of the window that has been observed.
+func (w *rateWindow) perMinute() float64 {
+	if w.buckets == nil {
+		return 0
+	}
+	minutes := int(w.head - w.first + 1)
+	return float64(w.last(minutes)) / float64(minutes)
+}
+
+func bucketIndex(minute, n int64) int {
+	i := minute % n
+	if i < 0 {
+		i += n
+	}
+	return int(i)
+}
@@ -0,0 +1,75 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateWindow(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	at := func(minute, second int) time.Time {
+		return t0.Add(time.Duration(minute)*time.Minute + time.Duration(second)*time.Second)
+	}
+	var w rateWindow
+
+	// A burst of 10 messages in minute 0 and 5 in minute 2.
+	for i := 0; i < 10; i++ {
+		w.add(at(0, i), 5)
+	}
+	for i := 0; i < 5; i++ {
+		w.add(at(2, i), 5)
+	}
+	assert.Equal(t, []int{10, 0, 5, 0, 0}, w.buckets)
+	assert.Equal(t, 15, w.last(5))
+	assert.Equal(t, 5, w.last(1))
+	assert.Equal(t, 5.0, w.perMinute())
+
+	// A late message within the window is counted in its own minute.
+	w.add(at(1, 30), 5)
+	assert.Equal(t, []int{10, 1, 5, 0, 0}, w.buckets)
+
+	// Minute 6 rotates out minutes 0 and 1; minutes 3-5 had no messages.
+	w.add(at(6, 0), 5)
+	assert.Equal(t, []int{0, 1, 5, 0, 0}, w.buckets)
+	assert.Equal(t, 6, w.last(5))
+
+	// Messages older than the window are dropped.
+	w.add(at(0, 0), 5)
+	assert.Equal(t, 6, w.last(5))
+
+	// A gap longer than the window clears every bucket.
+	w.add(at(60, 0), 5)
+	assert.Equal(t, []int{1, 0, 0, 0, 0}, w.buckets)
+	assert.Equal(t, 1, w.last(5))
+	assert.Equal(t, 1.0/5, w.perMinute())
+
+	w.add(time.Time{}, 5)
+	assert.Equal(t, 1, w.last(5))
+}
+
+func TestParserRate(t *testing.T) {
+	p, err := NewParserWithOptions(make(chan LogEntry), WithRateWindow(10))
+	require.NoError(t, err)
+	defer p.Stop()
+
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for minute := 0; minute < 20; minute++ {
+		// 1 message per minute, then a burst of 20 per minute at the end.
+		n := 1
+		if minute >= 18 {
+			n = 20
+		}
+		for i := 0; i < n; i++ {
+			p.inc(Message{Timestamp: t0.Add(time.Duration(minute)*time.Minute + time.Duration(i)*time.Second), Content: "connection refused", Level: LevelError})
+		}
+	}
+
+	counters := p.GetCounters()
+	require.Len(t, counters, 1)
+	assert.Equal(t, 58, counters[0].Messages)
+	assert.Equal(t, 43, counters[0].Last5mCount)
+	assert.Equal(t, 4.8, counters[0].RatePerMinute)
+}
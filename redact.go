@@ -0,0 +1,110 @@
+package logparser
+
+import (
+	"sort"
+	"strings"
+)
+
+// RedactionConfig controls how RedactSensitiveDataWithConfig masks secrets.
+type RedactionConfig struct {
+	// Mask replaces the hidden part of each secret. The placeholder "{name}"
+	// is substituted with the name of the pattern that matched, so
+	// "[REDACTED:{name}]" yields e.g. "[REDACTED:aws-access-token]".
+	// Empty means "****".
+	Mask string
+	// KeepPrefix and KeepSuffix leave that many leading/trailing bytes of the
+	// secret visible (e.g. KeepSuffix: 4 shows the last 4 characters). When the
+	// secret is not longer than KeepPrefix+KeepSuffix it is masked entirely.
+	KeepPrefix int
+	KeepSuffix int
+}
+
+const defaultRedactionMask = "****"
+
+// RedactSensitiveData returns line with every region matched by patterns
+// replaced by "****", along with the matches that were redacted.
+func RedactSensitiveData(line string, patterns []PrecompiledPattern) (string, []SensitivePatternMatch) {
+	return RedactSensitiveDataWithConfig(line, patterns, RedactionConfig{})
+}
+
+// RedactSensitiveDataWithConfig is like RedactSensitiveData but masks secrets
+// according to cfg. Overlapping matches from different patterns are merged
+// into a single masked region so the output is never corrupted.
+func RedactSensitiveDataWithConfig(line string, patterns []PrecompiledPattern, cfg RedactionConfig) (string, []SensitivePatternMatch) {
+	return redactSensitiveData(line, patterns, nil, cfg)
+}
+
+// redactSensitiveData implements RedactSensitiveDataWithConfig, leaving the
+// secrets allowed by global visible.
+func redactSensitiveData(line string, patterns []PrecompiledPattern, global *Allowlist, cfg RedactionConfig) (string, []SensitivePatternMatch) {
+	matches := detectSensitiveData(line, "", patterns, detectAll, global, nil)
+	if len(matches) == 0 {
+		return line, nil
+	}
+
+	// Only the secret part of each match (see PrecompiledPattern.SecretGroup)
+	// is masked so that the surrounding keyword (e.g. "password=") stays
+	// readable.
+	regions := make([]sensitiveRegion, 0, len(matches))
+	for _, m := range matches {
+		regions = append(regions, sensitiveRegion{start: m.SecretStart, end: m.SecretEnd, name: m.Name})
+	}
+
+	var b strings.Builder
+	b.Grow(len(line))
+	last := 0
+	for _, r := range mergeRegions(regions) {
+		b.WriteString(line[last:r.start])
+		b.WriteString(maskSecret(line[r.start:r.end], r.name, cfg))
+		last = r.end
+	}
+	b.WriteString(line[last:])
+	return b.String(), matches
+}
+
+type sensitiveRegion struct {
+	start, end int
+	name       string
+}
+
+// mergeRegions sorts regions by offset and coalesces overlapping or adjacent
+// ones. The merged region keeps the name of the earliest match.
+func mergeRegions(regions []sensitiveRegion) []sensitiveRegion {
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].start == regions[j].start {
+			return regions[i].end > regions[j].end
+		}
+		return regions[i].start < regions[j].start
+	})
+	merged := regions[:1]
+	for _, r := range regions[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end {
+			if r.end > last.end {
+				last.end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func maskSecret(secret, name string, cfg RedactionConfig) string {
+	mask := cfg.Mask
+	if mask == "" {
+		mask = defaultRedactionMask
+	}
+	mask = strings.ReplaceAll(mask, "{name}", name)
+	prefix, suffix := cfg.KeepPrefix, cfg.KeepSuffix
+	if prefix < 0 {
+		prefix = 0
+	}
+	if suffix < 0 {
+		suffix = 0
+	}
+	if prefix+suffix >= len(secret) {
+		return mask
+	}
+	return secret[:prefix] + mask + secret[len(secret)-suffix:]
+}
@@ -0,0 +1,300 @@
+package logparser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Report is the periodic counter report sent by a Reporter. Counts are
+// deltas since the previous report: only the counters that got messages in
+// between are included. Samples and templates are redacted.
+type Report struct {
+	// Node identifies the reporting process, the hostname by default.
+	Node string `json:"node"`
+	// Seq increases by one with every report, starting at 1, including the
+	// dropped ones, so that a receiver can detect gaps.
+	Seq       uint64            `json:"seq"`
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Patterns  []PatternExport   `json:"patterns"`
+	Sensitive []SensitiveExport `json:"sensitive"`
+	// Dropped is the number of reports dropped so far because their sink
+	// failed.
+	Dropped uint64 `json:"dropped"`
+}
+
+// ReportSink delivers the reports of a Reporter.
+type ReportSink interface {
+	Send(ctx context.Context, report Report) error
+}
+
+// Reporter periodically sends the counters of a parser to a sink. Reports
+// are built from snapshots, so the counters of the parser aren't reset and
+// may be read by others. A report the sink fails to send is dropped and
+// counted rather than retried by the reporter: the parser never waits for
+// the sink.
+type Reporter struct {
+	// Node is the node of the reports; it may be changed before Run.
+	Node string
+
+	parser   *Parser
+	interval time.Duration
+	sink     ReportSink
+
+	lock      sync.Mutex
+	seq       uint64
+	last      time.Time
+	messages  map[patternKey]reportCounts
+	sensitive map[sensitivePatternKey]int
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// reportCounts are the totals of a counter as of the previous report.
+type reportCounts struct {
+	messages int
+	bytes    int64
+}
+
+// NewReporter returns a reporter sending the counters of p to sink every
+// interval once Run is called. The first report covers the messages counted
+// since NewReporter.
+func NewReporter(p *Parser, interval time.Duration, sink ReportSink) *Reporter {
+	node, _ := os.Hostname()
+	r := &Reporter{Node: node, parser: p, interval: interval, sink: sink}
+	r.last, r.messages, r.sensitive = r.totals()
+	return r
+}
+
+// Run sends a report every interval until ctx is done, and returns the
+// context error. Each send is bounded by the interval. Messages counted since
+// the last report are not sent on return; call Flush for that.
+func (r *Reporter) Run(ctx context.Context) error {
+	if r.interval <= 0 {
+		return fmt.Errorf("report interval must be positive, got %s", r.interval)
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			sendCtx, cancel := context.WithTimeout(ctx, r.interval)
+			_ = r.Flush(sendCtx)
+			cancel()
+		}
+	}
+}
+
+// Flush sends a report of the messages counted since the previous one right
+// away. The report is dropped if the sink fails, and the error returned.
+func (r *Reporter) Flush(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	report, err := r.next()
+	if err != nil {
+		r.dropped.Add(1)
+		return err
+	}
+	if err := r.sink.Send(ctx, report); err != nil {
+		r.dropped.Add(1)
+		r.parser.logger.Error("error sending counter report", "seq", report.Seq, "error", err)
+		return err
+	}
+	r.sent.Add(1)
+	return nil
+}
+
+// Sent returns the number of reports sent.
+func (r *Reporter) Sent() uint64 {
+	return r.sent.Load()
+}
+
+// Dropped returns the number of reports dropped because they couldn't be
+// built or sent.
+func (r *Reporter) Dropped() uint64 {
+	return r.dropped.Load()
+}
+
+// totals returns the current totals of the counters of the parser.
+func (r *Reporter) totals() (time.Time, map[patternKey]reportCounts, map[sensitivePatternKey]int) {
+	snap := r.parser.Snapshot()
+	now := time.Now()
+	messages := make(map[patternKey]reportCounts, len(snap.Counters))
+	for _, c := range snap.Counters {
+		messages[patternKey{source: c.Source, level: c.Level, hash: c.Hash}] = reportCounts{messages: c.Messages, bytes: c.Bytes}
+	}
+	sensitive := make(map[sensitivePatternKey]int, len(snap.SensitiveCounters))
+	for _, c := range snap.SensitiveCounters {
+		sensitive[sensitivePatternKey{source: c.Source, name: c.Name, hash: c.Hash}] = c.Messages
+	}
+	return now, messages, sensitive
+}
+
+// next builds the next report and makes its totals the baseline of the
+// following one. The caller must hold the lock.
+func (r *Reporter) next() (Report, error) {
+	r.seq++
+	report := Report{Node: r.Node, Seq: r.seq, From: r.last, Patterns: []PatternExport{}, Sensitive: []SensitiveExport{}, Dropped: r.dropped.Load()}
+	redactor, err := r.parser.exportRedactor()
+	if err != nil {
+		return report, err
+	}
+	snap := r.parser.Snapshot()
+	report.To = time.Now()
+
+	messages := make(map[patternKey]reportCounts, len(snap.Counters))
+	for _, c := range snap.Counters {
+		k := patternKey{source: c.Source, level: c.Level, hash: c.Hash}
+		messages[k] = reportCounts{messages: c.Messages, bytes: c.Bytes}
+		prev := r.messages[k]
+		if c.Messages < prev.messages {
+			// The counter was evicted and created again since.
+			prev = reportCounts{}
+		}
+		if c.Messages == prev.messages {
+			continue
+		}
+		sample := redactor.redact(c.Sample)
+		template := ""
+		if c.Pattern != "" {
+			template = PatternTemplate(sample)
+		}
+		report.Patterns = append(report.Patterns, PatternExport{Level: c.Level.String(), Hash: c.Hash, HashVersion: c.HashVersion, Template: template, Sample: sample, SampleTruncated: c.SampleTruncated, Messages: c.Messages - prev.messages, Bytes: c.Bytes - prev.bytes, Source: c.Source, FirstSeen: c.FirstSeen, LastSeen: c.LastSeen})
+	}
+	sensitive := make(map[sensitivePatternKey]int, len(snap.SensitiveCounters))
+	for _, c := range snap.SensitiveCounters {
+		k := sensitivePatternKey{source: c.Source, name: c.Name, hash: c.Hash}
+		sensitive[k] = c.Messages
+		prev := r.sensitive[k]
+		if c.Messages < prev {
+			prev = 0
+		}
+		if c.Messages == prev {
+			continue
+		}
+		report.Sensitive = append(report.Sensitive, SensitiveExport{Name: c.Name, Category: c.Category, Severity: c.Severity, Hash: c.Hash, Sample: redactor.redact(c.Sample), Messages: c.Messages - prev, Source: c.Source, FirstSeen: c.FirstSeen, LastSeen: c.LastSeen})
+	}
+	r.last, r.messages, r.sensitive = report.To, messages, sensitive
+
+	sort.Slice(report.Patterns, func(i, j int) bool {
+		a, b := report.Patterns[i], report.Patterns[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.Hash < b.Hash
+	})
+	sort.Slice(report.Sensitive, func(i, j int) bool {
+		a, b := report.Sensitive[i], report.Sensitive[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Hash < b.Hash
+	})
+	return report, nil
+}
+
+// HTTPSink is a ReportSink posting each report as JSON to a URL. Network
+// errors and 429 or 5xx responses are retried with exponential backoff;
+// other responses outside 2xx fail right away.
+type HTTPSink struct {
+	URL string
+	// Headers are added to every request, e.g. Authorization.
+	Headers map[string]string
+	// Client sends the requests; http.DefaultClient if nil.
+	Client *http.Client
+	// Retries is the number of attempts after the first one.
+	Retries int
+	// Backoff is the wait before the first retry, doubled for each of the
+	// next ones.
+	Backoff time.Duration
+}
+
+// NewHTTPSink returns an HTTPSink posting to url with 3 retries, the first
+// after half a second.
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{URL: url, Retries: 3, Backoff: 500 * time.Millisecond}
+}
+
+func (s *HTTPSink) Send(ctx context.Context, report Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, body)
+		if err == nil || !retry || attempt >= s.Retries {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one request and reports whether a failure may be retried.
+func (s *HTTPSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("report sink %s returned %s", s.URL, resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// WriterSink is a ReportSink writing each report to a writer as a line of
+// JSON. It is safe for concurrent use.
+type WriterSink struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterSink returns a WriterSink writing to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Send(_ context.Context, report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
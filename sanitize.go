@@ -0,0 +1,154 @@
+package logparser
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxSanitizePending caps the unterminated escape sequence carried over to
+// the next line; a longer one is dropped.
+const maxSanitizePending = 256
+
+// SanitizeDecoder cleans up logs captured from a terminal: it strips ANSI
+// escape sequences, such as colours and cursor movements, turns "\r\n" and
+// bare "\r" into line breaks, drops trailing line breaks and replaces the
+// other control characters with spaces, so that coloured and plain variants
+// of a message share a pattern. An escape sequence split across lines is
+// completed with the start of the next line of the same source. Lines without
+// control characters are passed through unchanged. It is safe for concurrent
+// use.
+type SanitizeDecoder struct {
+	lock sync.Mutex
+	// pending holds the unterminated escape sequence at the end of the
+	// previous line of each source.
+	pending map[string]string
+}
+
+// NewSanitizeDecoder returns a SanitizeDecoder.
+func NewSanitizeDecoder() *SanitizeDecoder {
+	return &SanitizeDecoder{pending: map[string]string{}}
+}
+
+// Decode returns src without escape sequences and control characters.
+func (d *SanitizeDecoder) Decode(src string) (string, error) {
+	entry, _, err := d.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+// DecodeEntry returns entry with its content sanitized. It always returns
+// ok=true.
+func (d *SanitizeDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	src := entry.Content
+	if p, ok := d.pending[entry.Source]; ok {
+		src = p + src
+		delete(d.pending, entry.Source)
+	}
+	if !needsSanitize(src) {
+		return entry, true, nil
+	}
+	content, pending := sanitize(src)
+	if pending != "" && len(pending) <= maxSanitizePending {
+		d.pending[entry.Source] = pending
+	}
+	entry.Content = content
+	return entry, true, nil
+}
+
+// needsSanitize reports whether s has control characters other than tabs or
+// line feeds.
+func needsSanitize(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 && c != '\t' && c != '\n' || c == 0x7f || c == 0xc2 {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize returns s cleaned up as described for SanitizeDecoder, and the
+// unterminated escape sequence at its end, if any.
+func sanitize(s string) (string, string) {
+	var b strings.Builder
+	b.Grow(len(s))
+	pending := ""
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == 0x1b:
+			end, ok := escapeSequenceEnd(s, i)
+			if !ok {
+				pending = s[i:]
+				i = len(s)
+				continue
+			}
+			i = end
+			continue
+		case c == '\r':
+			if i+1 >= len(s) || s[i+1] != '\n' {
+				b.WriteByte('\n')
+			}
+		case c == '\t' || c == '\n':
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			b.WriteByte(' ')
+		case c == 0xc2 && i+1 < len(s) && s[i+1] >= 0x80 && s[i+1] <= 0x9f:
+			// a C1 control character, U+0080 to U+009F
+			b.WriteByte(' ')
+			i++
+		default:
+			b.WriteByte(c)
+		}
+		i++
+	}
+	return strings.TrimRight(b.String(), "\n"), pending
+}
+
+// escapeSequenceEnd returns the index after the escape sequence starting at
+// s[start], or ok=false if s ends before the sequence does. Malformed
+// sequences end before the first unexpected byte.
+func escapeSequenceEnd(s string, start int) (end int, ok bool) {
+	i := start + 1
+	if i >= len(s) {
+		return 0, false
+	}
+	switch c := s[i]; {
+	case c == '[':
+		// CSI: parameter bytes, intermediate bytes and a final byte
+		for i++; i < len(s) && s[i] >= 0x20 && s[i] <= 0x3f; i++ {
+		}
+		if i >= len(s) {
+			return 0, false
+		}
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1, true
+		}
+		return i, true
+	case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+		// OSC, DCS and other strings, terminated by BEL or ST (ESC \)
+		for i++; i < len(s); i++ {
+			if s[i] == 0x07 {
+				return i + 1, true
+			}
+			if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2, true
+			}
+		}
+		return 0, false
+	case c >= 0x20 && c <= 0x2f:
+		// intermediate bytes and a final byte, e.g. a charset selection
+		for ; i < len(s) && s[i] >= 0x20 && s[i] <= 0x2f; i++ {
+		}
+		if i >= len(s) {
+			return 0, false
+		}
+		if s[i] >= 0x30 && s[i] <= 0x7e {
+			return i + 1, true
+		}
+		return i, true
+	case c >= 0x30 && c <= 0x7e:
+		return i + 1, true
+	}
+	return i, true
+}
@@ -0,0 +1,73 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeDecoder(t *testing.T) {
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"plain line", "plain line"},
+		{"tab\tseparated", "tab\tseparated"},
+		{"\x1b[31mERROR\x1b[0m connection failed", "ERROR connection failed"},
+		{"\x1b[1;38;5;196mbold\x1b[m text", "bold text"},
+		{"\x1b[2K\x1b[1Gprogress", "progress"},
+		{"\x1b]8;;https://example.com\x07link\x1b]8;;\x07", "link"},
+		{"\x1b]0;title\x1b\\text", "text"},
+		{"\x1b(Bcharset", "charset"},
+		{"\x1b7saved\x1b8", "saved"},
+		{"windows line\r\n", "windows line"},
+		{"trailing cr\r", "trailing cr"},
+		{"10%\r100%", "10%\n100%"},
+		{"bell\x07 and nul\x00 and del\x7f", "bell  and nul  and del "},
+		{"c1\u009bcontrol", "c1 control"},
+		{"café ©", "café ©"},
+		{"\x1b[31", ""},
+	} {
+		d := NewSanitizeDecoder()
+		out, err := d.Decode(tc.in)
+		require.NoError(t, err)
+		assert.Equal(t, tc.out, out, "%q", tc.in)
+	}
+}
+
+func TestSanitizeDecoderSplitSequence(t *testing.T) {
+	d := NewSanitizeDecoder()
+	decode := func(source, content string) string {
+		entry, ok, err := d.DecodeEntry(LogEntry{Content: content, Source: source})
+		require.NoError(t, err)
+		require.True(t, ok)
+		return entry.Content
+	}
+	assert.Equal(t, "ERROR disk full ", decode("a", "ERROR disk full \x1b[3"))
+	assert.Equal(t, "other source", decode("b", "other source"))
+	assert.Equal(t, "next line", decode("a", "1mnext line\x1b[0m"))
+
+	assert.Equal(t, "link ", decode("a", "link \x1b]8;;https://exa"))
+	assert.Equal(t, "text", decode("a", "mple.com\x1b\\text"))
+}
+
+func TestSanitizeSameHash(t *testing.T) {
+	plain := "ERROR failed to connect to db-1:5432: connection refused"
+	colored := "\x1b[1;31mERROR\x1b[0m failed to connect to \x1b[36mdb-1:5432\x1b[0m: connection refused\r"
+	require.NotEqual(t, NewPattern(plain).Hash(), NewPattern(colored).Hash())
+	sanitized, err := NewSanitizeDecoder().Decode(colored)
+	require.NoError(t, err)
+	assert.Equal(t, NewPattern(plain).Hash(), NewPattern(sanitized).Hash())
+
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithSanitize(true), WithDecoder(DockerJSONDecoder{}))
+	require.NoError(t, err)
+	ch <- LogEntry{Content: plain, Timestamp: time.Now()}
+	ch <- LogEntry{Content: `{"log":"\u001b[31mERROR\u001b[0m failed to connect to db-2:5432: connection refused\n","stream":"stderr","time":"2024-01-01T00:00:00Z"}`, Timestamp: time.Now()}
+	require.NoError(t, p.StopAndDrain(time.Second))
+	counters := p.GetCounters()
+	require.Len(t, counters, 1)
+	assert.Equal(t, 2, counters[0].Messages)
+	assert.Equal(t, NewPattern(plain).Hash(), counters[0].Hash)
+}
@@ -0,0 +1,26 @@
+package logparser
+
+import "time"
+
+// seenRange tracks the earliest and latest message timestamps of a counter.
+// Zero timestamps are ignored.
+type seenRange struct {
+	first, last time.Time
+}
+
+func (r *seenRange) add(ts time.Time) {
+	if ts.IsZero() {
+		return
+	}
+	if r.first.IsZero() || ts.Before(r.first) {
+		r.first = ts
+	}
+	if ts.After(r.last) {
+		r.last = ts
+	}
+}
+
+func (r *seenRange) merge(o seenRange) {
+	r.add(o.first)
+	r.add(o.last)
+}
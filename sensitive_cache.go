@@ -0,0 +1,113 @@
+package logparser
+
+import (
+	"container/list"
+	"sync"
+)
+
+// detectionCacheRefresh is the number of cache hits after which a hash gets
+// a full detection again, to pick up secrets of the patterns skipped since.
+const detectionCacheRefresh = 16
+
+// detectionCache remembers which sensitive patterns matched the last message
+// of each pattern hash, as the messages of a pattern almost always have the
+// same outcome: a secret sits in one of its variable parts. Later messages of
+// the hash only run the regexes that matched before, to extract the new
+// values, and skip the others. Hashes without any match aren't cached, so a
+// pattern whose first messages are clean is still inspected in full, and
+// every detectionCacheRefresh-th hit of a hash runs every pattern again. The
+// least recently used hashes are evicted beyond size. It is safe for
+// concurrent use by the sensitive workers.
+type detectionCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// order holds the *detectionCacheEntry values, most recently used
+	// first.
+	order *list.List
+}
+
+type detectionCacheEntry struct {
+	hash string
+	// patterns identifies the pattern set matched holds the outcome of, so
+	// that entries computed before ReloadSensitivePatterns are ignored.
+	patterns *PrecompiledPattern
+	matched  []bool
+	hits     int
+}
+
+func newDetectionCache(size int) *detectionCache {
+	return &detectionCache{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// get returns the patterns that matched the last message of hash, nil and
+// false if it is unknown for patterns or due for a full detection.
+func (c *detectionCache) get(hash string, patterns []PrecompiledPattern) ([]bool, bool) {
+	if len(patterns) == 0 {
+		return nil, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*detectionCacheEntry)
+	if entry.patterns != &patterns[0] || len(entry.matched) != len(patterns) {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	entry.hits++
+	if entry.hits%detectionCacheRefresh == 0 {
+		return nil, false
+	}
+	return entry.matched, true
+}
+
+// put records the patterns matching a message of hash, or forgets hash if
+// none did.
+func (c *detectionCache) put(hash string, patterns []PrecompiledPattern, matches []SensitivePatternMatch) {
+	if len(patterns) == 0 {
+		return
+	}
+	if len(matches) == 0 {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if e, ok := c.entries[hash]; ok {
+			c.order.Remove(e)
+			delete(c.entries, hash)
+		}
+		return
+	}
+	matched := make([]bool, len(patterns))
+	for _, m := range matches {
+		for i := range patterns {
+			if patterns[i].Name == m.Name {
+				matched[i] = true
+			}
+		}
+	}
+	entry := &detectionCacheEntry{hash: hash, patterns: &patterns[0], matched: matched}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[hash]; ok {
+		entry.hits = e.Value.(*detectionCacheEntry).hits
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*detectionCacheEntry).hash)
+	}
+}
+
+// reset forgets every hash.
+func (c *detectionCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+}
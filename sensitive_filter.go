@@ -1,6 +1,7 @@
 package logparser
 
 import (
+	"fmt"
 	"math"
 	"regexp"
 	"strings"
@@ -18,14 +19,20 @@ func extractAnchors(regexStr string) []string {
 
 	// Find non-capturing groups and extract literal alternatives.
 	// Handles patterns like (?:adafruit), (?:AKIA|ASIA|ABIA), (?-i:Okta|OKTA).
+	// A group only yields anchors if every alternative has one; otherwise a
+	// line matching the anchor-less alternative would be wrongly skipped.
 	for _, m := range nonCapGroupRE.FindAllStringSubmatch(regexStr, -1) {
 		content := m[1]
+		var groupAnchors []string
 		for _, alt := range strings.Split(content, "|") {
 			lit := leadingLiteral(alt)
-			if len(lit) >= 3 {
-				anchors = append(anchors, strings.ToLower(lit))
+			if len(lit) < 3 {
+				groupAnchors = nil
+				break
 			}
+			groupAnchors = append(groupAnchors, strings.ToLower(lit))
 		}
+		anchors = append(anchors, groupAnchors...)
 	}
 
 	if len(anchors) > 0 {
@@ -77,6 +84,16 @@ func anchorMatchesLine(lowerLine string, anchors []string) bool {
 	return false
 }
 
+// allAnchorsMatchLine checks if every anchor substring appears in the lowercased line.
+func allAnchorsMatchLine(lowerLine string, anchors []string) bool {
+	for _, a := range anchors {
+		if !strings.Contains(lowerLine, a) {
+			return false
+		}
+	}
+	return true
+}
+
 // shannonEntropy calculates the Shannon entropy of a string in bits per character.
 // Real secrets (API keys, tokens) have high entropy (~4-6 bits).
 // Normal strings (English words, table names) have low entropy (~2-3 bits).
@@ -161,3 +178,76 @@ func dedupStrings(ss []string) []string {
 	}
 	return out
 }
+
+const (
+	// EntropyDetectorName is the pattern name reported by the entropy-based
+	// generic secret detector.
+	EntropyDetectorName = "high_entropy_secret"
+
+	defaultEntropyMinLength = 20
+	defaultEntropyThreshold = 4.0
+)
+
+// entropyKeywords are the assignment-like keywords after which the entropy
+// detector looks for a secret value. They double as pre-filter anchors.
+var entropyKeywords = []string{
+	"token", "secret", "password", "passwd", "pwd", "apikey", "api_key", "api-key",
+	"access_key", "access-key", "auth", "credential", "private_key", "client_secret",
+}
+
+// NewEntropyDetector returns a pattern that flags high-entropy values of at
+// least minLength characters assigned to a secret-like keyword, as in
+// `token=...`, `password: ...` or `secret "..."`. A value is reported when its
+// Shannon entropy is at least threshold bits per character. Zero values
+// select the defaults (20 characters, 4.0 bits).
+func NewEntropyDetector(minLength int, threshold float64) PrecompiledPattern {
+	if minLength <= 0 {
+		minLength = defaultEntropyMinLength
+	}
+	if threshold <= 0 {
+		threshold = defaultEntropyThreshold
+	}
+	keywords := make([]string, len(entropyKeywords))
+	for i, k := range entropyKeywords {
+		keywords[i] = regexp.QuoteMeta(k)
+	}
+	re := regexp.MustCompile(fmt.Sprintf(
+		`(?i)(?:%s)[\w.-]{0,20}["']?\s*(?:[:=]|=>)?\s*["'\x60]?([A-Za-z0-9+/_.~-]{%d,})`,
+		strings.Join(keywords, "|"), minLength))
+	return PrecompiledPattern{
+		Name:        EntropyDetectorName,
+		Pattern:     re,
+		Anchors:     entropyKeywords,
+		Confidence:  "medium",
+		Severity:    "high",
+		Category:    "credentials",
+		SecretGroup: 1,
+		Validator: func(s string) bool {
+			s = strings.TrimRight(s, "=")
+			return len(s) >= minLength && !uuid.MatchString(s) && shannonEntropy(s) >= threshold
+		},
+	}
+}
+
+// PrivateKeyDetectorName is the pattern name reported by the private key
+// detector (see NewPrivateKeyDetector).
+const PrivateKeyDetectorName = "private_key"
+
+// privateKeyRegex matches a PEM private key block. The END marker is
+// optional: if it was cut off (e.g. by the multiline size limit) the match
+// extends over the base64 body following the BEGIN marker.
+var privateKeyRegex = regexp.MustCompile(`(?i)-----BEGIN[ A-Z0-9_-]{0,100}PRIVATE KEY(?: BLOCK)?-----(?:[\s\S]*?-----END[ A-Z0-9_-]{0,100}PRIVATE KEY(?: BLOCK)?-----|[A-Za-z0-9+/=\s]*)`)
+
+// NewPrivateKeyDetector returns a pattern that flags PEM private keys in
+// multiline messages. It triggers on the BEGIN marker alone, so a key is
+// reported even if its END marker is missing.
+func NewPrivateKeyDetector() PrecompiledPattern {
+	return PrecompiledPattern{
+		Name:       PrivateKeyDetectorName,
+		Pattern:    privateKeyRegex,
+		Anchors:    []string{"-----begin"},
+		Confidence: "high",
+		Severity:   "critical",
+		Category:   "credentials",
+	}
+}
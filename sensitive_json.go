@@ -0,0 +1,294 @@
+package logparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SensitiveJSONFieldName is the pattern name reported when a JSON field is
+// flagged because of its key (e.g. "password") rather than its value.
+const SensitiveJSONFieldName = "sensitive-json-field"
+
+// DefaultSensitiveJSONKeys are the field names treated as sensitive by
+// DetectSensitiveDataJSON when no list is given.
+var DefaultSensitiveJSONKeys = []string{
+	"password", "passwd", "pwd", "secret", "token", "apikey", "api_key",
+	"accesskey", "access_key", "authorization", "credential", "credentials",
+	"privatekey", "private_key", "clientsecret", "client_secret", "cookie",
+}
+
+// DetectSensitiveDataJSON is like DetectAllSensitiveData, but if the line is
+// a JSON object or array it walks the decoded document instead of scanning
+// the serialized text:
+//   - a non-empty scalar field whose key matches sensitiveKeys is reported as
+//     SensitiveJSONFieldName;
+//   - every string or number value is checked against the patterns, so
+//     JSON-escaped secrets are decoded before matching, and so are HTML
+//     entities in strings.
+//
+// Each match carries the JSONPath of its field (e.g. "$.auth.tokens[0]") and
+// its Start/End offsets are relative to that field's value. Keys are compared
+// case-insensitively and ignoring "-" and "_"; a key also matches when it ends
+// with a listed name ("db_password"). A nil sensitiveKeys selects
+// DefaultSensitiveJSONKeys. Lines that are not JSON, or that nest more than
+// 64 levels deep, fall back to DetectAllSensitiveData.
+func DetectSensitiveDataJSON(line string, hash string, precompiledPatterns []PrecompiledPattern, sensitiveKeys []string) []SensitivePatternMatch {
+	return detectSensitiveDataJSON(line, hash, precompiledPatterns, normalizeJSONKeys(sensitiveKeys), detectPerPattern, nil, nil)
+}
+
+// detectSensitiveDataJSON implements DetectSensitiveDataJSON, reporting every
+// match in the detectAll mode and the first one of each pattern otherwise.
+func detectSensitiveDataJSON(line string, hash string, precompiledPatterns []PrecompiledPattern, keys []string, mode detectMode, global *Allowlist, budget *detectionBudget) []SensitivePatternMatch {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") || jsonTooDeep(trimmed) {
+		return detectSensitiveData(line, hash, precompiledPatterns, mode, global, budget)
+	}
+	var doc interface{}
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		return detectSensitiveData(line, hash, precompiledPatterns, mode, global, budget)
+	}
+	w := jsonWalker{hash: hash, patterns: precompiledPatterns, keys: keys, mode: mode, global: global, budget: budget, seen: map[string]bool{}}
+	w.walk("$", "", doc)
+	return w.matches
+}
+
+type jsonWalker struct {
+	hash     string
+	patterns []PrecompiledPattern
+	keys     []string
+	mode     detectMode
+	global   *Allowlist
+	budget   *detectionBudget
+	seen     map[string]bool // pattern names already reported
+	matches  []SensitivePatternMatch
+}
+
+func (w *jsonWalker) walk(path, key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			w.walk(path+"."+k, k, v[k])
+		}
+	case []interface{}:
+		for i, item := range v {
+			w.walk(path+"["+strconv.Itoa(i)+"]", key, item)
+		}
+	case nil:
+	default:
+		value := fmt.Sprint(v)
+		if value == "" {
+			return
+		}
+		if key != "" && isSensitiveJSONKey(key, w.keys) && !w.global.Allowed(value, value) {
+			w.add(SensitivePatternMatch{
+				Name:                SensitiveJSONFieldName,
+				Severity:            "high",
+				Category:            "credentials",
+				End:                 len(value),
+				SecretEnd:           len(value),
+				Value:               value,
+				JSONPath:            path,
+				sensitivePatternKey: sensitivePatternKey{name: SensitiveJSONFieldName, hash: w.hash},
+				hash:                w.hash,
+			})
+			return
+		}
+		if s, ok := jsonScalarText(v); ok {
+			for _, m := range detectSensitiveData(s, w.hash, w.patterns, w.mode, w.global, w.budget) {
+				m.JSONPath = path
+				w.add(m)
+			}
+		}
+	}
+}
+
+// add keeps the first match of each pattern, mirroring DetectAllSensitiveData,
+// or every match in the detectAll mode.
+func (w *jsonWalker) add(m SensitivePatternMatch) {
+	if w.seen[m.Name] && w.mode != detectAll {
+		return
+	}
+	w.seen[m.Name] = true
+	w.matches = append(w.matches, m)
+}
+
+func normalizeJSONKey(k string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(k))
+}
+
+func normalizeJSONKeys(keys []string) []string {
+	if keys == nil {
+		keys = DefaultSensitiveJSONKeys
+	}
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k = normalizeJSONKey(k); k != "" {
+			res = append(res, k)
+		}
+	}
+	return dedupStrings(res)
+}
+
+func isSensitiveJSONKey(key string, normalizedKeys []string) bool {
+	key = normalizeJSONKey(key)
+	for _, k := range normalizedKeys {
+		if strings.HasSuffix(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonScalarText returns the text matched against the patterns of a decoded
+// JSON value: strings with their HTML entities (e.g. "&quot;") decoded, and
+// numbers as written. Other values have none.
+func jsonScalarText(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		if strings.IndexByte(v, '&') >= 0 {
+			v = html.UnescapeString(v)
+		}
+		return v, true
+	case json.Number:
+		return string(v), true
+	}
+	return "", false
+}
+
+// redactJSONLine masks the secrets of line like RedactSensitiveDataWithConfig,
+// and if line is a JSON document, also the ones only found in its decoded
+// values (see DetectSensitiveDataJSON): the value of a field whose key is in
+// keys (normalized, nil for none) is masked whatever its type, and string or
+// number values with a match are replaced by their redacted text. The rest of
+// the document is kept byte for byte.
+func redactJSONLine(line string, patterns []PrecompiledPattern, keys []string, global *Allowlist, cfg RedactionConfig) string {
+	redacted, _ := redactSensitiveData(redactJSONValues(line, patterns, keys, global, cfg), patterns, global, cfg)
+	return redacted
+}
+
+// redactJSONValues implements the JSON part of redactJSONLine, returning line
+// unchanged if it isn't a JSON object or array.
+func redactJSONValues(line string, patterns []PrecompiledPattern, keys []string, global *Allowlist, cfg RedactionConfig) string {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") || jsonTooDeep(trimmed) {
+		return line
+	}
+	// The decoder is run token by token to get the raw byte range of every
+	// scalar value. A frame is an object or array being decoded, with the key
+	// of its current value; arrays inherit the key of their field.
+	type frame struct {
+		object, expectKey bool
+		key               string
+	}
+	type edit struct {
+		start, end int
+		value      string
+	}
+	var (
+		stack []frame
+		edits []edit
+		prev  int
+	)
+	offset := strings.Index(line, trimmed)
+	dec := json.NewDecoder(strings.NewReader(trimmed))
+	dec.UseNumber()
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return line
+		}
+		start, end := prev, int(dec.InputOffset())
+		for start < end && strings.IndexByte(" \t\r\n:,", trimmed[start]) >= 0 {
+			start++
+		}
+		prev = end
+		var top *frame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		if top != nil && top.object && top.expectKey {
+			if k, ok := tok.(string); ok {
+				top.key, top.expectKey = k, false
+				continue
+			}
+		}
+		key := ""
+		if top != nil {
+			key = top.key
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			stack = append(stack, frame{object: tok == json.Delim('{'), expectKey: true, key: key})
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 && dec.More() {
+				return line
+			}
+			if len(stack) > 0 && stack[len(stack)-1].object {
+				stack[len(stack)-1].expectKey = true
+			}
+			continue
+		}
+		if top != nil && top.object {
+			top.expectKey = true
+		}
+		value, ok := jsonScalarText(tok)
+		if !ok {
+			if b, isBool := tok.(bool); isBool {
+				value = strconv.FormatBool(b)
+			}
+		}
+		if value == "" {
+			continue
+		}
+		switch {
+		case key != "" && isSensitiveJSONKey(key, keys) && !global.Allowed(value, value):
+			edits = append(edits, edit{start, end, quoteJSON(maskSecret(value, SensitiveJSONFieldName, cfg))})
+		case ok:
+			if redacted, matches := redactSensitiveData(value, patterns, global, cfg); len(matches) > 0 {
+				edits = append(edits, edit{start, end, quoteJSON(redacted)})
+			}
+		}
+	}
+	if len(edits) == 0 {
+		return line
+	}
+	var b strings.Builder
+	b.Grow(len(line))
+	last := 0
+	for _, e := range edits {
+		b.WriteString(line[last : offset+e.start])
+		b.WriteString(e.value)
+		last = offset + e.end
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// quoteJSON returns s as a JSON string, without escaping HTML characters.
+func quoteJSON(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return `""`
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
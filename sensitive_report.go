@@ -0,0 +1,213 @@
+package logparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// sarifSchema is the JSON schema of the documents written by
+// WriteSensitiveReportSARIF.
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// ReportMeta describes the scan of a sensitive data report.
+type ReportMeta struct {
+	// ToolName is the name of the scanner; "logparser" if empty.
+	ToolName       string
+	ToolVersion    string
+	InformationURI string
+	// Patterns redact the samples of the report, which are never written
+	// unredacted; the embedded patterns of every confidence if nil.
+	Patterns  []PrecompiledPattern
+	Redaction RedactionConfig
+}
+
+// SensitiveReport is the document written by WriteSensitiveReportJSON.
+type SensitiveReport struct {
+	Tool        string            `json:"tool"`
+	ToolVersion string            `json:"tool_version,omitempty"`
+	Findings    []SensitiveExport `json:"findings"`
+}
+
+// WriteSensitiveReportJSON writes counters to w as a SensitiveReport JSON
+// document, sorted like the sensitive counters of MarshalCountersJSON.
+func WriteSensitiveReportJSON(w io.Writer, counters []SensitiveLogCounter, meta ReportMeta) error {
+	counters, err := meta.redact(counters)
+	if err != nil {
+		return err
+	}
+	report := SensitiveReport{Tool: meta.toolName(), ToolVersion: meta.ToolVersion, Findings: make([]SensitiveExport, 0, len(counters))}
+	for _, c := range counters {
+		report.Findings = append(report.Findings, SensitiveExport{Name: c.Name, Category: c.Category, Severity: c.Severity, Hash: c.Hash, Sample: c.Sample, Messages: c.Messages, Source: c.Source, FirstSeen: c.FirstSeen, LastSeen: c.LastSeen})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// WriteSensitiveReportSARIF writes counters to w as a SARIF 2.1.0 log with a
+// single run: every sensitive pattern is a rule and every counter a result
+// carrying its redacted sample, number of messages and pattern hash. The
+// source of a counter, if any, is the location of its result. Critical and
+// high severities are reported as errors, medium as warnings and the others
+// as notes.
+func WriteSensitiveReportSARIF(w io.Writer, counters []SensitiveLogCounter, meta ReportMeta) error {
+	counters, err := meta.redact(counters)
+	if err != nil {
+		return err
+	}
+	driver := sarifDriver{Name: meta.toolName(), Version: meta.ToolVersion, InformationURI: meta.InformationURI, Rules: []sarifRule{}}
+	rules := map[string]int{}
+	results := make([]sarifResult, 0, len(counters))
+	for _, c := range counters {
+		level := sarifLevel(c.Severity)
+		i, ok := rules[c.Name]
+		if !ok {
+			i = len(driver.Rules)
+			rules[c.Name] = i
+			driver.Rules = append(driver.Rules, sarifRule{
+				ID:                   c.Name,
+				Name:                 c.Name,
+				ShortDescription:     sarifMessage{Text: fmt.Sprintf("Sensitive data matched by the %s pattern", c.Name)},
+				DefaultConfiguration: sarifConfiguration{Level: level},
+				Properties:           map[string]any{"category": c.Category, "severity": c.Severity},
+			})
+		}
+		r := sarifResult{
+			RuleID:              c.Name,
+			RuleIndex:           i,
+			Level:               level,
+			Message:             sarifMessage{Text: fmt.Sprintf("%s found in %d messages: %s", c.Name, c.Messages, c.Sample)},
+			PartialFingerprints: map[string]string{"patternHash": c.Hash},
+			Properties: map[string]any{
+				"count":        c.Messages,
+				"occurrences":  c.Occurrences,
+				"uniqueValues": c.UniqueValues,
+				"patternHash":  c.Hash,
+				"sample":       c.Sample,
+				"firstSeen":    c.FirstSeen.UTC().Format(time.RFC3339Nano),
+				"lastSeen":     c.LastSeen.UTC().Format(time.RFC3339Nano),
+			},
+		}
+		if c.Source != "" {
+			uri := (&url.URL{Path: filepath.ToSlash(c.Source)}).String()
+			r.Locations = []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: uri}}}}
+		}
+		results = append(results, r)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}}})
+}
+
+func (m ReportMeta) toolName() string {
+	if m.ToolName == "" {
+		return "logparser"
+	}
+	return m.ToolName
+}
+
+// redact returns a sorted copy of counters with redacted samples.
+func (m ReportMeta) redact(counters []SensitiveLogCounter) ([]SensitiveLogCounter, error) {
+	patterns := m.Patterns
+	if patterns == nil {
+		var err error
+		if patterns, err = getOrLoadPatterns("low", slog.Default()); err != nil {
+			return nil, err
+		}
+	}
+	res := make([]SensitiveLogCounter, len(counters))
+	for i, c := range counters {
+		c.Sample, _ = RedactSensitiveDataWithConfig(c.Sample, patterns, m.Redaction)
+		res[i] = c
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Hash < b.Hash
+	})
+	return res, nil
+}
+
+// sarifLevel maps a pattern severity to a SARIF result level.
+func sarifLevel(severity string) string {
+	switch severity {
+	case "critical", "high":
+		return "error"
+	case "medium":
+		return "warning"
+	}
+	return "note"
+}
+
+// sarifLog is the subset of the SARIF 2.1.0 object model written by
+// WriteSensitiveReportSARIF.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           map[string]any     `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          map[string]any    `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
@@ -0,0 +1,83 @@
+package logparser
+
+import (
+	"context"
+	"time"
+)
+
+// sensitiveJobsPerWorker is the number of messages that can wait for each
+// sensitive detection worker before the counting goroutine blocks.
+const sensitiveJobsPerWorker = 64
+
+// sensitiveJob is a message waiting for sensitive detection, together with
+// the pattern set and allowlist in effect when it was counted.
+type sensitiveJob struct {
+	msg       Message
+	pattern   *Pattern
+	patterns  []PrecompiledPattern
+	allowlist *Allowlist
+	// cache is the detection cache, nil if disabled.
+	cache *detectionCache
+}
+
+// detect runs sensitive detection on the job's message within budget,
+// returning every occurrence of each pattern. It doesn't touch the parser
+// state, so it can run without the lock.
+func (j sensitiveJob) detect(jsonKeys []string, budget *detectionBudget) []SensitivePatternMatch {
+	content := j.msg.detectionContent()
+	if jsonKeys != nil {
+		return detectSensitiveDataJSON(content, j.pattern.Hash(), j.patterns, jsonKeys, detectAll, j.allowlist, budget)
+	}
+	hash := j.pattern.Hash()
+	if j.cache == nil {
+		return detectSensitiveData(content, hash, j.patterns, detectAll, j.allowlist, budget)
+	}
+	if matched, ok := j.cache.get(hash, j.patterns); ok {
+		// A message without the secrets found before may have others.
+		if matches := detectMatchedBefore(content, hash, j.patterns, matched, j.allowlist, budget); len(matches) > 0 || budget.aborted() {
+			return matches
+		}
+	}
+	matches := detectSensitiveData(content, hash, j.patterns, detectAll, j.allowlist, budget)
+	if !budget.aborted() {
+		// The outcome of an aborted detection is partial.
+		j.cache.put(hash, j.patterns, matches)
+	}
+	return matches
+}
+
+// detect runs job and records how long it took and whether it ran out of
+// the detection budget.
+func (p *Parser) detect(job sensitiveJob) []SensitivePatternMatch {
+	start := time.Now()
+	budget := newDetectionBudget(p.detectionBudget)
+	matches := job.detect(p.sensitiveJSONKeys, budget)
+	p.detectionLatency.observe(time.Since(start))
+	if budget.aborted() {
+		p.detectionTimeouts.Add(1)
+	}
+	return matches
+}
+
+// runSensitiveWorker detects sensitive data in queued messages until the
+// queue is closed or ctx is done. Only the counter updates take a lock.
+func (p *Parser) runSensitiveWorker(ctx context.Context) {
+	defer p.sensitiveWG.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-p.sensitiveJobs:
+			if !ok {
+				return
+			}
+			matches := p.detect(job)
+			p.lock.RLock()
+			p.sensitiveLock.Lock()
+			notify := p.recordSensitiveMatches(job.msg, job.pattern, matches)
+			p.sensitiveLock.Unlock()
+			p.lock.RUnlock()
+			p.notifySensitiveMatches(job.msg, notify, job.patterns)
+		}
+	}
+}
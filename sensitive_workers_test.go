@@ -0,0 +1,59 @@
+package logparser
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParserSensitiveWorkers(t *testing.T) {
+	const n = 2000
+	lines := make([]string, n)
+	for i := range lines {
+		switch i % 3 {
+		case 0:
+			lines[i] = fmt.Sprintf("push %d failed with token ghp_%036d", i, i)
+		case 1:
+			lines[i] = fmt.Sprintf("aws call %d failed for AKIAI44QH8DHBQ%06d", i, i)
+		default:
+			lines[i] = fmt.Sprintf("request %d failed", i)
+		}
+	}
+	type count struct {
+		name     string
+		messages int
+	}
+	run := func(opts ...ParserOption) []count {
+		ch := make(chan LogEntry, n)
+		opts = append([]ParserOption{WithSensitiveConfig(SensitiveConfig{Enabled: true, MinConfidence: "high"}), WithMultilineTimeout(time.Minute)}, opts...)
+		p, err := NewParserWithOptions(ch, opts...)
+		require.NoError(t, err)
+		for _, line := range lines {
+			ch <- LogEntry{Timestamp: time.Now(), Content: line, Level: LevelError}
+		}
+		require.NoError(t, p.StopAndDrain(10*time.Second))
+		byName := map[string]int{}
+		for _, c := range p.GetSensitiveCounters() {
+			byName[c.Name] += c.Messages
+		}
+		var res []count
+		for name, messages := range byName {
+			res = append(res, count{name: name, messages: messages})
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+		return res
+	}
+
+	inline := run()
+	require.NotEmpty(t, inline)
+	assert.Contains(t, inline, count{name: "github-pat", messages: (n + 2) / 3})
+	assert.Equal(t, inline, run(WithSensitiveWorkers(4)))
+	assert.Equal(t, inline, run(WithSensitiveWorkers(1)))
+
+	_, err := NewParserWithOptions(make(chan LogEntry), WithSensitiveWorkers(0))
+	assert.EqualError(t, err, "sensitive workers must be positive, got 0")
+}
@@ -0,0 +1,400 @@
+package logparser
+
+import (
+	"crypto/md5"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// levelShards is the number of pattern shards: one per level.
+const levelShards = int(LevelDebug) + 1
+
+// maxPatternAliases caps the number of alias keys recorded per pattern.
+// Further variants still find the pattern with the WeakEqual scan.
+var maxPatternAliases = 64
+
+// maxPatternDigests caps the number of message digests recorded per pattern.
+// Messages with further digests still find the pattern by its hash.
+var maxPatternDigests = 64
+
+// patternShard holds the pattern counters of one level. Messages of
+// different levels never share a counter, so each shard has its own lock and
+// the WeakEqual scan only covers the patterns of the message's level.
+type patternShard struct {
+	lock     sync.Mutex
+	patterns map[patternKey]*patternStat
+	// aliases maps the keys of patterns that were merged into another one by
+	// WeakEqual to the key of that pattern, so that later messages with the
+	// same hash skip the WeakEqual scan.
+	aliases map[patternKey]patternKey
+	// digests maps the digests of the patterns of counted messages to the
+	// key of their counter, so that the messages of existing patterns are
+	// counted without allocating their pattern. Only the digests of
+	// messages whose hash finds the counter without the WeakEqual scan are
+	// recorded, up to maxPatternDigests per pattern; they are dropped with
+	// the pattern on eviction.
+	digests map[digestKey]patternKey
+	// classified is the number of patterns with a counter of their own per
+	// limit scope (see limitScope), limited by patternsLimit.
+	classified map[string]int
+	// sources indexes the patterns by source if the limits are per source.
+	sources map[string]map[patternKey]*patternStat
+	// messages is the number of messages counted in the shard; unlike the
+	// pattern counters it is never reset.
+	messages uint64
+	// known holds the keys of the patterns ever created with the new
+	// pattern callback set, so that a pattern evicted or reset and created
+	// again isn't reported as new.
+	known map[patternKey]struct{}
+}
+
+// digestKey is the key of patternShard.digests.
+type digestKey struct {
+	source string
+	level  Level
+	digest [md5.Size]byte
+}
+
+// shard returns the shard holding the counters of level.
+func (p *Parser) shard(level Level) *patternShard {
+	if level < 0 || int(level) >= levelShards {
+		level = LevelUnknown
+	}
+	return &p.shards[level]
+}
+
+// lockShards locks every shard, in order, for operations that need a
+// consistent view of all counters.
+func (p *Parser) lockShards() {
+	for i := range p.shards {
+		p.shards[i].lock.Lock()
+	}
+}
+
+func (p *Parser) unlockShards() {
+	for i := range p.shards {
+		p.shards[i].lock.Unlock()
+	}
+}
+
+// countPattern counts msg in its shard and returns the hash of its counter
+// and the new pattern and spike callbacks due.
+// It reports false, without counting, if msg needs a new counter and the
+// WithMaxPatterns limit is reached, unless full is set, in which case the
+// message goes to the level's catch-all counter instead.
+func (p *Parser) countPattern(msg Message, lp *lazyPattern, full bool) (string, patternEvents, bool) {
+	s := p.shard(msg.Level)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var stat *patternStat
+	var key patternKey
+	created := false
+	var events patternEvents
+	if isLowLevel(msg.Level) && !p.patternizeAllLevels {
+		key = patternKey{source: msg.Source, level: msg.Level, hash: ""}
+		if stat = s.patterns[key]; stat == nil {
+			if !full && !p.hasRoomForPattern(msg.Source) {
+				return "", patternEvents{}, false
+			}
+			stat = p.newPatternStat(s, key, &patternStat{})
+		}
+	} else {
+		dk, digested := lp.digestKey()
+		if digested {
+			if k, ok := s.digests[dk]; ok {
+				stat, key = s.patterns[k], k
+			}
+		}
+		if stat == nil {
+			var ok, direct bool
+			if stat, key, created, direct, ok = p.countNewPattern(s, msg, lp.get(), full); !ok {
+				return "", patternEvents{}, false
+			}
+			if digested && direct && len(stat.digests) < maxPatternDigests {
+				s.digests[dk] = key
+				stat.digests = append(stat.digests, dk)
+			}
+			if created {
+				events.created = p.checkNewPattern(s, key, stat, msg.Timestamp)
+			}
+		}
+	}
+	if !created && stat.recent != nil && p.samplesPerPattern > 1 {
+		stat.addRecent(p.sample(msg), p.samplesPerPattern)
+	}
+	if p.onMsgCb != nil {
+		p.onMsgCb(msg.Timestamp, msg.Level, key.hash, msg.Content)
+	}
+	if p.onMessageCb != nil {
+		p.onMessageCb(msg, key.hash)
+	}
+	s.messages++
+	stat.messages++
+	stat.bytes += int64(len(msg.Content))
+	stat.seen.add(msg.Timestamp)
+	stat.rate.add(msg.Timestamp, p.rateWindow)
+	if p.histogramBuckets > 0 {
+		stat.histogram.add(msg.Timestamp, 1, p.histogramBuckets)
+	}
+	if d := p.spikeDetector; d != nil && stat.spike.add(msg.Timestamp, d) {
+		events.spike = &SpikeEvent{Level: key.level, Hash: key.hash, Source: key.source, Sample: stat.sample, At: msg.Timestamp, Messages: stat.spike.messages, Baseline: stat.spike.baseline, Window: d.Window}
+	}
+	return key.hash, events, true
+}
+
+// countNewPattern finds the counter of a message whose pattern digest isn't
+// known: the counter of its hash or of a similar pattern, else a new one.
+// direct reports whether the hash of the message finds that counter without
+// the WeakEqual scan from now on, so that its digest can be recorded. It
+// reports false if a new counter is needed but the WithMaxPatterns limit is
+// reached, unless full is set. The caller must hold the shard lock.
+func (p *Parser) countNewPattern(s *patternShard, msg Message, pattern *Pattern, full bool) (stat *patternStat, key patternKey, created, direct, ok bool) {
+	key = patternKey{source: msg.Source, level: msg.Level, hash: pattern.Hash()}
+	if stat = s.patterns[key]; stat == nil {
+		if k, ok := s.aliases[key]; ok {
+			stat, key = s.patterns[k], k
+		}
+	}
+	direct = stat != nil
+	if stat == nil {
+		// The most similar pattern wins, then the smallest hash, so that
+		// the counters don't depend on the map order.
+		var best patternKey
+		bestSimilarity := -1.0
+		for k, ps := range s.patterns {
+			if k.source != msg.Source || ps.pattern == nil || !ps.pattern.weakEqual(pattern, p.mergeThreshold) {
+				continue
+			}
+			if sim := ps.pattern.Similarity(pattern); sim > bestSimilarity || sim == bestSimilarity && k.hash < best.hash {
+				best, bestSimilarity = k, sim
+			}
+		}
+		if bestSimilarity >= 0 {
+			ps := s.patterns[best]
+			if len(ps.aliases) < maxPatternAliases {
+				s.aliases[key] = best
+				ps.aliases = append(ps.aliases, key)
+				direct = true
+			}
+			stat, key = ps, best
+		}
+	}
+	if stat == nil {
+		switch {
+		case s.classified[p.limitScope(msg.Source)] >= p.patternsLimit(msg.Level) || full:
+			stat, key = p.getFallbackPatternStat(s, msg.Source, msg.Level)
+		case !p.hasRoomForPattern(msg.Source):
+			return nil, key, false, false, false
+		default:
+			sample := p.redact(msg.Content)
+			stat = p.newPatternStat(s, key, &patternStat{pattern: pattern, sample: sample, template: PatternTemplate(sample)})
+			if msg.Truncated {
+				stat.sample, stat.sampleTruncated = truncatedSample(Message{Content: sample, Lines: msg.Lines}), true
+			}
+			if msg.Lines > 1 {
+				stat.exceptionClass, _, _ = parseStackTrace(msg.Content)
+			}
+			stat.attributes = extractAttributes(sample, p.attributes)
+			stat.recent = []string{stat.sample}
+			s.classified[p.limitScope(msg.Source)]++
+			created, direct = true, true
+		}
+	}
+	return stat, key, created, direct, true
+}
+
+// sample returns the redacted sample of msg, marked if it was truncated.
+func (p *Parser) sample(msg Message) string {
+	sample := p.redact(msg.Content)
+	if msg.Truncated {
+		return truncatedSample(Message{Content: sample, Lines: msg.Lines})
+	}
+	return sample
+}
+
+// addRecent adds sample to the recent samples of ps, replacing the oldest
+// one once there are n.
+func (ps *patternStat) addRecent(sample string, n int) {
+	if len(ps.recent) < n {
+		ps.recent = append(ps.recent, sample)
+		return
+	}
+	ps.recent[ps.recentNext] = sample
+	ps.recentNext = (ps.recentNext + 1) % len(ps.recent)
+}
+
+// recentSamples returns a copy of the recent samples of ps, oldest first.
+func (ps *patternStat) recentSamples() []string {
+	if len(ps.recent) == 0 {
+		return nil
+	}
+	res := make([]string, 0, len(ps.recent))
+	res = append(res, ps.recent[ps.recentNext:]...)
+	return append(res, ps.recent[:ps.recentNext]...)
+}
+
+// isLowLevel reports whether messages of level are counted without patterns
+// unless WithPatternizeAllLevels is set.
+func isLowLevel(level Level) bool {
+	return level == LevelUnknown || level == LevelDebug || level == LevelInfo
+}
+
+// patternsLimit returns the maximum number of patterns of level.
+func (p *Parser) patternsLimit(level Level) int {
+	if isLowLevel(level) {
+		return p.lowLevelPatternsLimit
+	}
+	return p.patternsPerLevelLimit
+}
+
+// newPatternStat adds stat to s under key; the caller must hold the shard
+// lock.
+func (p *Parser) newPatternStat(s *patternShard, key patternKey, stat *patternStat) *patternStat {
+	s.patterns[key] = stat
+	p.patternCount.Add(1)
+	if p.limitsPerSource {
+		p.sourcePatternCount(key.source).Add(1)
+		if s.sources == nil {
+			s.sources = map[string]map[patternKey]*patternStat{}
+		}
+		if s.sources[key.source] == nil {
+			s.sources[key.source] = map[patternKey]*patternStat{}
+		}
+		s.sources[key.source][key] = stat
+	}
+	p.addEvictionCandidate(s, key, stat)
+	return stat
+}
+
+// removePatternStat removes the counter of key from s; the caller must hold
+// the shard lock.
+func (p *Parser) removePatternStat(s *patternShard, key patternKey) {
+	delete(s.patterns, key)
+	p.patternCount.Add(-1)
+	if p.limitsPerSource {
+		p.sourcePatternCount(key.source).Add(-1)
+		delete(s.sources[key.source], key)
+	}
+}
+
+// limitScope returns the key of the pattern counts the limits apply to for
+// the messages of source: source itself if the limits are per source, ""
+// otherwise.
+func (p *Parser) limitScope(source string) string {
+	if p.limitsPerSource {
+		return source
+	}
+	return ""
+}
+
+// sourcePatternCount returns the number of counters of source, see
+// limitsPerSource.
+func (p *Parser) sourcePatternCount(source string) *atomic.Int64 {
+	if n, ok := p.sourcePatterns.Load(source); ok {
+		return n.(*atomic.Int64)
+	}
+	n, _ := p.sourcePatterns.LoadOrStore(source, new(atomic.Int64))
+	return n.(*atomic.Int64)
+}
+
+// getFallbackPatternStat returns the catch-all counter of the level, which
+// collects messages that can't get a pattern of their own. The caller must
+// hold the shard lock.
+func (p *Parser) getFallbackPatternStat(s *patternShard, source string, level Level) (*patternStat, patternKey) {
+	fallbackKey := patternKey{source: source, level: level, hash: unclassifiedPatternHash}
+	stat := s.patterns[fallbackKey]
+	if stat == nil {
+		stat = p.newPatternStat(s, fallbackKey, &patternStat{sample: unclassifiedPatternLabel})
+	}
+	return stat, fallbackKey
+}
+
+// hasRoomForPattern reports whether a new counter of source fits under the
+// WithMaxPatterns limit.
+func (p *Parser) hasRoomForPattern(source string) bool {
+	if p.maxPatterns <= 0 {
+		return true
+	}
+	if p.limitsPerSource {
+		return p.sourcePatternCount(source).Load() < int64(p.maxPatterns)
+	}
+	return p.patternCount.Load() < int64(p.maxPatterns)
+}
+
+// makeRoomForPattern evicts the patterns with the fewest messages, across
+// all shards, until a new one of source fits under the WithMaxPatterns
+// limit. Only the patterns of source are evicted if the limits are per
+// source. Evicted messages are merged into the catch-all counter of their
+// level. It reports false if there is nothing left to evict.
+func (p *Parser) makeRoomForPattern(source string) bool {
+	p.lockShards()
+	defer p.unlockShards()
+	for !p.hasRoomForPattern(source) {
+		e, ok := p.nextEvictionVictim(source)
+		if !ok {
+			return false
+		}
+		victimShard, victimKey, victim := e.shard, e.key, e.stat
+		p.removePatternStat(victimShard, victimKey)
+		for _, alias := range victim.aliases {
+			delete(victimShard.aliases, alias)
+		}
+		for _, dk := range victim.digests {
+			delete(victimShard.digests, dk)
+		}
+		victimShard.classified[p.limitScope(victimKey.source)]--
+		p.evictions.Add(1)
+		fallback, _ := p.getFallbackPatternStat(victimShard, victimKey.source, victimKey.level)
+		fallback.messages += victim.messages
+		fallback.bytes += victim.bytes
+		fallback.seen.merge(victim.seen)
+		fallback.histogram.merge(victim.histogram, p.histogramBuckets)
+	}
+	return true
+}
+
+// shardCounters appends the counters of s whose last message is not older
+// than since to res; the caller must hold the shard lock.
+func shardCounters(res []LogCounter, s *patternShard, since time.Time) []LogCounter {
+	for k, ps := range s.patterns {
+		if !since.IsZero() && ps.seen.last.Before(since) {
+			continue
+		}
+		res = append(res, newLogCounter(k, ps))
+	}
+	return res
+}
+
+// sourceCounters returns the counters of source; the limits must be per
+// source.
+func (p *Parser) sourceCounters(source string) []LogCounter {
+	var res []LogCounter
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.lock.Lock()
+		for k, ps := range s.sources[source] {
+			res = append(res, newLogCounter(k, ps))
+		}
+		s.lock.Unlock()
+	}
+	return res
+}
+
+// newLogCounter returns the counter of ps; the caller must hold the shard
+// lock.
+func newLogCounter(k patternKey, ps *patternStat) LogCounter {
+	return LogCounter{Level: k.level, Hash: k.hash, Pattern: ps.template, Sample: ps.sample, Samples: ps.recentSamples(), SampleTruncated: ps.sampleTruncated, ExceptionClass: ps.exceptionClass, Attributes: copyAttributes(ps.attributes), Messages: ps.messages, Bytes: ps.bytes, Source: k.source, FirstSeen: ps.seen.first, LastSeen: ps.seen.last, RatePerMinute: ps.rate.perMinute(), Last5mCount: ps.rate.last(5),
+		Histogram: ps.histogram.counts(), HistogramStart: ps.histogram.start, HistogramBucket: ps.histogram.bucket, HashVersion: HashVersion, SpikeBaseline: ps.spike.baselinePerMinute(), LastSpike: ps.spike.lastSpike}
+}
+
+// resetShard zeroes the message and byte counts of s; the caller must hold
+// the shard lock.
+func (p *Parser) resetShard(s *patternShard) {
+	p.evictionStale.Store(true)
+	for _, ps := range s.patterns {
+		ps.messages = 0
+		ps.bytes = 0
+	}
+}
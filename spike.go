@@ -0,0 +1,172 @@
+package logparser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The defaults of NewSpikeDetector.
+const (
+	defaultSpikeWindow      = time.Minute
+	defaultSpikeHalfLife    = 30 * time.Minute
+	defaultSpikeCooldown    = 10 * time.Minute
+	defaultSpikeMinMessages = 10
+	defaultSpikeWarmup      = 5
+)
+
+// SpikeEvent describes a pattern whose rate exceeds its baseline.
+type SpikeEvent struct {
+	Level  Level
+	Hash   string
+	Source string
+	// Sample is the redacted sample of the counter of the pattern.
+	Sample string
+	// At is the timestamp of the message that made the spike.
+	At time.Time
+	// Messages is the number of messages of the pattern in the window
+	// ending at At, Baseline the number expected from its history.
+	Messages int
+	Baseline float64
+	Window   time.Duration
+}
+
+// SpikeCallbackF is called when a pattern spikes.
+type SpikeCallbackF func(event SpikeEvent)
+
+// SpikeDetector flags the patterns whose rate suddenly exceeds their usual
+// one. It keeps an exponentially weighted baseline of the number of messages
+// per Window of every pattern, and calls OnSpike when the messages of the
+// current window exceed the baseline × Factor. Windows follow the message
+// timestamps rather than the wall clock, so replaying historical logs flags
+// the same spikes as live ingestion. Set it on a parser with
+// WithSpikeDetector; its fields must not change afterwards.
+type SpikeDetector struct {
+	// Factor is the ratio of the window rate to the baseline above which a
+	// pattern spikes.
+	Factor float64
+	// Window is the length of the windows the rate is measured over. They
+	// are aligned to the Unix epoch.
+	Window time.Duration
+	// HalfLife is the age at which a window weighs half as much as the
+	// current one in the baseline.
+	HalfLife time.Duration
+	// Cooldown is the minimum time between two spikes of a pattern, so that
+	// a burst straddling windows is reported once.
+	Cooldown time.Duration
+	// MinMessages is the minimum number of messages in a window for a spike,
+	// so that a rare pattern showing up twice isn't flagged.
+	MinMessages int
+	// Warmup is the number of windows since the first message of a pattern
+	// before its baseline is trusted.
+	Warmup  int
+	OnSpike SpikeCallbackF
+}
+
+// NewSpikeDetector returns a SpikeDetector calling cb when a pattern gets
+// more than factor times its baseline in a minute, with a 30 minute half
+// life, a 10 minute cooldown, at least 10 messages and 5 minutes of history.
+func NewSpikeDetector(factor float64, cb SpikeCallbackF) *SpikeDetector {
+	return &SpikeDetector{
+		Factor:      factor,
+		Window:      defaultSpikeWindow,
+		HalfLife:    defaultSpikeHalfLife,
+		Cooldown:    defaultSpikeCooldown,
+		MinMessages: defaultSpikeMinMessages,
+		Warmup:      defaultSpikeWarmup,
+		OnSpike:     cb,
+	}
+}
+
+func (d *SpikeDetector) validate() error {
+	switch {
+	case d.Factor <= 1:
+		return fmt.Errorf("spike factor must be greater than 1, got %g", d.Factor)
+	case d.Window <= 0:
+		return fmt.Errorf("spike window must be positive, got %s", d.Window)
+	case d.HalfLife < d.Window:
+		return fmt.Errorf("spike half life must be at least the window, got %s", d.HalfLife)
+	case d.Cooldown < 0 || d.MinMessages < 0 || d.Warmup < 0:
+		return errors.New("spike cooldown, min messages and warmup must not be negative")
+	case d.OnSpike == nil:
+		return errors.New("spike detector has no callback")
+	}
+	return nil
+}
+
+// alpha is the weight of the last window in the baseline.
+func (d *SpikeDetector) alpha() float64 {
+	return 1 - math.Pow(0.5, float64(d.Window)/float64(d.HalfLife))
+}
+
+// spikeState is the spike detection state of a pattern.
+type spikeState struct {
+	// start is the start of the current window of length window, messages
+	// its number of messages.
+	start    time.Time
+	window   time.Duration
+	messages int
+	// windows is the number of windows folded into baseline, the mean
+	// number of messages per window.
+	windows   int
+	baseline  float64
+	lastSpike time.Time
+}
+
+// add counts a message at ts and reports whether it makes the pattern spike.
+// Messages before the current window and zero timestamps are ignored.
+func (s *spikeState) add(ts time.Time, d *SpikeDetector) bool {
+	if ts.IsZero() {
+		return false
+	}
+	start := ts.Truncate(d.Window)
+	if s.start.IsZero() {
+		s.start, s.window = start, d.Window
+	}
+	if start.Before(s.start) {
+		return false
+	}
+	if start.After(s.start) {
+		// Fold the current window and the empty ones since into the
+		// baseline. The first windows are averaged so that the baseline
+		// doesn't start from 0.
+		alpha := d.alpha()
+		n := int(start.Sub(s.start) / d.Window)
+		count := s.messages
+		for i := 0; i < n; i++ {
+			s.windows++
+			w := 1 / float64(s.windows)
+			if w <= alpha {
+				if count == 0 {
+					// The remaining empty windows only decay the
+					// baseline.
+					s.baseline *= math.Pow(1-alpha, float64(n-i))
+					s.windows += n - i - 1
+					break
+				}
+				w = alpha
+			}
+			s.baseline += w * (float64(count) - s.baseline)
+			count = 0
+		}
+		s.start, s.messages = start, 0
+	}
+	s.messages++
+	if s.windows < d.Warmup || s.messages < d.MinMessages || float64(s.messages) <= s.baseline*d.Factor {
+		return false
+	}
+	if !s.lastSpike.IsZero() && ts.Sub(s.lastSpike) < d.Cooldown {
+		return false
+	}
+	s.lastSpike = ts
+	return true
+}
+
+// baselinePerMinute returns the baseline in messages per minute.
+func (s *spikeState) baselinePerMinute() float64 {
+	if s.window == 0 {
+		return 0
+	}
+	return s.baseline * float64(time.Minute) / float64(s.window)
+}
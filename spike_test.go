@@ -0,0 +1,92 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpikeDetector(t *testing.T) {
+	var events []SpikeEvent
+	p, err := NewParserWithOptions(make(chan LogEntry), WithSpikeDetector(NewSpikeDetector(10, func(e SpikeEvent) {
+		events = append(events, e)
+	})))
+	require.NoError(t, err)
+	defer p.Stop()
+
+	// 30 minutes of 5 timeouts and 20 slow queries per minute, a burst of
+	// 100 timeouts in the 31st minute, then 15 quiet minutes.
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	var timeoutHash string
+	for minute := 0; minute < 46; minute++ {
+		timeouts := 5
+		if minute == 30 {
+			timeouts = 100
+		}
+		for i := 0; i < 20; i++ {
+			ts := start.Add(time.Duration(minute)*time.Minute + time.Duration(i)*time.Second)
+			p.CountMessage(Message{Timestamp: ts, Content: "WARN slow query took 120ms", Level: LevelWarning})
+			if i < timeouts {
+				timeoutHash = p.CountMessage(Message{Timestamp: ts, Content: "ERROR connection to db-1 timed out", Level: LevelError}).PatternHash
+			}
+		}
+		for i := 20; i < timeouts; i++ {
+			ts := start.Add(time.Duration(minute)*time.Minute + time.Duration(i)*500*time.Millisecond)
+			p.CountMessage(Message{Timestamp: ts, Content: "ERROR connection to db-1 timed out", Level: LevelError})
+		}
+	}
+
+	require.Len(t, events, 1)
+	e := events[0]
+	assert.Equal(t, timeoutHash, e.Hash)
+	assert.Equal(t, LevelError, e.Level)
+	assert.Equal(t, "ERROR connection to db-1 timed out", e.Sample)
+	assert.Equal(t, 51, e.Messages)
+	assert.InDelta(t, 5, e.Baseline, 0.01)
+	assert.Equal(t, time.Minute, e.Window)
+	assert.Equal(t, start.Add(30*time.Minute), e.At.Truncate(time.Minute))
+
+	for _, c := range p.GetCounters() {
+		if c.Hash == timeoutHash {
+			assert.Equal(t, e.At, c.LastSpike)
+			assert.Greater(t, c.SpikeBaseline, 5.0)
+		} else {
+			assert.True(t, c.LastSpike.IsZero())
+			assert.InDelta(t, 20, c.SpikeBaseline, 0.01)
+		}
+	}
+}
+
+func TestSpikeStateDecay(t *testing.T) {
+	d := NewSpikeDetector(10, func(SpikeEvent) {})
+	d.MinMessages = 0
+	var s spikeState
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for minute := 0; minute < 60; minute++ {
+		s.add(start.Add(time.Duration(minute)*time.Minute), d)
+	}
+	assert.InDelta(t, 1, s.baselinePerMinute(), 1e-9)
+
+	// An hour without messages halves the baseline twice.
+	assert.False(t, s.add(start.Add(2*time.Hour), d))
+	assert.InDelta(t, 0.25, s.baselinePerMinute(), 1e-9)
+	assert.Equal(t, 120, s.windows)
+
+	// Messages before the current window are ignored.
+	assert.False(t, s.add(start, d))
+	assert.Equal(t, 1, s.messages)
+}
+
+func TestWithSpikeDetector(t *testing.T) {
+	for _, d := range []*SpikeDetector{
+		nil,
+		NewSpikeDetector(1, func(SpikeEvent) {}),
+		NewSpikeDetector(10, nil),
+		{Factor: 10, Window: time.Hour, HalfLife: time.Minute, OnSpike: func(SpikeEvent) {}},
+	} {
+		_, err := NewParserWithOptions(make(chan LogEntry), WithSpikeDetector(d))
+		assert.Error(t, err)
+	}
+}
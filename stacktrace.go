@@ -0,0 +1,106 @@
+package logparser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultFrameworkPrefixes are the frames skipped by the stack trace
+// fingerprint of WithStackTraceFingerprint unless other prefixes are given:
+// JDK, common Java frameworks and the Python standard library.
+var DefaultFrameworkPrefixes = []string{
+	"java.", "javax.", "jdk.", "sun.", "com.sun.", "kotlin.", "scala.",
+	"org.springframework.", "org.apache.", "org.hibernate.", "io.netty.", "reactor.",
+	"/usr/lib/python", "/usr/local/lib/python", "<frozen ",
+}
+
+var (
+	javaFrame     = regexp.MustCompile(`^\s*at\s+(?:[\w.$-]+/)?([\w$.<>]+)\(`)
+	javaException = regexp.MustCompile(`\b((?:[a-zA-Z_$][\w$]*\.)*[A-Z][\w$]*(?:Exception|Error|Throwable))\b`)
+	pythonFrame   = regexp.MustCompile(`^\s*File "([^"]+)", line \d+, in (\S+)`)
+	pythonClass   = regexp.MustCompile(`^([A-Za-z_][\w.]*(?:Error|Exception|Exit|Interrupt|Warning|Iteration))\b`)
+)
+
+// parseStackTrace returns the exception class and the frames, innermost
+// first and without line numbers, of the Java or Python stack trace in
+// content. ok is false if content has no stack trace.
+func parseStackTrace(content string) (class string, frames []string, ok bool) {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "Traceback (most recent call last)") {
+			return parsePythonTraceback(lines[i+1:])
+		}
+	}
+	for _, line := range lines {
+		if m := javaFrame.FindStringSubmatch(line); m != nil {
+			if class == "" {
+				// No exception before the first frame.
+				return "", nil, false
+			}
+			frames = append(frames, m[1])
+			continue
+		}
+		if len(frames) > 0 {
+			// The frames of the top exception end at "Caused by:" or
+			// "... N more".
+			break
+		}
+		if class == "" {
+			if m := javaException.FindStringSubmatch(line); m != nil {
+				class = m[1]
+			}
+		}
+	}
+	return class, frames, len(frames) > 0
+}
+
+// parsePythonTraceback parses the lines following "Traceback (most recent
+// call last):", whose innermost frame is the last one.
+func parsePythonTraceback(lines []string) (class string, frames []string, ok bool) {
+	for _, line := range lines {
+		if m := pythonFrame.FindStringSubmatch(line); m != nil {
+			frames = append([]string{m[1] + ":" + m[2]}, frames...)
+			continue
+		}
+		if len(frames) > 0 && line != "" && line[0] != ' ' && line[0] != '\t' {
+			if m := pythonClass.FindStringSubmatch(line); m != nil {
+				return m[1], frames, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// stackTracePattern returns the fingerprint pattern of a stack trace: its
+// exception class and its innermost n frames, skipping the ones starting with
+// one of skip unless there are no others. Fingerprint patterns are never
+// merged with similar ones.
+func stackTracePattern(class string, frames []string, n int, skip []string) *Pattern {
+	words := []string{class}
+	for _, f := range frames {
+		if len(words) > n {
+			break
+		}
+		if !hasAnyPrefix(f, skip) {
+			words = append(words, f)
+		}
+	}
+	if len(words) == 1 {
+		for _, f := range frames {
+			if len(words) > n {
+				break
+			}
+			words = append(words, f)
+		}
+	}
+	return &Pattern{words: words, exact: true}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
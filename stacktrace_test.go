@@ -0,0 +1,92 @@
+package logparser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func npe(message string, frames ...string) string {
+	lines := []string{"2024-01-15 10:00:00 ERROR [main] request failed", "java.lang.NullPointerException: " + message}
+	for _, f := range frames {
+		lines = append(lines, "\tat "+f)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParseStackTrace(t *testing.T) {
+	class, frames, ok := parseStackTrace(npe("x is null",
+		"java.base/java.util.Objects.requireNonNull(Objects.java:209)",
+		"com.example.OrderService.place(OrderService.java:42)",
+		"org.springframework.web.servlet.FrameworkServlet.service(FrameworkServlet.java:897)",
+	) + "\nCaused by: java.io.IOException: closed\n\tat com.example.Db.query(Db.java:7)")
+	require.True(t, ok)
+	assert.Equal(t, "java.lang.NullPointerException", class)
+	assert.Equal(t, []string{"java.util.Objects.requireNonNull", "com.example.OrderService.place", "org.springframework.web.servlet.FrameworkServlet.service"}, frames)
+
+	class, frames, ok = parseStackTrace(`ERROR:root:job failed
+Traceback (most recent call last):
+  File "/usr/local/lib/python3.11/runpy.py", line 198, in _run_module_as_main
+  File "/app/jobs/sync.py", line 12, in run
+    client.fetch()
+  File "/app/jobs/client.py", line 40, in fetch
+    raise ConnectionError("refused")
+requests.exceptions.ConnectionError: refused`)
+	require.True(t, ok)
+	assert.Equal(t, "requests.exceptions.ConnectionError", class)
+	assert.Equal(t, []string{"/app/jobs/client.py:fetch", "/app/jobs/sync.py:run", "/usr/local/lib/python3.11/runpy.py:_run_module_as_main"}, frames)
+
+	_, _, ok = parseStackTrace("ERROR request failed\nretrying in 5s")
+	assert.False(t, ok)
+	_, _, ok = parseStackTrace("java.lang.NullPointerException: no frames")
+	assert.False(t, ok)
+}
+
+func TestStackTraceFingerprint(t *testing.T) {
+	traces := []string{
+		npe("order is null", "java.base/java.util.Objects.requireNonNull(Objects.java:209)", "com.example.OrderService.place(OrderService.java:42)", "com.example.Api.post(Api.java:10)"),
+		npe("user is null", "java.base/java.util.Objects.requireNonNull(Objects.java:209)", "com.example.UserService.load(UserService.java:17)", "com.example.Api.get(Api.java:20)"),
+		// The first call site again, from another version and route.
+		npe("order 42 is null", "java.base/java.util.Objects.requireNonNull(Objects.java:211)", "com.example.OrderService.place(OrderService.java:45)",
+			"org.springframework.web.servlet.FrameworkServlet.service(FrameworkServlet.java:897)", "org.apache.catalina.core.ApplicationFilterChain.doFilter(ApplicationFilterChain.java:166)"),
+	}
+	counters := func(opts ...ParserOption) []LogCounter {
+		ch := make(chan LogEntry)
+		p, err := NewParserWithOptions(ch, opts...)
+		require.NoError(t, err)
+		for _, trace := range traces {
+			for _, line := range strings.Split(trace, "\n") {
+				ch <- LogEntry{Content: line, Level: LevelUnknown}
+			}
+		}
+		close(ch)
+		<-p.Done()
+		return p.GetCounters()
+	}
+
+	// By default, the whole traces make up the patterns.
+	res := counters()
+	require.Len(t, res, 3)
+	for _, c := range res {
+		assert.Equal(t, "java.lang.NullPointerException", c.ExceptionClass)
+	}
+
+	res = counters(WithStackTraceFingerprint(1))
+	require.Len(t, res, 2)
+	messages := map[string]int{}
+	for _, c := range res {
+		assert.Equal(t, "java.lang.NullPointerException", c.ExceptionClass)
+		messages[strings.Split(c.Sample, "\n")[1]] = c.Messages
+	}
+	assert.Equal(t, map[string]int{"java.lang.NullPointerException: order is null": 2, "java.lang.NullPointerException: user is null": 1}, messages)
+
+	// With only framework frames, the fingerprint falls back to them.
+	p := stackTracePattern("java.lang.NullPointerException", []string{"java.util.Objects.requireNonNull"}, 2, DefaultFrameworkPrefixes)
+	assert.Equal(t, "java.lang.NullPointerException java.util.Objects.requireNonNull", p.String())
+	assert.False(t, p.WeakEqual(&Pattern{words: []string{"java.lang.NullPointerException", "java.util.Objects.checkIndex"}}))
+
+	_, err := NewParserWithOptions(make(chan LogEntry), WithStackTraceFingerprint(0))
+	assert.Error(t, err)
+}
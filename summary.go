@@ -0,0 +1,351 @@
+package logparser
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// ReportFormat is the output format of GenerateReport.
+type ReportFormat string
+
+const (
+	ReportMarkdown ReportFormat = "markdown"
+	ReportText     ReportFormat = "text"
+)
+
+// The defaults of ReportOptions.
+const (
+	defaultReportTitle       = "Log report"
+	defaultReportSectionRows = 10
+	defaultReportSampleWidth = 120
+)
+
+// ReportOptions configures GenerateReport. Section limits of 0 mean the
+// default of 10 rows, negative ones hide the section.
+type ReportOptions struct {
+	// Format is ReportMarkdown if empty.
+	Format ReportFormat
+	// Title is "Log report" if empty.
+	Title string
+	// Baseline holds the counters of a reference period, e.g. before a
+	// deployment: the patterns of the counters missing from it, by level and
+	// hash, are listed as new. The section is left out if Baseline is nil.
+	Baseline []LogCounter
+
+	MaxErrorPatterns   int
+	MaxNewPatterns     int
+	MaxSensitive       int
+	MaxClusterPatterns int
+	// MaxSampleWidth is the number of characters of the samples and
+	// templates, cut to their first line; 120 if 0.
+	MaxSampleWidth int
+
+	// Redact masks the secrets of the samples of counters and clustered
+	// patterns. The samples of sensitive findings are always redacted.
+	Redact bool
+	// RedactPatterns are the patterns redacting samples; the embedded ones
+	// of every confidence if nil.
+	RedactPatterns []PrecompiledPattern
+	Redaction      RedactionConfig
+}
+
+// reportSection is a table of a report.
+type reportSection struct {
+	title   string
+	columns []string
+	// numeric marks the columns aligned right in Markdown.
+	numeric []bool
+	// code is the index of the column of log content, shown as code in
+	// Markdown; -1 if none.
+	code  int
+	rows  [][]string
+	empty string
+}
+
+// GenerateReport returns a summary of counters, sensitive findings and
+// clustered patterns meant to be pasted into a ticket: the volume of
+// messages by level, the top error patterns, the patterns that are new since
+// opts.Baseline, the sensitive findings and the top clustered patterns. Any
+// of the inputs may be empty. The report only depends on its inputs, so the
+// same inputs give the same report.
+func GenerateReport(counters []LogCounter, sensitive []SensitiveLogCounter, patterns []LogPattern, opts ReportOptions) (string, error) {
+	format := opts.Format
+	if format == "" {
+		format = ReportMarkdown
+	}
+	if format != ReportMarkdown && format != ReportText {
+		return "", fmt.Errorf("unknown report format %q, want markdown or text", format)
+	}
+	redactPatterns := opts.RedactPatterns
+	if redactPatterns == nil {
+		var err error
+		if redactPatterns, err = getOrLoadPatterns("low", slog.Default()); err != nil {
+			return "", err
+		}
+	}
+	width := opts.MaxSampleWidth
+	if width <= 0 {
+		width = defaultReportSampleWidth
+	}
+	sample := func(s string, redact bool) string {
+		if redact {
+			s, _ = RedactSensitiveDataWithConfig(s, redactPatterns, opts.Redaction)
+		}
+		return reportSample(s, width)
+	}
+
+	var sections []reportSection
+	sections = append(sections, levelSection(counters))
+	if limit := sectionLimit(opts.MaxErrorPatterns); limit > 0 {
+		s := reportSection{title: "Top error patterns", columns: []string{"Messages", "Level", "Hash", "Sample"}, numeric: []bool{true}, code: 3, empty: "No error messages."}
+		var errorCounters []LogCounter
+		for _, c := range counters {
+			if c.Level == LevelError || c.Level == LevelCritical {
+				errorCounters = append(errorCounters, c)
+			}
+		}
+		sortCounters(errorCounters)
+		for _, c := range errorCounters[:min(limit, len(errorCounters))] {
+			s.rows = append(s.rows, []string{fmt.Sprint(c.Messages), c.Level.String(), shortHash(c.Hash), sample(c.Sample, opts.Redact)})
+		}
+		sections = append(sections, s)
+	}
+	if limit := sectionLimit(opts.MaxNewPatterns); limit > 0 && opts.Baseline != nil {
+		s := reportSection{title: "New patterns", columns: []string{"Messages", "Level", "Hash", "Sample"}, numeric: []bool{true}, code: 3, empty: "No new patterns since the baseline."}
+		known := map[patternKey]bool{}
+		for _, c := range opts.Baseline {
+			known[patternKey{level: c.Level, hash: c.Hash}] = true
+		}
+		var added []LogCounter
+		for _, c := range counters {
+			if !known[patternKey{level: c.Level, hash: c.Hash}] {
+				added = append(added, c)
+			}
+		}
+		sortCounters(added)
+		for _, c := range added[:min(limit, len(added))] {
+			s.rows = append(s.rows, []string{fmt.Sprint(c.Messages), c.Level.String(), shortHash(c.Hash), sample(c.Sample, opts.Redact)})
+		}
+		sections = append(sections, s)
+	}
+	if limit := sectionLimit(opts.MaxSensitive); limit > 0 {
+		s := reportSection{title: "Sensitive findings", columns: []string{"Messages", "Pattern", "Severity", "Category", "Sample"}, numeric: []bool{true}, code: 4, empty: "No sensitive data found."}
+		sorted := append([]SensitiveLogCounter(nil), sensitive...)
+		sort.Slice(sorted, func(i, j int) bool {
+			a, b := sorted[i], sorted[j]
+			if la, lb := severityLevel(a.Severity), severityLevel(b.Severity); la != lb {
+				return la > lb
+			}
+			if a.Messages != b.Messages {
+				return a.Messages > b.Messages
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			if a.Source != b.Source {
+				return a.Source < b.Source
+			}
+			return a.Hash < b.Hash
+		})
+		for _, c := range sorted[:min(limit, len(sorted))] {
+			s.rows = append(s.rows, []string{fmt.Sprint(c.Messages), c.Name, c.Severity, c.Category, sample(c.Sample, true)})
+		}
+		sections = append(sections, s)
+	}
+	if limit := sectionLimit(opts.MaxClusterPatterns); limit > 0 && len(patterns) > 0 {
+		s := reportSection{title: "Top clustered patterns", columns: []string{"Logs", "Share", "Template"}, numeric: []bool{true, true}, code: 2}
+		sorted := append([]LogPattern(nil), patterns...)
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].Count != sorted[j].Count {
+				return sorted[i].Count > sorted[j].Count
+			}
+			return sorted[i].Hash < sorted[j].Hash
+		})
+		for _, p := range sorted[:min(limit, len(sorted))] {
+			s.rows = append(s.rows, []string{fmt.Sprint(p.Count), fmt.Sprintf("%.1f%%", p.Percentage), sample(p.Template, opts.Redact)})
+		}
+		sections = append(sections, s)
+	}
+
+	title := opts.Title
+	if title == "" {
+		title = defaultReportTitle
+	}
+	var b strings.Builder
+	if format == ReportMarkdown {
+		fmt.Fprintf(&b, "# %s\n", title)
+	} else {
+		fmt.Fprintf(&b, "%s\n%s\n", title, strings.Repeat("=", utf8.RuneCountInString(title)))
+	}
+	if period := reportPeriod(counters, patterns); period != "" {
+		fmt.Fprintf(&b, "\n%s\n", period)
+	}
+	for _, s := range sections {
+		b.WriteString("\n")
+		if format == ReportMarkdown {
+			writeMarkdownSection(&b, s)
+		} else {
+			writeTextSection(&b, s)
+		}
+	}
+	return b.String(), nil
+}
+
+// levelSection is the volume of messages by level, most severe first.
+func levelSection(counters []LogCounter) reportSection {
+	s := reportSection{title: "Volume by level", columns: []string{"Level", "Messages", "Share", "Bytes"}, numeric: []bool{false, true, true, true}, code: -1, empty: "No messages."}
+	stats := map[Level]LevelStats{}
+	total := 0
+	for _, c := range counters {
+		st := stats[c.Level]
+		st.Messages += c.Messages
+		st.Bytes += c.Bytes
+		stats[c.Level] = st
+		total += c.Messages
+	}
+	for _, l := range []Level{LevelCritical, LevelError, LevelWarning, LevelInfo, LevelDebug, LevelUnknown} {
+		st, ok := stats[l]
+		if !ok || total == 0 {
+			continue
+		}
+		s.rows = append(s.rows, []string{l.String(), fmt.Sprint(st.Messages), fmt.Sprintf("%.1f%%", 100*float64(st.Messages)/float64(total)), fmt.Sprint(st.Bytes)})
+	}
+	return s
+}
+
+// sortCounters sorts counters by decreasing number of messages, then by
+// level, hash and source.
+func sortCounters(counters []LogCounter) {
+	sort.Slice(counters, func(i, j int) bool {
+		a, b := counters[i], counters[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		if a.Hash != b.Hash {
+			return a.Hash < b.Hash
+		}
+		return a.Source < b.Source
+	})
+}
+
+func sectionLimit(n int) int {
+	if n == 0 {
+		return defaultReportSectionRows
+	}
+	return n
+}
+
+// shortHash returns the first 8 characters of a pattern hash, enough to
+// tell the patterns of a report apart.
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+// reportSample returns the first line of s cut to width characters, with an
+// ellipsis if anything was left out.
+func reportSample(s string, width int) string {
+	first, rest, multiline := strings.Cut(strings.TrimSpace(s), "\n")
+	first = strings.ReplaceAll(strings.TrimRight(first, "\r"), "\t", " ")
+	if utf8.RuneCountInString(first) > width {
+		runes := []rune(first)
+		return string(runes[:width-1]) + "…"
+	}
+	if multiline && strings.TrimSpace(rest) != "" {
+		return first + " …"
+	}
+	return first
+}
+
+// reportPeriod describes the time span of the inputs, if they have
+// timestamps.
+func reportPeriod(counters []LogCounter, patterns []LogPattern) string {
+	var first, last time.Time
+	add := func(f, l time.Time) {
+		if !f.IsZero() && (first.IsZero() || f.Before(first)) {
+			first = f
+		}
+		if l.After(last) {
+			last = l
+		}
+	}
+	for _, c := range counters {
+		add(c.FirstSeen, c.LastSeen)
+	}
+	for _, p := range patterns {
+		add(p.FirstSeen, p.LastSeen)
+	}
+	if first.IsZero() || last.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("Period: %s to %s (%s)", first.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339), last.Sub(first).Round(time.Second))
+}
+
+func writeMarkdownSection(b *strings.Builder, s reportSection) {
+	fmt.Fprintf(b, "## %s\n\n", s.title)
+	if len(s.rows) == 0 {
+		fmt.Fprintf(b, "_%s_\n", s.empty)
+		return
+	}
+	b.WriteString("|")
+	for _, c := range s.columns {
+		fmt.Fprintf(b, " %s |", c)
+	}
+	b.WriteString("\n|")
+	for i := range s.columns {
+		if i < len(s.numeric) && s.numeric[i] {
+			b.WriteString(" ---: |")
+		} else {
+			b.WriteString(" --- |")
+		}
+	}
+	b.WriteString("\n")
+	for _, row := range s.rows {
+		b.WriteString("|")
+		for i, cell := range row {
+			if i == s.code {
+				cell = markdownCode(cell)
+			} else {
+				cell = strings.ReplaceAll(cell, "|", `\|`)
+			}
+			fmt.Fprintf(b, " %s |", cell)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// markdownCode returns s as a code span of a table cell.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	s = strings.ReplaceAll(s, "|", `\|`)
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
+
+func writeTextSection(b *strings.Builder, s reportSection) {
+	fmt.Fprintf(b, "%s\n", strings.ToUpper(s.title))
+	if len(s.rows) == 0 {
+		fmt.Fprintf(b, "  %s\n", s.empty)
+		return
+	}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  %s\n", strings.Join(s.columns, "\t"))
+	for _, row := range s.rows {
+		fmt.Fprintf(w, "  %s\n", strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
@@ -0,0 +1,254 @@
+package logparser
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogTimestampLayouts are the timestamp formats accepted in RFC3164
+// headers, longest first: the standard "Oct  6 14:00:00", the same with
+// fractional seconds or a year, as written by some daemons, and RFC3339 as
+// written by rsyslog in high precision mode.
+var syslogTimestampLayouts = []string{
+	time.StampMicro,
+	time.StampMilli,
+	"Jan _2 2006 15:04:05",
+	time.Stamp,
+	time.RFC3339Nano,
+}
+
+// SyslogMessage is a syslog line split by ParseSyslog.
+type SyslogMessage struct {
+	// Facility and Severity are decoded from the PRI value; both are -1 if
+	// the line has no PRI, as in journalctl output.
+	Facility int
+	Severity int
+	// Timestamp is zero if the header has no timestamp or it isn't in a
+	// known format.
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	Message   string
+}
+
+// Level maps the syslog severity to a Level: emerg, alert and crit to
+// LevelCritical, err to LevelError, warning to LevelWarning, notice and info
+// to LevelInfo and debug to LevelDebug.
+func (m SyslogMessage) Level() Level {
+	switch m.Severity {
+	case 0, 1, 2:
+		return LevelCritical
+	case 3:
+		return LevelError
+	case 4:
+		return LevelWarning
+	case 5, 6:
+		return LevelInfo
+	case 7:
+		return LevelDebug
+	}
+	return LevelUnknown
+}
+
+// ParseSyslog splits a syslog line in the RFC5424 or RFC3164 format into its
+// header fields and message; ok is false if line is in neither. The PRI is
+// required except for RFC3164 lines starting with a timestamp. Missing
+// RFC5424 structured data is tolerated, and an RFC3164 timestamp without a
+// year is assumed to be within the last year.
+func ParseSyslog(line string) (msg SyslogMessage, ok bool) {
+	msg = SyslogMessage{Facility: -1, Severity: -1}
+	rest := line
+	pri, n := parseSyslogPRI(line)
+	if n > 0 {
+		msg.Facility, msg.Severity = pri/8, pri%8
+		rest = line[n:]
+		if strings.HasPrefix(rest, "1 ") {
+			return parseRFC5424(msg, rest[2:])
+		}
+	}
+	return parseRFC3164(msg, rest, n > 0)
+}
+
+// parseSyslogPRI returns the PRI value at the start of line and its length,
+// or 0 if there is none.
+func parseSyslogPRI(line string) (int, int) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, 0
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return 0, 0
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, 0
+	}
+	return pri, end + 1
+}
+
+// parseRFC5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG",
+// where "-" stands for a missing value.
+func parseRFC5424(msg SyslogMessage, rest string) (SyslogMessage, bool) {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 5 {
+		return msg, false
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+		msg.Timestamp = ts
+	}
+	nilValue := func(s string) string {
+		if s == "-" {
+			return ""
+		}
+		return s
+	}
+	msg.Hostname, msg.AppName, msg.ProcID, msg.MsgID = nilValue(fields[1]), nilValue(fields[2]), nilValue(fields[3]), nilValue(fields[4])
+	if len(fields) == 6 {
+		msg.Message = strings.TrimPrefix(skipStructuredData(fields[5]), "\ufeff")
+	}
+	return msg, true
+}
+
+// skipStructuredData returns s without its leading structured data: "-" or
+// a sequence of "[id key="value" ...]" elements. s is returned unchanged if
+// it doesn't start with structured data.
+func skipStructuredData(s string) string {
+	if s == "-" {
+		return ""
+	}
+	if strings.HasPrefix(s, "- ") {
+		return s[2:]
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		end := structuredDataElementEnd(s, i)
+		if end < 0 {
+			return s
+		}
+		i = end + 1
+	}
+	return strings.TrimPrefix(s[i:], " ")
+}
+
+// structuredDataElementEnd returns the index of the "]" closing the element
+// starting at s[start], or -1 if it is unterminated. Values are quoted and
+// may contain escaped quotes and brackets.
+func structuredDataElementEnd(s string, start int) int {
+	inQuotes := false
+	for i := start + 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && inQuotes:
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ']' && !inQuotes:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRFC3164 parses "TIMESTAMP HOSTNAME TAG[PID]: MSG". With a PRI, the
+// timestamp and hostname may be missing; without one, the timestamp is
+// required.
+func parseRFC3164(msg SyslogMessage, rest string, hasPRI bool) (SyslogMessage, bool) {
+	ts, n := parseSyslogTimestamp(rest)
+	if n == 0 && !hasPRI {
+		return msg, false
+	}
+	msg.Timestamp = ts
+	rest = strings.TrimLeft(rest[n:], " ")
+	if n > 0 {
+		if host, after, found := strings.Cut(rest, " "); found && !isSyslogTag(host) {
+			msg.Hostname, rest = host, after
+		}
+	}
+	if tag, after, found := strings.Cut(rest, " "); found && isSyslogTag(tag) {
+		tag = strings.TrimSuffix(tag, ":")
+		if i := strings.IndexByte(tag, '['); i > 0 {
+			msg.ProcID = tag[i+1 : len(tag)-1]
+			tag = tag[:i]
+		}
+		msg.AppName, rest = tag, after
+	}
+	msg.Message = rest
+	return msg, true
+}
+
+// parseSyslogTimestamp returns the timestamp at the start of s and its
+// length, or 0 if there is none.
+func parseSyslogTimestamp(s string) (time.Time, int) {
+	for _, layout := range syslogTimestampLayouts {
+		n := len(layout)
+		if layout == time.RFC3339Nano {
+			n = strings.IndexByte(s, ' ')
+		}
+		if n <= 0 || n > len(s) {
+			continue
+		}
+		ts, err := time.Parse(layout, s[:n])
+		if err != nil {
+			continue
+		}
+		return withCurrentYear(ts), n
+	}
+	return time.Time{}, 0
+}
+
+// isSyslogTag reports whether s is a "tag:" or "tag[pid]:" field.
+func isSyslogTag(s string) bool {
+	if !strings.HasSuffix(s, ":") || len(s) < 2 {
+		return false
+	}
+	s = strings.TrimSuffix(s, ":")
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		if i == 0 || !strings.HasSuffix(s, "]") {
+			return false
+		}
+		s = s[:i]
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-/@", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SyslogDecoder decodes RFC5424 and RFC3164 syslog lines (see ParseSyslog),
+// stripping the header. The entry timestamp is set from the header, and the
+// syslog severity is the level of entries without one, which takes
+// precedence over the level guessed from the content. Entries without a
+// Source get "hostname/app-name" as their source, so that messages of
+// different programs are never merged. Lines that aren't syslog are passed
+// through unchanged.
+type SyslogDecoder struct{}
+
+// Decode returns the message of src.
+func (d SyslogDecoder) Decode(src string) (string, error) {
+	entry, _, err := d.DecodeEntry(LogEntry{Content: src})
+	return entry.Content, err
+}
+
+// DecodeEntry returns entry with the message, timestamp, level and source of
+// its syslog line. It always returns ok=true.
+func (d SyslogDecoder) DecodeEntry(entry LogEntry) (LogEntry, bool, error) {
+	msg, ok := ParseSyslog(entry.Content)
+	if !ok {
+		return entry, true, nil
+	}
+	entry.Content = msg.Message
+	if !msg.Timestamp.IsZero() {
+		entry.Timestamp = msg.Timestamp
+	}
+	if entry.Level == LevelUnknown {
+		entry.Level = msg.Level()
+	}
+	if entry.Source == "" && (msg.Hostname != "" || msg.AppName != "") {
+		entry.Source = msg.Hostname + "/" + msg.AppName
+	}
+	return entry, true, nil
+}
@@ -0,0 +1,147 @@
+package logparser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyslogRFC5424(t *testing.T) {
+	msg, ok := ParseSyslog(`<165>1 2023-10-06T14:00:00.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication]" eventID="1011"][other@1 a="b"] BOMAn application event log entry`)
+	require.True(t, ok)
+	assert.Equal(t, SyslogMessage{
+		Facility:  20,
+		Severity:  5,
+		Timestamp: time.Date(2023, 10, 6, 14, 0, 0, 3000000, time.UTC),
+		Hostname:  "mymachine.example.com",
+		AppName:   "evntslog",
+		ProcID:    "1234",
+		MsgID:     "ID47",
+		Message:   "BOMAn application event log entry",
+	}, msg)
+	assert.Equal(t, LevelInfo, msg.Level())
+
+	// nil values and structured data
+	msg, ok = ParseSyslog("<11>1 - host - - - - \ufeffdisk failure on /dev/sda")
+	require.True(t, ok)
+	assert.Equal(t, SyslogMessage{Facility: 1, Severity: 3, Hostname: "host", Message: "disk failure on /dev/sda"}, msg)
+	assert.Equal(t, LevelError, msg.Level())
+
+	// missing structured data and a non-standard timestamp
+	msg, ok = ParseSyslog("<10>1 06/10/2023-14:00 host app 42 - connection refused [retrying]")
+	require.True(t, ok)
+	assert.Equal(t, SyslogMessage{Facility: 1, Severity: 2, Hostname: "host", AppName: "app", ProcID: "42", Message: "connection refused [retrying]"}, msg)
+	assert.Equal(t, LevelCritical, msg.Level())
+
+	msg, ok = ParseSyslog("<15>1 2023-10-06T14:00:00+02:00 host app - -")
+	require.True(t, ok)
+	assert.Equal(t, "", msg.Message)
+	assert.Equal(t, LevelDebug, msg.Level())
+}
+
+func TestParseSyslogRFC3164(t *testing.T) {
+	year := time.Now().Year()
+	if time.Date(year, 10, 6, 14, 0, 0, 0, time.UTC).After(time.Now().AddDate(0, 0, 1)) {
+		year--
+	}
+
+	msg, ok := ParseSyslog("<134>Oct  6 14:00:00 host app[123]: request served in 12ms")
+	require.True(t, ok)
+	assert.Equal(t, SyslogMessage{
+		Facility:  16,
+		Severity:  6,
+		Timestamp: time.Date(year, 10, 6, 14, 0, 0, 0, time.UTC),
+		Hostname:  "host",
+		AppName:   "app",
+		ProcID:    "123",
+		Message:   "request served in 12ms",
+	}, msg)
+
+	// journalctl output has no PRI
+	msg, ok = ParseSyslog("Oct 06 14:00:00.123 web-1 systemd[1]: Started nginx.service.")
+	require.True(t, ok)
+	assert.Equal(t, SyslogMessage{Facility: -1, Severity: -1, Timestamp: time.Date(year, 10, 6, 14, 0, 0, 123000000, time.UTC), Hostname: "web-1", AppName: "systemd", ProcID: "1", Message: "Started nginx.service."}, msg)
+	assert.Equal(t, LevelUnknown, msg.Level())
+
+	msg, ok = ParseSyslog("<12>2023-10-06T14:00:00.5+02:00 host kernel: usb 1-1: device disconnected")
+	require.True(t, ok)
+	assert.Equal(t, "host", msg.Hostname)
+	assert.Equal(t, "kernel", msg.AppName)
+	assert.Equal(t, "usb 1-1: device disconnected", msg.Message)
+	assert.True(t, msg.Timestamp.Equal(time.Date(2023, 10, 6, 12, 0, 0, 500000000, time.UTC)))
+	assert.Equal(t, LevelWarning, msg.Level())
+
+	// no hostname, no timestamp, no tag
+	msg, ok = ParseSyslog("<11>Oct  6 14:00:00 sshd[99]: Failed password for root")
+	require.True(t, ok)
+	assert.Equal(t, "", msg.Hostname)
+	assert.Equal(t, "sshd", msg.AppName)
+	msg, ok = ParseSyslog("<11>sshd: Failed password for root")
+	require.True(t, ok)
+	assert.True(t, msg.Timestamp.IsZero())
+	assert.Equal(t, "sshd", msg.AppName)
+	assert.Equal(t, "Failed password for root", msg.Message)
+	msg, ok = ParseSyslog("<11>something odd happened")
+	require.True(t, ok)
+	assert.Equal(t, "something odd happened", msg.Message)
+}
+
+func TestSyslogDecoder(t *testing.T) {
+	d := SyslogDecoder{}
+	ts := time.Now()
+	for _, line := range []string{
+		"",
+		"plain log line",
+		"2023-10-06 14:00:00 INFO not syslog",
+		"<html><body>",
+		"<999>1 2023-10-06T14:00:00Z host app - - - out of range PRI",
+		"<1a>Oct  6 14:00:00 host app: bad PRI",
+		"<14>1 truncated",
+		"\x00\xff garbage",
+	} {
+		entry, ok, err := d.DecodeEntry(LogEntry{Content: line, Timestamp: ts})
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, LogEntry{Content: line, Timestamp: ts}, entry, line)
+	}
+
+	entry, ok, err := d.DecodeEntry(LogEntry{Content: "<131>1 2023-10-06T14:00:00Z host app 1 - - connection reset", Timestamp: ts})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, LogEntry{Content: "connection reset", Timestamp: time.Date(2023, 10, 6, 14, 0, 0, 0, time.UTC), Level: LevelError, Source: "host/app"}, entry)
+
+	entry, _, err = d.DecodeEntry(LogEntry{Content: "<131>1 2023-10-06T14:00:00Z host app 1 - - connection reset", Level: LevelWarning, Source: "node-1"})
+	require.NoError(t, err)
+	assert.Equal(t, LevelWarning, entry.Level)
+	assert.Equal(t, "node-1", entry.Source)
+
+	msg, err := d.Decode("<134>Oct  6 14:00:00 host app[123]: hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg)
+}
+
+func TestParserSyslogDecoder(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithDecoder(SyslogDecoder{}), WithMultilineTimeout(time.Minute))
+	require.NoError(t, err)
+	for _, line := range []string{
+		"<131>Oct  6 14:00:00 host app[123]: connection reset by peer",
+		"<131>Oct  6 14:00:05 host app[124]: connection reset by peer",
+		"<134>Oct  6 14:00:06 host app[124]: request served",
+	} {
+		ch <- LogEntry{Content: line, Timestamp: time.Now()}
+	}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	byLevel := map[Level]LogCounter{}
+	for _, c := range p.GetCounters() {
+		byLevel[c.Level] = c
+	}
+	require.Len(t, byLevel, 2)
+	assert.Equal(t, 2, byLevel[LevelError].Messages)
+	assert.Equal(t, "connection reset by peer", byLevel[LevelError].Sample)
+	assert.Equal(t, "host/app", byLevel[LevelError].Source)
+	assert.Equal(t, 1, byLevel[LevelInfo].Messages)
+}
@@ -1,5 +1,12 @@
 package logparser
 
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
 const (
 	lookForTimestampLimit = 100
 )
@@ -31,3 +38,181 @@ func containsTimestamp(line string) bool {
 	}
 	return false
 }
+
+// builtinTimestampLayouts are the layouts TimestampExtractor tries on the
+// start of a line, after the custom ones. Fractional seconds, with a period
+// or a comma, are accepted after the seconds of any layout.
+var builtinTimestampLayouts = []string{
+	time.RFC3339,                // 2006-01-02T15:04:05.000Z07:00
+	"2006-01-02T15:04:05",       // log4j ISO8601, without a zone
+	"2006-01-02 15:04:05Z07:00", // RFC3339 with a space
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05", // log4j, logback, Python logging
+	"2006/01/02 15:04:05", // nginx error log, Go log package
+}
+
+// clfTimestampLayout is the bracketed timestamp of the common log format,
+// e.g. nginx and Apache access logs: [02/Jan/2006:15:04:05 -0700].
+const clfTimestampLayout = "02/Jan/2006:15:04:05 -0700"
+
+// klogTimestampLayout follows the severity letter of klog headers, e.g.
+// "I0102 15:04:05.000000".
+const klogTimestampLayout = "0102 15:04:05"
+
+// TimestampExtractor reads the timestamp at the start of log lines, so that
+// replayed logs keep their original times. It recognizes RFC3339, log4j and
+// logback ("2006-01-02 15:04:05,000"), nginx error logs and the Go log
+// package ("2006/01/02 15:04:05"), klog headers, syslog timestamps and the
+// bracketed timestamp of access logs in the common log format, which may
+// follow the client address. A timestamp may be enclosed in brackets.
+// Timestamps without a zone are taken as UTC, and those without a year are
+// assumed to be within the last year. Layouts that are ambiguous, such as
+// "01/02/2006", are not built in but can be added with AddLayout. It is safe
+// for concurrent use.
+type TimestampExtractor struct {
+	lock    sync.RWMutex
+	layouts []string
+}
+
+// NewTimestampExtractor returns a TimestampExtractor with the built-in
+// layouts.
+func NewTimestampExtractor() *TimestampExtractor {
+	return &TimestampExtractor{}
+}
+
+// AddLayout registers a custom time layout, in the format of the time
+// package, which is tried before the built-in ones and the custom layouts
+// added before it.
+func (e *TimestampExtractor) AddLayout(layout string) error {
+	ref := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(layout)
+	if ref == layout {
+		return fmt.Errorf("layout %q has no time elements", layout)
+	}
+	if _, err := time.Parse(layout, ref); err != nil {
+		return fmt.Errorf("invalid layout %q: %w", layout, err)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.layouts = append([]string{layout}, e.layouts...)
+	return nil
+}
+
+// Extract returns the timestamp of line and line without it; ok is false,
+// and line is returned unchanged, if line has no timestamp in a known
+// layout.
+func (e *TimestampExtractor) Extract(line string) (ts time.Time, rest string, ok bool) {
+	if ts, n := e.parsePrefix(line); n > 0 {
+		return ts, strings.TrimLeft(line[n:], " "), true
+	}
+	if strings.HasPrefix(line, "[") {
+		if end := strings.IndexByte(line, ']'); end > 0 && end < lookForTimestampLimit {
+			if ts, n := e.parsePrefix(line[1:end]); n == end-1 {
+				return ts, strings.TrimLeft(line[end+1:], " "), true
+			}
+		}
+	}
+	if ts, start, end, ok := parseCLFTimestamp(line); ok {
+		return ts, line[:start] + strings.TrimLeft(line[end:], " "), true
+	}
+	return time.Time{}, line, false
+}
+
+// Strip returns line without its timestamp.
+func (e *TimestampExtractor) Strip(line string) string {
+	_, rest, _ := e.Extract(line)
+	return rest
+}
+
+// parsePrefix returns the timestamp at the start of s and its length, or 0 if
+// there is none. A timestamp must be followed by a space or the end of s.
+func (e *TimestampExtractor) parsePrefix(s string) (time.Time, int) {
+	e.lock.RLock()
+	custom := e.layouts
+	e.lock.RUnlock()
+	for _, layout := range custom {
+		if ts, n := parseLayoutPrefix(s, layout); n > 0 {
+			return withCurrentYear(ts), n
+		}
+	}
+	for _, layout := range builtinTimestampLayouts {
+		if ts, n := parseLayoutPrefix(s, layout); n > 0 {
+			return ts, n
+		}
+	}
+	if len(s) > 1 && strings.IndexByte("IWEF", s[0]) >= 0 {
+		if ts, n := parseLayoutPrefix(s[1:], klogTimestampLayout); n > 0 {
+			return withCurrentYear(ts), n + 1
+		}
+	}
+	if ts, n := parseSyslogTimestamp(s); n > 0 && (n == len(s) || s[n] == ' ') {
+		return ts, n
+	}
+	return time.Time{}, 0
+}
+
+// parseLayoutPrefix parses the first fields of s, as many as layout has, with
+// layout, and returns the timestamp and its length, or 0 if they don't match.
+func parseLayoutPrefix(s, layout string) (time.Time, int) {
+	end := fieldsEnd(s, len(strings.Fields(layout)))
+	if end <= 0 {
+		return time.Time{}, 0
+	}
+	ts, err := time.Parse(layout, s[:end])
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return ts, end
+}
+
+// fieldsEnd returns the index after the n-th space-separated field of s, or
+// -1 if s has fewer fields or they are too long to be a timestamp.
+func fieldsEnd(s string, n int) int {
+	i := 0
+	for ; n > 0; n-- {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i == len(s) {
+			return -1
+		}
+		for i < len(s) && s[i] != ' ' {
+			i++
+		}
+		if i > lookForTimestampLimit {
+			return -1
+		}
+	}
+	return i
+}
+
+// parseCLFTimestamp finds a bracketed common log format timestamp near the
+// start of line, and returns it with the indexes of its brackets.
+func parseCLFTimestamp(line string) (ts time.Time, start, end int, ok bool) {
+	start = strings.IndexByte(line, '[')
+	if start < 0 || start > lookForTimestampLimit {
+		return
+	}
+	n := strings.IndexByte(line[start:], ']')
+	if n != len(clfTimestampLayout)+1 {
+		return
+	}
+	ts, err := time.Parse(clfTimestampLayout, line[start+1:start+n])
+	if err != nil {
+		return
+	}
+	return ts, start, start + n + 1, true
+}
+
+// withCurrentYear sets the year of a timestamp parsed without one, assuming
+// it is within the last year.
+func withCurrentYear(ts time.Time) time.Time {
+	if ts.Year() != 0 {
+		return ts
+	}
+	now := time.Now()
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.AddDate(0, 0, 1)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts
+}
@@ -1,9 +1,12 @@
 package logparser
 
 import (
-	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestContainsTimestamp(t *testing.T) {
@@ -45,3 +48,97 @@ func BenchmarkContainsTimestamp(b *testing.B) {
 		containsTimestamp(l)
 	}
 }
+
+func TestTimestampExtractor(t *testing.T) {
+	year := time.Now().Year()
+	if time.Date(year, 1, 2, 15, 4, 5, 0, time.UTC).After(time.Now().AddDate(0, 0, 1)) {
+		year--
+	}
+	e := NewTimestampExtractor()
+	for _, tc := range []struct {
+		line string
+		ts   time.Time
+		rest string
+	}{
+		// RFC3339
+		{"2024-01-02T15:04:05Z ERROR boom", time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), "ERROR boom"},
+		{"2024-01-02T15:04:05.123456+02:00 msg", time.Date(2024, 1, 2, 13, 4, 5, 123456000, time.UTC), "msg"},
+		// log4j, logback and Python logging
+		{"2024-01-02 15:04:05,123 ERROR [main] c.e.App - failed", time.Date(2024, 1, 2, 15, 4, 5, 123000000, time.UTC), "ERROR [main] c.e.App - failed"},
+		{"2024-01-02T15:04:05,123 WARN slow", time.Date(2024, 1, 2, 15, 4, 5, 123000000, time.UTC), "WARN slow"},
+		{"2024-01-02 15:04:05.5 -0700 msg", time.Date(2024, 1, 2, 22, 4, 5, 500000000, time.UTC), "msg"},
+		{"[2024-01-02 15:04:05,123] ERROR failed", time.Date(2024, 1, 2, 15, 4, 5, 123000000, time.UTC), "ERROR failed"},
+		// nginx error log
+		{"2024/01/02 15:04:05 [error] 31#31: *1 open() failed", time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), "[error] 31#31: *1 open() failed"},
+		// nginx access log
+		{`10.0.0.1 - - [02/Jan/2024:15:04:05 +0000] "GET / HTTP/1.1" 200 612`, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), `10.0.0.1 - - "GET / HTTP/1.1" 200 612`},
+		// klog
+		{"E0102 15:04:05.123456   12345 reflector.go:138] failed to watch", time.Date(year, 1, 2, 15, 4, 5, 123456000, time.UTC), "12345 reflector.go:138] failed to watch"},
+		// syslog
+		{"Jan  2 15:04:05 host sshd[1]: accepted", time.Date(year, 1, 2, 15, 4, 5, 0, time.UTC), "host sshd[1]: accepted"},
+	} {
+		ts, rest, ok := e.Extract(tc.line)
+		require.True(t, ok, tc.line)
+		assert.True(t, tc.ts.Equal(ts), "%s: %s", tc.line, ts)
+		assert.Equal(t, tc.rest, rest)
+	}
+
+	for _, line := range []string{
+		"",
+		"ERROR 2024-01-02T15:04:05Z failed",
+		"01/02/2024 15:04:05 ambiguous",
+		"2024-01-02 no time",
+		"2024-01-02T15:04:05Zjunk",
+		"I0102 not klog",
+		"[not a timestamp] msg",
+	} {
+		ts, rest, ok := e.Extract(line)
+		assert.False(t, ok, line)
+		assert.True(t, ts.IsZero())
+		assert.Equal(t, line, rest)
+	}
+}
+
+func TestTimestampExtractorCustomLayout(t *testing.T) {
+	e := NewTimestampExtractor()
+	_, _, ok := e.Extract("02.01.2024 15:04:05 ERROR failed")
+	require.False(t, ok)
+	require.NoError(t, e.AddLayout("02.01.2006 15:04:05"))
+	ts, rest, ok := e.Extract("02.01.2024 15:04:05 ERROR failed")
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), ts)
+	assert.Equal(t, "ERROR failed", rest)
+
+	assert.Error(t, e.AddLayout("no elements"))
+}
+
+func TestParserTimestampExtraction(t *testing.T) {
+	ch := make(chan LogEntry)
+	p, err := NewParserWithOptions(ch, WithTimestampExtraction(nil))
+	require.NoError(t, err)
+	for _, line := range []string{
+		"2024-01-02 15:04:05,123 ERROR failed to process order",
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.App.main(App.java:10)",
+		"2024-01-02 15:07:05,123 ERROR failed to process order",
+	} {
+		ch <- LogEntry{Content: line}
+	}
+	ch <- LogEntry{Content: "2024-01-02 15:09:05 ERROR failed to process order", Timestamp: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)}
+	require.NoError(t, p.StopAndDrain(time.Second))
+
+	counters := p.GetCounters()
+	require.Len(t, counters, 2)
+	byLines := map[int]LogCounter{}
+	for _, c := range counters {
+		byLines[strings.Count(c.Sample, "\n")+1] = c
+	}
+	single := byLines[1]
+	assert.Equal(t, "ERROR failed to process order", single.Sample)
+	assert.Equal(t, 2, single.Messages)
+	assert.Equal(t, time.Date(2024, 1, 2, 15, 7, 5, 123000000, time.UTC), single.FirstSeen)
+	assert.Equal(t, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), single.LastSeen)
+	trace := byLines[3]
+	assert.Equal(t, "ERROR failed to process order\njava.lang.IllegalStateException: boom\n\tat com.example.App.main(App.java:10)", trace.Sample)
+	assert.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 123000000, time.UTC), trace.FirstSeen)
+}
@@ -0,0 +1,138 @@
+package logparser
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SortKey is the order of GetTopCounters.
+type SortKey int
+
+const (
+	// SortByMessages orders counters by number of messages.
+	SortByMessages SortKey = iota
+	// SortByBytes orders counters by total size of the messages.
+	SortByBytes
+	// SortByLastSeen orders counters by timestamp of their last message.
+	SortByLastSeen
+)
+
+func (k SortKey) String() string {
+	switch k {
+	case SortByMessages:
+		return "messages"
+	case SortByBytes:
+		return "bytes"
+	case SortByLastSeen:
+		return "last-seen"
+	}
+	return fmt.Sprintf("SortKey(%d)", int(k))
+}
+
+// value returns the sort value of ps, higher first.
+func (k SortKey) value(ps *patternStat) int64 {
+	switch k {
+	case SortByBytes:
+		return ps.bytes
+	case SortByLastSeen:
+		if ps.seen.last.IsZero() {
+			return 0
+		}
+		return ps.seen.last.UnixNano()
+	}
+	return int64(ps.messages)
+}
+
+// topEntry is a counter selected by GetTopCounters.
+type topEntry struct {
+	value   int64
+	counter LogCounter
+}
+
+// before reports whether a ranks before b: higher values first, then
+// smaller hashes and sources, so that the result doesn't depend on the map
+// order.
+func (a *topEntry) before(value int64, k patternKey) bool {
+	if a.value != value {
+		return a.value > value
+	}
+	if a.counter.Hash != k.hash {
+		return a.counter.Hash < k.hash
+	}
+	if a.counter.Source != k.source {
+		return a.counter.Source < k.source
+	}
+	return a.counter.Level < k.level
+}
+
+func (a *topEntry) key() patternKey {
+	return patternKey{source: a.counter.Source, level: a.counter.Level, hash: a.counter.Hash}
+}
+
+// topHeap is a min-heap of the selected counters: its root ranks last.
+type topHeap []*topEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[j].before(h[i].value, h[i].key()) }
+func (h topHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x any)        { *h = append(*h, x.(*topEntry)) }
+func (h *topHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// GetTopCounters returns the n counters ranking first by sortBy, in order.
+// Only the selected counters are copied, so it is much cheaper than sorting
+// GetCounters when there are many patterns. Like GetCounters, it locks one
+// shard at a time.
+func (p *Parser) GetTopCounters(n int, sortBy SortKey) []LogCounter {
+	if n <= 0 {
+		return nil
+	}
+	h := make(topHeap, 0, n)
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.lock.Lock()
+		for k, ps := range s.patterns {
+			v := sortBy.value(ps)
+			if len(h) < n {
+				heap.Push(&h, &topEntry{value: v, counter: newLogCounter(k, ps)})
+				continue
+			}
+			if h[0].before(v, k) {
+				continue
+			}
+			h[0] = &topEntry{value: v, counter: newLogCounter(k, ps)}
+			heap.Fix(&h, 0)
+		}
+		s.lock.Unlock()
+	}
+	sort.Slice(h, func(i, j int) bool { return h[i].before(h[j].value, h[j].key()) })
+	res := make([]LogCounter, len(h))
+	for i, e := range h {
+		res[i] = e.counter
+	}
+	return res
+}
+
+// RangeCounters calls fn with each counter until it returns false, without
+// building the list of all the counters. The counters of a shard are copied
+// before fn is called, so fn may call the parser and doesn't block counting.
+func (p *Parser) RangeCounters(fn func(LogCounter) bool) {
+	var buf []LogCounter
+	for i := range p.shards {
+		s := &p.shards[i]
+		s.lock.Lock()
+		buf = shardCounters(buf[:0], s, time.Time{})
+		s.lock.Unlock()
+		for _, c := range buf {
+			if !fn(c) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package logparser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTopCounters(t *testing.T) {
+	p, err := NewParserWithOptions(make(chan LogEntry))
+	require.NoError(t, err)
+	defer p.Stop()
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	messages := []struct {
+		content string
+		count   int
+		level   Level
+	}{
+		{"failed to connect to db", 5, LevelError},
+		{"disk is full " + strings.Repeat("x", 200), 1, LevelCritical},
+		{"retrying request", 3, LevelWarning},
+		{"cache miss rate is high", 3, LevelWarning},
+		{"user logged out", 2, LevelError},
+	}
+	for i, m := range messages {
+		for j := 0; j < m.count; j++ {
+			p.inc(Message{Timestamp: t0.Add(time.Duration(i) * time.Minute), Content: m.content, Level: m.level})
+		}
+	}
+
+	samples := func(counters []LogCounter) []string {
+		var res []string
+		for _, c := range counters {
+			res = append(res, c.Sample)
+		}
+		return res
+	}
+	top := p.GetTopCounters(3, SortByMessages)
+	require.Len(t, top, 3)
+	assert.Equal(t, "failed to connect to db", top[0].Sample)
+	assert.Equal(t, []int{5, 3, 3}, []int{top[0].Messages, top[1].Messages, top[2].Messages})
+	// Ties are broken by hash.
+	assert.Less(t, top[1].Hash, top[2].Hash)
+
+	assert.Equal(t, []string{"disk is full " + strings.Repeat("x", 200), "failed to connect to db"}, samples(p.GetTopCounters(2, SortByBytes)))
+	assert.Equal(t, []string{"user logged out", "cache miss rate is high"}, samples(p.GetTopCounters(2, SortByLastSeen)))
+
+	// With n beyond the number of counters, all of them are sorted.
+	all := p.GetCounters()
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Messages != all[j].Messages {
+			return all[i].Messages > all[j].Messages
+		}
+		return all[i].Hash < all[j].Hash
+	})
+	assert.Equal(t, all, p.GetTopCounters(100, SortByMessages))
+	assert.Nil(t, p.GetTopCounters(0, SortByMessages))
+	assert.Equal(t, "last-seen", SortByLastSeen.String())
+}
+
+func TestRangeCounters(t *testing.T) {
+	p, err := NewParserWithOptions(make(chan LogEntry))
+	require.NoError(t, err)
+	defer p.Stop()
+	for i := 0; i < 10; i++ {
+		p.inc(Message{Timestamp: time.Now(), Content: fmt.Sprintf("%[1]s failed to start %[1]s", strings.Repeat(string(rune('a'+i)), 4)), Level: LevelError})
+	}
+
+	seen := 0
+	p.RangeCounters(func(c LogCounter) bool {
+		seen += c.Messages
+		return true
+	})
+	assert.Equal(t, 10, seen)
+
+	seen = 0
+	p.RangeCounters(func(c LogCounter) bool {
+		seen++
+		// The parser can be called back.
+		p.GetLevelStats()
+		return seen < 3
+	})
+	assert.Equal(t, 3, seen)
+
+	// Both are safe while counting.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			p.inc(Message{Timestamp: time.Now(), Content: fmt.Sprintf("request %d failed", i%50), Level: LevelError})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		p.GetTopCounters(5, SortByMessages)
+		p.RangeCounters(func(LogCounter) bool { return true })
+	}
+	wg.Wait()
+}
@@ -0,0 +1,28 @@
+package logparser
+
+import "hash/fnv"
+
+// maxUniqueValues caps the number of distinct values tracked per counter.
+const maxUniqueValues = 1024
+
+// uniqueValues counts distinct strings up to maxUniqueValues. Only 64-bit
+// hashes are kept, never the values themselves, so secrets are not retained.
+type uniqueValues struct {
+	seen map[uint64]struct{}
+}
+
+func (u *uniqueValues) add(v string) {
+	if len(u.seen) >= maxUniqueValues {
+		return
+	}
+	if u.seen == nil {
+		u.seen = map[uint64]struct{}{}
+	}
+	h := fnv.New64a()
+	h.Write([]byte(v))
+	u.seen[h.Sum64()] = struct{}{}
+}
+
+func (u *uniqueValues) count() int {
+	return len(u.seen)
+}
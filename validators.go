@@ -0,0 +1,219 @@
+package logparser
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Validators maps the names accepted in the "validator" field of
+// sensitive_patterns.json to their implementations.
+var Validators = map[string]func(string) bool{
+	"luhn":           ValidLuhn,
+	"iban":           ValidIBAN,
+	"base64_entropy": ValidBase64Entropy,
+	"phone":          ValidPhoneNumber,
+	"us_ssn":         ValidSSN,
+	"in_aadhaar":     ValidAadhaar,
+	"uk_nino":        ValidNINO,
+}
+
+// ValidLuhn reports whether s is a 12-19 digit number (spaces and dashes
+// allowed as separators) with a valid Luhn checksum, as used by payment
+// card numbers.
+func ValidLuhn(s string) bool {
+	var sum, n int
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '-':
+			continue
+		case c < '0' || c > '9':
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		n++
+	}
+	return n >= 12 && n <= 19 && sum%10 == 0
+}
+
+// ValidIBAN reports whether s is an International Bank Account Number with a
+// valid mod-97 check digit. Spaces are ignored.
+func ValidIBAN(s string) bool {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	for i := 0; i < 2; i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	if s[2] < '0' || s[2] > '9' || s[3] < '0' || s[3] > '9' {
+		return false
+	}
+	var digits strings.Builder
+	for _, c := range s[4:] + s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			digits.WriteString(strconv.Itoa(int(c-'A') + 10))
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// ValidBase64Entropy reports whether s consists of base64 (standard or
+// URL-safe) characters and is random enough to be a key rather than a word.
+func ValidBase64Entropy(s string) bool {
+	s = strings.TrimRight(s, "=")
+	if len(s) < 16 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '+', c == '/', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return shannonEntropy(s) >= 3.5
+}
+
+// ValidPhoneNumber reports whether s is an international phone number: a "+",
+// a country code not starting with 0 and 8 to 15 digits in total, as allowed
+// by E.164. Spaces, dots, dashes and parentheses are allowed as separators.
+func ValidPhoneNumber(s string) bool {
+	if len(s) < 2 || s[0] != '+' || s[1] < '1' || s[1] > '9' {
+		return false
+	}
+	n := 0
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			n++
+		case c == ' ' || c == '.' || c == '-' || c == '(' || c == ')':
+		default:
+			return false
+		}
+	}
+	return n >= 8 && n <= 15
+}
+
+// ValidSSN reports whether s is a US Social Security Number (dashes or spaces
+// allowed as separators) that can be issued: its area isn't 000, 666 or in
+// the 900s, its group isn't 00 and its serial isn't 0000.
+func ValidSSN(s string) bool {
+	digits, ok := stripDigits(s, "- ")
+	if !ok || len(digits) != 9 {
+		return false
+	}
+	area, group, serial := digits[:3], digits[3:5], digits[5:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// ValidAadhaar reports whether s is an Indian Aadhaar number: 12 digits
+// (spaces and dashes allowed as separators), not starting with 0 or 1, with a
+// valid Verhoeff check digit.
+func ValidAadhaar(s string) bool {
+	digits, ok := stripDigits(s, "- ")
+	if !ok || len(digits) != 12 || digits[0] < '2' {
+		return false
+	}
+	return validVerhoeff(digits)
+}
+
+// The tables of the Verhoeff algorithm: the multiplication table of the
+// dihedral group D5 and the permutation applied to each digit by position.
+var (
+	verhoeffD = [10][10]byte{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 2, 3, 4, 0, 6, 7, 8, 9, 5},
+		{2, 3, 4, 0, 1, 7, 8, 9, 5, 6},
+		{3, 4, 0, 1, 2, 8, 9, 5, 6, 7},
+		{4, 0, 1, 2, 3, 9, 5, 6, 7, 8},
+		{5, 9, 8, 7, 6, 0, 4, 3, 2, 1},
+		{6, 5, 9, 8, 7, 1, 0, 4, 3, 2},
+		{7, 6, 5, 9, 8, 2, 1, 0, 4, 3},
+		{8, 7, 6, 5, 9, 3, 2, 1, 0, 4},
+		{9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+	}
+	verhoeffP = [8][10]byte{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 5, 7, 6, 2, 8, 3, 0, 9, 4},
+		{5, 8, 0, 3, 7, 9, 6, 1, 4, 2},
+		{8, 9, 1, 6, 0, 4, 3, 5, 2, 7},
+		{9, 4, 5, 3, 1, 2, 6, 8, 7, 0},
+		{4, 2, 8, 6, 5, 7, 3, 9, 0, 1},
+		{2, 7, 9, 3, 8, 0, 6, 4, 1, 5},
+		{7, 0, 4, 6, 9, 1, 3, 2, 5, 8},
+	}
+)
+
+// validVerhoeff reports whether the last of digits is their Verhoeff check
+// digit.
+func validVerhoeff(digits string) bool {
+	var c byte
+	for i := 0; i < len(digits); i++ {
+		c = verhoeffD[c][verhoeffP[i%8][digits[len(digits)-1-i]-'0']]
+	}
+	return c == 0
+}
+
+// ValidNINO reports whether s is a UK National Insurance number (spaces
+// allowed between the pairs of digits): two prefix letters excluding D, F,
+// I, Q, U and V (and O as the second one) and the unused BG, GB, KN, NK, NT,
+// TN and ZZ, six digits and a suffix from A to D.
+func ValidNINO(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) != 9 || strings.ContainsAny(s[:1], "DFIQUV") || strings.ContainsAny(s[1:2], "DFIOQUV") {
+		return false
+	}
+	for i := 0; i < 2; i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	switch s[:2] {
+	case "BG", "GB", "KN", "NK", "NT", "TN", "ZZ":
+		return false
+	}
+	if _, ok := stripDigits(s[2:8], ""); !ok {
+		return false
+	}
+	return s[8] >= 'A' && s[8] <= 'D'
+}
+
+// stripDigits returns s without the separators in seps, and whether only
+// digits remain.
+func stripDigits(s, seps string) (string, bool) {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			b.WriteByte(c)
+		case strings.IndexByte(seps, c) < 0:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
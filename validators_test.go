@@ -0,0 +1,93 @@
+package logparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidLuhn(t *testing.T) {
+	assert.True(t, ValidLuhn("4111 1111 1111 1111"))
+	assert.True(t, ValidLuhn("4111-1111-1111-1111"))
+	assert.True(t, ValidLuhn("378282246310005"))
+	assert.False(t, ValidLuhn("1234 5678 9012 3456"))
+	assert.False(t, ValidLuhn("4111 1111 1111 1112"))
+	assert.False(t, ValidLuhn("0000"))
+	assert.False(t, ValidLuhn("4111x1111y1111z1111"))
+}
+
+func TestValidIBAN(t *testing.T) {
+	assert.True(t, ValidIBAN("GB82 WEST 1234 5698 7654 32"))
+	assert.True(t, ValidIBAN("DE89370400440532013000"))
+	assert.False(t, ValidIBAN("GB82 WEST 1234 5698 7654 33"))
+	assert.False(t, ValidIBAN("not an iban at all"))
+}
+
+func TestValidBase64Entropy(t *testing.T) {
+	assert.True(t, ValidBase64Entropy("dGhpc0lzQVJhbmRvbUtleTEyMw=="))
+	assert.True(t, ValidBase64Entropy("xK9mPq2wF7vL0aB3nR5tY8uJ1dG4hS6"))
+	assert.False(t, ValidBase64Entropy("aaaaaaaaaaaaaaaaaaaaaaaa"))
+	assert.False(t, ValidBase64Entropy("short"))
+	assert.False(t, ValidBase64Entropy("has spaces in it, not base64"))
+}
+
+func TestValidPhoneNumber(t *testing.T) {
+	assert.True(t, ValidPhoneNumber("+14155552671"))
+	assert.True(t, ValidPhoneNumber("+44 (20) 7946-0958"))
+	assert.False(t, ValidPhoneNumber("+0123456789"))
+	assert.False(t, ValidPhoneNumber("+1415555"))
+	assert.False(t, ValidPhoneNumber("+1415555267112345"))
+	assert.False(t, ValidPhoneNumber("14155552671"))
+	assert.False(t, ValidPhoneNumber("+1415x5552671"))
+}
+
+func TestValidSSN(t *testing.T) {
+	assert.True(t, ValidSSN("123-45-6789"))
+	assert.True(t, ValidSSN("536 90 4399"))
+	assert.False(t, ValidSSN("000-45-6789"))
+	assert.False(t, ValidSSN("666-45-6789"))
+	assert.False(t, ValidSSN("900-45-6789"))
+	assert.False(t, ValidSSN("123-00-6789"))
+	assert.False(t, ValidSSN("123-45-0000"))
+	assert.False(t, ValidSSN("123-45-678"))
+	assert.False(t, ValidSSN("12a-45-6789"))
+}
+
+func TestValidAadhaar(t *testing.T) {
+	assert.True(t, ValidAadhaar("2341 2341 2346"))
+	assert.True(t, ValidAadhaar("499187654323"))
+	assert.False(t, ValidAadhaar("2341 2341 2347"))
+	assert.False(t, ValidAadhaar("2341 2341 2364")) // transposed digits
+	assert.False(t, ValidAadhaar("199187654323"))
+	assert.False(t, ValidAadhaar("49918765432"))
+}
+
+func TestValidNINO(t *testing.T) {
+	assert.True(t, ValidNINO("AB123456C"))
+	assert.True(t, ValidNINO("JG 10 37 92 A"))
+	assert.False(t, ValidNINO("QQ123456C"))
+	assert.False(t, ValidNINO("AO123456C"))
+	assert.False(t, ValidNINO("GB123456A"))
+	assert.False(t, ValidNINO("TN123456A"))
+	assert.False(t, ValidNINO("AB123456E"))
+	assert.False(t, ValidNINO("AB12345XC"))
+	assert.False(t, ValidNINO("ab123456c"))
+}
+
+func TestDetectSensitiveDataCreditCard(t *testing.T) {
+	patterns, err := LoadPatterns("medium")
+	require.NoError(t, err)
+
+	names := func(line string) []string {
+		var res []string
+		for _, m := range DetectAllSensitiveData(line, "hash", patterns) {
+			res = append(res, m.Name)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"credit-card-number"}, names("charging card 4111 1111 1111 1111 for order"))
+	// Matches the card regex but fails the Luhn check.
+	assert.Empty(t, names("charging card 4111 1111 1111 1112 for order"))
+	assert.Empty(t, names("charging card 1234 5678 9012 3456 for order"))
+}
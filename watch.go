@@ -0,0 +1,66 @@
+package logparser
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// patternFileWatchInterval is how often WatchPatternFile checks the file.
+var patternFileWatchInterval = 5 * time.Second
+
+// WatchPatternFile loads sensitive patterns from path into parser and reloads
+// them whenever the file's modification time or size changes. A definition
+// that fails to parse, or contains no patterns, is logged and ignored so the
+// parser keeps using the last good set. Patterns are filtered by the parser's
+// SensitiveConfig.MinConfidence.
+//
+// The initial load error, if any, is returned immediately. Otherwise
+// WatchPatternFile blocks until ctx is done.
+func WatchPatternFile(ctx context.Context, path string, parser *Parser) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := reloadPatternFile(path, parser); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(patternFileWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			current, err := os.Stat(path)
+			if err != nil {
+				parser.logger.Error("error checking sensitive pattern file", "path", path, "error", err)
+				continue
+			}
+			if current.ModTime().Equal(info.ModTime()) && current.Size() == info.Size() {
+				continue
+			}
+			info = current
+			if err := reloadPatternFile(path, parser); err != nil {
+				parser.logger.Error("error reloading sensitive pattern file", "path", path, "error", err)
+			}
+		}
+	}
+}
+
+func reloadPatternFile(path string, parser *Parser) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	patterns, err := ParsePatterns(data, parser.sensitiveConfig.MinConfidence)
+	if err != nil {
+		return err
+	}
+	if len(patterns) == 0 {
+		return errors.New("no sensitive patterns found")
+	}
+	return parser.ReloadSensitivePatterns(patterns)
+}